@@ -0,0 +1,371 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::LazyLock;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::labels::{self, Labels};
+use crate::transcript::{self, format_utc};
+
+/// Label calls are charged back to unless a report groups by another one
+pub const TENANT_LABEL: &str = "tenant";
+
+/// Group of calls without the label a report groups by
+const UNLABELED: &str = "(none)";
+
+/// Longest period a report covers, in days
+pub const MAX_REPORT_DAYS: u64 = 366;
+
+/// Usage ledger loaded from USAGE_LEDGER environment variable at startup. Every session appends a
+/// line per tool call to the same file, so reports cover all of them. Unset, usage isn't recorded.
+static LEDGER: LazyLock<Option<PathBuf>> = LazyLock::new(|| {
+    std::env::var("USAGE_LEDGER")
+        .ok()
+        .filter(|s| !s.trim().is_empty())
+        .map(|s| PathBuf::from(s.trim()))
+});
+
+/// Resources a tool call used, as kept in the usage ledger
+#[derive(Debug, Clone, PartialEq)]
+pub struct Record {
+    /// When the call finished, in seconds since the Unix epoch
+    pub time: u64,
+    pub session: String,
+    pub tool: String,
+    /// Wall-clock time of the call
+    pub wall: Duration,
+    /// CPU time, user and system, of the commands the call ran
+    pub cpu: Duration,
+    /// Bytes of output the call produced, before output transformations
+    pub output_bytes: u64,
+    pub labels: Labels,
+}
+
+impl Record {
+    /// A line of the ledger: time, session, tool, wall and CPU milliseconds, output bytes and labels,
+    /// separated by spaces. Labels go last, since their values may contain spaces.
+    fn to_line(&self) -> String {
+        format!(
+            "{} {} {} {} {} {} {}",
+            self.time,
+            self.session,
+            self.tool,
+            self.wall.as_millis(),
+            self.cpu.as_millis(),
+            self.output_bytes,
+            labels::format(&self.labels)
+        )
+    }
+
+    /// Parse a line of the ledger
+    fn parse(line: &str) -> Option<Self> {
+        let mut fields = line.trim_end().splitn(7, ' ');
+        let time = fields.next()?.parse().ok()?;
+        let session = fields.next()?.to_string();
+        let tool = fields.next()?.to_string();
+        let wall = Duration::from_millis(fields.next()?.parse().ok()?);
+        let cpu = Duration::from_millis(fields.next()?.parse().ok()?);
+        let output_bytes = fields.next()?.parse().ok()?;
+        let labels = fields
+            .next()
+            .unwrap_or_default()
+            .split(',')
+            .filter_map(|pair| pair.split_once('='))
+            .map(|(name, value)| (name.to_string(), value.to_string()))
+            .collect();
+        Some(Record {
+            time,
+            session,
+            tool,
+            wall,
+            cpu,
+            output_bytes,
+            labels,
+        })
+    }
+}
+
+/// Add a finished tool call to the usage ledger
+pub fn record(tool: &str, labels: Labels, wall: Duration, cpu: Duration, output_bytes: u64) {
+    let Some(ref ledger) = *LEDGER else {
+        return;
+    };
+    let record = Record {
+        time: now_secs(),
+        session: transcript::session_id().to_string(),
+        tool: tool.to_string(),
+        wall,
+        cpu,
+        output_bytes,
+        labels,
+    };
+    if let Err(e) = append(ledger, &record) {
+        tracing::warn!("Failed to record usage in {}: {}", ledger.display(), e);
+    }
+}
+
+/// Internal implementation for testability - takes the ledger as parameter.
+/// The line is written at once, so lines appended by concurrent sessions don't interleave.
+fn append(ledger: &Path, record: &Record) -> std::io::Result<()> {
+    let mut file = std::fs::OpenOptions::new().create(true).append(true).open(ledger)?;
+    file.write_all(format!("{}\n", record.to_line()).as_bytes())
+}
+
+/// Records in the usage ledger from `since` on, in seconds since the Unix epoch
+pub fn load_since(since: u64) -> Result<Vec<Record>, String> {
+    let ledger = LEDGER.as_ref().ok_or("USAGE_LEDGER is not set")?;
+    load(ledger, since)
+}
+
+/// Internal implementation for testability - takes the ledger as parameter.
+fn load(ledger: &Path, since: u64) -> Result<Vec<Record>, String> {
+    let contents = match std::fs::read_to_string(ledger) {
+        Ok(contents) => contents,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(format!("Failed to read {}: {}", ledger.display(), e)),
+    };
+    Ok(contents
+        .lines()
+        .filter_map(Record::parse)
+        .filter(|record| record.time >= since)
+        .collect())
+}
+
+pub fn now_secs() -> u64 {
+    SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs())
+}
+
+/// Period usage is totalled over
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, schemars::JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Window {
+    Hour,
+    #[default]
+    Day,
+    /// Weeks start on Monday
+    Week,
+    /// The whole report period
+    All,
+}
+
+impl Window {
+    /// Start of the window `time` falls in, in UTC
+    fn start(self, time: u64) -> u64 {
+        // The Unix epoch was a Thursday, so weeks starting Monday are offset by 4 days
+        const MONDAY: u64 = 4 * 86_400;
+        match self {
+            Window::Hour => time - time % 3_600,
+            Window::Day => time - time % 86_400,
+            Window::Week if time >= MONDAY => time - (time - MONDAY) % (7 * 86_400),
+            Window::Week => 0,
+            Window::All => 0,
+        }
+    }
+
+    /// Parse a window name: "hour", "day", "week" or "all"
+    pub fn parse(name: &str) -> Option<Self> {
+        match name {
+            "hour" => Some(Window::Hour),
+            "day" => Some(Window::Day),
+            "week" => Some(Window::Week),
+            "all" => Some(Window::All),
+            _ => None,
+        }
+    }
+}
+
+/// Usage of one group of calls in one window
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Row {
+    /// Start of the window, in seconds since the Unix epoch; 0 for a report over the whole period
+    pub window_start: u64,
+    /// Value of the label the report groups by, or "(none)" for calls without it
+    pub group: String,
+    pub calls: u64,
+    pub wall: Duration,
+    pub cpu: Duration,
+    pub output_bytes: u64,
+}
+
+/// Total records by window and by the value of the label `group_by`, ordered by window then group
+pub fn aggregate(records: &[Record], group_by: &str, window: Window) -> Vec<Row> {
+    let mut rows: BTreeMap<(u64, String), Row> = BTreeMap::new();
+    for record in records {
+        let window_start = window.start(record.time);
+        let group = record.labels.get(group_by).map_or(UNLABELED, String::as_str).to_string();
+        let row = rows.entry((window_start, group.clone())).or_insert_with(|| Row {
+            window_start,
+            group,
+            ..Default::default()
+        });
+        row.calls += 1;
+        row.wall += record.wall;
+        row.cpu += record.cpu;
+        row.output_bytes += record.output_bytes;
+    }
+    rows.into_values().collect()
+}
+
+/// Rows as CSV with a header, for chargeback spreadsheets and billing pipelines
+pub fn to_csv(rows: &[Row], group_by: &str) -> String {
+    let mut csv = format!("window_start,{},calls,wall_seconds,cpu_seconds,output_bytes\n", group_by);
+    for row in rows {
+        csv.push_str(&format!(
+            "{},{},{},{:.3},{:.3},{}\n",
+            window_label(row.window_start),
+            csv_field(&row.group),
+            row.calls,
+            row.wall.as_secs_f64(),
+            row.cpu.as_secs_f64(),
+            row.output_bytes
+        ));
+    }
+    csv
+}
+
+/// Rows as one line each, e.g., "2026-10-15T00:00:00Z tenant=payments: 12 calls, wall 340.2s, CPU 1210.5s, 4.2 MiB"
+pub fn to_text(rows: &[Row], group_by: &str) -> String {
+    if rows.is_empty() {
+        return "No usage recorded in this period".to_string();
+    }
+    rows.iter()
+        .map(|row| {
+            format!(
+                "{} {}={}: {} call{}, wall {:.1}s, CPU {:.1}s, {} output bytes",
+                window_label(row.window_start),
+                group_by,
+                row.group,
+                row.calls,
+                if row.calls == 1 { "" } else { "s" },
+                row.wall.as_secs_f64(),
+                row.cpu.as_secs_f64(),
+                row.output_bytes
+            )
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+fn window_label(window_start: u64) -> String {
+    if window_start == 0 {
+        "all".to_string()
+    } else {
+        format_utc(window_start)
+    }
+}
+
+/// Quote a CSV field that needs it. Label values can't contain commas, but may contain quotes.
+fn csv_field(field: &str) -> String {
+    if field.contains('"') {
+        format!("\"{}\"", field.replace('"', "\"\""))
+    } else {
+        field.to_string()
+    }
+}
+
+/// The `usage-report` command: print the usage ledger as CSV for an operator, e.g.,
+/// `usage-report --group-by tenant --window day --days 30`.
+pub fn usage_report_command(args: &[String]) -> Result<String, String> {
+    let (mut group_by, mut window, mut days) = (TENANT_LABEL.to_string(), Window::Day, 30);
+    let mut args = args.iter();
+    while let Some(flag) = args.next() {
+        let value = args.next().ok_or_else(|| format!("{} needs a value", flag))?;
+        match flag.as_str() {
+            "--group-by" => group_by = value.clone(),
+            "--window" => {
+                window = Window::parse(value).ok_or_else(|| format!("'{}' is not hour, day, week or all", value))?
+            }
+            "--days" => {
+                days = value
+                    .parse()
+                    .ok()
+                    .filter(|days| (1..=MAX_REPORT_DAYS).contains(days))
+                    .ok_or_else(|| format!("--days must be 1-{}", MAX_REPORT_DAYS))?
+            }
+            _ => return Err(format!("Unknown option {}", flag)),
+        }
+    }
+    labels::validate_label(&group_by, "x").map_err(|e| e.to_string())?;
+    let records = load_since(now_secs().saturating_sub(days * 86_400))?;
+    Ok(to_csv(&aggregate(&records, &group_by, window), &group_by))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn record(time: u64, wall_ms: u64, labels: &[(&str, &str)]) -> Record {
+        Record {
+            time,
+            session: "abc".to_string(),
+            tool: "presubmit".to_string(),
+            wall: Duration::from_millis(wall_ms),
+            cpu: Duration::from_millis(wall_ms * 2),
+            output_bytes: 100,
+            labels: labels.iter().map(|(name, value)| (name.to_string(), value.to_string())).collect(),
+        }
+    }
+
+    #[test]
+    fn test_record_line_round_trip() {
+        let labeled = record(1000, 1500, &[("agent", "code review bot"), ("tenant", "payments")]);
+        assert_eq!(labeled.to_line(), "1000 abc presubmit 1500 3000 100 agent=code review bot,tenant=payments");
+        assert_eq!(Record::parse(&labeled.to_line()), Some(labeled));
+        let unlabeled = record(1000, 10, &[]);
+        assert_eq!(Record::parse(&unlabeled.to_line()), Some(unlabeled));
+        assert_eq!(Record::parse("garbage"), None);
+    }
+
+    #[test]
+    fn test_append_and_load() {
+        let temp_dir = TempDir::new().unwrap();
+        let ledger = temp_dir.path().join("usage");
+        assert!(load(&ledger, 0).unwrap().is_empty());
+        append(&ledger, &record(1000, 10, &[])).unwrap();
+        append(&ledger, &record(5000, 10, &[])).unwrap();
+        let records = load(&ledger, 2000).unwrap();
+        assert_eq!(records.len(), 1);
+        assert_eq!(records[0].time, 5000);
+    }
+
+    #[test]
+    fn test_window_start() {
+        // 2026-10-15T13:45:00Z, a Thursday
+        let time = 1_792_071_900;
+        assert_eq!(format_utc(Window::Hour.start(time)), "2026-10-15T13:00:00Z");
+        assert_eq!(format_utc(Window::Day.start(time)), "2026-10-15T00:00:00Z");
+        assert_eq!(format_utc(Window::Week.start(time)), "2026-10-12T00:00:00Z");
+        assert_eq!(Window::All.start(time), 0);
+    }
+
+    #[test]
+    fn test_aggregate_and_export() {
+        let records = [
+            record(86_400 + 10, 1000, &[("tenant", "payments")]),
+            record(86_400 + 20, 3000, &[("tenant", "payments"), ("task", "T-1")]),
+            record(86_400 + 30, 500, &[("tenant", "search")]),
+            record(2 * 86_400, 200, &[]),
+        ];
+        let rows = aggregate(&records, TENANT_LABEL, Window::Day);
+        assert_eq!(rows.len(), 3);
+        assert_eq!((rows[0].group.as_str(), rows[0].calls), ("payments", 2));
+        assert_eq!(rows[0].wall, Duration::from_secs(4));
+        assert_eq!(rows[0].cpu, Duration::from_secs(8));
+        assert_eq!(rows[2].group, UNLABELED);
+
+        let csv = to_csv(&aggregate(&records, "task", Window::All), "task");
+        assert_eq!(
+            csv,
+            "window_start,task,calls,wall_seconds,cpu_seconds,output_bytes\n\
+             all,(none),3,1.700,3.400,300\n\
+             all,T-1,1,3.000,6.000,100\n"
+        );
+        assert_eq!(
+            to_text(&rows[1..2], TENANT_LABEL),
+            "1970-01-02T00:00:00Z tenant=search: 1 call, wall 0.5s, CPU 1.0s, 100 output bytes"
+        );
+    }
+}
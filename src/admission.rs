@@ -0,0 +1,507 @@
+use std::collections::{HashMap, VecDeque};
+use std::path::Path;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Condvar, LazyLock, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+use crate::progress;
+use crate::tools::host_info::format_bytes;
+
+/// Tools whose executions are heavy enough to be held back on a busy host, when ADMISSION_TOOLS isn't set
+const DEFAULT_HEAVY_TOOLS: &str = "presubmit;debug_test";
+
+/// Programs quick and read-only enough to skip the limit on parallel commands, when INTERACTIVE_COMMANDS
+/// isn't set
+const DEFAULT_INTERACTIVE_COMMANDS: &str = "cat;file;grep;head;ls;stat;tail;wc";
+
+/// How often a queued execution checks the host again
+const POLL_INTERVAL: Duration = Duration::from_secs(1);
+
+/// How long a command waits for a slot when the call doesn't set queue_timeout_ms and COMMAND_QUEUE_MS
+/// isn't set (5 minutes)
+const DEFAULT_COMMAND_QUEUE: Duration = Duration::from_secs(300);
+
+/// How often a command waiting for a slot tells its client it is still queued
+const QUEUE_REPORT_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Limits a heavy execution must fit within to start
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Thresholds {
+    /// Tools the limits apply to
+    pub tools: Vec<String>,
+    /// Highest 1-minute load average per CPU at which a heavy execution still starts
+    pub max_load: Option<f64>,
+    /// Least available memory, in bytes, a heavy execution needs to start
+    pub min_free_memory: Option<u64>,
+    /// How long a heavy execution waits for the host to calm down before it is rejected
+    pub queue: Duration,
+}
+
+/// Admission thresholds loaded at startup. ADMISSION_MAX_LOAD sets the load average per CPU and
+/// ADMISSION_MIN_FREE_MB the available memory; with neither set every execution is admitted.
+/// ADMISSION_TOOLS lists the heavy tools ("presubmit;debug_test" by default) and ADMISSION_QUEUE_MS
+/// how long they wait for the host before being rejected (default: 0, reject at once).
+static THRESHOLDS: LazyLock<Thresholds> = LazyLock::new(|| load_thresholds(|var| std::env::var(var).ok()));
+
+/// How long a command or call waits for a slot unless it sets its own queue timeout, loaded from
+/// COMMAND_QUEUE_MS environment variable at startup (default: 300000)
+static QUEUE: LazyLock<Duration> = LazyLock::new(|| {
+    let queue = std::env::var("COMMAND_QUEUE_MS").ok().and_then(|s| s.trim().parse().ok());
+    queue.map_or(DEFAULT_COMMAND_QUEUE, Duration::from_millis)
+});
+
+/// Most commands the server runs at once, loaded from MAX_PARALLEL_COMMANDS environment variable at
+/// startup; unset or 0, there is no limit. Commands past it wait their turn.
+static SLOTS: LazyLock<Slots> = LazyLock::new(|| {
+    let max = std::env::var("MAX_PARALLEL_COMMANDS").ok().and_then(|s| s.trim().parse::<usize>().ok());
+    Slots::new("command", max.filter(|&max| max > 0), *QUEUE)
+});
+
+/// Programs whose commands never wait for a slot, loaded from INTERACTIVE_COMMANDS environment variable at
+/// startup as semicolon-separated names (default: "cat;file;grep;head;ls;stat;tail;wc"). Set it empty to
+/// make every command wait its turn.
+static INTERACTIVE_COMMANDS: LazyLock<Vec<String>> = LazyLock::new(|| {
+    std::env::var("INTERACTIVE_COMMANDS")
+        .unwrap_or_else(|_| DEFAULT_INTERACTIVE_COMMANDS.to_string())
+        .split(';')
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+        .collect()
+});
+
+/// Most calls of a tool the server runs at once, loaded from TOOL_MAX_CONCURRENT environment variable at
+/// startup as semicolon-separated tool=limit pairs, e.g., "presubmit=1;pipeline=8". Tools not listed
+/// have no limit of their own. Calls past a limit wait their turn.
+static TOOL_SLOTS: LazyLock<HashMap<String, Slots>> = LazyLock::new(|| {
+    let limits = load_tool_limits(&std::env::var("TOOL_MAX_CONCURRENT").unwrap_or_default());
+    limits.into_iter().map(|(tool, max)| (tool.clone(), Slots::new(&tool, Some(max), *QUEUE))).collect()
+});
+
+/// A heavy execution, or a command, waiting for the host to have room
+#[derive(Debug, Clone, PartialEq)]
+pub struct Queued {
+    pub id: u64,
+    pub tool: String,
+    /// When it was queued
+    pub since: Instant,
+    /// Why the host was busy when it was last checked
+    pub reason: String,
+}
+
+/// Executions waiting for the host, oldest first
+static QUEUED: Mutex<Vec<Queued>> = Mutex::new(Vec::new());
+
+/// ID of the last execution queued
+static LAST_QUEUED: AtomicU64 = AtomicU64::new(0);
+
+/// Executions waiting for the host now, oldest first
+pub fn queued() -> Vec<Queued> {
+    QUEUED.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// Lists an execution as queued for as long as the guard lives
+struct QueuedGuard(u64);
+
+impl QueuedGuard {
+    fn new(tool: &str, reason: &str) -> Self {
+        let id = LAST_QUEUED.fetch_add(1, Ordering::Relaxed) + 1;
+        QUEUED.lock().unwrap_or_else(|e| e.into_inner()).push(Queued {
+            id,
+            tool: tool.to_string(),
+            since: Instant::now(),
+            reason: reason.to_string(),
+        });
+        QueuedGuard(id)
+    }
+
+    fn update(&self, reason: &str) {
+        let mut queued = QUEUED.lock().unwrap_or_else(|e| e.into_inner());
+        if let Some(entry) = queued.iter_mut().find(|entry| entry.id == self.0) {
+            entry.reason = reason.to_string();
+        }
+    }
+}
+
+impl Drop for QueuedGuard {
+    fn drop(&mut self) {
+        QUEUED.lock().unwrap_or_else(|e| e.into_inner()).retain(|entry| entry.id != self.0);
+    }
+}
+
+/// A semaphore over the commands, or a tool's calls, running at once, which hands its slots out in the
+/// order they were asked for
+#[derive(Debug)]
+pub struct Slots {
+    /// What runs in a slot, for saying which slots are in use
+    name: String,
+    max: Option<usize>,
+    /// How long to wait for a slot by default
+    queue: Duration,
+    state: Mutex<SlotState>,
+    freed: Condvar,
+}
+
+#[derive(Debug, Default)]
+struct SlotState {
+    running: usize,
+    /// Queued IDs of those waiting for a slot, first in line first
+    waiting: VecDeque<u64>,
+}
+
+/// A slot, given back when dropped
+#[derive(Debug)]
+pub struct Slot<'a>(Option<&'a Slots>);
+
+impl Drop for Slot<'_> {
+    fn drop(&mut self) {
+        if let Some(slots) = self.0 {
+            slots.state.lock().unwrap_or_else(|e| e.into_inner()).running -= 1;
+            slots.freed.notify_all();
+        }
+    }
+}
+
+impl Slots {
+    pub fn new(name: &str, max: Option<usize>, queue: Duration) -> Self {
+        Slots {
+            name: name.to_string(),
+            max,
+            queue,
+            state: Mutex::new(SlotState::default()),
+            freed: Condvar::new(),
+        }
+    }
+
+    /// Wait for a slot to run a tool's command or call, for the given time or the default queue time.
+    /// Returns why there was none if the wait is up first.
+    pub fn acquire(&self, tool: &str, timeout: Option<Duration>) -> Result<Slot<'_>, String> {
+        let Some(max) = self.max else {
+            return Ok(Slot(None));
+        };
+        let mut state = self.state.lock().unwrap_or_else(|e| e.into_inner());
+        if state.running < max && state.waiting.is_empty() {
+            state.running += 1;
+            return Ok(Slot(Some(self)));
+        }
+        let started = Instant::now();
+        let deadline = started + timeout.unwrap_or(self.queue);
+        let reason = |running: usize, ahead: usize| {
+            format!("{} of {} {} slots in use, {} waiting ahead", running, max, self.name, ahead)
+        };
+        let queued = QueuedGuard::new(tool, &reason(state.running, state.waiting.len()));
+        state.waiting.push_back(queued.0);
+        let mut reported: Option<(usize, Instant)> = None;
+        loop {
+            let ahead = state.waiting.iter().position(|&id| id == queued.0).unwrap_or(0);
+            if state.running < max && ahead == 0 {
+                state.waiting.pop_front();
+                state.running += 1;
+                // The next in line may fit too
+                self.freed.notify_all();
+                return Ok(Slot(Some(self)));
+            }
+            let now = Instant::now();
+            if now >= deadline {
+                state.waiting.retain(|&id| id != queued.0);
+                self.freed.notify_all();
+                return Err(format!(
+                    "{}; gave up after {:.1}s",
+                    reason(state.running, ahead),
+                    started.elapsed().as_secs_f64()
+                ));
+            }
+            // Whenever the line moves, and now and then while it doesn't
+            if reported.is_none_or(|(last, at)| last != ahead || at.elapsed() >= QUEUE_REPORT_INTERVAL) {
+                let why = reason(state.running, ahead);
+                queued.update(&why);
+                progress::report(&format!("Queued {}: {}", tool, why));
+                reported = Some((ahead, now));
+            }
+            let wait = (deadline - now).min(QUEUE_REPORT_INTERVAL);
+            state = self.freed.wait_timeout(state, wait).unwrap_or_else(|e| e.into_inner()).0;
+        }
+    }
+}
+
+/// Wait for a slot under the server's limit on parallel commands. See Slots::acquire.
+pub fn acquire_slot(tool: &str, timeout: Option<Duration>) -> Result<Slot<'static>, String> {
+    SLOTS.acquire(tool, timeout)
+}
+
+/// Whether a command, or every stage of a pipeline, runs an interactive program, so a quick read such as
+/// ls or cat never waits behind builds for a slot
+pub fn is_interactive(programs: &[String]) -> bool {
+    is_interactive_impl(programs, &INTERACTIVE_COMMANDS)
+}
+
+/// Internal implementation for testability - takes the interactive programs as parameter.
+fn is_interactive_impl(programs: &[String], interactive: &[String]) -> bool {
+    !programs.is_empty()
+        && programs.iter().all(|program| {
+            let name = Path::new(program).file_name().and_then(|name| name.to_str()).unwrap_or(program);
+            interactive.iter().any(|i| i == name)
+        })
+}
+
+/// Wait for a slot under the tool's own limit on parallel calls, if it has one. See Slots::acquire.
+pub fn acquire_tool_slot(tool: &str, timeout: Option<Duration>) -> Result<Slot<'static>, String> {
+    match TOOL_SLOTS.get(tool) {
+        Some(slots) => slots.acquire(tool, timeout),
+        None => Ok(Slot(None)),
+    }
+}
+
+/// Parse per-tool limits such as "presubmit=1;pipeline=8". Invalid entries are logged and skipped.
+fn load_tool_limits(config: &str) -> Vec<(String, usize)> {
+    let mut limits = Vec::new();
+    for entry in config.split(';').map(str::trim).filter(|e| !e.is_empty()) {
+        match entry.split_once('=').map(|(tool, max)| (tool.trim(), max.trim().parse::<usize>())) {
+            Some((tool, Ok(max))) if !tool.is_empty() && max > 0 => limits.push((tool.to_string(), max)),
+            _ => tracing::warn!("Ignoring invalid TOOL_MAX_CONCURRENT entry {:?}", entry),
+        }
+    }
+    limits
+}
+
+/// The host's current load and memory
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct HostLoad {
+    /// 1-minute load average per CPU
+    pub load: Option<f64>,
+    /// Memory available to new processes, in bytes
+    pub free_memory: Option<u64>,
+}
+
+/// Internal implementation for testability - takes a variable lookup as parameter.
+fn load_thresholds(var: impl Fn(&str) -> Option<String>) -> Thresholds {
+    let tools = var("ADMISSION_TOOLS").unwrap_or_else(|| DEFAULT_HEAVY_TOOLS.to_string());
+    Thresholds {
+        tools: tools.split(';').map(str::trim).filter(|t| !t.is_empty()).map(String::from).collect(),
+        max_load: var("ADMISSION_MAX_LOAD").and_then(|s| s.trim().parse().ok()).filter(|&l: &f64| l > 0.0),
+        min_free_memory: var("ADMISSION_MIN_FREE_MB")
+            .and_then(|s| s.trim().parse::<u64>().ok())
+            .map(|mb| mb * 1024 * 1024),
+        queue: Duration::from_millis(var("ADMISSION_QUEUE_MS").and_then(|s| s.trim().parse().ok()).unwrap_or(0)),
+    }
+}
+
+/// Wait until the host has room for a tool's execution. Returns the reason the host is busy if it
+/// still has none once the queue time is up. Tools that aren't heavy are always admitted.
+pub fn admit(tool: &str) -> Result<(), String> {
+    admit_impl(tool, &THRESHOLDS, host_load)
+}
+
+/// Internal implementation for testability - takes the thresholds and a host reading as parameters.
+fn admit_impl(tool: &str, thresholds: &Thresholds, mut host: impl FnMut() -> HostLoad) -> Result<(), String> {
+    if !thresholds.tools.iter().any(|t| t == tool) {
+        return Ok(());
+    }
+    let deadline = Instant::now() + thresholds.queue;
+    let mut queued: Option<QueuedGuard> = None;
+    loop {
+        let reason = match busy_reason(thresholds, &host()) {
+            Some(reason) => reason,
+            None => return Ok(()),
+        };
+        let now = Instant::now();
+        if now >= deadline {
+            return Err(reason);
+        }
+        match queued {
+            Some(ref queued) => queued.update(&reason),
+            None => queued = Some(QueuedGuard::new(tool, &reason)),
+        }
+        tracing::info!("Queueing {}: {}", tool, reason);
+        progress::report(&format!("Queued {}: {}", tool, reason));
+        thread::sleep(POLL_INTERVAL.min(deadline - now));
+    }
+}
+
+/// Why the host is too busy for a heavy execution, or None if it has room. Readings the host
+/// doesn't provide never hold an execution back.
+fn busy_reason(thresholds: &Thresholds, host: &HostLoad) -> Option<String> {
+    if let (Some(max), Some(load)) = (thresholds.max_load, host.load) {
+        if load > max {
+            return Some(format!("load average {:.2} per CPU is above {:.2}", load, max));
+        }
+    }
+    if let (Some(min), Some(free)) = (thresholds.min_free_memory, host.free_memory) {
+        if free < min {
+            return Some(format!("{} memory available, {} required", format_bytes(free), format_bytes(min)));
+        }
+    }
+    None
+}
+
+/// The host's load per CPU and available memory, from /proc on Linux
+fn host_load() -> HostLoad {
+    let cpus = thread::available_parallelism().map_or(1, |n| n.get()) as f64;
+    let load = std::fs::read_to_string("/proc/loadavg")
+        .ok()
+        .and_then(|loadavg| loadavg.split_whitespace().next().and_then(|l| l.parse::<f64>().ok()))
+        .map(|load| load / cpus);
+    let free_memory = std::fs::read_to_string("/proc/meminfo").ok().and_then(|m| parse_meminfo_available(&m));
+    HostLoad { load, free_memory }
+}
+
+/// Parse the MemAvailable line of /proc/meminfo into bytes
+fn parse_meminfo_available(meminfo: &str) -> Option<u64> {
+    meminfo
+        .lines()
+        .find(|line| line.starts_with("MemAvailable:"))
+        .and_then(|line| line.split_whitespace().nth(1))
+        .and_then(|kb| kb.parse::<u64>().ok())
+        .map(|kb| kb * 1024)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const GIB: u64 = 1024 * 1024 * 1024;
+
+    fn thresholds(queue: Duration) -> Thresholds {
+        Thresholds {
+            tools: vec!["presubmit".to_string()],
+            max_load: Some(1.5),
+            min_free_memory: Some(4 * GIB),
+            queue,
+        }
+    }
+
+    fn host(load: f64, free_gib: u64) -> HostLoad {
+        HostLoad {
+            load: Some(load),
+            free_memory: Some(free_gib * GIB),
+        }
+    }
+
+    #[test]
+    fn test_load_thresholds() {
+        let vars = |var: &str| match var {
+            "ADMISSION_MAX_LOAD" => Some("1.5".to_string()),
+            "ADMISSION_MIN_FREE_MB" => Some("4096".to_string()),
+            "ADMISSION_QUEUE_MS" => Some("30000".to_string()),
+            _ => None,
+        };
+        assert_eq!(
+            load_thresholds(vars),
+            Thresholds {
+                tools: vec!["presubmit".to_string(), "debug_test".to_string()],
+                max_load: Some(1.5),
+                min_free_memory: Some(4 * GIB),
+                queue: Duration::from_secs(30),
+            }
+        );
+        let unset = load_thresholds(|_| None);
+        assert_eq!((unset.max_load, unset.min_free_memory), (None, None));
+    }
+
+    #[test]
+    fn test_busy_reason() {
+        let limits = thresholds(Duration::ZERO);
+        assert_eq!(busy_reason(&limits, &host(0.5, 8)), None);
+        assert_eq!(
+            busy_reason(&limits, &host(2.0, 8)),
+            Some("load average 2.00 per CPU is above 1.50".to_string())
+        );
+        assert_eq!(
+            busy_reason(&limits, &host(0.5, 1)),
+            Some("1.0 GiB memory available, 4.0 GiB required".to_string())
+        );
+        let unknown = HostLoad {
+            load: None,
+            free_memory: None,
+        };
+        assert_eq!(busy_reason(&limits, &unknown), None);
+    }
+
+    #[test]
+    fn test_admit_rejects_busy_host() {
+        let limits = thresholds(Duration::ZERO);
+        assert!(admit_impl("presubmit", &limits, || host(3.0, 8)).is_err());
+        // Light tools aren't held back
+        assert!(admit_impl("ls_tool", &limits, || host(3.0, 8)).is_ok());
+    }
+
+    #[test]
+    fn test_admit_queues_until_host_calms() {
+        let limits = thresholds(Duration::from_secs(5));
+        let mut readings = vec![host(0.5, 8), host(3.0, 8)];
+        let started = Instant::now();
+        let mut listed = Vec::new();
+        let read = || {
+            listed.push(queued().iter().any(|q| q.tool == "presubmit" && q.reason.starts_with("load average")));
+            readings.pop().unwrap()
+        };
+        assert!(admit_impl("presubmit", &limits, read).is_ok());
+        assert!(started.elapsed() >= POLL_INTERVAL);
+        // Listed while it waits, and no longer once admitted
+        assert_eq!(listed, [false, true]);
+        assert!(!queued().iter().any(|q| q.tool == "presubmit"));
+    }
+
+    #[test]
+    fn test_slots_unlimited() {
+        let slots = Slots::new("command", None, Duration::ZERO);
+        let held: Vec<_> = (0..10).map(|_| slots.acquire("presubmit", None).unwrap()).collect();
+        assert_eq!(held.len(), 10);
+    }
+
+    #[test]
+    fn test_slots_time_out_when_full() {
+        let slots = Slots::new("command", Some(1), Duration::from_millis(50));
+        let held = slots.acquire("presubmit", None).unwrap();
+        let err = slots.acquire("presubmit", None).unwrap_err();
+        assert!(err.starts_with("1 of 1 command slots in use, 0 waiting ahead; gave up after"), "{}", err);
+        // Once the slot is given back it can be taken again
+        drop(held);
+        assert!(slots.acquire("presubmit", Some(Duration::ZERO)).is_ok());
+    }
+
+    #[test]
+    fn test_slots_queue_until_freed() {
+        let slots = Slots::new("bazel_cache", Some(2), Duration::from_secs(10));
+        let first = slots.acquire("bazel_cache", None).unwrap();
+        let _second = slots.acquire("bazel_cache", None).unwrap();
+        thread::scope(|scope| {
+            let waiter = scope.spawn(|| slots.acquire("bazel_cache", None).map(|_| ()));
+            while !queued().iter().any(|q| q.tool == "bazel_cache") {
+                thread::sleep(Duration::from_millis(10));
+            }
+            assert!(queued().iter().any(|q| q.reason == "2 of 2 bazel_cache slots in use, 0 waiting ahead"));
+            drop(first);
+            assert_eq!(waiter.join().unwrap(), Ok(()));
+        });
+        assert!(!queued().iter().any(|q| q.tool == "bazel_cache"));
+    }
+
+    #[test]
+    fn test_is_interactive() {
+        let interactive: Vec<String> = DEFAULT_INTERACTIVE_COMMANDS.split(';').map(String::from).collect();
+        let programs = |names: &[&str]| names.iter().map(|s| s.to_string()).collect::<Vec<_>>();
+        assert!(is_interactive_impl(&programs(&["ls"]), &interactive));
+        assert!(is_interactive_impl(&programs(&["/usr/bin/cat", "grep", "wc"]), &interactive));
+        assert!(!is_interactive_impl(&programs(&["bazel"]), &interactive));
+        assert!(!is_interactive_impl(&programs(&["cat", "sort"]), &interactive));
+        assert!(!is_interactive_impl(&[], &interactive));
+        assert!(!is_interactive_impl(&programs(&["ls"]), &[]));
+    }
+
+    #[test]
+    fn test_load_tool_limits() {
+        assert_eq!(
+            load_tool_limits("presubmit=1; pipeline = 8;;bazel_cache=0;git=two;=3"),
+            [("presubmit".to_string(), 1), ("pipeline".to_string(), 8)]
+        );
+        assert!(load_tool_limits("").is_empty());
+    }
+
+    #[test]
+    fn test_parse_meminfo_available() {
+        let meminfo = "MemTotal:       16318192 kB\nMemFree:          402152 kB\nMemAvailable:    8159096 kB\n";
+        assert_eq!(parse_meminfo_available(meminfo), Some(8159096 * 1024));
+        assert_eq!(parse_meminfo_available("MemTotal: 1 kB\n"), None);
+    }
+}
@@ -0,0 +1,303 @@
+use serde_json::Value;
+use std::collections::{HashSet, VecDeque};
+use std::path::Path;
+use std::sync::{LazyLock, Mutex};
+use std::time::{Duration, Instant};
+
+/// Window over which calls are counted when ANOMALY_WINDOW_SECS isn't set
+const DEFAULT_WINDOW: Duration = Duration::from_secs(60);
+
+/// Limits on a session's recent activity. Each process serves one session, so they apply to the whole process.
+#[derive(Debug, Clone, Default, PartialEq)]
+struct Guards {
+    /// Most calls in the window
+    max_calls: Option<usize>,
+    /// Most distinct paths touched in the window
+    max_paths: Option<usize>,
+    /// Most rejected calls in the window
+    max_denials: Option<usize>,
+    window: Duration,
+    /// Reject every later call once a limit is passed
+    block: bool,
+}
+
+/// Anomaly guards loaded at startup. ANOMALY_MAX_CALLS, ANOMALY_MAX_PATHS and ANOMALY_MAX_DENIALS set
+/// the limits over ANOMALY_WINDOW_SECS (default 60); with none set nothing is checked.
+/// ANOMALY_BLOCK=1 also blocks the session once a limit is passed.
+static GUARDS: LazyLock<Guards> = LazyLock::new(|| load_guards(|var| std::env::var(var).ok()));
+
+/// Decoy paths loaded from HONEYPOT_PATHS at startup, semicolon-separated, e.g., "/home/dev/.aws/credentials".
+/// Nothing legitimate uses them, so a call touching one blocks the session at once.
+static HONEYPOTS: LazyLock<Vec<String>> = LazyLock::new(|| {
+    std::env::var("HONEYPOT_PATHS")
+        .unwrap_or_default()
+        .split(';')
+        .map(str::trim)
+        .filter(|s| !s.is_empty())
+        .map(|s| Path::new(s).canonicalize().map_or_else(|_| s.to_string(), |p| p.to_string_lossy().to_string()))
+        .collect()
+});
+
+static ACTIVITY: Mutex<Option<Activity>> = Mutex::new(None);
+
+/// A session's calls in the current window and the anomalies already reported
+#[derive(Debug, Default)]
+struct Activity {
+    calls: VecDeque<Call>,
+    /// Anomalies reported since their counts were last back within the limits, so each is reported once
+    reported: HashSet<Kind>,
+    /// Why the session is blocked, if it is
+    blocked: Option<String>,
+}
+
+#[derive(Debug)]
+struct Call {
+    at: Instant,
+    paths: Vec<String>,
+    denied: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+enum Kind {
+    Calls,
+    Paths,
+    Denials,
+}
+
+/// Internal implementation for testability - takes a variable lookup as parameter.
+fn load_guards(var: impl Fn(&str) -> Option<String>) -> Guards {
+    let limit = |name: &str| var(name).and_then(|s| s.trim().parse::<usize>().ok()).filter(|&n| n > 0);
+    Guards {
+        max_calls: limit("ANOMALY_MAX_CALLS"),
+        max_paths: limit("ANOMALY_MAX_PATHS"),
+        max_denials: limit("ANOMALY_MAX_DENIALS"),
+        window: var("ANOMALY_WINDOW_SECS")
+            .and_then(|s| s.trim().parse().ok())
+            .map_or(DEFAULT_WINDOW, Duration::from_secs),
+        block: var("ANOMALY_BLOCK").is_some_and(|s| matches!(s.trim(), "1" | "true")),
+    }
+}
+
+/// Why the session is blocked, if an anomaly blocked it
+pub fn blocked_reason() -> Option<String> {
+    let activity = ACTIVITY.lock().unwrap_or_else(|e| e.into_inner());
+    activity.as_ref()?.blocked.clone()
+}
+
+/// Block the session if a request touches a decoy path, returning why. The caller alerts on it.
+pub fn trip_honeypot(input: &Value) -> Option<String> {
+    let honeypot = find_honeypot(input, &HONEYPOTS)?;
+    let reason = format!("touched decoy path {}", honeypot);
+    let mut activity = ACTIVITY.lock().unwrap_or_else(|e| e.into_inner());
+    let activity = activity.get_or_insert_with(Activity::default);
+    activity.blocked.get_or_insert_with(|| reason.clone());
+    Some(reason)
+}
+
+/// Internal implementation for testability - takes the honeypots as parameter.
+/// Any string in the request counts, not just path parameters, so a decoy passed in a command's
+/// arguments or an env value is caught too. Relative paths are resolved against the working directory.
+fn find_honeypot(input: &Value, honeypots: &[String]) -> Option<String> {
+    if honeypots.is_empty() {
+        return None;
+    }
+    let working_dir = input.get("working_dir").and_then(Value::as_str);
+    let mut strings = Vec::new();
+    collect_strings(input, &mut strings);
+    strings.into_iter().find_map(|s| {
+        let resolved = match working_dir {
+            Some(dir) if !s.starts_with('/') => Path::new(dir).join(s),
+            _ => Path::new(s).to_path_buf(),
+        };
+        // Canonicalized so a symlink to a decoy counts as the decoy
+        let resolved = resolved.canonicalize().unwrap_or(resolved);
+        let resolved = resolved.to_string_lossy();
+        honeypots
+            .iter()
+            .find(|honeypot| {
+                resolved == honeypot.as_str()
+                    || resolved.starts_with(&format!("{}/", honeypot))
+                    || s.contains(honeypot.as_str())
+            })
+            .cloned()
+    })
+}
+
+fn collect_strings<'a>(value: &'a Value, strings: &mut Vec<&'a str>) {
+    match value {
+        Value::String(s) => strings.push(s),
+        Value::Array(items) => items.iter().for_each(|item| collect_strings(item, strings)),
+        Value::Object(fields) => fields.values().for_each(|value| collect_strings(value, strings)),
+        _ => {}
+    }
+}
+
+/// Count a finished call, returning the anomalies it newly trips. The caller alerts on them.
+/// `denied` is true when the request was rejected before running.
+pub fn observe(input: &Value, denied: bool) -> Vec<String> {
+    let guards = &*GUARDS;
+    if guards.max_calls.is_none() && guards.max_paths.is_none() && guards.max_denials.is_none() {
+        return Vec::new();
+    }
+    let mut activity = ACTIVITY.lock().unwrap_or_else(|e| e.into_inner());
+    observe_in(activity.get_or_insert_with(Activity::default), guards, input, denied, Instant::now())
+}
+
+fn observe_in(activity: &mut Activity, guards: &Guards, input: &Value, denied: bool, now: Instant) -> Vec<String> {
+    while activity.calls.front().is_some_and(|call| now.duration_since(call.at) > guards.window) {
+        activity.calls.pop_front();
+    }
+    let mut paths = Vec::new();
+    collect_paths(input, false, &mut paths);
+    activity.calls.push_back(Call { at: now, paths, denied });
+
+    let calls = activity.calls.len();
+    let paths: HashSet<&str> = activity.calls.iter().flat_map(|call| call.paths.iter().map(String::as_str)).collect();
+    let denials = activity.calls.iter().filter(|call| call.denied).count();
+    let window = guards.window.as_secs();
+    let checks = [
+        (Kind::Calls, guards.max_calls, calls, "calls"),
+        (Kind::Paths, guards.max_paths, paths.len(), "distinct paths"),
+        (Kind::Denials, guards.max_denials, denials, "rejected calls"),
+    ];
+    let mut anomalies = Vec::new();
+    for (kind, limit, count, what) in checks {
+        let Some(limit) = limit else {
+            continue;
+        };
+        if count <= limit {
+            activity.reported.remove(&kind);
+        } else if activity.reported.insert(kind) {
+            anomalies.push(format!("{} {} in the last {}s, above the limit of {}", count, what, window, limit));
+        }
+    }
+    if guards.block && activity.blocked.is_none() {
+        activity.blocked = anomalies.first().cloned();
+    }
+    anomalies
+}
+
+/// Paths a request touches: its working directory and the values of fields named like paths, files or directories
+fn collect_paths(value: &Value, in_path_field: bool, paths: &mut Vec<String>) {
+    match value {
+        Value::String(s) if in_path_field && !s.is_empty() => paths.push(s.clone()),
+        Value::Array(items) => {
+            for item in items {
+                collect_paths(item, in_path_field, paths);
+            }
+        }
+        Value::Object(fields) => {
+            for (key, value) in fields {
+                let is_path = ["path", "dir", "file"].iter().any(|word| key.contains(word));
+                collect_paths(value, is_path, paths);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn guards(block: bool) -> Guards {
+        Guards {
+            max_calls: Some(3),
+            max_paths: Some(2),
+            max_denials: Some(1),
+            window: Duration::from_secs(60),
+            block,
+        }
+    }
+
+    #[test]
+    fn test_load_guards() {
+        let vars = |var: &str| match var {
+            "ANOMALY_MAX_CALLS" => Some("100".to_string()),
+            "ANOMALY_MAX_DENIALS" => Some("0".to_string()),
+            "ANOMALY_BLOCK" => Some("1".to_string()),
+            _ => None,
+        };
+        assert_eq!(
+            load_guards(vars),
+            Guards {
+                max_calls: Some(100),
+                max_paths: None,
+                max_denials: None,
+                window: DEFAULT_WINDOW,
+                block: true,
+            }
+        );
+    }
+
+    #[test]
+    fn test_observe_reports_call_volume_once() {
+        let mut activity = Activity::default();
+        let start = Instant::now();
+        let input = json!({"subcommand": "status"});
+        for i in 0..3 {
+            let at = start + Duration::from_secs(i);
+            assert!(observe_in(&mut activity, &guards(false), &input, false, at).is_empty());
+        }
+        let anomalies = observe_in(&mut activity, &guards(false), &input, false, start + Duration::from_secs(3));
+        assert_eq!(anomalies, vec!["4 calls in the last 60s, above the limit of 3"]);
+        assert!(observe_in(&mut activity, &guards(false), &input, false, start + Duration::from_secs(4)).is_empty());
+        assert!(activity.blocked.is_none());
+        // Once the window has passed, the count is back within the limit and can be reported again
+        let later = start + Duration::from_secs(120);
+        assert!(observe_in(&mut activity, &guards(false), &input, false, later).is_empty());
+        assert!(!activity.reported.contains(&Kind::Calls));
+    }
+
+    #[test]
+    fn test_observe_paths_and_denials_block() {
+        let mut activity = Activity::default();
+        let now = Instant::now();
+        let first = json!({"working_dir": "/src/app", "path": "/etc"});
+        assert!(observe_in(&mut activity, &guards(true), &first, true, now).is_empty());
+        let second = json!({"paths": ["/root/.ssh", "/etc"]});
+        assert_eq!(
+            observe_in(&mut activity, &guards(true), &second, true, now),
+            vec![
+                "3 distinct paths in the last 60s, above the limit of 2",
+                "2 rejected calls in the last 60s, above the limit of 1"
+            ]
+        );
+        assert_eq!(activity.blocked.as_deref(), Some("3 distinct paths in the last 60s, above the limit of 2"));
+    }
+
+    #[test]
+    fn test_find_honeypot() {
+        let dir = tempfile::TempDir::new().unwrap();
+        let decoy = dir.path().join(".aws/credentials");
+        std::fs::create_dir_all(decoy.parent().unwrap()).unwrap();
+        std::fs::write(&decoy, "[default]\n").unwrap();
+        let honeypots = vec![decoy.canonicalize().unwrap().to_string_lossy().to_string()];
+        let workspace = dir.path().to_string_lossy().to_string();
+
+        let direct = json!({"path": decoy.to_string_lossy()});
+        assert_eq!(find_honeypot(&direct, &honeypots), Some(honeypots[0].clone()));
+        let relative = json!({"working_dir": workspace, "args": ["--config", ".aws/credentials"]});
+        assert_eq!(find_honeypot(&relative, &honeypots), Some(honeypots[0].clone()));
+        #[cfg(unix)]
+        {
+            std::os::unix::fs::symlink(&decoy, dir.path().join("innocent")).unwrap();
+            let linked = json!({"working_dir": workspace, "path": "innocent"});
+            assert_eq!(find_honeypot(&linked, &honeypots), Some(honeypots[0].clone()));
+        }
+        let embedded = json!({"env": {"CREDS": format!("file={}", honeypots[0])}});
+        assert_eq!(find_honeypot(&embedded, &honeypots), Some(honeypots[0].clone()));
+        let innocent = json!({"working_dir": workspace, "path": ".aws"});
+        assert_eq!(find_honeypot(&innocent, &honeypots), None);
+    }
+
+    #[test]
+    fn test_collect_paths() {
+        let mut paths = Vec::new();
+        let input = json!({"working_dir": "/src", "files": ["a.txt", "b.txt"], "pattern": "*.rs", "env": {"X": "/x"}});
+        collect_paths(&input, false, &mut paths);
+        paths.sort();
+        assert_eq!(paths, vec!["/src", "a.txt", "b.txt"]);
+    }
+}
@@ -0,0 +1,287 @@
+use std::io::Write;
+use std::net::{TcpStream, UdpSocket};
+use std::path::PathBuf;
+use std::sync::mpsc;
+use std::sync::LazyLock;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::events;
+use crate::grants::Grant;
+use crate::labels;
+use crate::transcript::{self, TranscriptEntry};
+
+/// Syslog facility of audit records: log audit
+const FACILITY: u8 = 13;
+
+/// Seconds to wait for a syslog server to accept a TCP connection
+const CONNECT_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Where audit records are sent
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Sink {
+    Udp(String),
+    /// Messages are framed by octet counting (RFC 6587)
+    Tcp(String),
+    /// The local syslog daemon's datagram socket, e.g., /dev/log
+    Unix(PathBuf),
+}
+
+/// Audit sink loaded from AUDIT_SYSLOG at startup: udp://host:514, tcp://host:601 or unix:///dev/log.
+/// Unset or unparseable, no audit records are sent.
+static SINK: LazyLock<Option<Sink>> =
+    LazyLock::new(|| std::env::var("AUDIT_SYSLOG").ok().and_then(|url| parse_sink(&url)));
+
+/// Records waiting to be sent, in call order, by a single thread that keeps its connection open
+static QUEUE: LazyLock<Option<mpsc::Sender<String>>> = LazyLock::new(|| {
+    let sink = SINK.clone()?;
+    let (tx, rx) = mpsc::channel::<String>();
+    std::thread::spawn(move || {
+        let mut connection = None;
+        for message in rx {
+            if let Err(e) = send(&sink, &mut connection, &message) {
+                tracing::warn!("Failed to send audit record to syslog: {}", e);
+                connection = None;
+            }
+        }
+    });
+    Some(tx)
+});
+
+fn parse_sink(url: &str) -> Option<Sink> {
+    let (scheme, address) = url.trim().split_once("://")?;
+    if address.is_empty() {
+        return None;
+    }
+    match scheme {
+        "udp" => Some(Sink::Udp(address.to_string())),
+        "tcp" => Some(Sink::Tcp(address.to_string())),
+        "unix" => Some(Sink::Unix(PathBuf::from(address))),
+        _ => None,
+    }
+}
+
+/// Send a finished tool call to the SIEM as a syslog message carrying a CEF record.
+/// `denied` is true when the request was rejected before running; `grants` are those that allowed it.
+pub fn export(entry: &TranscriptEntry, denied: bool, grants: &[Grant]) {
+    if let Some(queue) = QUEUE.as_ref() {
+        let time_ms = SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_millis());
+        let _ = queue.send(syslog_message(entry, denied, grants, time_ms));
+    }
+}
+
+/// How a call ended, with its syslog and CEF severities
+fn outcome(entry: &TranscriptEntry, denied: bool) -> (&'static str, u8, u8) {
+    match (denied, entry.status) {
+        (true, _) => ("denied", 4, 7),
+        (false, "error") => ("failed", 5, 5),
+        _ => ("succeeded", 6, 3),
+    }
+}
+
+/// An RFC 5424 syslog message whose text is the call's CEF record
+fn syslog_message(entry: &TranscriptEntry, denied: bool, grants: &[Grant], time_ms: u128) -> String {
+    let (_, syslog_severity, _) = outcome(entry, denied);
+    format!(
+        "<{}>1 {} {} {} {} - - {}",
+        FACILITY * 8 + syslog_severity,
+        entry.time,
+        events::source(),
+        env!("CARGO_PKG_NAME"),
+        std::process::id(),
+        cef_record(entry, denied, grants, time_ms)
+    )
+}
+
+/// A CEF record of a tool call. The input is the transcript's, with environment variable values redacted.
+fn cef_record(entry: &TranscriptEntry, denied: bool, grants: &[Grant], time_ms: u128) -> String {
+    let (action, _, severity) = outcome(entry, denied);
+    let mut extensions = vec![
+        ("rt", time_ms.to_string()),
+        ("act", action.to_string()),
+        ("suser", std::env::var("USER").unwrap_or_default()),
+        ("cs1Label", "session".to_string()),
+        ("cs1", transcript::session_id().to_string()),
+        ("cs2Label", "input".to_string()),
+        ("cs2", entry.input.to_string()),
+        ("cn1Label", "call".to_string()),
+        ("cn1", entry.call.to_string()),
+        ("cn2Label", "errors".to_string()),
+        ("cn2", entry.errors.to_string()),
+        ("msg", entry.summary.clone()),
+    ];
+    if !entry.labels.is_empty() {
+        extensions.push(("cs4Label", "labels".to_string()));
+        extensions.push(("cs4", labels::format(&entry.labels)));
+    }
+    if !grants.is_empty() {
+        let grants: Vec<String> = grants.iter().map(Grant::describe).collect();
+        extensions.push(("cs3Label", "grants".to_string()));
+        extensions.push(("cs3", grants.join("; ")));
+    }
+    let extensions: Vec<String> = extensions
+        .iter()
+        .map(|(key, value)| format!("{}={}", key, escape_extension(value)))
+        .collect();
+    format!(
+        "CEF:0|command-runner|{}|{}|{}|{} {}|{}|{}",
+        env!("CARGO_PKG_NAME"),
+        env!("CARGO_PKG_VERSION"),
+        escape_header(&entry.tool),
+        escape_header(&entry.tool),
+        action,
+        severity,
+        extensions.join(" ")
+    )
+}
+
+/// Escape a CEF header field, where backslashes and pipes are special
+fn escape_header(value: &str) -> String {
+    value.replace('\\', "\\\\").replace('|', "\\|")
+}
+
+/// Escape a CEF extension value, where backslashes, equals signs and line breaks are special
+fn escape_extension(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('=', "\\=")
+        .replace('\r', "\\r")
+        .replace('\n', "\\n")
+}
+
+/// Send one message. A TCP connection is opened on first use and kept in `connection` for the next.
+fn send(sink: &Sink, connection: &mut Option<TcpStream>, message: &str) -> std::io::Result<()> {
+    match sink {
+        Sink::Udp(address) => {
+            let socket = UdpSocket::bind("0.0.0.0:0")?;
+            socket.send_to(message.as_bytes(), address.as_str())?;
+        }
+        Sink::Tcp(address) => {
+            if connection.is_none() {
+                *connection = Some(connect(address)?);
+            }
+            if let Some(stream) = connection {
+                write!(stream, "{} {}", message.len(), message)?;
+            }
+        }
+        #[cfg(unix)]
+        Sink::Unix(path) => {
+            let socket = std::os::unix::net::UnixDatagram::unbound()?;
+            socket.send_to(message.as_bytes(), path)?;
+        }
+        #[cfg(not(unix))]
+        Sink::Unix(_) => {
+            let message = "Unix sockets are only supported on Unix";
+            return Err(std::io::Error::new(std::io::ErrorKind::Unsupported, message));
+        }
+    }
+    Ok(())
+}
+
+fn connect(address: &str) -> std::io::Result<TcpStream> {
+    use std::net::ToSocketAddrs;
+    let mut last_error = std::io::Error::other(format!("{} did not resolve", address));
+    for addr in address.to_socket_addrs()? {
+        match TcpStream::connect_timeout(&addr, CONNECT_TIMEOUT) {
+            Ok(stream) => return Ok(stream),
+            Err(e) => last_error = e,
+        }
+    }
+    Err(last_error)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::grants::Access;
+    use serde_json::json;
+
+    fn entry(status: &'static str) -> TranscriptEntry {
+        TranscriptEntry {
+            call: 3,
+            time: "2026-10-15T12:00:00Z".to_string(),
+            tool: "git".to_string(),
+            input: json!({"subcommand": "status", "env": {"TOKEN": "[REDACTED]"}}),
+            status,
+            summary: "On branch main".to_string(),
+            lines: 2,
+            errors: 0,
+            warnings: 0,
+            labels: labels::Labels::new(),
+        }
+    }
+
+    #[test]
+    fn test_parse_sink() {
+        assert_eq!(parse_sink("udp://siem:514"), Some(Sink::Udp("siem:514".to_string())));
+        assert_eq!(parse_sink("tcp://siem:601"), Some(Sink::Tcp("siem:601".to_string())));
+        assert_eq!(parse_sink("unix:///dev/log"), Some(Sink::Unix(PathBuf::from("/dev/log"))));
+        assert_eq!(parse_sink("tls://siem:6514"), None);
+        assert_eq!(parse_sink("siem:514"), None);
+    }
+
+    #[test]
+    fn test_cef_record() {
+        let record = cef_record(&entry("ok"), false, &[], 1760529600000);
+        let header = format!(
+            "CEF:0|command-runner|{}|{}|git|git succeeded|3|",
+            env!("CARGO_PKG_NAME"),
+            env!("CARGO_PKG_VERSION")
+        );
+        assert!(record.starts_with(&header), "{}", record);
+        assert!(record.contains(" act=succeeded "));
+        assert!(record.contains(r#" cs2={"env":{"TOKEN":"[REDACTED]"},"subcommand":"status"} "#), "{}", record);
+        assert!(record.ends_with(" cn1=3 cn2Label=errors cn2=0 msg=On branch main"));
+        assert!(cef_record(&entry("ok"), true, &[], 0).contains("|git denied|7|"));
+        assert!(cef_record(&entry("error"), false, &[], 0).contains("|git failed|5|"));
+    }
+
+    #[test]
+    fn test_cef_record_labels() {
+        let mut entry = entry("ok");
+        entry.labels.insert("task".to_string(), "T-42".to_string());
+        entry.labels.insert("agent".to_string(), "reviewer".to_string());
+        let record = cef_record(&entry, false, &[], 0);
+        assert!(record.ends_with(" cs4Label=labels cs4=agent\\=reviewer,task\\=T-42"), "{}", record);
+    }
+
+    #[test]
+    fn test_cef_record_notes_grants() {
+        let grant = Grant {
+            session: "*".to_string(),
+            access: Access::Tool("download".to_string()),
+            expires: 1800,
+            reason: "hotfix".to_string(),
+        };
+        let record = cef_record(&entry("ok"), false, &[grant], 0);
+        let grants = " cs3Label=grants cs3=tool:download until 1970-01-01T00:30:00Z (hotfix)";
+        assert!(record.ends_with(grants), "{}", record);
+    }
+
+    #[test]
+    fn test_syslog_message() {
+        let message = syslog_message(&entry("ok"), true, &[], 0);
+        let prefix = format!("<108>1 2026-10-15T12:00:00Z {} {} ", events::source(), env!("CARGO_PKG_NAME"));
+        assert!(message.starts_with(&prefix), "{}", message);
+        assert!(message.contains(" - - CEF:0|"));
+    }
+
+    #[test]
+    fn test_escape() {
+        assert_eq!(escape_header(r"a|b\c"), r"a\|b\\c");
+        assert_eq!(escape_extension("a=b\nc\\"), r"a\=b\nc\\");
+    }
+
+    #[test]
+    fn test_send_tcp_frames_messages() {
+        use std::io::Read;
+        let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+        let sink = Sink::Tcp(listener.local_addr().unwrap().to_string());
+        let mut connection = None;
+        send(&sink, &mut connection, "first").unwrap();
+        send(&sink, &mut connection, "second").unwrap();
+        drop(connection);
+        let mut received = String::new();
+        listener.accept().unwrap().0.read_to_string(&mut received).unwrap();
+        assert_eq!(received, "5 first6 second");
+    }
+}
@@ -0,0 +1,217 @@
+use std::io;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::LazyLock;
+use std::thread;
+use std::time::Duration;
+
+/// Period of the CPU quota written to cpu.max, in microseconds
+const CPU_PERIOD_US: u64 = 100_000;
+
+/// Attempts at removing a cgroup while its killed processes exit
+const REMOVE_ATTEMPTS: u32 = 50;
+
+/// How long to wait between attempts at removing a cgroup
+const REMOVE_INTERVAL: Duration = Duration::from_millis(20);
+
+/// Where and how commands are confined
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Confinement {
+    /// Delegated cgroup v2 directory the per-command cgroups are created in
+    pub parent: PathBuf,
+    /// Value written to memory.max, in bytes or with a K, M or G suffix
+    pub memory_max: Option<String>,
+    /// CPUs the command may use, e.g., 1.5; written to cpu.max as a quota
+    pub cpus: Option<f64>,
+}
+
+/// Cgroup confinement loaded at startup. CGROUP_PARENT enables it, and CGROUP_MEMORY_MAX and
+/// CGROUP_CPUS set the limits.
+static CONFINEMENT: LazyLock<Option<Confinement>> =
+    LazyLock::new(|| load_confinement(|var| std::env::var(var).ok()));
+
+/// Cgroups created so far, to give each a unique name
+static CREATED: AtomicU64 = AtomicU64::new(0);
+
+/// Internal implementation for testability - takes a variable lookup as parameter.
+fn load_confinement(var: impl Fn(&str) -> Option<String>) -> Option<Confinement> {
+    let parent = var("CGROUP_PARENT").filter(|s| !s.trim().is_empty())?;
+    Some(Confinement {
+        parent: PathBuf::from(parent.trim()),
+        memory_max: var("CGROUP_MEMORY_MAX").map(|s| s.trim().to_string()).filter(|s| !s.is_empty()),
+        cpus: var("CGROUP_CPUS").and_then(|s| s.trim().parse().ok()).filter(|&cpus: &f64| cpus > 0.0),
+    })
+}
+
+/// The configured confinement, if commands are confined
+pub fn configured() -> Option<&'static Confinement> {
+    CONFINEMENT.as_ref()
+}
+
+/// A cgroup holding one command and its descendants. Dropping it kills anything still in it and removes it.
+#[derive(Debug)]
+pub struct Cgroup {
+    path: PathBuf,
+}
+
+impl Cgroup {
+    /// Create a cgroup for a command under the confinement's parent, with its limits set
+    pub fn create(confinement: &Confinement) -> io::Result<Self> {
+        let name = format!("command-{}-{}", std::process::id(), CREATED.fetch_add(1, Ordering::Relaxed));
+        let cgroup = Cgroup {
+            path: confinement.parent.join(name),
+        };
+        std::fs::create_dir(&cgroup.path)?;
+        // From here on, dropping the cgroup removes it again
+        if let Some(ref max) = confinement.memory_max {
+            cgroup.write("memory.max", max)?;
+        }
+        if let Some(cpus) = confinement.cpus {
+            cgroup.write("cpu.max", &cpu_max(cpus))?;
+        }
+        Ok(cgroup)
+    }
+
+    fn write(&self, file: &str, value: &str) -> io::Result<()> {
+        std::fs::write(self.path.join(file), value)
+            .map_err(|e| io::Error::new(e.kind(), format!("writing {} to {}: {}", value, file, e)))
+    }
+
+    /// Move a command into the cgroup as it starts, before it runs anything, so its descendants are
+    /// confined too. Setting it again for another attempt changes nothing.
+    #[cfg(unix)]
+    pub fn confine(&self, cmd: &mut Command) {
+        use std::ffi::CString;
+        use std::os::unix::ffi::OsStrExt;
+        use std::os::unix::process::CommandExt;
+
+        let Ok(procs) = CString::new(self.path.join("cgroup.procs").as_os_str().as_bytes()) else {
+            return;
+        };
+        // SAFETY: the hook only calls open, write and close, which are async-signal-safe, and doesn't allocate.
+        // Writing 0 to cgroup.procs moves the writing process.
+        unsafe {
+            cmd.pre_exec(move || {
+                let fd = libc::open(procs.as_ptr(), libc::O_WRONLY | libc::O_CLOEXEC);
+                if fd < 0 {
+                    return Err(io::Error::last_os_error());
+                }
+                let written = libc::write(fd, b"0".as_ptr().cast(), 1);
+                let error = io::Error::last_os_error();
+                libc::close(fd);
+                if written != 1 {
+                    return Err(error);
+                }
+                Ok(())
+            });
+        }
+    }
+
+    #[cfg(not(unix))]
+    pub fn confine(&self, _cmd: &mut Command) {}
+
+    /// Most memory the cgroup's processes have used at once, page cache included (memory.peak, Linux 5.19+)
+    pub fn memory_peak(&self) -> Option<u64> {
+        std::fs::read_to_string(self.path.join("memory.peak")).ok()?.trim().parse().ok()
+    }
+}
+
+impl Drop for Cgroup {
+    fn drop(&mut self) {
+        // Kill anything left behind, such as a daemon a build started (cgroup.kill, Linux 5.14+)
+        let _ = std::fs::write(self.path.join("cgroup.kill"), "1");
+        if let Err(e) = remove(&self.path) {
+            tracing::warn!("Failed to remove cgroup {}: {}", self.path.display(), e);
+        }
+    }
+}
+
+/// Remove a cgroup, waiting for its killed processes to exit; it can't be removed while it has any
+fn remove(path: &Path) -> io::Result<()> {
+    let mut attempts = 1;
+    loop {
+        match std::fs::remove_dir(path) {
+            Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+            Err(_) if attempts < REMOVE_ATTEMPTS => {
+                attempts += 1;
+                thread::sleep(REMOVE_INTERVAL);
+            }
+            result => return result,
+        }
+    }
+}
+
+/// The cpu.max value giving a cgroup `cpus` CPUs' worth of time
+fn cpu_max(cpus: f64) -> String {
+    format!("{} {}", (cpus * CPU_PERIOD_US as f64).round() as u64, CPU_PERIOD_US)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_load_confinement() {
+        let vars = |var: &str| match var {
+            "CGROUP_PARENT" => Some("/sys/fs/cgroup/command-runner".to_string()),
+            "CGROUP_MEMORY_MAX" => Some("4G".to_string()),
+            "CGROUP_CPUS" => Some("2".to_string()),
+            _ => None,
+        };
+        assert_eq!(
+            load_confinement(vars),
+            Some(Confinement {
+                parent: PathBuf::from("/sys/fs/cgroup/command-runner"),
+                memory_max: Some("4G".to_string()),
+                cpus: Some(2.0),
+            })
+        );
+        assert_eq!(load_confinement(|_| None), None);
+    }
+
+    #[test]
+    fn test_cpu_max() {
+        assert_eq!(cpu_max(2.0), "200000 100000");
+        assert_eq!(cpu_max(0.5), "50000 100000");
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_create_and_confine() {
+        // A plain directory stands in for the cgroup filesystem, which tests can't rely on
+        let parent = TempDir::new().unwrap();
+        let confinement = Confinement {
+            parent: parent.path().to_path_buf(),
+            memory_max: Some("4G".to_string()),
+            cpus: Some(1.5),
+        };
+        let cgroup = Cgroup::create(&confinement).unwrap();
+        assert_eq!(std::fs::read_to_string(cgroup.path.join("memory.max")).unwrap(), "4G");
+        assert_eq!(std::fs::read_to_string(cgroup.path.join("cpu.max")).unwrap(), "150000 100000");
+
+        std::fs::write(cgroup.path.join("cgroup.procs"), "").unwrap();
+        let mut cmd = Command::new("true");
+        cgroup.confine(&mut cmd);
+        assert!(cmd.status().unwrap().success());
+        assert_eq!(std::fs::read_to_string(cgroup.path.join("cgroup.procs")).unwrap(), "0");
+
+        assert_eq!(cgroup.memory_peak(), None);
+        std::fs::write(cgroup.path.join("memory.peak"), "1048576\n").unwrap();
+        assert_eq!(cgroup.memory_peak(), Some(1048576));
+        // The stand-in's files would keep it from being removed like a real cgroup
+        std::fs::remove_dir_all(&cgroup.path).unwrap();
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_confine_fails_without_cgroup() {
+        let cgroup = Cgroup {
+            path: PathBuf::from("/nonexistent/command-runner-test"),
+        };
+        let mut cmd = Command::new("true");
+        cgroup.confine(&mut cmd);
+        assert!(cmd.status().is_err());
+    }
+}
@@ -0,0 +1,173 @@
+use regex::Regex;
+use std::sync::LazyLock;
+
+/// Category reported when no rule matches the failure output
+pub const UNKNOWN_CATEGORY: &str = "unknown";
+
+/// Category reported when a command is killed for exceeding its timeout
+pub const TIMEOUT_CATEGORY: &str = "timeout";
+
+/// Built-in rules, checked in order after any custom rules. Infrastructure
+/// failures come first so automation can safely retry only those.
+const DEFAULT_RULES: &[(&str, &str)] = &[
+    (
+        "oom",
+        r"(?i)out of memory|OutOfMemoryError|cannot allocate memory|std::bad_alloc|memory allocation of \d+ bytes failed",
+    ),
+    (
+        "cache-miss-timeout",
+        r"(?i)remote cache.*(timed out|timeout|deadline)|DEADLINE_EXCEEDED",
+    ),
+    (
+        "flaky-infra",
+        r"(?i)connection (reset|refused|timed out)|network is unreachable|temporary failure in name resolution|could not resolve host|503 service unavailable|broken pipe",
+    ),
+    ("disk-full", r"(?i)no space left on device|disk quota exceeded"),
+    (
+        "compile-error",
+        r"(?i)error\[E\d{4}\]|could not compile|compilation failed|undefined reference to|cannot find symbol|\.(c|cc|cpp|h|hpp|go|java|rs|ts):\d+:\d+:( fatal)? error",
+    ),
+    (
+        "test-failure",
+        r"(?m)^--- FAIL:|^FAIL\s|test result: FAILED|AssertionError|\d+ tests? failed|panicked at",
+    ),
+    ("permission-denied", r"(?i)permission denied|operation not permitted"),
+    ("not-found", r"(?i)no such file or directory|did not match any file|not a git repository"),
+    ("conflict", r"(?i)merge conflict|would be overwritten by (merge|checkout)|CONFLICT \("),
+];
+
+/// A rule tagging failure output that matches `pattern` with `category`
+pub struct Rule {
+    category: String,
+    pattern: Regex,
+}
+
+impl Rule {
+    fn new(category: &str, pattern: &str) -> Option<Self> {
+        match Regex::new(pattern) {
+            Ok(pattern) => Some(Self {
+                category: category.to_string(),
+                pattern,
+            }),
+            Err(e) => {
+                tracing::warn!("Ignoring failure category rule '{}': {}", category, e);
+                None
+            }
+        }
+    }
+}
+
+/// Parse custom rules. Format: semicolon-separated "category=regex" pairs,
+/// e.g., "flaky-infra=Bazel server died;lint-error=clippy::"
+fn parse_rules(spec: &str) -> Vec<Rule> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .filter_map(|(category, pattern)| Rule::new(category.trim(), pattern))
+        .collect()
+}
+
+/// Classification rules: custom rules from FAILURE_CATEGORY_RULES (loaded at startup)
+/// take precedence over the built-in defaults.
+static RULES: LazyLock<Vec<Rule>> = LazyLock::new(|| {
+    let mut rules = parse_rules(&std::env::var("FAILURE_CATEGORY_RULES").unwrap_or_default());
+    rules.extend(
+        DEFAULT_RULES
+            .iter()
+            .filter_map(|(category, pattern)| Rule::new(category, pattern)),
+    );
+    rules
+});
+
+/// Internal implementation for testability - takes rules as parameter.
+fn classify_impl<'a>(output: &str, rules: &'a [Rule]) -> &'a str {
+    rules
+        .iter()
+        .find(|rule| rule.pattern.is_match(output))
+        .map(|rule| rule.category.as_str())
+        .unwrap_or(UNKNOWN_CATEGORY)
+}
+
+/// Classify failure output into a known failure category
+pub fn classify(output: &str) -> &'static str {
+    classify_impl(output, &RULES)
+}
+
+/// Whether a tool result reports a failure
+pub fn is_failure(output: &str) -> bool {
+    output.starts_with("Error") || output.contains("\nFailure category: ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn defaults() -> Vec<Rule> {
+        DEFAULT_RULES
+            .iter()
+            .filter_map(|(category, pattern)| Rule::new(category, pattern))
+            .collect()
+    }
+
+    #[test]
+    fn test_classify_compile_error() {
+        let output = "error[E0425]: cannot find value `x` in this scope";
+        assert_eq!(classify_impl(output, &defaults()), "compile-error");
+        let output = "src/main.cc:10:5: error: expected ';'";
+        assert_eq!(classify_impl(output, &defaults()), "compile-error");
+    }
+
+    #[test]
+    fn test_classify_test_failure() {
+        assert_eq!(classify_impl("--- FAIL: TestFoo (0.00s)", &defaults()), "test-failure");
+        assert_eq!(classify_impl("test result: FAILED. 1 passed; 1 failed", &defaults()), "test-failure");
+    }
+
+    #[test]
+    fn test_classify_oom() {
+        assert_eq!(classify_impl("java.lang.OutOfMemoryError: Java heap space", &defaults()), "oom");
+    }
+
+    #[test]
+    fn test_classify_flaky_infra() {
+        assert_eq!(classify_impl("fatal: unable to access: Could not resolve host: github.com", &defaults()), "flaky-infra");
+    }
+
+    #[test]
+    fn test_classify_cache_miss_timeout() {
+        assert_eq!(classify_impl("WARNING: Remote Cache: DEADLINE_EXCEEDED", &defaults()), "cache-miss-timeout");
+    }
+
+    #[test]
+    fn test_classify_infra_before_compile_error() {
+        // A compile step killed by the OOM killer is an infra failure, not a code problem
+        let output = "src/lib.rs:1:1: error: out of memory";
+        assert_eq!(classify_impl(output, &defaults()), "oom");
+    }
+
+    #[test]
+    fn test_classify_unknown() {
+        assert_eq!(classify_impl("something odd happened", &defaults()), UNKNOWN_CATEGORY);
+    }
+
+    #[test]
+    fn test_custom_rules_take_precedence() {
+        let mut rules = parse_rules("bazel-server-crash=Server terminated abruptly;lint-error=clippy::");
+        rules.extend(defaults());
+        assert_eq!(classify_impl("Server terminated abruptly (error code: 37)", &rules), "bazel-server-crash");
+        assert_eq!(classify_impl("warning: clippy::needless_return", &rules), "lint-error");
+    }
+
+    #[test]
+    fn test_parse_rules_skips_invalid_entries() {
+        let rules = parse_rules("no-equals-sign;bad=[unclosed;good=ok");
+        assert_eq!(rules.len(), 1);
+        assert_eq!(rules[0].category, "good");
+    }
+
+    #[test]
+    fn test_is_failure() {
+        assert!(is_failure("Error: Command timed out\nFailure category: timeout"));
+        assert!(is_failure("Verdict: FAIL\nlint: FAIL\n\n--- lint output ---\nError: x\nFailure category: unknown"));
+        assert!(!is_failure("Verdict: PASS"));
+    }
+}
@@ -0,0 +1,31 @@
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::OnceLock;
+
+/// Sends a log message to the client as an MCP logging notification, given the logger's name
+type Sink = Box<dyn Fn(&str, &str) + Send + Sync>;
+
+static SINK: OnceLock<Sink> = OnceLock::new();
+
+/// Whether the client wants info messages; it can raise its level past them with logging/setLevel
+static INFO_ENABLED: AtomicBool = AtomicBool::new(true);
+
+/// Send messages from work the server does on its own, such as scheduled cleanup, to the client through `sink`.
+/// Only the first sink is kept; messages logged before it is connected only go to the server log.
+pub fn connect(sink: impl Fn(&str, &str) + Send + Sync + 'static) {
+    let _ = SINK.set(Box::new(sink));
+}
+
+/// Set whether the client gets info messages, from the level it asked for
+pub fn set_info_enabled(enabled: bool) {
+    INFO_ENABLED.store(enabled, Ordering::Relaxed);
+}
+
+/// Log an info message to the server log and, if connected and it wants them, the client
+pub fn info(logger: &str, message: &str) {
+    tracing::info!("{}", message);
+    if INFO_ENABLED.load(Ordering::Relaxed) {
+        if let Some(sink) = SINK.get() {
+            sink(logger, message);
+        }
+    }
+}
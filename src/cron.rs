@@ -0,0 +1,150 @@
+use crate::transcript::civil_from_days;
+
+/// How far ahead `next_after` looks for a matching minute: four years, so a schedule for February 29 is found
+const HORIZON_SECS: u64 = 4 * 366 * 86_400;
+
+/// A cron schedule of five fields, minute hour day-of-month month day-of-week, in UTC, e.g.,
+/// "0 3 * * *" for 03:00 every day. Fields take `*`, numbers, ranges `a-b`, steps `*/n` or `a-b/n`,
+/// and comma-separated lists of these. Day of week runs 0-7, with both 0 and 7 Sunday.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Schedule {
+    /// Bit n is set if the field matches n
+    minutes: u64,
+    hours: u64,
+    days: u64,
+    months: u64,
+    weekdays: u64,
+    /// Whether day of month and day of week are `*`; when both are restricted, either may match
+    any_day: bool,
+    any_weekday: bool,
+}
+
+impl Schedule {
+    /// Parse a cron expression. Returns what is wrong with it if it is invalid.
+    pub fn parse(expression: &str) -> Result<Self, String> {
+        let fields: Vec<&str> = expression.split_whitespace().collect();
+        let [minute, hour, day, month, weekday] = fields[..] else {
+            return Err(format!("expected 5 fields (minute hour day month weekday), found {}", fields.len()));
+        };
+        let mut weekdays = parse_field(weekday, 0, 7)?;
+        // Sunday is both 0 and 7
+        if weekdays & (1 << 7) != 0 {
+            weekdays |= 1;
+        }
+        Ok(Schedule {
+            minutes: parse_field(minute, 0, 59)?,
+            hours: parse_field(hour, 0, 23)?,
+            days: parse_field(day, 1, 31)?,
+            months: parse_field(month, 1, 12)?,
+            weekdays,
+            any_day: day == "*",
+            any_weekday: weekday == "*",
+        })
+    }
+
+    /// The first minute strictly after `secs` the schedule matches, in seconds since the Unix epoch.
+    /// None if it never matches, e.g., "0 0 31 2 *".
+    pub fn next_after(&self, secs: u64) -> Option<u64> {
+        let end = secs.saturating_add(HORIZON_SECS);
+        let mut t = (secs / 60 + 1) * 60;
+        while t <= end {
+            let day = t / 86_400;
+            if !self.matches_day(day) {
+                t = (day + 1) * 86_400;
+                continue;
+            }
+            let hour = t % 86_400 / 3_600;
+            if self.hours & (1 << hour) == 0 {
+                t = (t / 3_600 + 1) * 3_600;
+                continue;
+            }
+            if self.minutes & (1 << (t % 3_600 / 60)) != 0 {
+                return Some(t);
+            }
+            t += 60;
+        }
+        None
+    }
+
+    /// Whether the schedule runs on a day counted from the Unix epoch
+    fn matches_day(&self, day: u64) -> bool {
+        let (_, month, day_of_month) = civil_from_days(day as i64);
+        if self.months & (1 << month) == 0 {
+            return false;
+        }
+        // The epoch was a Thursday
+        let weekday = (day + 4) % 7;
+        let day_matches = self.days & (1 << day_of_month) != 0;
+        let weekday_matches = self.weekdays & (1 << weekday) != 0;
+        match (self.any_day, self.any_weekday) {
+            (false, false) => day_matches || weekday_matches,
+            _ => day_matches && weekday_matches,
+        }
+    }
+}
+
+/// Parse one field into a bit set of the values it matches between `min` and `max`
+fn parse_field(field: &str, min: u64, max: u64) -> Result<u64, String> {
+    let mut bits = 0;
+    for part in field.split(',') {
+        let (range, step) = match part.split_once('/') {
+            Some((range, step)) => match step.parse::<u64>() {
+                Ok(step) if step > 0 => (range, step),
+                _ => return Err(format!("invalid step in {:?}", part)),
+            },
+            None => (part, 1),
+        };
+        let number = |s: &str| match s.parse::<u64>() {
+            Ok(n) if (min..=max).contains(&n) => Ok(n),
+            _ => Err(format!("{:?} is not a number from {} to {}", s, min, max)),
+        };
+        let (first, last) = match range {
+            "*" => (min, max),
+            _ => match range.split_once('-') {
+                Some((first, last)) => (number(first)?, number(last)?),
+                // "5/15" runs from 5 to the end of the range
+                None if step > 1 => (number(range)?, max),
+                None => (number(range)?, number(range)?),
+            },
+        };
+        if first > last {
+            return Err(format!("range {:?} runs backwards", range));
+        }
+        for value in (first..=last).step_by(step as usize) {
+            bits |= 1 << value;
+        }
+    }
+    Ok(bits)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// 2026-10-15T13:45:00Z, a Thursday
+    const NOW: u64 = 1_792_071_900;
+
+    #[test]
+    fn test_parse_rejects_invalid_expressions() {
+        assert!(Schedule::parse("* * * *").unwrap_err().contains("expected 5 fields"));
+        assert!(Schedule::parse("60 * * * *").unwrap_err().contains("from 0 to 59"));
+        assert!(Schedule::parse("*/0 * * * *").unwrap_err().contains("invalid step"));
+        assert!(Schedule::parse("0 5-1 * * *").unwrap_err().contains("backwards"));
+        assert!(Schedule::parse("0 3 * * mon").is_err());
+    }
+
+    #[test]
+    fn test_next_after() {
+        let next = |expression: &str| Schedule::parse(expression).unwrap().next_after(NOW);
+        assert_eq!(next("* * * * *"), Some(NOW + 60));
+        assert_eq!(next("*/10 * * * *"), Some(NOW + 5 * 60));
+        assert_eq!(next("0 3 * * *"), Some(NOW + 13 * 3_600 + 15 * 60));
+        assert_eq!(next("30 13,14 * * *"), Some(NOW + 45 * 60));
+        // Sunday, as 0 or 7
+        assert_eq!(next("0 0 * * 0"), Some(NOW - 13 * 3_600 - 45 * 60 + 3 * 86_400));
+        assert_eq!(next("0 0 * * 7"), next("0 0 * * 0"));
+        // With both days restricted, either one matches: the 16th comes before the next Monday
+        assert_eq!(next("0 0 16 * 1"), Some(NOW - 13 * 3_600 - 45 * 60 + 86_400));
+        assert_eq!(next("0 0 31 2 *"), None);
+    }
+}
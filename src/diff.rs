@@ -0,0 +1,204 @@
+/// Lines of unchanged context shown around each change
+const CONTEXT_LINES: usize = 3;
+
+/// Largest number of changed lines diffed before giving up, bounding time and memory
+const MAX_EDIT_DISTANCE: usize = 2_000;
+
+/// A single step turning the old lines into the new lines
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Op {
+    Equal(usize),
+    Delete(usize),
+    Insert(usize),
+}
+
+/// Produce a unified diff of two texts, compared line by line.
+/// Returns an empty string if they are identical, or None if they differ in too many lines to diff.
+pub fn unified_diff(old: &str, new: &str, old_label: &str, new_label: &str) -> Option<String> {
+    let a: Vec<&str> = old.lines().collect();
+    let b: Vec<&str> = new.lines().collect();
+    let ops = edit_script(&a, &b)?;
+
+    let changes: Vec<usize> = ops
+        .iter()
+        .enumerate()
+        .filter(|(_, op)| !matches!(op, Op::Equal(_)))
+        .map(|(i, _)| i)
+        .collect();
+    if changes.is_empty() {
+        return Some(String::new());
+    }
+
+    // Line positions in the old and new text before each op
+    let mut positions = Vec::with_capacity(ops.len() + 1);
+    let (mut old_pos, mut new_pos) = (0, 0);
+    for op in &ops {
+        positions.push((old_pos, new_pos));
+        match op {
+            Op::Equal(_) => {
+                old_pos += 1;
+                new_pos += 1;
+            }
+            Op::Delete(_) => old_pos += 1,
+            Op::Insert(_) => new_pos += 1,
+        }
+    }
+    positions.push((old_pos, new_pos));
+
+    // Changes separated by no more than twice the context share a hunk
+    let mut hunks: Vec<(usize, usize)> = Vec::new();
+    for &change in &changes {
+        match hunks.last_mut() {
+            Some((_, last)) if change - *last <= 2 * CONTEXT_LINES => *last = change,
+            _ => hunks.push((change, change)),
+        }
+    }
+
+    let mut out = format!("--- {}\n+++ {}\n", old_label, new_label);
+    for (first, last) in hunks {
+        let start = first.saturating_sub(CONTEXT_LINES);
+        let end = (last + CONTEXT_LINES + 1).min(ops.len());
+        let (old_start, new_start) = positions[start];
+        let (old_end, new_end) = positions[end];
+        out.push_str(&format!(
+            "@@ -{} +{} @@\n",
+            hunk_range(old_start, old_end - old_start),
+            hunk_range(new_start, new_end - new_start)
+        ));
+        for op in &ops[start..end] {
+            match *op {
+                Op::Equal(i) => out.push_str(&format!(" {}\n", a[i])),
+                Op::Delete(i) => out.push_str(&format!("-{}\n", a[i])),
+                Op::Insert(j) => out.push_str(&format!("+{}\n", b[j])),
+            }
+        }
+    }
+    Some(out)
+}
+
+/// Format a hunk range as "start,len" with a 1-based start (0 for an empty range at the top)
+fn hunk_range(start: usize, len: usize) -> String {
+    if len == 0 {
+        format!("{},0", start)
+    } else {
+        format!("{},{}", start + 1, len)
+    }
+}
+
+/// Shortest edit script between two line sequences (Myers' algorithm).
+/// Returns None if more than MAX_EDIT_DISTANCE lines differ.
+fn edit_script(a: &[&str], b: &[&str]) -> Option<Vec<Op>> {
+    let (n, m) = (a.len() as isize, b.len() as isize);
+    let max = (n + m) as usize;
+    let offset = max as isize + 1;
+    let mut v = vec![0isize; 2 * max + 3];
+    // trace[d] holds the furthest x on each diagonal -d..=d before step d
+    let mut trace: Vec<Vec<isize>> = Vec::new();
+
+    for d in 0..=max.min(MAX_EDIT_DISTANCE) as isize {
+        trace.push(v[(offset - d) as usize..=(offset + d) as usize].to_vec());
+        for k in (-d..=d).step_by(2) {
+            let mut x = if k == -d || (k != d && v[(offset + k - 1) as usize] < v[(offset + k + 1) as usize]) {
+                v[(offset + k + 1) as usize]
+            } else {
+                v[(offset + k - 1) as usize] + 1
+            };
+            let mut y = x - k;
+            while x < n && y < m && a[x as usize] == b[y as usize] {
+                x += 1;
+                y += 1;
+            }
+            v[(offset + k) as usize] = x;
+            if x >= n && y >= m {
+                return Some(backtrack(&trace, n, m));
+            }
+        }
+    }
+    None
+}
+
+/// Walk the recorded diagonals back from the end to recover the edit script
+fn backtrack(trace: &[Vec<isize>], n: isize, m: isize) -> Vec<Op> {
+    let mut ops = Vec::new();
+    let (mut x, mut y) = (n, m);
+    for (d, v) in trace.iter().enumerate().rev() {
+        if d == 0 {
+            // Whatever remains is the common prefix
+            while x > 0 {
+                x -= 1;
+                ops.push(Op::Equal(x as usize));
+            }
+            break;
+        }
+        let d = d as isize;
+        let at = |k: isize| v[(k + d) as usize];
+        let k = x - y;
+        let prev_k = if k == -d || (k != d && at(k - 1) < at(k + 1)) { k + 1 } else { k - 1 };
+        let prev_x = at(prev_k);
+        let prev_y = prev_x - prev_k;
+        while x > prev_x && y > prev_y {
+            x -= 1;
+            y -= 1;
+            ops.push(Op::Equal(x as usize));
+        }
+        if x == prev_x {
+            ops.push(Op::Insert(prev_y as usize));
+        } else {
+            ops.push(Op::Delete(prev_x as usize));
+        }
+        x = prev_x;
+        y = prev_y;
+    }
+    ops.reverse();
+    ops
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_identical_texts_have_empty_diff() {
+        assert_eq!(unified_diff("a\nb\n", "a\nb\n", "old", "new"), Some(String::new()));
+        assert_eq!(unified_diff("", "", "old", "new"), Some(String::new()));
+    }
+
+    #[test]
+    fn test_single_line_change() {
+        let diff = unified_diff("a\nb\nc\n", "a\nB\nc\n", "old", "new").unwrap();
+        assert_eq!(diff, "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n");
+    }
+
+    #[test]
+    fn test_insert_into_empty() {
+        let diff = unified_diff("", "x\ny\n", "old", "new").unwrap();
+        assert_eq!(diff, "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n");
+    }
+
+    #[test]
+    fn test_distant_changes_use_separate_hunks() {
+        let old: String = (1..=20).map(|i| format!("line{}\n", i)).collect();
+        let new = old.replace("line2\n", "LINE2\n").replace("line19\n", "LINE19\n");
+        let diff = unified_diff(&old, &new, "old", "new").unwrap();
+        assert!(diff.contains("@@ -1,5 +1,5 @@\n line1\n-line2\n+LINE2\n line3\n"));
+        assert!(diff.contains("@@ -16,5 +16,5 @@\n line16\n line17\n line18\n-line19\n+LINE19\n line20\n"));
+        assert_eq!(diff.matches("@@ -").count(), 2);
+    }
+
+    #[test]
+    fn test_nearby_changes_share_a_hunk() {
+        let old: String = (1..=10).map(|i| format!("line{}\n", i)).collect();
+        let new = old.replace("line3\n", "").replace("line7\n", "line7\nextra\n");
+        let diff = unified_diff(&old, &new, "old", "new").unwrap();
+        assert_eq!(diff.matches("@@ -").count(), 1);
+        assert!(diff.contains("-line3\n"));
+        assert!(diff.contains("+extra\n"));
+    }
+
+    #[test]
+    fn test_too_many_differences() {
+        let old: String = (0..MAX_EDIT_DISTANCE).map(|i| format!("a{}\n", i)).collect();
+        let new: String = (0..MAX_EDIT_DISTANCE).map(|i| format!("b{}\n", i)).collect();
+        assert_eq!(unified_diff(&old, &new, "old", "new"), None);
+    }
+}
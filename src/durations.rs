@@ -0,0 +1,148 @@
+use serde_json::Value;
+use std::collections::{HashMap, VecDeque};
+use std::fmt;
+use std::sync::Mutex;
+use std::time::Duration;
+
+use crate::notify::format_elapsed;
+
+/// Durations kept for each tool and target; the oldest are dropped first
+const MAX_RUNS: usize = 10;
+
+/// Targets whose durations are kept; when full, the target run least recently is dropped
+const MAX_TARGETS: usize = 500;
+
+/// Recent durations by tool and target, with when each target was last run
+#[derive(Debug, Default)]
+struct Durations {
+    runs: HashMap<(String, String), VecDeque<Duration>>,
+    /// Targets in the order they were last run, oldest first
+    order: VecDeque<(String, String)>,
+}
+
+static DURATIONS: Mutex<Option<Durations>> = Mutex::new(None);
+
+/// How long a call is expected to take, from earlier runs of the same tool on the same target
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Estimate {
+    /// Median of the earlier durations
+    pub duration: Duration,
+    pub runs: usize,
+}
+
+impl fmt::Display for Estimate {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "Estimated duration: {}, the median of {} earlier run{}",
+            format_elapsed(self.duration),
+            self.runs,
+            if self.runs == 1 { "" } else { "s" }
+        )
+    }
+}
+
+/// What a call runs on: its working directory and its tool-specific parameters. Output transformations
+/// and other common parameters don't change how long a call takes, so they aren't part of it.
+pub fn target(working_dir: Option<&str>, params: &Value) -> String {
+    format!("{} {}", working_dir.unwrap_or("."), params)
+}
+
+/// Keep how long a call took
+pub fn record(tool: &str, target: &str, elapsed: Duration) {
+    let mut durations = DURATIONS.lock().unwrap_or_else(|e| e.into_inner());
+    record_in(durations.get_or_insert_with(Durations::default), tool, target, elapsed, MAX_TARGETS);
+}
+
+/// The expected duration of a call, if the same tool has run on the same target before
+pub fn estimate(tool: &str, target: &str) -> Option<Estimate> {
+    let durations = DURATIONS.lock().unwrap_or_else(|e| e.into_inner());
+    estimate_in(durations.as_ref()?, tool, target)
+}
+
+fn record_in(durations: &mut Durations, tool: &str, target: &str, elapsed: Duration, max_targets: usize) {
+    let key = (tool.to_string(), target.to_string());
+    durations.order.retain(|k| *k != key);
+    while durations.order.len() >= max_targets {
+        if let Some(oldest) = durations.order.pop_front() {
+            durations.runs.remove(&oldest);
+        }
+    }
+    durations.order.push_back(key.clone());
+    let runs = durations.runs.entry(key).or_default();
+    if runs.len() >= MAX_RUNS {
+        runs.pop_front();
+    }
+    runs.push_back(elapsed);
+}
+
+fn estimate_in(durations: &Durations, tool: &str, target: &str) -> Option<Estimate> {
+    let runs = durations.runs.get(&(tool.to_string(), target.to_string()))?;
+    let mut sorted: Vec<Duration> = runs.iter().copied().collect();
+    sorted.sort();
+    // The median, so one cold-cache or interrupted run doesn't skew the estimate
+    let duration = *sorted.get(sorted.len() / 2)?;
+    Some(Estimate {
+        duration,
+        runs: sorted.len(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn secs(s: u64) -> Duration {
+        Duration::from_secs(s)
+    }
+
+    #[test]
+    fn test_estimate_is_median_of_recent_runs() {
+        let mut durations = Durations::default();
+        assert_eq!(estimate_in(&durations, "presubmit", "/src/app"), None);
+        for s in [130, 600, 125] {
+            record_in(&mut durations, "presubmit", "/src/app", secs(s), MAX_TARGETS);
+        }
+        assert_eq!(
+            estimate_in(&durations, "presubmit", "/src/app"),
+            Some(Estimate {
+                duration: secs(130),
+                runs: 3,
+            })
+        );
+        // Other targets have their own history
+        assert_eq!(estimate_in(&durations, "presubmit", "/src/lib"), None);
+    }
+
+    #[test]
+    fn test_record_keeps_recent_runs_and_targets() {
+        let mut durations = Durations::default();
+        for s in 0..15 {
+            record_in(&mut durations, "presubmit", "a", secs(s), 2);
+        }
+        assert_eq!(estimate_in(&durations, "presubmit", "a").unwrap().runs, MAX_RUNS);
+        record_in(&mut durations, "presubmit", "b", secs(1), 2);
+        record_in(&mut durations, "presubmit", "a", secs(1), 2);
+        record_in(&mut durations, "presubmit", "c", secs(1), 2);
+        // b was run least recently
+        assert!(estimate_in(&durations, "presubmit", "b").is_none());
+        assert!(estimate_in(&durations, "presubmit", "a").is_some());
+    }
+
+    #[test]
+    fn test_target() {
+        let params = json!({"stages": ["build"]});
+        assert_eq!(target(Some("/src/app"), &params), "/src/app {\"stages\":[\"build\"]}");
+        assert_eq!(target(None, &json!({})), ". {}");
+    }
+
+    #[test]
+    fn test_estimate_display() {
+        let estimate = Estimate {
+            duration: secs(130),
+            runs: 1,
+        };
+        assert_eq!(estimate.to_string(), "Estimated duration: 2m 10s, the median of 1 earlier run");
+    }
+}
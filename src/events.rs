@@ -0,0 +1,231 @@
+use std::io::Write;
+use std::process::{Command, Stdio};
+use std::sync::LazyLock;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::classify::is_failure;
+use crate::labels::Labels;
+use crate::severity;
+use crate::transcript;
+
+/// Message bus the execution events are published to
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Bus {
+    /// A NATS subject, published with the nats CLI
+    Nats { server: String, subject: String },
+    /// A Kafka topic, produced with kcat
+    Kafka { brokers: String, topic: String },
+}
+
+/// Event bus loaded from EVENTS_URL at startup: nats://host:4222/subject or kafka://broker1:9092,broker2:9092/topic.
+/// Unset or unparseable, no events are published.
+static BUS: LazyLock<Option<Bus>> =
+    LazyLock::new(|| std::env::var("EVENTS_URL").ok().and_then(|url| parse_bus_url(&url)));
+
+/// Name identifying this instance in its events and audit records: EVENTS_SOURCE, or the hostname
+static SOURCE: LazyLock<String> = LazyLock::new(|| {
+    std::env::var("EVENTS_SOURCE")
+        .ok()
+        .or_else(|| std::fs::read_to_string("/proc/sys/kernel/hostname").ok())
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+        .unwrap_or_else(|| "unknown".to_string())
+});
+
+/// Name identifying this instance
+pub fn source() -> &'static str {
+    &SOURCE
+}
+
+fn parse_bus_url(url: &str) -> Option<Bus> {
+    let (scheme, rest) = url.trim().split_once("://")?;
+    let (address, name) = rest.split_once('/')?;
+    if address.is_empty() || name.is_empty() {
+        return None;
+    }
+    match scheme {
+        "nats" => Some(Bus::Nats {
+            server: format!("nats://{}", address),
+            subject: name.to_string(),
+        }),
+        "kafka" => Some(Bus::Kafka {
+            brokers: address.to_string(),
+            topic: name.to_string(),
+        }),
+        _ => None,
+    }
+}
+
+/// Publish that a tool call has started
+pub fn publish_started(tool: &str, call: u64, labels: &Labels) {
+    if BUS.is_some() {
+        publish(started_event(tool, call, now(), labels));
+    }
+}
+
+/// Publish that a tool call has finished, with a summary of its result.
+/// `denied` is true when the request was rejected before running.
+pub fn publish_finished(tool: &str, call: u64, elapsed: Duration, output: &str, denied: bool, labels: &Labels) {
+    if BUS.is_some() {
+        publish(finished_event(tool, call, now(), elapsed, output, denied, labels));
+    }
+}
+
+fn now() -> u128 {
+    SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_millis())
+}
+
+/// The fields every event carries, and the call's labels if it has any
+fn event(
+    name: &str,
+    tool: &str,
+    call: u64,
+    time_ms: u128,
+    labels: &Labels,
+) -> serde_json::Map<String, serde_json::Value> {
+    let mut event = serde_json::Map::new();
+    event.insert("event".into(), name.into());
+    event.insert("source".into(), source().into());
+    event.insert("session".into(), transcript::session_id().into());
+    event.insert("call".into(), call.into());
+    event.insert("tool".into(), tool.into());
+    event.insert("time_ms".into(), serde_json::json!(time_ms));
+    if !labels.is_empty() {
+        event.insert("labels".into(), serde_json::json!(labels));
+    }
+    event
+}
+
+fn started_event(tool: &str, call: u64, time_ms: u128, labels: &Labels) -> serde_json::Value {
+    event("started", tool, call, time_ms, labels).into()
+}
+
+/// The finished event: the outcome, how long the call took, and its error and warning line counts
+fn finished_event(
+    tool: &str,
+    call: u64,
+    time_ms: u128,
+    elapsed: Duration,
+    output: &str,
+    denied: bool,
+    labels: &Labels,
+) -> serde_json::Value {
+    let (errors, warnings) = severity::counts(output);
+    let outcome = if denied {
+        "denied"
+    } else if is_failure(output) {
+        "failed"
+    } else {
+        "succeeded"
+    };
+    let mut event = event("finished", tool, call, time_ms, labels);
+    event.insert("outcome".into(), outcome.into());
+    event.insert("duration_ms".into(), serde_json::json!(elapsed.as_millis()));
+    event.insert("errors".into(), errors.into());
+    event.insert("warnings".into(), warnings.into());
+    event.insert("summary".into(), output.lines().next().unwrap_or_default().into());
+    event.into()
+}
+
+/// Publish an event in the background so a slow or unreachable bus never delays the tool result
+fn publish(event: serde_json::Value) {
+    let Some(bus) = BUS.as_ref() else {
+        return;
+    };
+    let mut command = publish_command(bus);
+    std::thread::spawn(move || {
+        if let Err(e) = run_publish(&mut command, &event.to_string()) {
+            tracing::warn!("Failed to publish execution event: {}", e);
+        }
+    });
+}
+
+/// The command publishing one message, read from its stdin
+fn publish_command(bus: &Bus) -> Command {
+    match bus {
+        Bus::Nats { server, subject } => {
+            let mut command = Command::new("nats");
+            command.args(["pub", "--server", server, subject, "--force-stdin"]);
+            command
+        }
+        Bus::Kafka { brokers, topic } => {
+            let mut command = Command::new("kcat");
+            command.args(["-P", "-b", brokers, "-t", topic]);
+            command
+        }
+    }
+}
+
+fn run_publish(command: &mut Command, message: &str) -> std::io::Result<()> {
+    let mut child = command
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn()?;
+    if let Some(mut stdin) = child.stdin.take() {
+        writeln!(stdin, "{}", message)?;
+    }
+    let status = child.wait()?;
+    if !status.success() {
+        return Err(std::io::Error::other(format!("{:?} exited with {}", command.get_program(), status)));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_bus_url() {
+        assert_eq!(
+            parse_bus_url("nats://nats.internal:4222/builds.events"),
+            Some(Bus::Nats {
+                server: "nats://nats.internal:4222".to_string(),
+                subject: "builds.events".to_string(),
+            })
+        );
+        assert_eq!(
+            parse_bus_url("kafka://b1:9092,b2:9092/build-events"),
+            Some(Bus::Kafka {
+                brokers: "b1:9092,b2:9092".to_string(),
+                topic: "build-events".to_string(),
+            })
+        );
+        assert_eq!(parse_bus_url("kafka://b1:9092"), None);
+        assert_eq!(parse_bus_url("amqp://rabbit/events"), None);
+    }
+
+    #[test]
+    fn test_finished_event() {
+        let output = "Error: exit code 1\nerror: cannot find value `x`\nwarning: unused import";
+        let labels = Labels::from([("task".to_string(), "T-42".to_string())]);
+        let event = finished_event("presubmit", 7, 1000, Duration::from_millis(1500), output, false, &labels);
+        assert_eq!(event["event"], "finished");
+        assert_eq!(event["tool"], "presubmit");
+        assert_eq!(event["call"], 7);
+        assert_eq!(event["outcome"], "failed");
+        assert_eq!(event["duration_ms"], 1500);
+        assert_eq!(event["errors"], 2);
+        assert_eq!(event["warnings"], 1);
+        assert_eq!(event["summary"], "Error: exit code 1");
+        assert_eq!(event["labels"]["task"], "T-42");
+
+        let denied = finished_event("git", 8, 1000, Duration::ZERO, "Error: Path not allowed", true, &Labels::new());
+        assert_eq!(denied["outcome"], "denied");
+        assert!(denied.get("labels").is_none());
+        assert_eq!(started_event("git", 8, 1000, &labels)["event"], "started");
+    }
+
+    #[test]
+    fn test_publish_command() {
+        let bus = Bus::Kafka {
+            brokers: "b1:9092".to_string(),
+            topic: "build-events".to_string(),
+        };
+        let command = publish_command(&bus);
+        assert_eq!(command.get_program(), "kcat");
+        let args: Vec<_> = command.get_args().collect();
+        assert_eq!(args, ["-P", "-b", "b1:9092", "-t", "build-events"]);
+    }
+}
@@ -1,22 +1,565 @@
-use std::process::{Command, Output};
-use std::time::Duration;
+use base64::Engine;
+use regex::Regex;
+use std::cell::Cell;
+use std::collections::BTreeMap;
+use std::fmt;
+use std::io::{self, BufRead, Read, Write};
+use std::path::{Path, PathBuf};
+use std::process::{Child, Command, ExitStatus, Output, Stdio};
+use std::sync::atomic::{AtomicBool, AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Arc, LazyLock, Mutex};
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
 use std::thread;
 use std::sync::mpsc;
 
-use crate::request::ExecutionContext;
+use crate::admission;
+use crate::cgroup::{self, Cgroup};
+use crate::classify::{classify, TIMEOUT_CATEGORY};
+use crate::history::{self, CommandEntry};
+use crate::jobs::Job;
+use crate::priority::{self, Priority};
+use crate::profile::Profile;
+use crate::request::{BinaryOutput, ExecutionContext, PtySize, RetryPolicy};
+use crate::rlimits::{self, Rlimits};
+use crate::run_as;
+use crate::sampler::{Sampler, Timeline};
+use crate::security::ValidationError;
+use crate::severity::{severity, Severity};
+use crate::spool::{self, Spooled};
+use crate::tools::host_info::format_bytes;
+use crate::workspace_env::workspace_env;
+
+/// Lines kept after the first error line in fail_fast mode, for the rest of its message and context
+const ERROR_BLOCK_LINES: usize = 20;
+
+/// How long to wait for a killed command's output to close before abandoning its reader thread
+const KILL_GRACE: Duration = Duration::from_secs(5);
+
+/// Default time a timed-out command gets to exit after SIGTERM before it is killed (5 seconds)
+const DEFAULT_TERM_GRACE_MS: u64 = 5000;
+
+/// Time between SIGTERM and SIGKILL for a timed-out command, loaded from TERM_GRACE_MS environment
+/// variable at startup. Lets bazel and test runners shut down cleanly; 0 kills immediately.
+static TERM_GRACE: LazyLock<Duration> = LazyLock::new(|| {
+    let ms = std::env::var("TERM_GRACE_MS")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_TERM_GRACE_MS);
+    Duration::from_millis(ms)
+});
+
+/// Default cap on each of a command's output streams in the result (1 MiB)
+const DEFAULT_MAX_OUTPUT_BYTES: usize = 1024 * 1024;
+
+/// Cap on each output stream loaded from MAX_OUTPUT_BYTES environment variable at startup.
+/// The end of the output is kept, since that is where errors and summaries usually are.
+static MAX_OUTPUT_BYTES: LazyLock<usize> = LazyLock::new(|| {
+    std::env::var("MAX_OUTPUT_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_MAX_OUTPUT_BYTES)
+});
+
+/// Default cap on a single output line in the result (64 KiB)
+const DEFAULT_MAX_LINE_BYTES: usize = 64 * 1024;
+
+/// Cap on each output line loaded from MAX_LINE_BYTES environment variable at startup.
+/// Minified JavaScript and bazel stack traces can produce single lines of megabytes.
+static MAX_LINE_BYTES: LazyLock<usize> = LazyLock::new(|| {
+    std::env::var("MAX_LINE_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_MAX_LINE_BYTES)
+});
+
+/// Default cap on the output read from each of a command's streams while it runs (64 MiB)
+const DEFAULT_MAX_CAPTURE_BYTES: usize = 64 * 1024 * 1024;
+
+/// Cap on the output read from each stream loaded from MAX_CAPTURE_BYTES environment variable at startup.
+/// A command that writes more is stopped, so a runaway `cat` of a huge log can't exhaust memory.
+static MAX_CAPTURE_BYTES: LazyLock<usize> = LazyLock::new(|| {
+    std::env::var("MAX_CAPTURE_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_MAX_CAPTURE_BYTES)
+});
+
+/// Default cap on the lines read from each of a command's streams while it runs
+const DEFAULT_MAX_OUTPUT_LINES: usize = 100_000;
+
+/// Cap on the lines read from each stream loaded from MAX_OUTPUT_LINES environment variable at startup.
+/// A request's max_output_lines can lower it but not raise it.
+static MAX_OUTPUT_LINES: LazyLock<usize> = LazyLock::new(|| {
+    std::env::var("MAX_OUTPUT_LINES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_MAX_OUTPUT_LINES)
+});
+
+/// Variables commands inherit from the server's environment; everything else is dropped
+const INHERITED_ENV_VARS: &[&str] = &[
+    "PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "LC_CTYPE", "TERM", "TMPDIR", "TZ",
+    "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+];
+
+/// Additional variables to inherit, loaded from ENV_PASSTHROUGH environment variable at startup.
+/// Format: semicolon-separated names, e.g., "SSH_AUTH_SOCK;GOPATH"
+static ENV_PASSTHROUGH: LazyLock<Vec<String>> = LazyLock::new(|| {
+    std::env::var("ENV_PASSTHROUGH")
+        .unwrap_or_default()
+        .split(';')
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+        .collect()
+});
+
+/// Commands currently running
+static RUNNING: AtomicUsize = AtomicUsize::new(0);
+
+/// Commands currently running, by ID, for listing what is still in flight
+static COMMANDS: Mutex<BTreeMap<u64, Arc<RunningCommand>>> = Mutex::new(BTreeMap::new());
+
+/// ID of the last command started
+static LAST_COMMAND: AtomicU64 = AtomicU64::new(0);
+
+/// Reader threads abandoned because a killed command's output pipes stayed open
+static LEAKED: AtomicUsize = AtomicUsize::new(0);
+
+/// Executor resource counters, for spotting leaks
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct WatchdogStats {
+    pub running: usize,
+    pub leaked: usize,
+}
+
+/// Current executor resource counters
+pub fn watchdog_stats() -> WatchdogStats {
+    WatchdogStats {
+        running: RUNNING.load(Ordering::Relaxed),
+        leaked: LEAKED.load(Ordering::Relaxed),
+    }
+}
+
+/// Resources used by the commands run on a thread, for reporting with a tool call's result
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Usage {
+    pub commands: usize,
+    /// Wall-clock time the commands ran, one after another
+    pub wall: Duration,
+    /// CPU time of the commands and the descendants they waited for
+    pub user: Duration,
+    pub sys: Duration,
+    /// Peak resident set size of the largest command, in bytes
+    pub max_rss: u64,
+    /// Peak memory of the largest command's cgroup, page cache included, when commands are confined
+    pub cgroup_memory_peak: Option<u64>,
+    /// Resource samples of each command, when the execution context asks for them
+    pub timelines: Vec<Timeline>,
+}
+
+impl fmt::Display for Usage {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        if self.commands == 0 {
+            return write!(f, "Usage: no commands run");
+        }
+        write!(
+            f,
+            "Usage: {} command{} in {:.1}s; CPU {:.1}s user, {:.1}s sys; max RSS {}",
+            self.commands,
+            if self.commands == 1 { "" } else { "s" },
+            self.wall.as_secs_f64(),
+            self.user.as_secs_f64(),
+            self.sys.as_secs_f64(),
+            format_bytes(self.max_rss)
+        )?;
+        if let Some(peak) = self.cgroup_memory_peak {
+            write!(f, "; cgroup peak memory {}", format_bytes(peak))?;
+        }
+        Ok(())
+    }
+}
+
+thread_local! {
+    /// Usage of the commands run on this thread since the last take_usage
+    static USAGE: Cell<Usage> = Cell::new(Usage::default());
+}
+
+/// Usage of the commands run on this thread since the last call. A tool call runs its commands on
+/// the calling thread, so taking the usage before and after the call gives the call's usage.
+pub fn take_usage() -> Usage {
+    USAGE.with(Cell::take)
+}
+
+fn record_usage(update: impl FnOnce(&mut Usage)) {
+    USAGE.with(|cell| {
+        let mut usage = cell.take();
+        update(&mut usage);
+        cell.set(usage);
+    });
+}
+
+/// A command, or pipeline, a tool call is running
+#[derive(Debug)]
+pub struct RunningCommand {
+    /// Invocation ID, unique for the life of the server process
+    pub id: u64,
+    /// Tool whose call runs the command, or "server" for the server's own checks
+    pub tool: String,
+    /// Command line, with pipeline stages separated by " | "
+    pub command: String,
+    /// When the command started, in seconds since the Unix epoch
+    pub started: u64,
+    /// Background job the command runs in
+    pub job: Option<u64>,
+    started_at: Instant,
+    /// Output lines read so far, from stdout and stderr
+    lines: AtomicUsize,
+    /// Process ID of the attempt running now, if one is
+    pid: Mutex<Option<u32>>,
+    cancelled: AtomicBool,
+    /// Where its output is kept for get_output, across retries
+    spool: Option<Arc<Spooled>>,
+}
+
+impl RunningCommand {
+    pub fn elapsed(&self) -> Duration {
+        self.started_at.elapsed()
+    }
+
+    /// Output lines read so far, across retries
+    pub fn lines(&self) -> usize {
+        self.lines.load(Ordering::Relaxed)
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.cancelled.load(Ordering::Relaxed)
+    }
+
+    /// Cancel the command: stop it and its descendants, and retry it no more. The call running it
+    /// returns the output read so far. Returns false if it had already been cancelled.
+    pub fn cancel(&self) -> bool {
+        let pid = self.pid.lock().unwrap_or_else(|e| e.into_inner());
+        if self.cancelled.swap(true, Ordering::Relaxed) {
+            return false;
+        }
+        if let Some(pid) = *pid {
+            stop_in_background(pid);
+        }
+        true
+    }
+
+    /// Note the process of the attempt running now, stopping it if the command was cancelled meanwhile
+    fn set_pid(&self, pid: Option<u32>) {
+        let mut current = self.pid.lock().unwrap_or_else(|e| e.into_inner());
+        *current = pid;
+        if let Some(pid) = pid.filter(|_| self.is_cancelled()) {
+            stop_in_background(pid);
+        }
+    }
+}
+
+/// Commands currently running, oldest first
+pub fn running_commands() -> Vec<Arc<RunningCommand>> {
+    COMMANDS.lock().unwrap_or_else(|e| e.into_inner()).values().cloned().collect()
+}
+
+/// The running command with an invocation ID
+pub fn running_command(id: u64) -> Option<Arc<RunningCommand>> {
+    COMMANDS.lock().unwrap_or_else(|e| e.into_inner()).get(&id).cloned()
+}
+
+/// Counts a command as running, and lists it, for as long as the guard lives
+struct RunningGuard(Arc<RunningCommand>);
+
+impl RunningGuard {
+    fn new(tool: Option<&str>, command: String, job: Option<u64>) -> Self {
+        RUNNING.fetch_add(1, Ordering::Relaxed);
+        let id = LAST_COMMAND.fetch_add(1, Ordering::Relaxed) + 1;
+        let started = SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs());
+        // The server's own checks aren't spooled; no call returns their output
+        let spool = tool.and_then(|tool| spool::create(id, tool, &command, started));
+        let running = Arc::new(RunningCommand {
+            id,
+            tool: tool.unwrap_or("server").to_string(),
+            command,
+            started,
+            job,
+            started_at: Instant::now(),
+            lines: AtomicUsize::new(0),
+            pid: Mutex::new(None),
+            cancelled: AtomicBool::new(false),
+            spool,
+        });
+        COMMANDS.lock().unwrap_or_else(|e| e.into_inner()).insert(running.id, running.clone());
+        RunningGuard(running)
+    }
+}
+
+impl Drop for RunningGuard {
+    fn drop(&mut self) {
+        RUNNING.fetch_sub(1, Ordering::Relaxed);
+        COMMANDS.lock().unwrap_or_else(|e| e.into_inner()).remove(&self.0.id);
+        if let Some(ref spool) = self.0.spool {
+            spool.finish();
+        }
+    }
+}
 
 /// Result of command execution
 pub enum ExecutionResult {
     Success(String),
     Error(String),
-    Timeout,
+    /// Killed after running for the given timeout
+    Timeout(Duration),
+}
+
+/// Runs the commands tools build. Tools call run_command, which hands each command to the execution
+/// context's executor, so tests can inject a fake and other backends (sandboxed, remote, containerized)
+/// can be swapped in without changing the tools.
+pub trait CommandExecutor: fmt::Debug + Send + Sync {
+    /// Run a command with the given execution context
+    fn execute(&self, cmd: Command, ctx: &ExecutionContext) -> ExecutionResult;
+
+    /// Run commands as a pipeline, each one's stdout wired to the next one's stdin
+    fn execute_pipeline(&self, stages: Vec<Command>, ctx: &ExecutionContext) -> ExecutionResult;
+}
+
+/// Runs commands as child processes of the server
+#[derive(Debug, Clone, Copy, Default)]
+pub struct LocalExecutor;
+
+impl CommandExecutor for LocalExecutor {
+    fn execute(&self, cmd: Command, ctx: &ExecutionContext) -> ExecutionResult {
+        run_local(vec![cmd], ctx)
+    }
+
+    fn execute_pipeline(&self, stages: Vec<Command>, ctx: &ExecutionContext) -> ExecutionResult {
+        run_local(stages, ctx)
+    }
+}
+
+/// An executor for tests that records the commands it is given instead of running them
+#[cfg(test)]
+#[derive(Debug, Default)]
+pub struct FakeExecutor {
+    /// Each command's program and arguments, in the order they were given
+    pub calls: std::sync::Mutex<Vec<Vec<String>>>,
+    /// Programs that fail; all others succeed with no output
+    pub failing: Vec<String>,
+}
+
+#[cfg(test)]
+impl FakeExecutor {
+    /// Commands given so far, each as its program and arguments joined by spaces
+    pub fn commands(&self) -> Vec<String> {
+        self.calls.lock().unwrap().iter().map(|argv| argv.join(" ")).collect()
+    }
+}
+
+#[cfg(test)]
+impl CommandExecutor for FakeExecutor {
+    fn execute(&self, cmd: Command, ctx: &ExecutionContext) -> ExecutionResult {
+        self.execute_pipeline(vec![cmd], ctx)
+    }
+
+    /// Recorded as one command, its stages separated by "|"
+    fn execute_pipeline(&self, stages: Vec<Command>, _ctx: &ExecutionContext) -> ExecutionResult {
+        let mut argv = Vec::new();
+        for cmd in &stages {
+            if !argv.is_empty() {
+                argv.push("|".to_string());
+            }
+            argv.push(cmd.get_program().to_string_lossy().to_string());
+            argv.extend(cmd.get_args().map(|arg| arg.to_string_lossy().to_string()));
+        }
+        self.calls.lock().unwrap().push(argv);
+        let programs: Vec<_> = stages.iter().map(|cmd| cmd.get_program().to_string_lossy()).collect();
+        match programs.iter().find(|program| self.failing.iter().any(|f| f == *program)) {
+            Some(program) => ExecutionResult::Error(format!("Error: {} failed", program)),
+            None => ExecutionResult::Success(String::new()),
+        }
+    }
+}
+
+/// Run a command with the given execution context, through the context's executor.
+/// A dry run describes the command instead.
+pub fn run_command(cmd: Command, ctx: &ExecutionContext) -> ExecutionResult {
+    if ctx.dry_run {
+        return ExecutionResult::Success(describe(vec![cmd], ctx));
+    }
+    match ctx.executor {
+        Some(ref executor) => executor.execute(cmd, ctx),
+        None => LocalExecutor.execute(cmd, ctx),
+    }
+}
+
+/// Run commands through the context's executor as a pipeline, each one's stdout wired to the next one's
+/// stdin without a shell. Like a shell without pipefail, the pipeline succeeds or fails as its last
+/// command does. Every command's stderr is read as the pipeline's.
+pub fn run_pipeline(stages: Vec<Command>, ctx: &ExecutionContext) -> ExecutionResult {
+    if ctx.dry_run {
+        return ExecutionResult::Success(describe(stages, ctx));
+    }
+    match ctx.executor {
+        Some(ref executor) => executor.execute_pipeline(stages, ctx),
+        None => LocalExecutor.execute_pipeline(stages, ctx),
+    }
+}
+
+/// Run a command, or a pipeline of several, as child processes of the server
+fn run_local(stages: Vec<Command>, ctx: &ExecutionContext) -> ExecutionResult {
+    run_local_impl(stages, ctx, &MITIGATIONS)
+}
+
+/// Internal implementation for testability - takes the retry mitigations as parameter.
+fn run_local_impl(stages: Vec<Command>, ctx: &ExecutionContext, mitigations: &[Mitigation]) -> ExecutionResult {
+    if stages.is_empty() {
+        return ExecutionResult::Error("Error: No commands to run".to_string());
+    }
+    // For matching mitigations, which are configured by program, and for the log
+    let program = programs(&stages);
+    let names: Vec<String> = stages.iter().map(|cmd| cmd.get_program().to_string_lossy().into_owned()).collect();
+    let mut stages: Vec<Command> = stages.into_iter().map(|cmd| prepare(cmd, ctx)).collect();
+
+    // Wait for a slot under the server's limit on parallel commands; a pipeline takes one, and quick
+    // read-only commands take none
+    let _slot = if admission::is_interactive(&names) {
+        None
+    } else {
+        match admission::acquire_slot(ctx.tool.as_deref().unwrap_or("command"), ctx.queue_timeout) {
+            Ok(slot) => Some(slot),
+            Err(reason) => return ExecutionResult::Error(ValidationError::ServerBusy(reason).to_string()),
+        }
+    };
+
+    // One cgroup for all attempts, removed when the last one is done
+    let cgroup = match cgroup::configured().map(Cgroup::create).transpose() {
+        Ok(cgroup) => cgroup.map(Arc::new),
+        Err(e) => return ExecutionResult::Error(format!("Error: Failed to create a cgroup for the command: {}", e)),
+    };
+
+    // Execute with optional timeout
+    let command = stages.iter().map(command_line).collect::<Vec<_>>().join(" | ");
+    let running = RunningGuard::new(ctx.tool.as_deref(), command, ctx.job.as_ref().map(|job| job.id));
+    let stdin = ctx.stdin.as_deref();
+    let limits = OutputLimits {
+        bytes: *MAX_CAPTURE_BYTES,
+        lines: ctx.max_output_lines.map_or(*MAX_OUTPUT_LINES, |lines| lines.min(*MAX_OUTPUT_LINES)),
+        fail_fast: ctx.fail_fast,
+    };
+    let options = RunOptions {
+        text: TextOptions {
+            binary: ctx.binary_output,
+            strip_ansi: !ctx.keep_ansi,
+        },
+        limits,
+        filter: ctx.filter.clone(),
+        pty: ctx.pty,
+        sample_interval: ctx.sample_interval,
+        rlimits: rlimits::for_command(ctx.profile.map(|profile| &profile.ulimits)),
+        priority: priority::for_command(ctx.profile.map(Profile::priority)),
+        cgroup,
+        job: ctx.job.clone(),
+        running: running.0.clone(),
+    };
+    confine(&mut stages, &options);
+    let policy = ctx.retry.as_ref();
+    let max_attempts = policy.map_or(1, RetryPolicy::attempts);
+    let mut notes = Vec::new();
+    let mut mitigated = false;
+    // Every way out of the attempts below is kept in the command history
+    let stage_argv: Vec<Vec<String>> = stages.iter().map(argv).collect();
+    let finish = |result: ExecutionResult, exit_code: Option<i32>| {
+        record_history(&options.running, &stage_argv, &result, exit_code);
+        result
+    };
+    for attempt in 1.. {
+        // A cancelled job runs nothing more
+        if options.job.as_ref().is_some_and(|job| job.is_cancelled()) {
+            return finish(with_notes(ExecutionResult::Error("Error: The job was cancelled".to_string()), &notes), None);
+        }
+        let id = options.running.id;
+        if options.running.is_cancelled() {
+            let result = ExecutionResult::Error(format!("Error: Command #{} was cancelled", id));
+            return finish(with_notes(result, &notes), None);
+        }
+        let started = Instant::now();
+        let (result, exit_code) = match ctx.timeout {
+            Some(timeout) => run_with_timeout(&mut stages, timeout, stdin, &options),
+            None => run_without_timeout(&mut stages, stdin, &options),
+        };
+        record_usage(|usage| {
+            usage.commands += 1;
+            usage.wall += started.elapsed();
+        });
+        // The output read before it was stopped is returned, as a failure even if the command exited cleanly
+        if options.running.is_cancelled() {
+            notes.push(format!("[command #{} was cancelled after {:.1}s]", id, started.elapsed().as_secs_f64()));
+            let result = match result {
+                ExecutionResult::Success(output) => ExecutionResult::Error(output),
+                result => result,
+            };
+            return finish(with_notes(result, &notes), exit_code);
+        }
+        let (policy, reason) = match policy.and_then(|p| retry_reason(&result, exit_code, p).map(|r| (p, r))) {
+            Some(retry) if attempt < max_attempts => retry,
+            _ => {
+                if let Some(peak) = options.cgroup.as_ref().and_then(|cgroup| cgroup.memory_peak()) {
+                    record_usage(|usage| usage.cgroup_memory_peak = usage.cgroup_memory_peak.max(Some(peak)));
+                }
+                return finish(with_notes(result, &notes), exit_code);
+            }
+        };
+        let delay = policy.backoff(attempt);
+        let mut note = format!(
+            "[attempt {} of {} failed ({}); retrying in {}",
+            attempt,
+            max_attempts,
+            reason,
+            format_timeout(delay)
+        );
+        // Added once; later attempts keep them. Pipelines are retried as they are.
+        match (stages.as_mut_slice(), mitigation_args(mitigations, &program, &reason)) {
+            ([cmd], Some(args)) if !mitigated => {
+                *cmd = with_args(cmd, args);
+                // The copy has none of the original's hooks, so it needs the overlay and limits again
+                if let Some(ref overlay) = ctx.overlay {
+                    overlay.enter(cmd);
+                }
+                confine(std::slice::from_mut(cmd), &options);
+                mitigated = true;
+                note.push_str(&format!(" with {}]", args.join(" ")));
+            }
+            _ => note.push(']'),
+        }
+        tracing::info!("#{} {}: {}", id, program, note);
+        notes.push(note);
+        thread::sleep(delay);
+    }
+    unreachable!("the last attempt returns")
 }
 
-/// Run a command with the given execution context
-pub fn run_command(mut cmd: Command, ctx: &ExecutionContext) -> ExecutionResult {
-    // Set working directory if specified
+/// Set up a command to run for a tool call: its profile, environment, user and working directory
+fn prepare(cmd: Command, ctx: &ExecutionContext) -> Command {
+    // Apply the tool's profile first so the request's env can override its variables
+    let mut cmd = match ctx.profile {
+        Some(profile) => profile.apply(cmd, ctx),
+        None => cmd,
+    };
+    sanitize_env(&mut cmd, &ENV_PASSTHROUGH);
+    // After the inherited variables, so the user's own HOME, USER and LOGNAME replace the server's
+    run_as::apply(&mut cmd);
+
+    // Set working directory if specified, with the allowlisted variables from its .env/.envrc
     if let Some(ref dir) = ctx.working_dir {
         cmd.current_dir(dir);
+        for (key, value) in workspace_env(Path::new(dir)) {
+            cmd.env(key, value);
+        }
+    }
+    // After the working directory, which the command changes back into once the overlay is mounted
+    if let Some(ref overlay) = ctx.overlay {
+        overlay.enter(&mut cmd);
     }
 
     // Set environment variables if specified
@@ -25,26 +568,827 @@ pub fn run_command(mut cmd: Command, ctx: &ExecutionContext) -> ExecutionResult
             cmd.env(key, value);
         }
     }
+    cmd
+}
 
-    // Execute with optional timeout
-    match ctx.timeout {
-        Some(timeout) => run_with_timeout(cmd, timeout),
-        None => run_without_timeout(cmd),
+/// Describe a command or pipeline a dry run would have run: its command line as it would be spawned, its
+/// working directory and environment, and the policies applied to it. Values from the request's env and
+/// the workspace env files may be secrets, so they are redacted, as in the transcript.
+fn describe(stages: Vec<Command>, ctx: &ExecutionContext) -> String {
+    let stages: Vec<Command> = stages.into_iter().map(|cmd| prepare(cmd, ctx)).collect();
+    let Some(first) = stages.first() else {
+        return "Dry run: no commands to run".to_string();
+    };
+    let command_lines: Vec<String> = stages.iter().map(command_line).collect();
+    let mut lines = vec![format!("Dry run, not executed: {}", command_lines.join(" | "))];
+    if let Some(dir) = first.get_current_dir() {
+        lines.push(format!("Working directory: {}", dir.display()));
+    }
+
+    let mut secret: Vec<String> = ctx.env.iter().flatten().map(|(key, _)| key.clone()).collect();
+    if let Some(ref dir) = ctx.working_dir {
+        secret.extend(workspace_env(Path::new(dir)).into_iter().map(|(key, _)| key));
+    }
+    lines.push("Environment:".to_string());
+    for (key, value) in first.get_envs() {
+        let (key, value) = match value {
+            Some(value) => (key.to_string_lossy(), value.to_string_lossy()),
+            None => continue,
+        };
+        let value = if secret.iter().any(|s| *s == key) { "[REDACTED]".into() } else { value };
+        lines.push(format!("  {}={}", key, value));
+    }
+
+    let mut policies = Vec::new();
+    if let Some(timeout) = ctx.timeout {
+        policies.push(format!("timeout {}", format_timeout(timeout)));
+    }
+    if let Ok(Some(run_as)) = run_as::configured() {
+        policies.push(format!("run as uid {}, gid {}", run_as.uid, run_as.gid));
+    }
+    let limits = rlimits::for_command(ctx.profile.map(|profile| &profile.ulimits));
+    if limits != Rlimits::default() {
+        policies.push(format!("resource limits {}", limits));
+    }
+    let priority = priority::for_command(ctx.profile.map(Profile::priority));
+    if priority != Priority::default() {
+        policies.push(format!("priority {}", priority));
+    }
+    if let Some(confinement) = cgroup::configured() {
+        let mut cgroup = format!("cgroup under {}", confinement.parent.display());
+        if let Some(ref max) = confinement.memory_max {
+            cgroup.push_str(&format!(", memory.max {}", max));
+        }
+        if let Some(cpus) = confinement.cpus {
+            cgroup.push_str(&format!(", {} CPUs", cpus));
+        }
+        policies.push(cgroup);
+    }
+    if let Some(ref retry) = ctx.retry {
+        policies.push(format!("up to {} attempts for {}", retry.attempts(), retry.categories().join(", ")));
+    }
+    if let Some(ref stdin) = ctx.stdin {
+        policies.push(format!("{} bytes of stdin", stdin.len()));
+    }
+    if let (Some(size), [_]) = (ctx.pty, stages.as_slice()) {
+        policies.push(format!("pseudo-terminal of {}x{}", size.cols(), size.rows()));
+    }
+    if !policies.is_empty() {
+        lines.push("Policies:".to_string());
+        lines.extend(policies.into_iter().map(|policy| format!("  {}", policy)));
+    }
+    lines.join("\n") + "\n"
+}
+
+/// A command's program and arguments
+fn argv(cmd: &Command) -> Vec<String> {
+    std::iter::once(cmd.get_program())
+        .chain(cmd.get_args())
+        .map(|word| word.to_string_lossy().into_owned())
+        .collect()
+}
+
+/// Keep a finished command in the command history, with the result its call gets
+fn record_history(running: &RunningCommand, argv: &[Vec<String>], result: &ExecutionResult, exit_code: Option<i32>) {
+    let output = match result {
+        ExecutionResult::Success(output) | ExecutionResult::Error(output) => output.clone(),
+        ExecutionResult::Timeout(timeout) => {
+            format!("Error: Command timed out and was killed after {}", format_timeout(*timeout))
+        }
+    };
+    history::record_command(CommandEntry {
+        id: running.id,
+        tool: running.tool.clone(),
+        argv: argv.to_vec(),
+        started: running.started,
+        duration_ms: running.elapsed().as_millis() as u64,
+        exit_code,
+        output,
+        output_truncated: false,
+    });
+}
+
+/// A command's program and arguments as they could be typed into a shell
+fn command_line(cmd: &Command) -> String {
+    let words: Vec<String> = std::iter::once(cmd.get_program())
+        .chain(cmd.get_args())
+        .map(|word| quote(&word.to_string_lossy()))
+        .collect();
+    words.join(" ")
+}
+
+/// Quote a word for a shell if it has anything but letters, digits and a few safe characters
+pub fn quote(word: &str) -> String {
+    let safe = |c: char| c.is_ascii_alphanumeric() || "-_./=:,+@%".contains(c);
+    if !word.is_empty() && word.chars().all(safe) {
+        return word.to_string();
+    }
+    format!("'{}'", word.replace('\'', "'\\''"))
+}
+
+/// The programs of a command or pipeline, separated by " | "
+fn programs(stages: &[Command]) -> String {
+    let programs: Vec<_> = stages.iter().map(|cmd| cmd.get_program().to_string_lossy()).collect();
+    programs.join(" | ")
+}
+
+/// Why a failed attempt should be retried under the policy: its failure category or exit code.
+/// None for successes and failures the policy doesn't cover.
+fn retry_reason(result: &ExecutionResult, exit_code: Option<i32>, policy: &RetryPolicy) -> Option<String> {
+    let category = match result {
+        ExecutionResult::Success(_) => return None,
+        ExecutionResult::Error(output) => classify(output),
+        ExecutionResult::Timeout(_) => TIMEOUT_CATEGORY,
+    };
+    if policy.categories().iter().any(|c| c == category) {
+        return Some(category.to_string());
+    }
+    match exit_code {
+        Some(code) if policy.exit_codes.contains(&code) => Some(format!("exit code {}", code)),
+        _ => None,
+    }
+}
+
+/// Arguments a retry adds to a program's command line, for one failure category or for all of them
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Mitigation {
+    program: String,
+    category: Option<String>,
+    args: Vec<String>,
+}
+
+/// Retry mitigations loaded from RETRY_MITIGATIONS environment variable at startup. Format:
+/// semicolon-separated "program[:category]=args" entries, e.g.,
+/// "bazel:cache-miss-timeout=--noremote_accept_cached;go=-count=1". When a retry policy retries a
+/// command, the first matching entry's arguments are added for the remaining attempts.
+static MITIGATIONS: LazyLock<Vec<Mitigation>> =
+    LazyLock::new(|| parse_mitigations(&std::env::var("RETRY_MITIGATIONS").unwrap_or_default()));
+
+fn parse_mitigations(spec: &str) -> Vec<Mitigation> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .filter_map(|(key, args)| {
+            let (program, category) = match key.split_once(':') {
+                Some((program, category)) => (program.trim(), Some(category.trim().to_string())),
+                None => (key.trim(), None),
+            };
+            let args: Vec<String> = args.split_whitespace().map(String::from).collect();
+            (!program.is_empty() && !args.is_empty()).then(|| Mitigation {
+                program: program.to_string(),
+                category,
+                args,
+            })
+        })
+        .collect()
+}
+
+/// The arguments to add when retrying `program` for `reason`, a failure category or exit code.
+/// Programs are matched by file name, so "bazel" also covers "/usr/local/bin/bazel".
+fn mitigation_args<'a>(mitigations: &'a [Mitigation], program: &str, reason: &str) -> Option<&'a [String]> {
+    let name = Path::new(program).file_name()?.to_str()?;
+    mitigations
+        .iter()
+        .find(|m| m.program == name && m.category.as_deref().is_none_or(|category| category == reason))
+        .map(|m| m.args.as_slice())
+}
+
+/// A copy of a command with `args` added before its first "--", which ends the options of programs
+/// like bazel, or at the end if it has none. The command's environment was already sanitized, so the
+/// copy starts from an empty one too.
+fn with_args(cmd: &Command, args: &[String]) -> Command {
+    let existing: Vec<_> = cmd.get_args().collect();
+    let split = existing.iter().position(|arg| *arg == "--").unwrap_or(existing.len());
+    let mut copy = Command::new(cmd.get_program());
+    copy.args(&existing[..split]).args(args).args(&existing[split..]);
+    copy.env_clear();
+    for (key, value) in cmd.get_envs() {
+        match value {
+            Some(value) => copy.env(key, value),
+            None => copy.env_remove(key),
+        };
+    }
+    if let Some(dir) = cmd.get_current_dir() {
+        copy.current_dir(dir);
+    }
+    // Command can't report the user it runs as, so set it again
+    run_as::set_ids(&mut copy);
+    copy
+}
+
+/// Add the notes of earlier, retried attempts to the end of the final attempt's result
+fn with_notes(result: ExecutionResult, notes: &[String]) -> ExecutionResult {
+    if notes.is_empty() {
+        return result;
+    }
+    let append = |output: String| format!("{}\n{}", output.trim_end(), notes.join("\n"));
+    match result {
+        ExecutionResult::Success(output) => ExecutionResult::Success(append(output)),
+        ExecutionResult::Error(output) => ExecutionResult::Error(append(output)),
+        // The timeout message is fixed; the notes are in the server log
+        ExecutionResult::Timeout(timeout) => ExecutionResult::Timeout(timeout),
+    }
+}
+
+/// How each attempt at a command is run and its output read
+#[derive(Debug, Clone)]
+struct RunOptions {
+    text: TextOptions,
+    limits: OutputLimits,
+    /// Keep only the output lines matching this
+    filter: Option<Regex>,
+    /// Run under a pseudo-terminal of this size instead of with pipes
+    pty: Option<PtySize>,
+    sample_interval: Option<Duration>,
+    rlimits: Rlimits,
+    priority: Priority,
+    /// Cgroup every attempt runs in, when commands are confined
+    cgroup: Option<Arc<Cgroup>>,
+    /// Background job whose log gets the output as it is read
+    job: Option<Arc<Job>>,
+    /// Listing of the command, which counts the output lines as they are read
+    running: Arc<RunningCommand>,
+}
+
+/// How a command's output streams are turned into result text
+#[derive(Debug, Clone, Copy)]
+struct TextOptions {
+    binary: BinaryOutput,
+    /// Remove ANSI escape sequences, such as colors and cursor movement, from text output
+    strip_ansi: bool,
+}
+
+/// Limits on the output read from each of a command's streams
+#[derive(Debug, Clone, Copy)]
+struct OutputLimits {
+    bytes: usize,
+    lines: usize,
+    /// Stop at the end of the first block of error lines
+    fail_fast: bool,
+}
+
+/// A finished command's output, with the number of lines kept from each stream the limits cut off
+struct Captured {
+    output: Output,
+    stdout_truncated_at: Option<usize>,
+    stderr_truncated_at: Option<usize>,
+    usage: Option<ProcessUsage>,
+    /// Invocation whose output is spooled in full, for get_output
+    spooled: Option<u64>,
+}
+
+impl From<Output> for Captured {
+    fn from(output: Output) -> Self {
+        Captured {
+            output,
+            stdout_truncated_at: None,
+            stderr_truncated_at: None,
+            usage: None,
+            spooled: None,
+        }
+    }
+}
+
+/// CPU time and peak memory of an exited process
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct ProcessUsage {
+    user: Duration,
+    sys: Duration,
+    max_rss: u64,
+}
+
+impl Captured {
+    /// Add the command's CPU time and peak memory to this thread's usage
+    fn record_usage(&self) {
+        if let Some(process) = self.usage {
+            record_usage(|usage| {
+                usage.user += process.user;
+                usage.sys += process.sys;
+                usage.max_rss = usage.max_rss.max(process.max_rss);
+            });
+        }
     }
 }
 
-fn run_without_timeout(mut cmd: Command) -> ExecutionResult {
-    match cmd.output() {
-        Ok(output) => output_to_result(output),
-        Err(e) => ExecutionResult::Error(format!("Failed to execute command: {}", e)),
+/// Replace the environment a command would inherit from the server with just the base variables
+/// and the passthrough ones, so the server's own configuration and secrets don't leak into commands.
+/// Variables already set on the command, such as a profile's, are kept.
+fn sanitize_env(cmd: &mut Command, passthrough: &[String]) {
+    let explicit: Vec<_> = cmd
+        .get_envs()
+        .map(|(key, value)| (key.to_os_string(), value.map(|v| v.to_os_string())))
+        .collect();
+    cmd.env_clear();
+    let inherited = INHERITED_ENV_VARS.iter().copied().chain(passthrough.iter().map(String::as_str));
+    for key in inherited {
+        if let Some(value) = std::env::var_os(key) {
+            cmd.env(key, value);
+        }
+    }
+    for (key, value) in explicit {
+        match value {
+            Some(value) => cmd.env(key, value),
+            None => cmd.env_remove(key),
+        };
     }
 }
 
-fn run_with_timeout(mut cmd: Command, timeout: Duration) -> ExecutionResult {
+/// Spawn a command with its priority and resource limits, with pipes or under a pseudo-terminal if the
+/// options ask for one. Several commands are spawned as a pipeline, which always uses pipes; the child
+/// returned is its last command.
+fn start(stages: &mut [Command], stdin: Option<&[u8]>, options: &RunOptions) -> io::Result<Child> {
+    match (stages, options.pty) {
+        ([cmd], Some(size)) => spawn_pty(cmd, stdin, size),
+        ([cmd], None) => spawn(cmd, stdin),
+        (stages, _) => spawn_pipeline(stages, stdin),
+    }
+}
+
+/// Set a command's resource limits, priority and cgroup as it starts. Called once per command, when it
+/// is built or rebuilt for a retry: each call adds hooks, and the priority's adds the niceness to the
+/// one the process already has, so a command that kept its hooks across attempts would run at twice
+/// the niceness on the second.
+fn confine(stages: &mut [Command], options: &RunOptions) {
+    for cmd in stages.iter_mut() {
+        options.rlimits.set(cmd);
+        options.priority.set(cmd);
+        if let Some(ref cgroup) = options.cgroup {
+            cgroup.confine(cmd);
+        }
+    }
+}
+
+/// Spawn a command with its output captured and `stdin` piped to it, or an empty stdin if None.
+/// The server's own stdio is the MCP channel, so it is never inherited.
+fn spawn(cmd: &mut Command, stdin: Option<&[u8]>) -> io::Result<Child> {
+    // Run in a new process group so a timeout or runaway output can kill every descendant holding the pipes
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::CommandExt;
+        cmd.process_group(0);
+    }
+    let input = if stdin.is_some() { Stdio::piped() } else { Stdio::null() };
+    cmd.stdin(input).stdout(Stdio::piped()).stderr(Stdio::piped());
+    let mut child = cmd.spawn()?;
+    if let (Some(data), Some(mut pipe)) = (stdin, child.stdin.take()) {
+        // Written from its own thread so a command that fills its output pipes before
+        // reading all of its input can't deadlock; the pipe closes when the thread ends
+        let data = data.to_vec();
+        thread::spawn(move || {
+            let _ = pipe.write_all(&data);
+        });
+    }
+    Ok(child)
+}
+
+/// Spawn a pipeline's commands with each one's stdout piped to the next one's stdin, as a shell would.
+/// The last command is spawned first so the others can join its process group, letting a timeout or
+/// runaway output kill them all through it. Every command writes to one stderr pipe, returned as the
+/// last command's, and the others are reaped in the background as they exit.
+#[cfg(unix)]
+fn spawn_pipeline(stages: &mut [Command], stdin: Option<&[u8]>) -> io::Result<Child> {
+    use std::os::fd::OwnedFd;
+    use std::os::unix::process::CommandExt;
+    use std::process::ChildStderr;
+
+    let (stderr, stderr_writer) = io::pipe()?;
+    let mut input = None;
+    let mut stdins = vec![match stdin {
+        Some(_) => {
+            let (reader, writer) = io::pipe()?;
+            input = Some(writer);
+            Stdio::from(reader)
+        }
+        None => Stdio::null(),
+    }];
+    let mut stdouts = Vec::new();
+    for _ in 1..stages.len() {
+        let (reader, writer) = io::pipe()?;
+        stdouts.push(Stdio::from(writer));
+        stdins.push(Stdio::from(reader));
+    }
+    stdouts.push(Stdio::piped());
+
+    let mut group = None;
+    let mut children = Vec::new();
+    for ((cmd, stdin), stdout) in stages.iter_mut().zip(stdins).zip(stdouts).rev() {
+        cmd.process_group(group.map_or(0, |pid: u32| pid as i32));
+        cmd.stdin(stdin).stdout(stdout).stderr(stderr_writer.try_clone()?);
+        let spawned = cmd.spawn();
+        // Drop the command's copies of the pipes, or the commands reading them would never see their end
+        cmd.stdin(Stdio::null()).stdout(Stdio::null()).stderr(Stdio::null());
+        match spawned {
+            Ok(child) => {
+                group.get_or_insert(child.id());
+                children.push(child);
+            }
+            Err(e) => {
+                if let Some(pid) = group {
+                    kill_process(pid);
+                }
+                for mut child in children {
+                    let _ = child.wait();
+                }
+                return Err(e);
+            }
+        }
+    }
+    if let (Some(data), Some(mut pipe)) = (stdin, input) {
+        // Written from its own thread, as spawn does, and closed when the thread ends
+        let data = data.to_vec();
+        thread::spawn(move || {
+            let _ = pipe.write_all(&data);
+        });
+    }
+    let mut last = children.remove(0);
+    last.stderr = Some(ChildStderr::from(OwnedFd::from(stderr)));
+    thread::spawn(move || {
+        for mut child in children {
+            let _ = child.wait();
+        }
+    });
+    Ok(last)
+}
+
+#[cfg(not(unix))]
+fn spawn_pipeline(_stages: &mut [Command], _stdin: Option<&[u8]>) -> io::Result<Child> {
+    Err(io::Error::new(io::ErrorKind::Unsupported, "pipelines are only supported on Unix"))
+}
+
+/// Spawn a command with a new pseudo-terminal as its stdin, stdout and stderr, for programs that
+/// behave differently or refuse to run without one. Its merged output is read from the terminal as
+/// the child's stdout, and `stdin` is typed into it. The terminal doesn't echo input or turn "\n"
+/// into "\r\n", so the output holds just what the command wrote.
+#[cfg(unix)]
+fn spawn_pty(cmd: &mut Command, stdin: Option<&[u8]>, size: PtySize) -> io::Result<Child> {
+    use std::os::fd::{AsRawFd, FromRawFd, OwnedFd};
+    use std::os::unix::process::CommandExt;
+    use std::process::ChildStdout;
+
+    let (mut master, mut terminal) = (0, 0);
+    let winsize = libc::winsize {
+        ws_row: size.rows(),
+        ws_col: size.cols(),
+        ws_xpixel: 0,
+        ws_ypixel: 0,
+    };
+    // SAFETY: the out pointers are to live locals, and a null name and termios are allowed
+    if unsafe { libc::openpty(&mut master, &mut terminal, std::ptr::null_mut(), std::ptr::null(), &winsize) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+    // SAFETY: openpty succeeded, so both are open descriptors that nothing else owns
+    let (master, terminal) = unsafe { (OwnedFd::from_raw_fd(master), OwnedFd::from_raw_fd(terminal)) };
+    // SAFETY: termios is plain data, filled in by tcgetattr before it is read
+    unsafe {
+        let mut termios: libc::termios = std::mem::zeroed();
+        if libc::tcgetattr(terminal.as_raw_fd(), &mut termios) == 0 {
+            termios.c_lflag &= !libc::ECHO;
+            termios.c_oflag &= !libc::ONLCR;
+            libc::tcsetattr(terminal.as_raw_fd(), libc::TCSANOW, &termios);
+        }
+    }
+    // SAFETY: the hook only makes async-signal-safe calls. A new session also makes a new process group,
+    // as spawn's process_group(0) would, so a timeout can still kill every descendant. The calls are
+    // repeated harmlessly when a retry spawns the command again.
+    unsafe {
+        cmd.pre_exec(|| {
+            libc::setsid();
+            libc::ioctl(0, libc::TIOCSCTTY as _, 0);
+            Ok(())
+        });
+    }
+    cmd.stdin(terminal.try_clone()?).stdout(terminal.try_clone()?).stderr(terminal);
+    let spawned = cmd.spawn();
+    // Drop the command's copies of the terminal, or reading it would never see the end of the output
+    cmd.stdin(Stdio::null()).stdout(Stdio::null()).stderr(Stdio::null());
+    let mut child = spawned?;
+    if let Some(data) = stdin {
+        let mut input = std::fs::File::from(master.try_clone()?);
+        let data = data.to_vec();
+        thread::spawn(move || {
+            let _ = input.write_all(&data);
+        });
+    }
+    child.stdout = Some(ChildStdout::from(master));
+    Ok(child)
+}
+
+#[cfg(not(unix))]
+fn spawn_pty(_cmd: &mut Command, _stdin: Option<&[u8]>, _size: PtySize) -> io::Result<Child> {
+    Err(io::Error::new(io::ErrorKind::Unsupported, "pseudo-terminals are only supported on Unix"))
+}
+
+/// Whether a read failed because a pseudo-terminal closed: reading it reports EIO once every process
+/// holding the terminal has exited, which ends the output like EOF on a pipe
+#[cfg(unix)]
+fn is_closed_terminal(e: &io::Error) -> bool {
+    e.raw_os_error() == Some(libc::EIO)
+}
+
+#[cfg(not(unix))]
+fn is_closed_terminal(_e: &io::Error) -> bool {
+    false
+}
+
+/// Wait for a command, reading stdout and stderr concurrently so a command that fills one pipe while
+/// the other is read can't deadlock. With a filter, only the lines matching it are kept and counted.
+/// A stream that passes the limits is cut off there and the command and its descendants are killed,
+/// rather than left writing into a closed pipe. In a background job, the output is also added to the
+/// job's log as it is read, and the command can be cancelled. Lines read are counted on `running`.
+fn wait_limited(
+    mut child: Child,
+    limits: OutputLimits,
+    filter: Option<Regex>,
+    job: Option<Arc<Job>>,
+    running: Arc<RunningCommand>,
+) -> io::Result<Captured> {
+    let pid = child.id();
+    if let Some(ref job) = job {
+        job.set_pid(Some(pid));
+    }
+    running.set_pid(Some(pid));
+    let read = move |stream: Option<Box<dyn Read + Send>>| -> io::Result<(Vec<u8>, Option<usize>)> {
+        let (data, truncated_at) = match stream {
+            Some(stream) => read_limited(stream, limits)?,
+            None => (Vec::new(), None),
+        };
+        if truncated_at.is_some() {
+            kill_process(pid);
+        }
+        Ok((data, truncated_at))
+    };
+    let stderr = child.stderr.take().map(|s| filtered(logged(counted(s, &running), job.clone()), filter.clone()));
+    let stderr_reader = thread::spawn(move || read(stderr));
+    let stdout = child.stdout.take().map(|s| filtered(logged(counted(s, &running), job.clone()), filter));
+    let (stdout, stdout_truncated_at) = read(stdout)?;
+    let (stderr, stderr_truncated_at) = stderr_reader
+        .join()
+        .map_err(|_| io::Error::other("stderr reader panicked"))??;
+    let (status, usage) = wait_with_usage(&mut child)?;
+    if let Some(ref job) = job {
+        job.set_pid(None);
+    }
+    running.set_pid(None);
+    Ok(Captured {
+        output: Output { status, stdout, stderr },
+        stdout_truncated_at,
+        stderr_truncated_at,
+        usage,
+        spooled: running.spool.as_ref().map(|spool| spool.id),
+    })
+}
+
+/// A stream that also adds what is read from it to a job's log, or the stream as it is outside a job
+fn logged(stream: impl Read + Send + 'static, job: Option<Arc<Job>>) -> Box<dyn Read + Send> {
+    match job {
+        Some(job) => Box::new(JobOutput { stream, job }),
+        None => Box::new(stream),
+    }
+}
+
+/// Reads a command's output stream, adding what it reads to the log of the job running the command
+struct JobOutput<R> {
+    stream: R,
+    job: Arc<Job>,
+}
+
+impl<R: Read> Read for JobOutput<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let n = self.stream.read(buf)?;
+        self.job.append(&buf[..n]);
+        Ok(n)
+    }
+}
+
+/// A stream that counts the lines read from it as output of a running command, and spools it
+fn counted(stream: impl Read + Send + 'static, running: &Arc<RunningCommand>) -> impl Read + Send + 'static {
+    CountedOutput {
+        stream,
+        running: running.clone(),
+    }
+}
+
+/// Reads a command's output stream, counting its lines on the command's listing and adding what it
+/// reads to the command's spool
+struct CountedOutput<R> {
+    stream: R,
+    running: Arc<RunningCommand>,
+}
+
+impl<R: Read> Read for CountedOutput<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let n = self.stream.read(buf)?;
+        let lines = buf[..n].iter().filter(|&&byte| byte == b'\n').count();
+        self.running.lines.fetch_add(lines, Ordering::Relaxed);
+        if let Some(ref spool) = self.running.spool {
+            spool.append(&buf[..n]);
+        }
+        Ok(n)
+    }
+}
+
+/// A stream that yields only the lines matching `filter`, or the stream as it is without one
+fn filtered(stream: impl Read + Send + 'static, filter: Option<Regex>) -> Box<dyn Read + Send> {
+    match filter {
+        Some(filter) => Box::new(LineFilter {
+            lines: io::BufReader::new(stream),
+            filter,
+            line: Vec::new(),
+            pos: 0,
+        }),
+        None => Box::new(stream),
+    }
+}
+
+/// Longest piece of a line matched against a filter on its own; longer lines are matched in pieces
+/// so a command that never prints a newline can't grow the buffer without bound
+const MAX_FILTERED_LINE_BYTES: u64 = 1024 * 1024;
+
+/// Reads the lines of a stream that match a filter, dropping the rest. Lines are matched with their
+/// ANSI escape sequences removed, so a colored "ERROR" still matches.
+struct LineFilter<R> {
+    lines: io::BufReader<R>,
+    filter: Regex,
+    /// The matching line being read out, and how much of it has been
+    line: Vec<u8>,
+    pos: usize,
+}
+
+impl<R: Read> Read for LineFilter<R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        while self.pos == self.line.len() {
+            self.line.clear();
+            self.pos = 0;
+            if (&mut self.lines).take(MAX_FILTERED_LINE_BYTES).read_until(b'\n', &mut self.line)? == 0 {
+                return Ok(0);
+            }
+            if !self.filter.is_match(&strip_ansi(&String::from_utf8_lossy(&self.line))) {
+                self.line.clear();
+            }
+        }
+        let n = (self.line.len() - self.pos).min(buf.len());
+        buf[..n].copy_from_slice(&self.line[self.pos..self.pos + n]);
+        self.pos += n;
+        Ok(n)
+    }
+}
+
+/// Wait for a child to exit, with the CPU time and peak memory of it and the descendants it waited for
+#[cfg(unix)]
+fn wait_with_usage(child: &mut Child) -> io::Result<(ExitStatus, Option<ProcessUsage>)> {
+    use std::os::unix::process::ExitStatusExt;
+    let mut status = 0;
+    // SAFETY: rusage is plain data, for which all zeroes is a valid value
+    let mut rusage: libc::rusage = unsafe { std::mem::zeroed() };
+    loop {
+        // SAFETY: both pointers are to live locals. The child hasn't been waited for, so its pid
+        // can't have been reused, and Child won't wait for it again once this reaps it.
+        let pid = unsafe { libc::wait4(child.id() as libc::pid_t, &mut status, 0, &mut rusage) };
+        if pid >= 0 {
+            break;
+        }
+        let e = io::Error::last_os_error();
+        if e.kind() != io::ErrorKind::Interrupted {
+            return Err(e);
+        }
+    }
+    let time = |tv: libc::timeval| Duration::from_secs(tv.tv_sec as u64) + Duration::from_micros(tv.tv_usec as u64);
+    // ru_maxrss is in bytes on macOS and kilobytes elsewhere
+    let max_rss = if cfg!(target_os = "macos") { rusage.ru_maxrss as u64 } else { rusage.ru_maxrss as u64 * 1024 };
+    let usage = ProcessUsage {
+        user: time(rusage.ru_utime),
+        sys: time(rusage.ru_stime),
+        max_rss,
+    };
+    Ok((ExitStatus::from_raw(status), Some(usage)))
+}
+
+#[cfg(not(unix))]
+fn wait_with_usage(child: &mut Child) -> io::Result<(ExitStatus, Option<ProcessUsage>)> {
+    Ok((child.wait()?, None))
+}
+
+/// Read a stream until it closes or passes the limits. Returns what was read and, if the limits
+/// cut it off, the number of whole lines kept.
+fn read_limited(mut stream: impl Read, limits: OutputLimits) -> io::Result<(Vec<u8>, Option<usize>)> {
+    let mut data = Vec::new();
+    let mut lines = 0;
+    // With fail_fast: where the first line not yet checked starts, and the lines left in the error block
+    let mut unchecked = 0;
+    let mut block_left = None;
+    let mut buf = [0u8; 64 * 1024];
+    loop {
+        let n = match stream.read(&mut buf) {
+            Ok(0) => return Ok((data, None)),
+            Ok(n) => n,
+            Err(e) if e.kind() == io::ErrorKind::Interrupted => continue,
+            Err(e) if is_closed_terminal(&e) => return Ok((data, None)),
+            Err(e) => return Err(e),
+        };
+        // Cut at the first byte past the last allowed line or byte
+        let mut end = n;
+        for (i, &byte) in buf[..n].iter().enumerate() {
+            if lines >= limits.lines {
+                end = i;
+                break;
+            }
+            if byte == b'\n' {
+                lines += 1;
+            }
+        }
+        let cut = end < n || data.len() + end > limits.bytes;
+        end = end.min(limits.bytes - data.len());
+        data.extend_from_slice(&buf[..end]);
+        if limits.fail_fast {
+            if let Some(end) = end_of_first_error(&data, &mut unchecked, &mut block_left) {
+                data.truncate(end);
+                let kept = data.iter().filter(|&&byte| byte == b'\n').count();
+                return Ok((data, Some(kept)));
+            }
+        }
+        if cut {
+            let kept = data.iter().filter(|&&byte| byte == b'\n').count();
+            return Ok((data, Some(kept)));
+        }
+    }
+}
+
+/// Start sampling a command's process group when the execution context asks for a timeline
+fn start_sampling(pid: u32, interval: Option<Duration>) -> Option<Sampler> {
+    interval.map(|interval| Sampler::start(pid, interval))
+}
+
+/// Stop sampling a command or pipeline and add its timeline to this thread's usage
+fn finish_sampling(sampler: Option<Sampler>, stages: &[Command], interval: Option<Duration>) {
+    if let (Some(sampler), Some(interval)) = (sampler, interval) {
+        let timeline = Timeline {
+            program: programs(stages),
+            interval,
+            samples: sampler.stop(),
+        };
+        record_usage(|usage| usage.timelines.push(timeline));
+    }
+}
+
+/// Check the lines of `data` completed since the last call for the first block of errors: its first error
+/// line and up to ERROR_BLOCK_LINES lines of context after it, ending early at a blank line. Returns
+/// where the block ends once it has.
+fn end_of_first_error(data: &[u8], unchecked: &mut usize, block_left: &mut Option<usize>) -> Option<usize> {
+    while let Some(newline) = data[*unchecked..].iter().position(|&byte| byte == b'\n') {
+        let line_end = *unchecked + newline + 1;
+        let line = strip_ansi(&String::from_utf8_lossy(&data[*unchecked..line_end]));
+        *unchecked = line_end;
+        *block_left = match *block_left {
+            None if severity(&line) == Severity::Error => Some(ERROR_BLOCK_LINES),
+            None => None,
+            Some(_) if line.trim().is_empty() => Some(0),
+            Some(left) => Some(left.saturating_sub(1)),
+        };
+        if *block_left == Some(0) {
+            return Some(line_end);
+        }
+    }
+    None
+}
+
+/// Run a command or pipeline once and return its result with its exit code, if it exited normally
+fn run_without_timeout(
+    stages: &mut [Command],
+    stdin: Option<&[u8]>,
+    options: &RunOptions,
+) -> (ExecutionResult, Option<i32>) {
+    let waited = start(stages, stdin, options).and_then(|child| {
+        let sampler = start_sampling(child.id(), options.sample_interval);
+        let waited = wait_limited(
+            child,
+            options.limits,
+            options.filter.clone(),
+            options.job.clone(),
+            options.running.clone(),
+        );
+        finish_sampling(sampler, stages, options.sample_interval);
+        waited
+    });
+    match waited {
+        Ok(output) => {
+            output.record_usage();
+            let exit_code = output.output.status.code();
+            (output_to_result(output, options.text), exit_code)
+        }
+        Err(e) => (ExecutionResult::Error(format!("Failed to execute command: {}", e)), None),
+    }
+}
+
+/// Run a command or pipeline once, killing it after `timeout`, and return its result with its exit code
+fn run_with_timeout(
+    stages: &mut [Command],
+    timeout: Duration,
+    stdin: Option<&[u8]>,
+    options: &RunOptions,
+) -> (ExecutionResult, Option<i32>) {
     // Spawn the command
-    let child = match cmd.spawn() {
+    let child = match start(stages, stdin, options) {
         Ok(child) => child,
-        Err(e) => return ExecutionResult::Error(format!("Failed to spawn command: {}", e)),
+        Err(e) => return (ExecutionResult::Error(format!("Failed to spawn command: {}", e)), None),
     };
 
     // Use a channel to communicate between threads
@@ -52,81 +1396,482 @@ fn run_with_timeout(mut cmd: Command, timeout: Duration) -> ExecutionResult {
 
     // Get the child's pid before moving it into the thread
     let child_id = child.id();
+    let sampler = start_sampling(child_id, options.sample_interval);
 
-    // Spawn a thread to wait for the child
+    // Spawn a thread to wait for the child and read its output
+    let (limits, filter, job) = (options.limits, options.filter.clone(), options.job.clone());
+    let running = options.running.clone();
     let handle = thread::spawn(move || {
-        let result = child.wait_with_output();
+        let result = wait_limited(child, limits, filter, job, running);
         let _ = tx.send(result);
     });
 
     // Wait for either completion or timeout
-    match rx.recv_timeout(timeout) {
+    let received = rx.recv_timeout(timeout);
+    // Stopped before the command is killed, so a timeout keeps the samples taken while it ran
+    finish_sampling(sampler, stages, options.sample_interval);
+    let result = match received {
         Ok(Ok(output)) => {
             let _ = handle.join();
-            output_to_result(output)
+            output.record_usage();
+            let exit_code = output.output.status.code();
+            return (output_to_result(output, options.text), exit_code);
         }
         Ok(Err(e)) => {
             let _ = handle.join();
             ExecutionResult::Error(format!("Command failed: {}", e))
         }
         Err(mpsc::RecvTimeoutError::Timeout) => {
-            // Kill the child process to avoid resource leaks
-            kill_process(child_id);
-            // Wait for the thread to finish (it will get an error from the killed process)
-            let _ = handle.join();
-            ExecutionResult::Timeout
+            if stop_process(child_id, &rx, *TERM_GRACE) {
+                let _ = handle.join();
+            } else {
+                LEAKED.fetch_add(1, Ordering::Relaxed);
+                tracing::warn!(
+                    "Output of killed process {} is still held open by a descendant; abandoning its reader thread",
+                    child_id
+                );
+            }
+            ExecutionResult::Timeout(timeout)
         }
         Err(mpsc::RecvTimeoutError::Disconnected) => {
             ExecutionResult::Error("Command thread disconnected unexpectedly".to_string())
         }
+    };
+    (result, None)
+}
+
+/// Stop a timed-out command and its descendants: SIGTERM the process group, give it `grace` to exit,
+/// then SIGKILL whatever is left. Returns whether the reader thread finished. It finishes once every
+/// process holding the output pipes has exited; descendants that left the process group can keep them
+/// open indefinitely, so it is given up on after a further grace period rather than hanging the call.
+fn stop_process<T>(pid: u32, rx: &mpsc::Receiver<T>, grace: Duration) -> bool {
+    terminate_process(pid);
+    let exited = rx.recv_timeout(grace).is_ok();
+    // Also reaches descendants still running after the command itself exited
+    kill_process(pid);
+    exited || rx.recv_timeout(KILL_GRACE).is_ok()
+}
+
+/// Stop a command and its descendants from outside the call running it, as when its job is cancelled:
+/// SIGTERM the process group, then SIGKILL whatever is left once the grace period is up
+pub fn stop_in_background(pid: u32) {
+    terminate_process(pid);
+    thread::spawn(move || {
+        thread::sleep(*TERM_GRACE);
+        kill_process(pid);
+    });
+}
+
+/// Ask a process and its descendants to exit by the process ID
+fn terminate_process(pid: u32) {
+    #[cfg(unix)]
+    {
+        // The process leads its own group, so a negative PID signals the whole group
+        let _ = Command::new("kill")
+            .args(["-TERM", "--", &format!("-{}", pid)])
+            .output();
+    }
+    #[cfg(windows)]
+    {
+        let _ = Command::new("taskkill")
+            .args(["/T", "/PID", &pid.to_string()])
+            .output();
     }
 }
 
-/// Kill a process by its ID
+/// Kill a process and its descendants by the process ID
 fn kill_process(pid: u32) {
     #[cfg(unix)]
     {
+        // The process leads its own group, so a negative PID kills the whole group
         let _ = Command::new("kill")
-            .args(["-9", &pid.to_string()])
+            .args(["-9", "--", &format!("-{}", pid)])
             .output();
     }
     #[cfg(windows)]
     {
         let _ = Command::new("taskkill")
-            .args(["/F", "/PID", &pid.to_string()])
+            .args(["/F", "/T", "/PID", &pid.to_string()])
             .output();
     }
 }
 
-fn output_to_result(output: Output) -> ExecutionResult {
-    if output.status.success() {
-        ExecutionResult::Success(String::from_utf8_lossy(&output.stdout).into_owned())
-    } else {
-        let stderr = String::from_utf8_lossy(&output.stderr);
-        let stdout = String::from_utf8_lossy(&output.stdout);
-        if stderr.is_empty() {
-            ExecutionResult::Error(format!("Error: {}", stdout))
+/// Find an executable by name in the server's PATH
+pub fn find_executable(name: &str) -> Option<PathBuf> {
+    let path = std::env::var_os("PATH")?;
+    std::env::split_paths(&path)
+        .map(|dir| dir.join(name))
+        .find(|candidate| candidate.is_file())
+}
+
+fn output_to_result(captured: Captured, text: TextOptions) -> ExecutionResult {
+    output_to_result_impl(captured, text, *MAX_OUTPUT_BYTES, *MAX_LINE_BYTES)
+}
+
+/// Internal implementation for testability - takes the output and line caps as parameters.
+fn output_to_result_impl(
+    captured: Captured,
+    text: TextOptions,
+    max_bytes: usize,
+    max_line_bytes: usize,
+) -> ExecutionResult {
+    let output = captured.output;
+    let cut = captured.stdout_truncated_at.is_some()
+        || captured.stderr_truncated_at.is_some()
+        || exceeds_caps(&output.stdout, max_bytes, max_line_bytes)
+        || exceeds_caps(&output.stderr, max_bytes, max_line_bytes);
+    let stdout = stream_to_text(&output.stdout, text, max_bytes, max_line_bytes);
+    let stdout = mark_truncated(stdout, captured.stdout_truncated_at);
+    let stderr = stream_to_text(&output.stderr, text, max_bytes, max_line_bytes);
+    let stderr = mark_truncated(stderr, captured.stderr_truncated_at);
+    let result = if output.status.success() {
+        // Tools like bazel report on stderr only; return that rather than nothing
+        if stdout.is_empty() {
+            ExecutionResult::Success(stderr)
         } else {
-            ExecutionResult::Error(format!("Error: {}", stderr))
+            ExecutionResult::Success(stdout)
+        }
+    } else if stderr.is_empty() {
+        ExecutionResult::Error(format!("Error: {}", stdout))
+    } else if stdout.trim().is_empty() {
+        ExecutionResult::Error(format!("Error: {}", stderr))
+    } else {
+        // Test runners often print failures on stdout and only a summary on stderr
+        ExecutionResult::Error(format!("Error: {}\n--- stdout ---\n{}", stderr.trim_end(), stdout))
+    };
+    match (result, captured.spooled.filter(|_| cut)) {
+        (ExecutionResult::Success(text), Some(id)) => ExecutionResult::Success(mark_spooled(text, id)),
+        (ExecutionResult::Error(text), Some(id)) => ExecutionResult::Error(mark_spooled(text, id)),
+        (result, _) => result,
+    }
+}
+
+/// End a stream's text with a note if the output limits cut it off
+fn mark_truncated(text: String, truncated_at: Option<usize>) -> String {
+    match truncated_at {
+        Some(lines) => {
+            let separator = if text.is_empty() || text.ends_with('\n') { "" } else { "\n" };
+            format!("{}{}[output truncated at {} lines; the command was stopped]\n", text, separator, lines)
+        }
+        None => text,
+    }
+}
+
+/// Whether a stream's output is longer than the output cap, or has a line longer than the line cap
+fn exceeds_caps(bytes: &[u8], max_bytes: usize, max_line_bytes: usize) -> bool {
+    bytes.len() > max_bytes || bytes.split(|&byte| byte == b'\n').any(|line| line.len() > max_line_bytes)
+}
+
+/// End a result with where the output cut out of it can be read in full
+fn mark_spooled(text: String, id: u64) -> String {
+    let separator = if text.is_empty() || text.ends_with('\n') { "" } else { "\n" };
+    format!(
+        "{}{}[full output kept as invocation {}; read it with get_output {{\"id\": {}}}]\n",
+        text, separator, id, id
+    )
+}
+
+/// An ANSI escape sequence: CSI ("ESC [" parameters, final byte), OSC ("ESC ]" text ended by BEL or
+/// "ESC \"), a character set selection such as "ESC ( B", or a two-byte escape such as "ESC c"
+static ANSI_ESCAPE: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[()][0-9A-Za-z]|[@-Z\\-_])").unwrap()
+});
+
+/// Bytes checked for binary content, as git does
+const BINARY_SNIFF_BYTES: usize = 8000;
+
+/// Bytes shown in a hex dump of binary output
+const HEXDUMP_BYTES: usize = 256;
+
+/// Whether output is binary rather than text: it has a NUL byte or invalid UTF-8 near the start
+fn is_binary(bytes: &[u8]) -> bool {
+    let start = &bytes[..bytes.len().min(BINARY_SNIFF_BYTES)];
+    // A multi-byte character cut off at the end of the sample isn't invalid
+    start.contains(&0) || std::str::from_utf8(start).is_err_and(|e| e.error_len().is_some())
+}
+
+/// Convert a captured output stream to result text. Escape sequences are stripped from text as requested
+/// before it is capped by line and in total; binary output is rendered as requested instead, so a stray
+/// `cat` of a binary can't return garbage.
+fn stream_to_text(bytes: &[u8], text: TextOptions, max_bytes: usize, max_line_bytes: usize) -> String {
+    if !is_binary(bytes) {
+        let mut content = String::from_utf8_lossy(bytes);
+        if text.strip_ansi {
+            content = strip_ansi(&content).into();
+        }
+        return cap_output(&cap_lines(&content, max_line_bytes), max_bytes);
+    }
+    let summary = format!("[binary output, {} bytes]", bytes.len());
+    match text.binary {
+        BinaryOutput::Summary => summary,
+        BinaryOutput::Hexdump => format!("{}\n{}", summary, hexdump(&bytes[..bytes.len().min(HEXDUMP_BYTES)])),
+        // Encoded output can't be truncated without corrupting it
+        BinaryOutput::Base64 if bytes.len() / 3 * 4 > max_bytes => {
+            format!("{} (too large to return as base64; the limit is {} bytes)", summary, max_bytes)
+        }
+        BinaryOutput::Base64 => base64::engine::general_purpose::STANDARD.encode(bytes),
+    }
+}
+
+/// Remove ANSI escape sequences: colors and cursor movement (CSI), window titles and hyperlinks (OSC),
+/// and the two-byte escapes. Bazel and many other CLIs emit them even when not writing to a terminal.
+fn strip_ansi(text: &str) -> String {
+    if !text.contains('\x1b') {
+        return text.to_string();
+    }
+    ANSI_ESCAPE.replace_all(text, "").into_owned()
+}
+
+/// A `hexdump -C` style dump: offset, 16 hex bytes, and their printable ASCII
+fn hexdump(bytes: &[u8]) -> String {
+    bytes
+        .chunks(16)
+        .enumerate()
+        .map(|(i, chunk)| {
+            let hex: Vec<String> = chunk.iter().map(|b| format!("{:02x}", b)).collect();
+            let ascii: String = chunk
+                .iter()
+                .map(|&b| if b.is_ascii_graphic() || b == b' ' { b as char } else { '.' })
+                .collect();
+            format!("{:08x}  {:<47}  |{}|", i * 16, hex.join(" "), ascii)
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Keep at most the first `max_bytes` of each line, noting how much of the line was dropped
+fn cap_lines(text: &str, max_bytes: usize) -> String {
+    if text.len() <= max_bytes {
+        return text.to_string();
+    }
+    let mut result = String::with_capacity(text.len().min(max_bytes * 2));
+    for line in text.split_inclusive('\n') {
+        let (content, newline) = match line.strip_suffix('\n') {
+            Some(content) => (content, "\n"),
+            None => (line, ""),
+        };
+        if content.len() <= max_bytes {
+            result.push_str(line);
+            continue;
+        }
+        let mut end = max_bytes;
+        while !content.is_char_boundary(end) {
+            end -= 1;
+        }
+        result.push_str(&content[..end]);
+        result.push_str(&format!(" [... {} bytes truncated from this line ...]", content.len() - end));
+        result.push_str(newline);
+    }
+    result
+}
+
+/// Keep at most the last `max_bytes` of an output stream, noting how much was dropped
+fn cap_output(text: &str, max_bytes: usize) -> String {
+    if text.len() <= max_bytes {
+        return text.to_string();
+    }
+    let mut start = text.len() - max_bytes;
+    while !text.is_char_boundary(start) {
+        start += 1;
+    }
+    // Start at a line boundary so the first kept line is whole
+    if let Some(newline) = text[start..].find('\n') {
+        start += newline + 1;
+    }
+    format!("[... {} bytes truncated ...]\n{}", start, &text[start..])
+}
+
+/// Format a timeout in whole seconds, or milliseconds if it isn't a whole number of seconds
+fn format_timeout(timeout: Duration) -> String {
+    if timeout.subsec_millis() == 0 {
+        format!("{}s", timeout.as_secs())
+    } else {
+        format!("{}ms", timeout.as_millis())
+    }
+}
+
+impl ExecutionResult {
+    /// Convert to a string result for the tool response.
+    /// Failures end with a "Failure category:" line so automation can decide whether to retry.
+    pub fn into_string(self) -> String {
+        match self {
+            ExecutionResult::Success(s) => s,
+            ExecutionResult::Error(s) => {
+                format!("{}\nFailure category: {}", s.trim_end(), classify(&s))
+            }
+            ExecutionResult::Timeout(timeout) => format!(
+                "Error: Command timed out and was killed after {}\nFailure category: {}",
+                format_timeout(timeout),
+                TIMEOUT_CATEGORY
+            ),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Arc;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_sanitize_env() {
+        let mut cmd = Command::new("env");
+        cmd.env("PROFILE_VAR", "1");
+        sanitize_env(&mut cmd, &["CARGO".to_string()]);
+        let envs: HashMap<_, _> = cmd
+            .get_envs()
+            .map(|(key, value)| (key.to_string_lossy().to_string(), value.is_some()))
+            .collect();
+        assert_eq!(envs.get("PROFILE_VAR"), Some(&true));
+        assert_eq!(envs.contains_key("PATH"), std::env::var_os("PATH").is_some());
+        // cargo sets CARGO for tests, and it is only inherited because it was passed through
+        assert_eq!(envs.contains_key("CARGO"), std::env::var_os("CARGO").is_some());
+        assert!(!envs.contains_key("CARGO_PKG_NAME"));
+    }
+
+    #[test]
+    fn test_run_command_does_not_inherit_server_env() {
+        let cmd = Command::new("env");
+        match run_command(cmd, &ExecutionContext::default()) {
+            ExecutionResult::Success(s) => {
+                assert!(s.contains("PATH="));
+                assert!(!s.contains("CARGO_PKG_NAME="), "{}", s);
+            }
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[test]
+    fn test_run_command_uses_context_executor() {
+        let fake = Arc::new(FakeExecutor::default());
+        let ctx = ExecutionContext {
+            executor: Some(fake.clone()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("rm");
+        cmd.args(["-rf", "/nonexistent"]);
+        assert!(matches!(run_command(cmd, &ctx), ExecutionResult::Success(_)));
+        assert_eq!(fake.commands(), vec!["rm -rf /nonexistent"]);
+
+        let (mut cat, mut grep) = (Command::new("cat"), Command::new("grep"));
+        cat.arg("log.txt");
+        grep.arg("err");
+        assert!(matches!(run_pipeline(vec![cat, grep], &ctx), ExecutionResult::Success(_)));
+        assert_eq!(fake.commands()[1], "cat log.txt | grep err");
+    }
+
+    /// A script that fails with `stderr` and `code` until it has run `failures` times in `dir`
+    fn flaky(dir: &Path, failures: usize, stderr: &str, code: i32) -> Command {
+        let script = format!(
+            "n=$(ls | wc -l); touch run$n; if [ $n -lt {} ]; then echo '{}' >&2; exit {}; fi; echo ok",
+            failures, stderr, code
+        );
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", &script]).current_dir(dir);
+        cmd
+    }
+
+    fn retry_context(policy: RetryPolicy) -> ExecutionContext {
+        ExecutionContext {
+            retry: Some(policy),
+            ..Default::default()
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_retries_transient_failure() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let ctx = retry_context(RetryPolicy {
+            backoff_ms: Some(10),
+            ..Default::default()
+        });
+        match run_command(flaky(temp_dir.path(), 2, "connection reset by peer", 1), &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(
+                s,
+                "ok\n[attempt 1 of 3 failed (flaky-infra); retrying in 10ms]\n\
+                 [attempt 2 of 3 failed (flaky-infra); retrying in 20ms]"
+            ),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_retries_listed_exit_code() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let ctx = retry_context(RetryPolicy {
+            max_attempts: Some(2),
+            backoff_ms: Some(1),
+            exit_codes: vec![75],
+            ..Default::default()
+        });
+        match run_command(flaky(temp_dir.path(), 5, "busy", 75), &ctx) {
+            ExecutionResult::Error(s) => {
+                assert!(s.starts_with("Error: busy\n"), "{}", s);
+                assert!(s.ends_with("[attempt 1 of 2 failed (exit code 75); retrying in 1ms]"), "{}", s);
+            }
+            _ => panic!("Expected error"),
+        }
+        assert_eq!(std::fs::read_dir(temp_dir.path()).unwrap().count(), 2);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_retries_with_mitigation() {
+        let mut cmd = Command::new("sh");
+        // Fails like a remote cache timeout unless run with --fresh
+        cmd.args([
+            "-c",
+            "if [ \"$1\" = --fresh ]; then echo ok; else echo 'remote cache timed out' >&2; exit 1; fi",
+            "sh",
+        ]);
+        let ctx = retry_context(RetryPolicy {
+            backoff_ms: Some(1),
+            ..Default::default()
+        });
+        let mitigations = parse_mitigations("sh:cache-miss-timeout=--fresh");
+        match run_local_impl(vec![cmd], &ctx, &mitigations) {
+            ExecutionResult::Success(s) => assert_eq!(
+                s,
+                "ok\n[attempt 1 of 3 failed (cache-miss-timeout); retrying in 1ms with --fresh]"
+            ),
+            _ => panic!("Expected success"),
         }
     }
-}
 
-impl ExecutionResult {
-    /// Convert to a string result for the tool response
-    pub fn into_string(self) -> String {
-        match self {
-            ExecutionResult::Success(s) => s,
-            ExecutionResult::Error(s) => s,
-            ExecutionResult::Timeout => "Error: Command timed out".to_string(),
-        }
+    #[test]
+    fn test_mitigation_args() {
+        let mitigations = parse_mitigations("bazel:cache-miss-timeout=--noremote_accept_cached; go = -count=1 ;bogus;x=");
+        assert_eq!(mitigations.len(), 2);
+        assert_eq!(
+            mitigation_args(&mitigations, "/usr/bin/bazel", "cache-miss-timeout"),
+            Some(&["--noremote_accept_cached".to_string()][..])
+        );
+        assert_eq!(mitigation_args(&mitigations, "bazel", "flaky-infra"), None);
+        assert_eq!(mitigation_args(&mitigations, "go", "exit code 75"), Some(&["-count=1".to_string()][..]));
     }
-}
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use std::collections::HashMap;
+    #[test]
+    fn test_with_args_goes_before_double_dash() {
+        let mut cmd = Command::new("bazel");
+        cmd.args(["run", "//app", "--", "--port=80"]).env("CC", "clang");
+        let copy = with_args(&cmd, &["--noremote_accept_cached".to_string()]);
+        let args: Vec<_> = copy.get_args().collect();
+        assert_eq!(args, ["run", "//app", "--noremote_accept_cached", "--", "--port=80"]);
+        assert_eq!(copy.get_envs().count(), 1);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_does_not_retry_other_failures() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let ctx = retry_context(RetryPolicy::default());
+        let result = run_command(flaky(temp_dir.path(), 1, "--- FAIL: TestParse", 1), &ctx);
+        assert!(matches!(result, ExecutionResult::Error(ref s) if !s.contains("[attempt")));
+        assert_eq!(std::fs::read_dir(temp_dir.path()).unwrap().count(), 1);
+    }
 
     #[test]
     fn test_run_command_simple() {
@@ -141,6 +1886,25 @@ mod tests {
         }
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_records_history() {
+        let ctx = ExecutionContext {
+            tool: Some("test_run_command_records_history".to_string()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo failing >&2; exit 3"]);
+        run_command(cmd, &ctx);
+        let entry = history::commands()
+            .into_iter()
+            .find(|entry| entry.tool == "test_run_command_records_history")
+            .unwrap();
+        assert_eq!(entry.argv, [["sh", "-c", "echo failing >&2; exit 3"]]);
+        assert_eq!(entry.exit_code, Some(3));
+        assert_eq!(entry.output, "Error: failing\n");
+    }
+
     #[test]
     fn test_run_command_with_working_dir() {
         let cmd = Command::new("pwd");
@@ -172,6 +1936,235 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_run_command_with_stdin() {
+        let mut cmd = Command::new("grep");
+        cmd.arg("b");
+        let ctx = ExecutionContext {
+            stdin: Some(b"a\nb\nc\n".to_vec()),
+            ..Default::default()
+        };
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s, "b\n"),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[test]
+    fn test_dry_run_describes_command() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        std::fs::write(temp_dir.path().join("keep me"), "").unwrap();
+        let mut cmd = Command::new("rm");
+        cmd.args(["-f", "keep me"]);
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            env: Some(HashMap::from([("API_TOKEN".to_string(), "secret".to_string())])),
+            dry_run: true,
+            ..Default::default()
+        };
+        let s = match run_command(cmd, &ctx) {
+            ExecutionResult::Success(s) => s,
+            _ => panic!("Expected success"),
+        };
+        assert!(s.starts_with("Dry run, not executed: rm -f 'keep me'\n"), "{}", s);
+        assert!(s.contains(&format!("Working directory: {}\n", temp_dir.path().display())), "{}", s);
+        assert!(s.contains("\n  API_TOKEN=[REDACTED]\n") && !s.contains("secret"), "{}", s);
+        assert!(s.contains("\nPolicies:\n  timeout 10s\n"), "{}", s);
+        assert!(temp_dir.path().join("keep me").exists());
+    }
+
+    #[test]
+    fn test_quote() {
+        assert_eq!(quote("--output=a/b.txt"), "--output=a/b.txt");
+        assert_eq!(quote("two words"), "'two words'");
+        assert_eq!(quote("it's"), "'it'\\''s'");
+        assert_eq!(quote(""), "''");
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_pipeline() {
+        let (mut printf, mut grep, mut head) = (Command::new("printf"), Command::new("grep"), Command::new("head"));
+        printf.arg("a\\nerr 1\\nb\\nerr 2\\n");
+        grep.arg("err");
+        head.args(["-n", "1"]);
+        match run_pipeline(vec![printf, grep, head], &ExecutionContext::default()) {
+            ExecutionResult::Success(s) => assert_eq!(s, "err 1\n"),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_pipeline_with_stdin() {
+        let mut wc = Command::new("wc");
+        wc.arg("-l");
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            stdin: Some(b"a\nb\n".to_vec()),
+            ..Default::default()
+        };
+        match run_pipeline(vec![Command::new("cat"), wc], &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s.trim(), "2"),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_pipeline_reads_every_stderr() {
+        let (mut cat, mut grep) = (Command::new("cat"), Command::new("grep"));
+        cat.arg("/nonexistent/log.txt");
+        grep.arg("err");
+        match run_pipeline(vec![cat, grep], &ExecutionContext::default()) {
+            ExecutionResult::Error(s) => assert!(s.contains("/nonexistent/log.txt"), "{}", s),
+            _ => panic!("Expected error"),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_pipeline_timeout_kills_every_command() {
+        // The first command holds the pipe the last one reads, so both must be killed
+        let mut sleep = Command::new("sleep");
+        sleep.arg("30");
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_millis(100)),
+            ..Default::default()
+        };
+        let started = std::time::Instant::now();
+        assert!(matches!(run_pipeline(vec![sleep, Command::new("cat")], &ctx), ExecutionResult::Timeout(_)));
+        assert!(started.elapsed() < KILL_GRACE);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_cancel_running_command_returns_partial_output() {
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            tool: Some("test_cancel_running_command".to_string()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo partial; sleep 30"]);
+        let running = thread::spawn(move || run_command(cmd, &ctx));
+        let command = loop {
+            let found = running_commands().into_iter().find(|c| c.tool == "test_cancel_running_command");
+            match found {
+                Some(command) if command.lines() > 0 => break command,
+                _ => thread::sleep(Duration::from_millis(10)),
+            }
+        };
+        let started = std::time::Instant::now();
+        assert!(running_command(command.id).unwrap().cancel());
+        assert!(!command.cancel());
+        let ExecutionResult::Error(output) = running.join().unwrap() else {
+            panic!("a cancelled command fails");
+        };
+        assert!(output.contains("partial"), "{}", output);
+        assert!(output.contains(&format!("[command #{} was cancelled after ", command.id)), "{}", output);
+        assert!(started.elapsed() < Duration::from_secs(5));
+        assert!(running_command(command.id).is_none());
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_in_job_logs_output_and_can_be_cancelled() {
+        let job = Arc::new(Job::detached(1, "presubmit"));
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            job: Some(job.clone()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo started; sleep 30"]);
+        let running = {
+            let ctx = ctx.clone();
+            thread::spawn(move || run_command(cmd, &ctx))
+        };
+        while job.log_len() == 0 {
+            thread::sleep(Duration::from_millis(10));
+        }
+        assert_eq!(job.read_log(0, 100), (0, b"started\n".to_vec()));
+        let started = std::time::Instant::now();
+        assert!(job.cancel());
+        assert!(!matches!(running.join().unwrap(), ExecutionResult::Success(_)));
+        assert!(started.elapsed() < Duration::from_secs(5));
+        // Nothing more runs once the job is cancelled
+        match run_command(Command::new("true"), &ctx) {
+            ExecutionResult::Error(e) => assert_eq!(e, "Error: The job was cancelled"),
+            _ => panic!("Expected the cancellation error"),
+        }
+    }
+
+    #[test]
+    fn test_run_command_with_large_stdin() {
+        // More than a pipe buffer in both directions, so writing and reading must overlap
+        let input = "x".repeat(99) + "\n";
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            stdin: Some(input.repeat(2000).into_bytes()),
+            ..Default::default()
+        };
+        match run_command(Command::new("cat"), &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s.len(), 200_000),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[test]
+    fn test_run_command_drains_both_pipes() {
+        // Fill stderr well past a pipe buffer before writing stdout
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "i=0; while [ $i -lt 5000 ]; do echo 'INFO: Analyzing target' >&2; i=$((i+1)); done; echo done"]);
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            ..Default::default()
+        };
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s, "done\n"),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    /// Spawn a shell script in its own process group with a thread waiting for it, as run_with_timeout does
+    #[cfg(unix)]
+    fn spawn_group(script: &str) -> (u32, mpsc::Receiver<std::io::Result<Output>>) {
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", script]);
+        let child = spawn(&mut cmd, None).unwrap();
+        let pid = child.id();
+        let (tx, rx) = mpsc::channel();
+        thread::spawn(move || {
+            let _ = tx.send(child.wait_with_output());
+        });
+        (pid, rx)
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_stop_process_terminates_gracefully() {
+        let (pid, rx) = spawn_group("sleep 30");
+        let started = std::time::Instant::now();
+        assert!(stop_process(pid, &rx, Duration::from_secs(5)));
+        // sleep exits on SIGTERM, well before the grace period is up
+        assert!(started.elapsed() < Duration::from_secs(4));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_stop_process_kills_after_grace() {
+        // Ignoring SIGTERM is inherited by sleep, so only SIGKILL stops them
+        let (pid, rx) = spawn_group("trap '' TERM; sleep 30");
+        // Let the shell install its trap first
+        thread::sleep(Duration::from_millis(200));
+        let started = std::time::Instant::now();
+        assert!(stop_process(pid, &rx, Duration::from_millis(300)));
+        let elapsed = started.elapsed();
+        assert!(elapsed >= Duration::from_millis(300) && elapsed < Duration::from_secs(4), "{:?}", elapsed);
+    }
+
     #[test]
     fn test_run_command_timeout() {
         let mut cmd = Command::new("sleep");
@@ -182,11 +2175,413 @@ mod tests {
         };
         let result = run_command(cmd, &ctx);
         match result {
-            ExecutionResult::Timeout => {}
+            ExecutionResult::Timeout(timeout) => assert_eq!(timeout, Duration::from_millis(100)),
             _ => panic!("Expected timeout"),
         }
     }
 
+    #[test]
+    fn test_run_command_with_timeout_captures_output() {
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo captured; echo failed >&2"]);
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            ..Default::default()
+        };
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s, "captured\n"),
+            _ => panic!("Expected success"),
+        }
+    }
+
+    #[test]
+    fn test_run_command_timeout_kills_descendants() {
+        // The background sleep inherits the output pipes; killing only the shell would
+        // leave them open until it exits
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "sleep 30 & sleep 30"]);
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_millis(100)),
+            ..Default::default()
+        };
+        let started = std::time::Instant::now();
+        assert!(matches!(run_command(cmd, &ctx), ExecutionResult::Timeout(_)));
+        assert!(started.elapsed() < KILL_GRACE);
+    }
+
+    #[test]
+    fn test_into_string_adds_failure_category() {
+        let result = ExecutionResult::Error("Error: ls: cannot access 'x': No such file or directory\n".to_string());
+        assert_eq!(
+            result.into_string(),
+            "Error: ls: cannot access 'x': No such file or directory\nFailure category: not-found"
+        );
+        assert_eq!(
+            ExecutionResult::Timeout(Duration::from_secs(180)).into_string(),
+            "Error: Command timed out and was killed after 180s\nFailure category: timeout"
+        );
+        assert!(ExecutionResult::Timeout(Duration::from_millis(1500))
+            .into_string()
+            .starts_with("Error: Command timed out and was killed after 1500ms\n"));
+        assert_eq!(ExecutionResult::Success("ok\n".to_string()).into_string(), "ok\n");
+    }
+
+    /// Text options that render binary output as `binary` and strip escape sequences
+    fn text(binary: BinaryOutput) -> TextOptions {
+        TextOptions {
+            binary,
+            strip_ansi: true,
+        }
+    }
+
+    /// Convert the output of a command exiting with `code` after printing `stdout` and `stderr`
+    fn convert(code: i32, stdout: &str, stderr: &str) -> String {
+        let script = format!("printf '%s' \"$0\"; printf '%s' \"$1\" >&2; exit {}", code);
+        let output = Command::new("sh").args(["-c", &script, stdout, stderr]).output().unwrap();
+        match output_to_result_impl(output.into(), text(BinaryOutput::Summary), 1024, 1024) {
+            ExecutionResult::Success(s) => format!("ok: {}", s),
+            ExecutionResult::Error(s) => s,
+            ExecutionResult::Timeout(_) => "timeout".to_string(),
+        }
+    }
+
+    #[test]
+    fn test_output_to_result_returns_stderr_when_stdout_is_empty() {
+        assert_eq!(convert(0, "out", "err"), "ok: out");
+        assert_eq!(convert(0, "", "INFO: Build completed"), "ok: INFO: Build completed");
+    }
+
+    #[test]
+    fn test_output_to_result_includes_stdout_on_failure() {
+        assert_eq!(
+            convert(1, "test parse ... FAILED\n", "error: test failed\n"),
+            "Error: error: test failed\n--- stdout ---\ntest parse ... FAILED\n"
+        );
+        assert_eq!(convert(1, "", "boom"), "Error: boom");
+        assert_eq!(convert(1, "boom", ""), "Error: boom");
+    }
+
+    #[test]
+    fn test_output_to_result_points_cut_output_at_spool() {
+        let output = Command::new("sh").args(["-c", "seq 1 100"]).output().unwrap();
+        let captured = |spooled| Captured {
+            spooled,
+            ..Captured::from(output.clone())
+        };
+        let result = output_to_result_impl(captured(Some(42)), text(BinaryOutput::Summary), 20, 1024).into_string();
+        assert!(result.starts_with("[... 273 bytes truncated ...]\n"));
+        let note = "[full output kept as invocation 42; read it with get_output {\"id\": 42}]\n";
+        assert!(result.ends_with(&format!("\n100\n{}", note)));
+        // Output that isn't cut needs no pointer
+        let result = output_to_result_impl(captured(Some(42)), text(BinaryOutput::Summary), 1024, 1024).into_string();
+        assert!(!result.contains("get_output"));
+    }
+
+    #[test]
+    fn test_read_limited() {
+        let limits = OutputLimits {
+            bytes: 100,
+            lines: 2,
+            fail_fast: false,
+        };
+        assert_eq!(read_limited(&b"a\nb\n"[..], limits).unwrap(), (b"a\nb\n".to_vec(), None));
+        assert_eq!(read_limited(&b"a\nb\nc\n"[..], limits).unwrap(), (b"a\nb\n".to_vec(), Some(2)));
+        let limits = OutputLimits {
+            bytes: 5,
+            lines: 10,
+            fail_fast: false,
+        };
+        assert_eq!(read_limited(&b"abc\ndefgh"[..], limits).unwrap(), (b"abc\nd".to_vec(), Some(1)));
+        assert_eq!(read_limited(&b"abc\nd"[..], limits).unwrap(), (b"abc\nd".to_vec(), None));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_under_pty() {
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(10)),
+            pty: Some(PtySize {
+                cols: Some(100),
+                rows: None,
+            }),
+            stdin: Some(b"hello\n".to_vec()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "test -t 1 && echo tty; stty size; read x; echo got $x >&2"]);
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Success(s) => assert_eq!(s, "tty\n40 100\ngot hello\n"),
+            ExecutionResult::Error(s) => panic!("Expected success: {}", s),
+            ExecutionResult::Timeout(_) => panic!("Expected success, timed out"),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_retries_keep_profile_priority() {
+        let profile: &'static Profile = Box::leak(Box::new(Profile {
+            nice: Some(3),
+            ..Default::default()
+        }));
+        let niceness = |retry: Option<RetryPolicy>| {
+            let temp_dir = tempfile::TempDir::new().unwrap();
+            let ctx = ExecutionContext {
+                profile: Some(profile),
+                retry,
+                ..Default::default()
+            };
+            let mut cmd = Command::new("sh");
+            cmd.args(["-c", "n=$(ls | wc -l); touch run$n; nice; [ $n -lt 2 ] && exit 75; exit 0"]);
+            cmd.current_dir(temp_dir.path());
+            // The niceness the last attempt printed, which leads its output; a single attempt fails
+            match run_command(cmd, &ctx) {
+                ExecutionResult::Success(s) | ExecutionResult::Error(s) => {
+                    s.trim_start_matches("Error: ").lines().next().unwrap().trim().to_string()
+                }
+                ExecutionResult::Timeout(_) => panic!("Expected the command to finish, timed out"),
+            }
+        };
+        let once = niceness(None);
+        let retried = niceness(Some(RetryPolicy {
+            max_attempts: Some(3),
+            backoff_ms: Some(1),
+            exit_codes: vec![75],
+            ..Default::default()
+        }));
+        // The third attempt runs at the same niceness as a single one
+        assert_eq!(retried, once);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_sets_profile_limits_and_priority() {
+        let profile: &'static Profile = Box::leak(Box::new(Profile {
+            nice: Some(5),
+            ulimits: Rlimits::parse("files=64;cpu=30"),
+            ..Default::default()
+        }));
+        for pty in [None, Some(PtySize::default())] {
+            let ctx = ExecutionContext {
+                timeout: Some(Duration::from_secs(10)),
+                profile: Some(profile),
+                pty,
+                ..Default::default()
+            };
+            let mut cmd = Command::new("sh");
+            cmd.args(["-c", "ulimit -n; ulimit -t; nice"]);
+            match run_command(cmd, &ctx) {
+                // Lowered to the hard limits the tests run with, if those are lower
+                ExecutionResult::Success(s) => {
+                    let limits: Vec<u64> = s.lines().map(|l| l.trim().parse().unwrap()).collect();
+                    assert!(limits[0] <= 64 && limits[1] <= 30, "{}", s);
+                    // nice prints the niceness it runs at, which includes the profile's adjustment
+                    assert!(limits[2] >= 5, "{}", s);
+                }
+                ExecutionResult::Error(s) => panic!("Expected success: {}", s),
+                ExecutionResult::Timeout(_) => panic!("Expected success, timed out"),
+            }
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_under_pty_timeout() {
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_millis(300)),
+            pty: Some(PtySize::default()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sleep");
+        cmd.arg("30");
+        let started = std::time::Instant::now();
+        assert!(matches!(run_command(cmd, &ctx), ExecutionResult::Timeout(_)));
+        assert!(started.elapsed() < Duration::from_secs(10));
+    }
+
+    #[test]
+    fn test_line_filter() {
+        let filter = Regex::new("ERROR|FAIL").unwrap();
+        let stream = &b"INFO: Analyzing\n\x1b[31mERROR:\x1b[0m foo.cc:1\n[12 / 300] Compiling\nFAIL: //a:test"[..];
+        let limits = OutputLimits {
+            bytes: 100,
+            lines: 10,
+            fail_fast: false,
+        };
+        assert_eq!(
+            read_limited(filtered(stream, Some(filter.clone())), limits).unwrap(),
+            (b"\x1b[31mERROR:\x1b[0m foo.cc:1\nFAIL: //a:test".to_vec(), None)
+        );
+        // Only the kept lines count toward the limits
+        let limits = OutputLimits {
+            bytes: 100,
+            lines: 1,
+            fail_fast: false,
+        };
+        assert_eq!(read_limited(filtered(stream, Some(filter)), limits).unwrap().1, Some(1));
+    }
+
+    #[test]
+    fn test_read_limited_fail_fast() {
+        let limits = OutputLimits {
+            bytes: 1000,
+            lines: 100,
+            fail_fast: true,
+        };
+        let block = b"INFO: Analyzing\nERROR: foo/BUILD:3:1: Compiling foo.cc failed\nfoo.cc:1: error: x\n\n";
+        let output = [&block[..], b"ERROR: bar\n"].concat();
+        assert_eq!(read_limited(&output[..], limits).unwrap(), (block.to_vec(), Some(4)));
+        // A block without a blank line ends after ERROR_BLOCK_LINES lines
+        let mut output = b"--- FAIL: TestParse\n".to_vec();
+        output.extend(b"    parse_test.go:12: got 1\n".repeat(30));
+        let (data, kept) = read_limited(&output[..], limits).unwrap();
+        assert_eq!(kept, Some(ERROR_BLOCK_LINES + 1));
+        assert!(data.ends_with(b"got 1\n"));
+        assert_eq!(read_limited(&b"ok\nall good\n"[..], limits).unwrap(), (b"ok\nall good\n".to_vec(), None));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_stops_runaway_output() {
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "yes line; echo unreachable"]);
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(30)),
+            max_output_lines: Some(3),
+            ..Default::default()
+        };
+        let started = std::time::Instant::now();
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Error(s) => assert_eq!(
+                s,
+                "Error: line\nline\nline\n[output truncated at 3 lines; the command was stopped]\n"
+            ),
+            _ => panic!("Expected error"),
+        }
+        assert!(started.elapsed() < Duration::from_secs(10));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_records_usage() {
+        take_usage();
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done"]);
+        assert!(matches!(run_command(cmd, &ExecutionContext::default()), ExecutionResult::Success(_)));
+        let usage = take_usage();
+        assert_eq!(usage.commands, 1);
+        assert!(usage.wall > Duration::ZERO);
+        assert!(usage.user + usage.sys > Duration::ZERO, "{:?}", usage);
+        assert!(usage.max_rss > 0);
+        assert_eq!(take_usage(), Usage::default());
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn test_run_command_samples_timeline() {
+        take_usage();
+        let ctx = ExecutionContext {
+            sample_interval: Some(Duration::from_millis(100)),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sleep");
+        cmd.arg("0.5");
+        assert!(matches!(run_command(cmd, &ctx), ExecutionResult::Success(_)));
+        let usage = take_usage();
+        assert_eq!(usage.timelines.len(), 1);
+        assert_eq!(usage.timelines[0].program, "sleep");
+        assert!(!usage.timelines[0].samples.is_empty());
+    }
+
+    #[test]
+    fn test_usage_display() {
+        let usage = Usage {
+            commands: 2,
+            wall: Duration::from_millis(12_400),
+            user: Duration::from_millis(10_050),
+            sys: Duration::from_millis(1_200),
+            max_rss: 512 * 1024 * 1024,
+            cgroup_memory_peak: None,
+            timelines: Vec::new(),
+        };
+        assert_eq!(usage.to_string(), "Usage: 2 commands in 12.4s; CPU 10.1s user, 1.2s sys; max RSS 512.0 MiB");
+        let confined = Usage {
+            commands: 1,
+            cgroup_memory_peak: Some(3 * 1024 * 1024 * 1024),
+            ..usage
+        };
+        assert!(confined.to_string().ends_with("; max RSS 512.0 MiB; cgroup peak memory 3.0 GiB"));
+        assert_eq!(Usage::default().to_string(), "Usage: no commands run");
+    }
+
+    #[test]
+    fn test_is_binary() {
+        assert!(!is_binary(b"plain text\n"));
+        assert!(!is_binary("caf\u{e9}\n".as_bytes()));
+        assert!(is_binary(b"\x7fELF\x02\x01\x01\x00"));
+        assert!(is_binary(b"\xff\xfe latin-1 or worse"));
+        // A UTF-8 character split by the sample boundary is still text
+        let mut text = "a".repeat(BINARY_SNIFF_BYTES - 1).into_bytes();
+        text.extend_from_slice("é".as_bytes());
+        assert!(!is_binary(&text));
+    }
+
+    #[test]
+    fn test_stream_to_text_binary_modes() {
+        let bytes = b"\x7fELF\x00\x01hello";
+        assert_eq!(stream_to_text(bytes, text(BinaryOutput::Summary), 1024, 1024), "[binary output, 11 bytes]");
+        assert_eq!(
+            stream_to_text(bytes, text(BinaryOutput::Hexdump), 1024, 1024),
+            "[binary output, 11 bytes]\n00000000  7f 45 4c 46 00 01 68 65 6c 6c 6f                 |.ELF..hello|"
+        );
+        assert_eq!(stream_to_text(bytes, text(BinaryOutput::Base64), 1024, 1024), "f0VMRgABaGVsbG8=");
+        assert!(stream_to_text(bytes, text(BinaryOutput::Base64), 8, 1024).contains("too large to return as base64"));
+    }
+
+    #[test]
+    fn test_strip_ansi() {
+        assert_eq!(
+            strip_ansi("\x1b[32mINFO:\x1b[0m Build completed\n\x1b[1A\x1b[K(12:01:02) Analyzing\x1b(B\n"),
+            "INFO: Build completed\n(12:01:02) Analyzing\n"
+        );
+        assert_eq!(strip_ansi("\x1b]8;;https://example.com\x07link\x1b]8;;\x1b\\ done"), "link done");
+        assert_eq!(strip_ansi("no escapes [0m"), "no escapes [0m");
+    }
+
+    #[test]
+    fn test_stream_to_text_keeps_ansi_when_asked() {
+        let bytes = b"\x1b[31mFAIL\x1b[0m\n";
+        assert_eq!(stream_to_text(bytes, text(BinaryOutput::Summary), 1024, 1024), "FAIL\n");
+        let keep = TextOptions {
+            binary: BinaryOutput::Summary,
+            strip_ansi: false,
+        };
+        assert_eq!(stream_to_text(bytes, keep, 1024, 1024), "\x1b[31mFAIL\x1b[0m\n");
+    }
+
+    #[test]
+    fn test_cap_lines() {
+        assert_eq!(cap_lines("short\nlines\n", 8), "short\nlines\n");
+        assert_eq!(
+            cap_lines("ok\nvar a=1;var b=2;\nend", 8),
+            "ok\nvar a=1; [... 8 bytes truncated from this line ...]\nend"
+        );
+        assert_eq!(cap_lines("ééééé", 3), "é [... 8 bytes truncated from this line ...]");
+    }
+
+    #[test]
+    fn test_cap_output_keeps_the_end() {
+        assert_eq!(cap_output("short", 10), "short");
+        assert_eq!(cap_output("line one\nline two\nline three\n", 15), "[... 18 bytes truncated ...]\nline three\n");
+        // Never splits a multi-byte character
+        assert!(cap_output("ééééé", 3).ends_with("é"));
+    }
+
+    #[test]
+    fn test_find_executable() {
+        assert!(find_executable("sh").is_some());
+        assert!(find_executable("definitely-not-a-real-binary").is_none());
+    }
+
     #[test]
     fn test_run_command_error() {
         let cmd = Command::new("ls");
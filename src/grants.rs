@@ -0,0 +1,321 @@
+use std::cell::RefCell;
+use std::fmt;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::LazyLock;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::transcript::{self, format_utc};
+
+/// Longest a grant may last, so an exception can't quietly become permanent
+const MAX_TTL_SECS: u64 = 24 * 60 * 60;
+
+/// Grants file loaded from GRANTS_FILE environment variable at startup. It is read on every check, so
+/// grants take effect and lapse without a restart. Unset, nothing can be granted.
+static GRANTS_FILE: LazyLock<Option<PathBuf>> = LazyLock::new(|| {
+    std::env::var("GRANTS_FILE")
+        .ok()
+        .filter(|s| !s.trim().is_empty())
+        .map(|s| PathBuf::from(s.trim()))
+});
+
+thread_local! {
+    /// Grants that allowed something during the current tool call, for its audit record
+    static USED: RefCell<Vec<Grant>> = const { RefCell::new(Vec::new()) };
+}
+
+#[cfg(test)]
+thread_local! {
+    /// Grants file of the current test's thread, used instead of GRANTS_FILE when set
+    static TEST_GRANTS_FILE: RefCell<Option<PathBuf>> = const { RefCell::new(None) };
+}
+
+/// The grants file, if one is configured
+pub fn grants_file() -> Option<PathBuf> {
+    #[cfg(test)]
+    if let Some(file) = TEST_GRANTS_FILE.with(|file| file.borrow().clone()) {
+        return Some(file);
+    }
+    GRANTS_FILE.clone()
+}
+
+/// Use a grants file for the rest of the current test's thread, in place of GRANTS_FILE
+#[cfg(test)]
+pub fn set_grants_file_for_test(file: &Path) {
+    TEST_GRANTS_FILE.with(|test_file| *test_file.borrow_mut() = Some(file.to_path_buf()));
+}
+
+/// What a grant allows
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Access {
+    /// A tool read-only mode disables
+    Tool(String),
+    /// A blocked path and everything under it
+    Path(PathBuf),
+}
+
+/// Temporary access for a session to something the server's configuration otherwise denies
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Grant {
+    /// Session the grant is for, or "*" for every session
+    pub session: String,
+    pub access: Access,
+    /// When the grant lapses, in seconds since the Unix epoch
+    pub expires: u64,
+    /// Why it was granted, for the audit trail
+    pub reason: String,
+}
+
+impl fmt::Display for Access {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Access::Tool(tool) => write!(f, "tool:{}", tool),
+            Access::Path(path) => write!(f, "path:{}", path.display()),
+        }
+    }
+}
+
+impl fmt::Display for Grant {
+    /// A line of the grants file: expiry, session, access and reason, separated by spaces
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{} {} {} {}", self.expires, self.session, self.access, self.reason)
+    }
+}
+
+impl Grant {
+    /// Parse a line of the grants file, e.g., "1760551200 3f2a... tool:download fetch the hotfix toolchain"
+    fn parse(line: &str) -> Option<Self> {
+        let mut fields = line.trim().splitn(4, char::is_whitespace);
+        let expires = fields.next()?.parse().ok()?;
+        let session = fields.next()?.to_string();
+        let access = match fields.next()?.split_once(':')? {
+            ("tool", tool) if !tool.is_empty() => Access::Tool(tool.to_string()),
+            ("path", path) if path.starts_with('/') => Access::Path(PathBuf::from(path)),
+            _ => return None,
+        };
+        let reason = fields.next().unwrap_or_default().trim().to_string();
+        Some(Grant {
+            session,
+            access,
+            expires,
+            reason,
+        })
+    }
+
+    /// A short description for logs and audit records, e.g., "tool:download until 2026-10-15T18:00:00Z (hotfix)"
+    pub fn describe(&self) -> String {
+        format!("{} until {} ({})", self.access, format_utc(self.expires), self.reason)
+    }
+}
+
+/// Internal implementation for testability - takes the grants file as parameter.
+fn load(grants_file: &Path) -> Vec<Grant> {
+    let Ok(contents) = std::fs::read_to_string(grants_file) else {
+        return Vec::new();
+    };
+    contents
+        .lines()
+        .filter(|line| !line.trim().is_empty() && !line.trim_start().starts_with('#'))
+        .filter_map(|line| {
+            let grant = Grant::parse(line);
+            if grant.is_none() {
+                tracing::warn!("Ignoring grant '{}'", line.trim());
+            }
+            grant
+        })
+        .collect()
+}
+
+/// The first grant in `grants` that is current for `session` at `now` and covers what `covers` checks
+fn find<'a>(grants: &'a [Grant], session: &str, now: u64, covers: impl Fn(&Access) -> bool) -> Option<&'a Grant> {
+    grants.iter().find(|grant| {
+        (grant.session == "*" || grant.session == session) && now < grant.expires && covers(&grant.access)
+    })
+}
+
+/// Find a current grant for this session, noting it as used by the current call
+fn use_grant(covers: impl Fn(&Access) -> bool) -> bool {
+    let Some(grants_file) = grants_file() else {
+        return false;
+    };
+    let grants = load(&grants_file);
+    let Some(grant) = find(&grants, transcript::session_id(), now_secs(), covers) else {
+        return false;
+    };
+    tracing::warn!("Session {} used grant {}", transcript::session_id(), grant.describe());
+    USED.with(|used| {
+        let mut used = used.borrow_mut();
+        if !used.contains(grant) {
+            used.push(grant.clone());
+        }
+    });
+    true
+}
+
+/// Whether grants are configured, so tools read-only mode disables may still be granted
+pub fn enabled() -> bool {
+    grants_file().is_some()
+}
+
+/// Whether a grant lets this session call a tool read-only mode disables
+pub fn tool_granted(tool: &str) -> bool {
+    use_grant(|access| matches!(access, Access::Tool(granted) if granted == tool))
+}
+
+/// Whether a grant lets this session use a blocked path. `path` is resolved, as blocked paths are compared.
+pub fn path_granted(path: &Path) -> bool {
+    use_grant(|access| matches!(access, Access::Path(granted) if path.starts_with(granted)))
+}
+
+/// Take the grants used on this thread since the last call, for a tool call's audit record
+pub fn take_used() -> Vec<Grant> {
+    USED.with(|used| std::mem::take(&mut *used.borrow_mut()))
+}
+
+fn now_secs() -> u64 {
+    SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs())
+}
+
+/// Parse a time to live such as "90s", "30m" or "2h"; a bare number is seconds
+fn parse_ttl(ttl: &str) -> Option<u64> {
+    let ttl = ttl.trim();
+    let (number, unit) = match ttl.find(|c: char| !c.is_ascii_digit()) {
+        Some(split) => ttl.split_at(split),
+        None => (ttl, "s"),
+    };
+    let multiplier = match unit {
+        "s" => 1,
+        "m" => 60,
+        "h" => 60 * 60,
+        _ => return None,
+    };
+    number.parse::<u64>().ok()?.checked_mul(multiplier).filter(|&secs| secs > 0)
+}
+
+/// The `grant` command: add a grant to GRANTS_FILE for an operator, e.g.,
+/// `grant --session 3f2a... --tool download --ttl 30m --reason "fetch the hotfix toolchain"`.
+/// Returns the grant added.
+pub fn grant_command(args: &[String]) -> Result<Grant, String> {
+    let grants_file = GRANTS_FILE.as_ref().ok_or("GRANTS_FILE is not set")?;
+    let grant = parse_grant_args(args, now_secs())?;
+    let mut file = std::fs::OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(grants_file)
+        .map_err(|e| format!("Failed to open {}: {}", grants_file.display(), e))?;
+    writeln!(file, "{}", grant).map_err(|e| format!("Failed to write {}: {}", grants_file.display(), e))?;
+    Ok(grant)
+}
+
+/// Internal implementation for testability - takes the current time as parameter.
+fn parse_grant_args(args: &[String], now: u64) -> Result<Grant, String> {
+    let (mut session, mut access, mut ttl, mut reason) = (None, None, None, None);
+    let mut args = args.iter();
+    while let Some(flag) = args.next() {
+        let value = args.next().ok_or_else(|| format!("{} needs a value", flag))?;
+        match flag.as_str() {
+            "--session" => session = Some(value.clone()),
+            "--tool" => access = Some(Access::Tool(value.clone())),
+            "--path" if value.starts_with('/') && !value.contains(char::is_whitespace) => {
+                access = Some(Access::Path(PathBuf::from(value)))
+            }
+            "--path" => return Err(format!("'{}' is not an absolute path without spaces", value)),
+            "--ttl" => ttl = Some(parse_ttl(value).ok_or_else(|| format!("'{}' is not a duration like 30m", value))?),
+            "--reason" => reason = Some(value.trim().to_string()),
+            _ => return Err(format!("Unknown option {}", flag)),
+        }
+    }
+    let usage = "usage: grant --session <id|*> (--tool <name> | --path <path>) --ttl <30m> --reason <text>";
+    let (Some(session), Some(access), Some(ttl), Some(reason)) = (session, access, ttl, reason) else {
+        return Err(usage.to_string());
+    };
+    if session.is_empty() || session.contains(char::is_whitespace) || reason.is_empty() {
+        return Err(usage.to_string());
+    }
+    if ttl > MAX_TTL_SECS {
+        return Err(format!("A grant can last at most {}h", MAX_TTL_SECS / 3600));
+    }
+    Ok(Grant {
+        session,
+        access,
+        expires: now + ttl,
+        reason,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn args(args: &[&str]) -> Vec<String> {
+        args.iter().map(|arg| arg.to_string()).collect()
+    }
+
+    #[test]
+    fn test_parse_grant_args_round_trip() {
+        let grant = parse_grant_args(
+            &args(&["--session", "abc", "--tool", "download", "--ttl", "30m", "--reason", "hotfix toolchain"]),
+            1000,
+        )
+        .unwrap();
+        assert_eq!(
+            grant,
+            Grant {
+                session: "abc".to_string(),
+                access: Access::Tool("download".to_string()),
+                expires: 2800,
+                reason: "hotfix toolchain".to_string(),
+            }
+        );
+        assert_eq!(grant.to_string(), "2800 abc tool:download hotfix toolchain");
+        assert_eq!(Grant::parse(&grant.to_string()), Some(grant));
+    }
+
+    #[test]
+    fn test_parse_grant_args_rejects_bad_grants() {
+        let base = ["--session", "*", "--path", "/etc/ssl", "--reason", "cert audit"];
+        assert!(parse_grant_args(&args(&[&base[..], &["--ttl", "2h"]].concat()), 0).is_ok());
+        // Without a reason, too long, or not a duration
+        assert!(parse_grant_args(&args(&base[..4]), 0).is_err());
+        assert!(parse_grant_args(&args(&[&base[..], &["--ttl", "25h"]].concat()), 0).is_err());
+        assert!(parse_grant_args(&args(&[&base[..], &["--ttl", "soon"]].concat()), 0).is_err());
+        assert!(parse_grant_args(&args(&["--path", "etc", "--session", "*"]), 0).is_err());
+    }
+
+    #[test]
+    fn test_parse_ttl() {
+        assert_eq!(parse_ttl("90"), Some(90));
+        assert_eq!(parse_ttl("30m"), Some(1800));
+        assert_eq!(parse_ttl("2h"), Some(7200));
+        assert_eq!(parse_ttl("0"), None);
+        assert_eq!(parse_ttl("1d"), None);
+    }
+
+    #[test]
+    fn test_load_and_find() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("grants");
+        let contents = "# granted by ops\n2000 abc tool:download hotfix\nbogus line\n3000 * path:/etc/ssl cert audit\n";
+        std::fs::write(&path, contents).unwrap();
+        let grants = load(&path);
+        assert_eq!(grants.len(), 2);
+
+        let download = |access: &Access| *access == Access::Tool("download".to_string());
+        assert_eq!(find(&grants, "abc", 1000, download), Some(&grants[0]));
+        // Another session, or after it expires
+        assert_eq!(find(&grants, "xyz", 1000, download), None);
+        assert_eq!(find(&grants, "abc", 2000, download), None);
+
+        let ssl = |access: &Access| matches!(access, Access::Path(p) if Path::new("/etc/ssl/certs").starts_with(p));
+        assert_eq!(find(&grants, "xyz", 2500, ssl), Some(&grants[1]));
+        assert_eq!(grants[1].describe(), "path:/etc/ssl until 1970-01-01T00:50:00Z (cert audit)");
+    }
+
+    #[test]
+    fn test_no_grants_without_file() {
+        assert!(load(Path::new("/nonexistent/grants")).is_empty());
+        assert!(!tool_granted("download"));
+        assert!(take_used().is_empty());
+    }
+}
@@ -0,0 +1,196 @@
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::VecDeque;
+use std::sync::Mutex;
+
+use crate::transcript;
+
+/// Maximum number of calls kept for re-running and diffing; the oldest are dropped first
+pub const MAX_HISTORY_ENTRIES: usize = 100;
+
+/// Maximum number of commands kept in the command history; the oldest are dropped first
+pub const MAX_COMMAND_ENTRIES: usize = 200;
+
+/// Output kept with each command in the command history (4 KiB), from its end, where errors usually are
+const COMMAND_OUTPUT_BYTES: usize = 4 * 1024;
+
+/// URI scheme of the command history resource
+const HISTORY_URI_SCHEME: &str = "history://";
+
+/// A finished tool call with its exact request and full output.
+/// Unlike the transcript, inputs are not redacted, so entries never leave the server.
+#[derive(Debug, Clone)]
+pub struct HistoryEntry {
+    /// Call number shared with the session transcript
+    pub call: u64,
+    pub tool: String,
+    pub input: Value,
+    pub output: String,
+}
+
+static HISTORY: Mutex<VecDeque<HistoryEntry>> = Mutex::new(VecDeque::new());
+
+/// A finished command invocation, with the end of what its call got back from it
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct CommandEntry {
+    /// Invocation ID of the command
+    pub id: u64,
+    /// Tool whose call ran the command, or "server" for the server's own checks
+    pub tool: String,
+    /// Program and arguments of each stage of the command, one stage unless it is a pipeline
+    pub argv: Vec<Vec<String>>,
+    /// When the command started, in seconds since the Unix epoch
+    pub started: u64,
+    pub duration_ms: u64,
+    /// Exit code of its last attempt; None if it was killed, timed out or never ran
+    pub exit_code: Option<i32>,
+    /// The end of its result, at most 4 KiB
+    pub output: String,
+    /// Whether the start of the output was cut to fit; set when it is recorded
+    pub output_truncated: bool,
+}
+
+static COMMANDS: Mutex<VecDeque<CommandEntry>> = Mutex::new(VecDeque::new());
+
+/// Keep a finished call so it can be re-run or compared later
+pub fn record(call: u64, tool: &str, input: Value, output: &str) {
+    let entry = HistoryEntry {
+        call,
+        tool: tool.to_string(),
+        input,
+        output: output.to_string(),
+    };
+    let mut history = HISTORY.lock().unwrap_or_else(|e| e.into_inner());
+    push_capped(&mut history, entry, MAX_HISTORY_ENTRIES);
+}
+
+/// Look up a call by number, if it is still kept
+pub fn get(call: u64) -> Option<HistoryEntry> {
+    let history = HISTORY.lock().unwrap_or_else(|e| e.into_inner());
+    history.iter().find(|e| e.call == call).cloned()
+}
+
+/// The most recent call matching a predicate, if any is still kept
+pub fn latest(matches: impl Fn(&HistoryEntry) -> bool) -> Option<HistoryEntry> {
+    let history = HISTORY.lock().unwrap_or_else(|e| e.into_inner());
+    history.iter().rev().find(|e| matches(e)).cloned()
+}
+
+/// Keep a finished command in the command history, with the end of its output
+pub fn record_command(mut entry: CommandEntry) {
+    let (output, truncated) = output_tail(&entry.output, COMMAND_OUTPUT_BYTES);
+    entry.output = output;
+    entry.output_truncated = truncated;
+    let mut commands = COMMANDS.lock().unwrap_or_else(|e| e.into_inner());
+    push_capped(&mut commands, entry, MAX_COMMAND_ENTRIES);
+}
+
+/// Commands still kept in the command history, oldest first
+pub fn commands() -> Vec<CommandEntry> {
+    COMMANDS.lock().unwrap_or_else(|e| e.into_inner()).iter().cloned().collect()
+}
+
+/// URI of the current session's command history
+pub fn history_uri() -> String {
+    format!("{}{}", HISTORY_URI_SCHEME, transcript::session_id())
+}
+
+/// The command history as JSON, oldest command first
+pub fn commands_to_json(commands: &[CommandEntry]) -> String {
+    let history = serde_json::json!({
+        "session": transcript::session_id(),
+        "commands": commands,
+    });
+    serde_json::to_string_pretty(&history).unwrap_or_default()
+}
+
+/// At most the last `max` bytes of `output`, starting on a character boundary, and whether any was cut
+fn output_tail(output: &str, max: usize) -> (String, bool) {
+    if output.len() <= max {
+        return (output.to_string(), false);
+    }
+    let mut start = output.len() - max;
+    while !output.is_char_boundary(start) {
+        start += 1;
+    }
+    (output[start..].to_string(), true)
+}
+
+fn push_capped<T>(history: &mut VecDeque<T>, entry: T, max: usize) {
+    while history.len() >= max {
+        history.pop_front();
+    }
+    history.push_back(entry);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn entry(call: u64) -> HistoryEntry {
+        HistoryEntry {
+            call,
+            tool: "ls_tool".to_string(),
+            input: json!({}),
+            output: String::new(),
+        }
+    }
+
+    #[test]
+    fn test_record_and_get() {
+        record(1_000_001, "git", json!({"subcommand": "status"}), "On branch main");
+        let found = get(1_000_001).unwrap();
+        assert_eq!(found.tool, "git");
+        assert_eq!(found.input["subcommand"], "status");
+        assert_eq!(found.output, "On branch main");
+        assert!(get(1_000_002).is_none());
+    }
+
+    #[test]
+    fn test_latest() {
+        record(1_000_011, "git", json!({}), "first");
+        record(1_000_012, "git", json!({}), "second");
+        let found = latest(|e| e.call > 1_000_010 && e.call < 1_000_020).unwrap();
+        assert_eq!(found.call, 1_000_012);
+        assert!(latest(|e| e.tool == "no-such-tool").is_none());
+    }
+
+    #[test]
+    fn test_push_capped_drops_oldest() {
+        let mut history = VecDeque::new();
+        for call in 1..=4 {
+            push_capped(&mut history, entry(call), 3);
+        }
+        let calls: Vec<u64> = history.iter().map(|e| e.call).collect();
+        assert_eq!(calls, vec![2, 3, 4]);
+    }
+
+    #[test]
+    fn test_record_command_keeps_end_of_output() {
+        record_command(CommandEntry {
+            id: 1_000_021,
+            tool: "git".to_string(),
+            argv: vec![vec!["git".to_string(), "log".to_string()]],
+            started: 1_792_071_900,
+            duration_ms: 120,
+            exit_code: Some(0),
+            output: format!("{}é\nend\n", "x".repeat(COMMAND_OUTPUT_BYTES)),
+            output_truncated: false,
+        });
+        let found = commands().into_iter().find(|c| c.id == 1_000_021).unwrap();
+        assert!(found.output_truncated);
+        assert!(found.output.ends_with("é\nend\n"));
+        assert!(found.output.len() <= COMMAND_OUTPUT_BYTES);
+        let json: Value = serde_json::from_str(&commands_to_json(&[found])).unwrap();
+        assert_eq!(json["commands"][0]["argv"][0][1], "log");
+        assert_eq!(json["commands"][0]["exit_code"], 0);
+    }
+
+    #[test]
+    fn test_output_tail() {
+        assert_eq!(output_tail("abc", 3), ("abc".to_string(), false));
+        // The cut falls inside "é", so the tail starts after it
+        assert_eq!(output_tail("aébc", 3), ("bc".to_string(), true));
+    }
+}
@@ -0,0 +1,157 @@
+use regex::Regex;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::tools::owners::pattern_to_regex;
+
+/// A .gitignore rule, matched against paths relative to the directory of the file it came from
+struct Rule {
+    base: PathBuf,
+    pattern: Regex,
+    negated: bool,
+}
+
+/// The .gitignore and .bazelignore rules of a repository. Like git, the last matching rule wins,
+/// so a later `!pattern` re-includes a path an earlier rule ignored.
+pub struct IgnoreRules {
+    root: PathBuf,
+    rules: Vec<Rule>,
+}
+
+impl IgnoreRules {
+    /// Rules that apply inside `dir`: the repository root's .bazelignore and the .gitignore files
+    /// from the root down to `dir`. Without a repository, only `dir`'s own files are read.
+    pub fn for_dir(dir: &Path) -> Self {
+        let root = dir
+            .ancestors()
+            .find(|ancestor| ancestor.join(".git").exists())
+            .unwrap_or(dir)
+            .to_path_buf();
+        let mut ignore = IgnoreRules {
+            root: root.clone(),
+            rules: Vec::new(),
+        };
+        if let Ok(content) = fs::read_to_string(root.join(".bazelignore")) {
+            ignore.add_bazelignore(&content);
+        }
+        let mut dirs: Vec<&Path> = dir.ancestors().take_while(|d| d.starts_with(&root)).collect();
+        dirs.reverse();
+        for d in dirs {
+            ignore.enter(d);
+        }
+        ignore
+    }
+
+    /// Add the rules of `dir`'s .gitignore, if it has one, when walking into it
+    pub fn enter(&mut self, dir: &Path) {
+        if let Ok(content) = fs::read_to_string(dir.join(".gitignore")) {
+            let base = dir.strip_prefix(&self.root).unwrap_or(Path::new("")).to_path_buf();
+            self.add_gitignore(&base, &content);
+        }
+    }
+
+    fn add_gitignore(&mut self, base: &Path, content: &str) {
+        for line in content.lines().map(str::trim_end) {
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let (negated, pattern) = match line.strip_prefix('!') {
+                Some(pattern) => (true, pattern),
+                None => (false, line),
+            };
+            if let Some(pattern) = pattern_to_regex(pattern) {
+                self.rules.push(Rule {
+                    base: base.to_path_buf(),
+                    pattern,
+                    negated,
+                });
+            }
+        }
+    }
+
+    /// .bazelignore lists directories relative to the repository root, one per line
+    fn add_bazelignore(&mut self, content: &str) {
+        for line in content.lines().map(str::trim) {
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            if let Some(pattern) = pattern_to_regex(&format!("/{}/", line.trim_matches('/'))) {
+                self.rules.push(Rule {
+                    base: PathBuf::new(),
+                    pattern,
+                    negated: false,
+                });
+            }
+        }
+    }
+
+    /// Whether a path inside the repository is ignored. The `.git` directory always is.
+    pub fn is_ignored(&self, path: &Path) -> bool {
+        let relative = match path.strip_prefix(&self.root) {
+            Ok(relative) => relative,
+            Err(_) => return false,
+        };
+        if relative.components().any(|c| c.as_os_str() == ".git") {
+            return true;
+        }
+        let mut ignored = false;
+        for rule in &self.rules {
+            if let Ok(sub) = relative.strip_prefix(&rule.base) {
+                if rule.pattern.is_match(&sub.to_string_lossy()) {
+                    ignored = !rule.negated;
+                }
+            }
+        }
+        ignored
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn setup_repo() -> TempDir {
+        let temp_dir = TempDir::new().unwrap();
+        let root = temp_dir.path();
+        fs::create_dir_all(root.join(".git")).unwrap();
+        fs::create_dir_all(root.join("web/node_modules/react")).unwrap();
+        fs::write(root.join(".gitignore"), "# build output\nnode_modules/\n*.log\n!keep.log\n").unwrap();
+        fs::write(root.join(".bazelignore"), "third_party/vendored\n").unwrap();
+        fs::write(root.join("web/.gitignore"), "/dist\n").unwrap();
+        temp_dir
+    }
+
+    #[test]
+    fn test_gitignore_rules() {
+        let temp_dir = setup_repo();
+        let root = temp_dir.path();
+        let ignore = IgnoreRules::for_dir(&root.join("web"));
+        assert!(ignore.is_ignored(&root.join("web/node_modules")));
+        assert!(ignore.is_ignored(&root.join("web/node_modules/react/index.js")));
+        assert!(ignore.is_ignored(&root.join("web/debug.log")));
+        assert!(!ignore.is_ignored(&root.join("web/keep.log")));
+        assert!(!ignore.is_ignored(&root.join("web/src/app.ts")));
+        assert!(ignore.is_ignored(&root.join(".git/config")));
+    }
+
+    #[test]
+    fn test_nested_gitignore_is_relative_to_its_directory() {
+        let temp_dir = setup_repo();
+        let root = temp_dir.path();
+        let ignore = IgnoreRules::for_dir(&root.join("web"));
+        assert!(ignore.is_ignored(&root.join("web/dist")));
+        assert!(!ignore.is_ignored(&root.join("dist")));
+        // Not loaded until the walk enters web/
+        assert!(!IgnoreRules::for_dir(root).is_ignored(&root.join("web/dist")));
+    }
+
+    #[test]
+    fn test_bazelignore_directories() {
+        let temp_dir = setup_repo();
+        let root = temp_dir.path();
+        let ignore = IgnoreRules::for_dir(root);
+        assert!(ignore.is_ignored(&root.join("third_party/vendored/lib.go")));
+        assert!(!ignore.is_ignored(&root.join("third_party/other/vendored")));
+    }
+}
@@ -0,0 +1,463 @@
+use serde_json::Value;
+use std::cell::RefCell;
+use std::collections::VecDeque;
+use std::fs::File;
+use std::io::{Read, Seek, SeekFrom, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+use crate::executor;
+use crate::scratch::scratch_dir;
+use crate::transcript;
+
+/// Maximum number of jobs kept for status and logs; the oldest finished ones are dropped first, with their logs
+pub const MAX_JOBS: usize = 20;
+
+/// Maximum number of jobs running at once
+pub const MAX_RUNNING_JOBS: usize = 4;
+
+/// Most recent output of each job kept in memory (256 KiB); older output is read back from the log file
+const MEMORY_LOG_BYTES: usize = 256 * 1024;
+
+/// Cap on each job's log file (64 MiB); output past it is only kept in memory
+const DISK_LOG_BYTES: u64 = 64 * 1024 * 1024;
+
+static JOBS: Mutex<VecDeque<Arc<Job>>> = Mutex::new(VecDeque::new());
+
+static NEXT_ID: AtomicU64 = AtomicU64::new(1);
+
+thread_local! {
+    /// The job whose tool call is running on this thread
+    static CURRENT: RefCell<Option<Arc<Job>>> = const { RefCell::new(None) };
+}
+
+/// A tool call running in the background. Output of the commands it runs is appended to its log as it is read.
+#[derive(Debug)]
+pub struct Job {
+    pub id: u64,
+    pub tool: String,
+    started: Instant,
+    log: Mutex<JobLog>,
+    /// Set once the tool call returns
+    result: Mutex<Option<JobResult>>,
+    cancelled: AtomicBool,
+    /// Process group of the command running now, so cancelling can stop it
+    pid: Mutex<Option<u32>>,
+}
+
+/// What a finished job's tool call returned
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct JobResult {
+    pub output: String,
+    pub elapsed: Duration,
+}
+
+/// A job's output: the most recent part in memory and, when it could be created, all of it in a file
+#[derive(Debug, Default)]
+struct JobLog {
+    /// Bytes of output so far
+    len: u64,
+    /// The most recent output, which starts at offset len - tail.len()
+    tail: Vec<u8>,
+    path: Option<PathBuf>,
+    file: Option<File>,
+    /// Bytes of output in the file, from the start
+    written: u64,
+}
+
+impl JobLog {
+    /// Internal implementation for testability - takes the log directory as parameter.
+    fn create_in(dir: &Path, id: u64) -> Self {
+        let path = dir.join(format!("{}-{}.log", transcript::session_id(), id));
+        match std::fs::create_dir_all(dir).and_then(|()| File::create(&path)) {
+            Ok(file) => JobLog {
+                path: Some(path),
+                file: Some(file),
+                ..Default::default()
+            },
+            Err(e) => {
+                tracing::warn!("Failed to create job log {}; keeping only recent output: {}", path.display(), e);
+                JobLog::default()
+            }
+        }
+    }
+
+    fn append(&mut self, data: &[u8]) {
+        if let Some(ref mut file) = self.file {
+            let n = (DISK_LOG_BYTES.saturating_sub(self.written) as usize).min(data.len());
+            match file.write_all(&data[..n]) {
+                Ok(()) => self.written += n as u64,
+                Err(e) => {
+                    tracing::warn!("Failed to write job log; keeping only recent output: {}", e);
+                    self.file = None;
+                }
+            }
+        }
+        self.len += data.len() as u64;
+        self.tail.extend_from_slice(data);
+        // Trimmed in batches so appends stay cheap
+        if self.tail.len() > 2 * MEMORY_LOG_BYTES {
+            self.tail.drain(..self.tail.len() - MEMORY_LOG_BYTES);
+        }
+    }
+
+    /// Up to `max` bytes of output from `offset`, and the offset they start at. That is later than `offset`
+    /// when the output there is no longer kept.
+    fn read(&self, offset: u64, max: usize) -> (u64, Vec<u8>) {
+        let tail_start = self.len - self.tail.len() as u64;
+        if offset < tail_start && offset < self.written {
+            let len = max.min((self.written - offset) as usize);
+            if let Some(data) = self.path.as_deref().and_then(|path| read_file(path, offset, len).ok()) {
+                return (offset, data);
+            }
+        }
+        let start = offset.clamp(tail_start, self.len);
+        let from = (start - tail_start) as usize;
+        let to = from.saturating_add(max).min(self.tail.len());
+        (start, self.tail[from..to].to_vec())
+    }
+}
+
+/// Up to `len` bytes of a file from `offset`
+pub fn read_file(path: &Path, offset: u64, len: usize) -> std::io::Result<Vec<u8>> {
+    let mut file = File::open(path)?;
+    file.seek(SeekFrom::Start(offset))?;
+    let mut data = Vec::with_capacity(len);
+    file.take(len as u64).read_to_end(&mut data)?;
+    Ok(data)
+}
+
+impl Job {
+    fn new(id: u64, tool: &str, log: JobLog) -> Self {
+        Job {
+            id,
+            tool: tool.to_string(),
+            started: Instant::now(),
+            log: Mutex::new(log),
+            result: Mutex::new(None),
+            cancelled: AtomicBool::new(false),
+            pid: Mutex::new(None),
+        }
+    }
+
+    /// A job that isn't started or kept, for running commands in tests
+    #[cfg(test)]
+    pub fn detached(id: u64, tool: &str) -> Self {
+        Job::new(id, tool, JobLog::default())
+    }
+
+    /// Add output of one of the job's commands to its log
+    pub fn append(&self, data: &[u8]) {
+        self.log.lock().unwrap_or_else(|e| e.into_inner()).append(data);
+    }
+
+    /// Up to `max` bytes of the log from `offset`, and the offset they start at. That is later than
+    /// `offset` when the output there is no longer kept.
+    pub fn read_log(&self, offset: u64, max: usize) -> (u64, Vec<u8>) {
+        self.log.lock().unwrap_or_else(|e| e.into_inner()).read(offset, max)
+    }
+
+    /// Bytes of output so far
+    pub fn log_len(&self) -> u64 {
+        self.log.lock().unwrap_or_else(|e| e.into_inner()).len
+    }
+
+    /// What the tool call returned, once it has
+    pub fn result(&self) -> Option<JobResult> {
+        self.result.lock().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// How long the job has run, or ran
+    pub fn elapsed(&self) -> Duration {
+        self.result().map_or_else(|| self.started.elapsed(), |result| result.elapsed)
+    }
+
+    /// How long ago the job started
+    pub fn age(&self) -> Duration {
+        self.started.elapsed()
+    }
+
+    /// "running", "cancelled", "failed" or "succeeded"
+    pub fn state(&self) -> &'static str {
+        match self.result() {
+            None => "running",
+            Some(_) if self.is_cancelled() => "cancelled",
+            Some(result) if result.output.starts_with("Error") => "failed",
+            Some(_) => "succeeded",
+        }
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.cancelled.load(Ordering::Relaxed)
+    }
+
+    /// Cancel the job: stop the command it is running and skip any it would run next.
+    /// Returns false if it had already finished.
+    pub fn cancel(&self) -> bool {
+        if self.result().is_some() {
+            return false;
+        }
+        let pid = self.pid.lock().unwrap_or_else(|e| e.into_inner());
+        self.cancelled.store(true, Ordering::Relaxed);
+        if let Some(pid) = *pid {
+            executor::stop_in_background(pid);
+        }
+        true
+    }
+
+    /// Note the command the job is running, or None once it has exited. A command started just as the job
+    /// was cancelled is stopped here.
+    pub fn set_pid(&self, pid: Option<u32>) {
+        let mut current = self.pid.lock().unwrap_or_else(|e| e.into_inner());
+        *current = pid;
+        if let Some(pid) = pid.filter(|_| self.is_cancelled()) {
+            executor::stop_in_background(pid);
+        }
+    }
+
+    fn finish(&self, output: String) {
+        let elapsed = self.started.elapsed();
+        *self.result.lock().unwrap_or_else(|e| e.into_inner()) = Some(JobResult { output, elapsed });
+    }
+
+    fn remove_log(&self) {
+        if let Some(ref path) = self.log.lock().unwrap_or_else(|e| e.into_inner()).path {
+            let _ = std::fs::remove_file(path);
+        }
+    }
+}
+
+/// Start running a tool call in the background. `run` runs the tool by name, returning None if it
+/// cannot be run this way.
+pub fn start(
+    tool: &str,
+    arguments: Value,
+    run: impl FnOnce(&str, Value) -> Option<String> + Send + 'static,
+) -> Result<Arc<Job>, String> {
+    start_in(&scratch_dir().join("jobs"), tool, arguments, run)
+}
+
+/// Internal implementation for testability - takes the log directory as parameter.
+fn start_in(
+    log_dir: &Path,
+    tool: &str,
+    arguments: Value,
+    run: impl FnOnce(&str, Value) -> Option<String> + Send + 'static,
+) -> Result<Arc<Job>, String> {
+    let mut jobs = JOBS.lock().unwrap_or_else(|e| e.into_inner());
+    let running = jobs.iter().filter(|job| job.result().is_none()).count();
+    if running >= MAX_RUNNING_JOBS {
+        return Err(format!(
+            "Error: {} jobs are already running; wait for one to finish or cancel it with job_cancel",
+            running
+        ));
+    }
+    // Make room by dropping the oldest finished jobs, which can't be running since fewer than MAX_JOBS are
+    while jobs.len() >= MAX_JOBS {
+        let Some(oldest) = jobs.iter().position(|job| job.result().is_some()) else {
+            break;
+        };
+        if let Some(job) = jobs.remove(oldest) {
+            job.remove_log();
+        }
+    }
+    let id = NEXT_ID.fetch_add(1, Ordering::Relaxed);
+    let job = Arc::new(Job::new(id, tool, JobLog::create_in(log_dir, id)));
+    jobs.push_back(job.clone());
+    drop(jobs);
+
+    let (running, tool) = (job.clone(), tool.to_string());
+    let spawned = thread::Builder::new().name(format!("job-{}", id)).spawn(move || {
+        CURRENT.with(|current| *current.borrow_mut() = Some(running.clone()));
+        let output = run(&tool, arguments).unwrap_or_else(|| format!("Error: Tool '{}' cannot be run as a job", tool));
+        running.finish(output);
+    });
+    if let Err(e) = spawned {
+        let error = format!("Error: Failed to start job: {}", e);
+        job.finish(error.clone());
+        return Err(error);
+    }
+    Ok(job)
+}
+
+/// Look up a job by ID, if it is still kept
+pub fn get(id: u64) -> Option<Arc<Job>> {
+    let jobs = JOBS.lock().unwrap_or_else(|e| e.into_inner());
+    jobs.iter().find(|job| job.id == id).cloned()
+}
+
+/// Every job still kept, oldest first
+pub fn all() -> Vec<Arc<Job>> {
+    JOBS.lock().unwrap_or_else(|e| e.into_inner()).iter().cloned().collect()
+}
+
+/// The job whose tool call is running on this thread, if any
+pub fn current() -> Option<Arc<Job>> {
+    CURRENT.with(|current| current.borrow().clone())
+}
+
+/// Drop the job logs and spooled output of server processes that exited without cleaning up, e.g., after a
+/// crash. Their jobs were interrupted and can't be read back by any session, so only a warning remains of them.
+pub fn reconcile() {
+    let dirs = [scratch_dir().join("jobs"), scratch_dir().join("spool")];
+    let removed = reconcile_in(&dirs, process_alive);
+    if removed > 0 {
+        tracing::warn!("Removed {} job logs and spool files of server processes that exited", removed);
+    }
+}
+
+/// Internal implementation for testability - takes the log directories and the liveness check as parameters.
+fn reconcile_in(dirs: &[PathBuf], alive: impl Fn(u32) -> bool) -> usize {
+    let mut removed = 0;
+    for dir in dirs {
+        let Ok(entries) = std::fs::read_dir(dir) else {
+            continue;
+        };
+        for entry in entries.flatten() {
+            let path = entry.path();
+            let Some(pid) = log_owner(&path) else {
+                continue;
+            };
+            if pid == std::process::id() || alive(pid) {
+                continue;
+            }
+            tracing::warn!("Job output {} of server process {}, which exited, was interrupted", path.display(), pid);
+            match std::fs::remove_file(&path) {
+                Ok(()) => removed += 1,
+                Err(e) => tracing::warn!("Failed to remove {}: {}", path.display(), e),
+            }
+        }
+    }
+    removed
+}
+
+/// Process ID of the server that wrote a "{started}-{pid}-{id}.log" file
+fn log_owner(path: &Path) -> Option<u32> {
+    if path.extension()? != "log" {
+        return None;
+    }
+    let stem = path.file_stem()?.to_str()?;
+    let mut parts = stem.split('-');
+    let (_started, pid, _id) = (parts.next()?, parts.next()?, parts.next()?);
+    if parts.next().is_some() {
+        return None;
+    }
+    pid.parse().ok()
+}
+
+/// Whether a process is running. A process of another user, which can't be signalled, counts as running.
+fn process_alive(pid: u32) -> bool {
+    let Ok(pid) = libc::pid_t::try_from(pid) else {
+        return false;
+    };
+    let signalled = unsafe { libc::kill(pid, 0) } == 0;
+    signalled || std::io::Error::last_os_error().raw_os_error() == Some(libc::EPERM)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    /// Wait for a job to finish
+    fn wait(job: &Job) -> JobResult {
+        for _ in 0..500 {
+            if let Some(result) = job.result() {
+                return result;
+            }
+            thread::sleep(Duration::from_millis(10));
+        }
+        panic!("job {} did not finish", job.id);
+    }
+
+    #[test]
+    fn test_log_reads_old_output_from_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let mut log = JobLog::create_in(temp_dir.path(), 1);
+        let chunk: Vec<u8> = (0..=255).cycle().take(64 * 1024).collect();
+        for _ in 0..10 {
+            log.append(&chunk);
+        }
+        assert_eq!(log.len, 640 * 1024);
+        assert!(log.tail.len() < log.len as usize);
+        // From the file, then from memory
+        assert_eq!(log.read(1, 4), (1, vec![1, 2, 3, 4]));
+        assert_eq!(log.read(log.len - 2, 10), (log.len - 2, vec![254, 255]));
+        assert_eq!(log.read(log.len + 5, 10), (log.len, vec![]));
+    }
+
+    #[test]
+    fn test_log_without_file_skips_dropped_output() {
+        let mut log = JobLog::default();
+        log.append(&vec![b'a'; 3 * MEMORY_LOG_BYTES]);
+        let (start, data) = log.read(0, 4);
+        assert_eq!(start, 3 * MEMORY_LOG_BYTES as u64 - log.tail.len() as u64);
+        assert_eq!(data, b"aaaa");
+    }
+
+    #[test]
+    fn test_start_runs_in_background() {
+        let temp_dir = TempDir::new().unwrap();
+        let job = start_in(temp_dir.path(), "git", serde_json::json!({"subcommand": "status"}), |tool, input| {
+            let job = current().expect("the job's thread knows its job");
+            job.append(b"checking\n");
+            Some(format!("{} {}", tool, input["subcommand"]))
+        })
+        .unwrap();
+        assert_eq!(wait(&job).output, "git \"status\"");
+        assert_eq!(job.state(), "succeeded");
+        assert_eq!(job.read_log(0, 100), (0, b"checking\n".to_vec()));
+        assert!(get(job.id).is_some());
+        // Already finished
+        assert!(!job.cancel());
+
+        let job = start_in(temp_dir.path(), "golden", Value::Null, |_, _| None).unwrap();
+        assert_eq!(wait(&job).output, "Error: Tool 'golden' cannot be run as a job");
+        assert_eq!(job.state(), "failed");
+    }
+
+    #[test]
+    fn test_cancel_stops_command() {
+        let temp_dir = TempDir::new().unwrap();
+        let (tx, rx) = std::sync::mpsc::channel();
+        let job = start_in(temp_dir.path(), "presubmit", Value::Null, move |_, _| {
+            rx.recv().unwrap();
+            let cancelled = current().unwrap().is_cancelled();
+            Some(if cancelled { "Error: Cancelled" } else { "PASS" }.to_string())
+        })
+        .unwrap();
+        assert_eq!(job.state(), "running");
+        assert!(job.cancel());
+        tx.send(()).unwrap();
+        wait(&job);
+        assert_eq!(job.state(), "cancelled");
+    }
+
+    #[test]
+    fn test_reconcile_drops_output_of_exited_servers() {
+        let temp_dir = TempDir::new().unwrap();
+        let dirs = [temp_dir.path().join("jobs"), temp_dir.path().join("spool")];
+        let own = format!("{}-3.log", transcript::session_id());
+        for dir in &dirs {
+            std::fs::create_dir_all(dir).unwrap();
+            for name in ["1700000000-111-1.log", "1700000000-222-2.log", own.as_str(), "notes.txt", "a-b-c.log"] {
+                std::fs::write(dir.join(name), "output").unwrap();
+            }
+        }
+        // pid 222 is still running, pid 111 exited
+        assert_eq!(reconcile_in(&dirs, |pid| pid == 222), 2);
+        for dir in &dirs {
+            assert!(!dir.join("1700000000-111-1.log").exists());
+            assert!(dir.join("1700000000-222-2.log").exists());
+            assert!(dir.join(&own).exists());
+            assert!(dir.join("notes.txt").exists());
+            assert!(dir.join("a-b-c.log").exists());
+        }
+        // Missing directories are skipped
+        assert_eq!(reconcile_in(&[temp_dir.path().join("missing")], |_| false), 0);
+        assert!(process_alive(std::process::id()));
+    }
+}
@@ -0,0 +1,135 @@
+use std::collections::BTreeMap;
+use std::sync::LazyLock;
+
+use crate::security::ValidationError;
+
+/// Longest label name
+const MAX_NAME_LEN: usize = 64;
+
+/// Longest label value
+const MAX_VALUE_LEN: usize = 256;
+
+/// Labels attributing calls to an agent, task or ticket, e.g., {"agent": "reviewer", "task": "T-42"}
+pub type Labels = BTreeMap<String, String>;
+
+/// Labels of this session loaded from SESSION_LABELS environment variable at startup. Each client gets its
+/// own server process, so the client sets them when it starts the session, e.g., "agent=reviewer;task=T-42".
+static SESSION_LABELS: LazyLock<Labels> =
+    LazyLock::new(|| parse(&std::env::var("SESSION_LABELS").unwrap_or_default()));
+
+/// Parse semicolon-separated name=value pairs, skipping invalid ones
+fn parse(spec: &str) -> Labels {
+    let mut labels = Labels::new();
+    for pair in spec.split(';').map(str::trim).filter(|pair| !pair.is_empty()) {
+        let (name, value) = pair.split_once('=').unwrap_or((pair, ""));
+        let (name, value) = (name.trim(), value.trim());
+        match validate_label(name, value) {
+            Ok(()) => {
+                labels.insert(name.to_string(), value.to_string());
+            }
+            Err(e) => tracing::warn!("Ignoring session label '{}': {}", pair, e),
+        }
+    }
+    labels
+}
+
+/// Labels of the current session
+pub fn session() -> &'static Labels {
+    &SESSION_LABELS
+}
+
+/// Labels of a call: the session's, with any the call sets added or replacing them
+pub fn for_call(call: Option<&Labels>) -> Labels {
+    let mut labels = session().clone();
+    if let Some(call) = call {
+        labels.extend(call.iter().map(|(name, value)| (name.clone(), value.clone())));
+    }
+    labels
+}
+
+/// Validate a label. Names are letters, digits, '_', '-' and '.'; values are printable text without
+/// the separators labels are written with.
+pub fn validate_label(name: &str, value: &str) -> Result<(), ValidationError> {
+    let valid_name = !name.is_empty()
+        && name.len() <= MAX_NAME_LEN
+        && name.chars().all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '.'));
+    if !valid_name {
+        return Err(ValidationError::InvalidLabel(format!(
+            "'{}' must be 1-{} letters, digits, '_', '-' or '.'",
+            name, MAX_NAME_LEN
+        )));
+    }
+    let valid_value = !value.is_empty()
+        && value.len() <= MAX_VALUE_LEN
+        && !value.chars().any(|c| c.is_control() || matches!(c, ';' | ','));
+    if !valid_value {
+        return Err(ValidationError::InvalidLabel(format!(
+            "the value of '{}' must be 1-{} bytes without control characters, ';' or ','",
+            name, MAX_VALUE_LEN
+        )));
+    }
+    Ok(())
+}
+
+/// Labels as comma-separated name=value pairs, e.g., "agent=reviewer,task=T-42"
+pub fn format(labels: &Labels) -> String {
+    labels
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, value))
+        .collect::<Vec<_>>()
+        .join(",")
+}
+
+/// Whether labels match a selector: "name=value" for that value, or "name" for any
+pub fn matches(labels: &Labels, selector: &str) -> bool {
+    match selector.split_once('=') {
+        Some((name, value)) => labels.get(name.trim()).is_some_and(|v| v == value.trim()),
+        None => labels.contains_key(selector.trim()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn labels(pairs: &[(&str, &str)]) -> Labels {
+        pairs.iter().map(|(name, value)| (name.to_string(), value.to_string())).collect()
+    }
+
+    #[test]
+    fn test_parse() {
+        assert_eq!(
+            parse(" agent=reviewer; task = T-42 ;;ticket=OPS-7"),
+            labels(&[("agent", "reviewer"), ("task", "T-42"), ("ticket", "OPS-7")])
+        );
+        // Without a value, or with a bad name
+        assert_eq!(parse("agent;bad name=x;task=T-1"), labels(&[("task", "T-1")]));
+    }
+
+    #[test]
+    fn test_validate_label() {
+        assert!(validate_label("ticket", "JIRA-1234").is_ok());
+        assert!(validate_label("agent.name", "code review bot").is_ok());
+        assert!(matches!(validate_label("", "x"), Err(ValidationError::InvalidLabel(_))));
+        assert!(matches!(validate_label("task=", "x"), Err(ValidationError::InvalidLabel(_))));
+        assert!(matches!(validate_label("task", "a,b"), Err(ValidationError::InvalidLabel(_))));
+        assert!(matches!(validate_label("task", "a\nb"), Err(ValidationError::InvalidLabel(_))));
+    }
+
+    #[test]
+    fn test_format_and_matches() {
+        let labels = labels(&[("task", "T-42"), ("agent", "reviewer")]);
+        assert_eq!(format(&labels), "agent=reviewer,task=T-42");
+        assert!(matches(&labels, "task=T-42"));
+        assert!(matches(&labels, "agent"));
+        assert!(!matches(&labels, "task=T-43"));
+        assert!(!matches(&labels, "ticket"));
+    }
+
+    #[test]
+    fn test_for_call_overrides_session_labels() {
+        let call = labels(&[("task", "T-43")]);
+        assert_eq!(for_call(Some(&call))["task"], "T-43");
+        assert_eq!(for_call(None), *session());
+    }
+}
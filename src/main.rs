@@ -1,11 +1,49 @@
+mod accounting;
+mod admission;
+mod anomaly;
+mod audit;
+mod cgroup;
+mod classify;
+mod client_log;
+mod cron;
+mod diff;
+mod durations;
+mod events;
 mod executor;
+mod grants;
+mod history;
+mod ignore;
+mod jobs;
+mod labels;
+mod maintenance;
+mod normalize;
+mod notify;
+mod overlay;
+mod paging;
+mod preflight;
+mod priority;
+mod profile;
+mod progress;
 mod request;
+mod retention;
+mod rlimits;
+mod run_as;
+mod sampler;
+mod scratch;
 mod security;
 mod server;
+mod severity;
+mod spool;
+mod template;
+mod timeline;
+mod toolchain;
 mod tools;
+mod transcript;
+mod workspace_env;
 
 use rmcp::{transport::stdio, ServiceExt};
 use server::CommandRunnerServer;
+use std::time::Duration;
 
 #[tokio::main]
 async fn main() -> Result<(), Box<dyn std::error::Error>> {
@@ -14,6 +52,61 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
         .with_ansi(false)
         .init();
 
-    CommandRunnerServer::new().serve(stdio()).await?.waiting().await?;
+    // Log panics with a backtrace; run_tool turns them into error results
+    std::panic::set_hook(Box::new(|info| {
+        tracing::error!("{}\n{}", info, std::backtrace::Backtrace::force_capture());
+    }));
+
+    // `grant` adds an elevated-access grant for an operator instead of serving
+    let args: Vec<String> = std::env::args().skip(1).collect();
+    if args.first().is_some_and(|arg| arg == "grant") {
+        let grant = grants::grant_command(&args[1..])?;
+        println!("Granted session {} {}", grant.session, grant.describe());
+        return Ok(());
+    }
+    // `usage-report` prints the usage ledger as CSV for chargeback instead of serving
+    if args.first().is_some_and(|arg| arg == "usage-report") {
+        print!("{}", accounting::usage_report_command(&args[1..])?);
+        return Ok(());
+    }
+
+    // --read-only disables tools that modify the filesystem
+    security::set_read_only(std::env::args().any(|arg| arg == "--read-only"));
+
+    // Check tool dependencies up front so a missing binary disables its tool
+    // instead of failing mid-call; --strict refuses to start instead
+    let problems = preflight::run();
+    for problem in &problems {
+        tracing::warn!(tool = problem.tool, "Preflight check failed: {}; tool disabled", problem.detail);
+    }
+    if !problems.is_empty() && std::env::args().any(|arg| arg == "--strict") {
+        return Err(format!("{} preflight check(s) failed; refusing to start with --strict", problems.len()).into());
+    }
+    for warning in preflight::missing_optional() {
+        tracing::warn!("Preflight check: {}", warning);
+    }
+    preflight::disable_tools(problems);
+
+    // Commands must never fall back to the server's own user when RUN_AS can't be used
+    if let Some(run_as) = run_as::configured()? {
+        tracing::info!("Running commands as uid {} gid {}", run_as.uid, run_as.gid);
+    }
+
+    // Warn about toolchains that differ from their pins, in the environment presubmit runs in.
+    // presubmit results repeat the warning.
+    let ctx = request::ExecutionContext {
+        timeout: Some(Duration::from_secs(10)),
+        profile: profile::for_tool("presubmit"),
+        ..Default::default()
+    };
+    for warning in toolchain::mismatches(&ctx) {
+        tracing::warn!("{}", warning);
+    }
+    jobs::reconcile();
+    retention::start_cleanup()?;
+
+    let service = CommandRunnerServer::new().serve(stdio()).await?;
+    client_log::connect(server::client_logger(service.peer().clone()));
+    service.waiting().await?;
     Ok(())
 }
@@ -0,0 +1,76 @@
+use std::path::{Path, PathBuf};
+use std::sync::LazyLock;
+
+/// Reason reported when the freeze file exists but is empty or unreadable
+const DEFAULT_FREEZE_REASON: &str = "maintenance in progress";
+
+/// Freeze file loaded from FREEZE_FILE environment variable at startup.
+/// While this file exists, new executions are rejected; its contents are the reason shown to clients.
+/// A file (rather than a signal) lets an operator freeze every server instance on a host at once.
+static FREEZE_FILE: LazyLock<Option<PathBuf>> = LazyLock::new(|| {
+    std::env::var("FREEZE_FILE")
+        .ok()
+        .filter(|s| !s.trim().is_empty())
+        .map(|s| PathBuf::from(s.trim()))
+});
+
+/// Internal implementation for testability - takes the freeze file as parameter.
+fn frozen_reason_impl(freeze_file: Option<&Path>) -> Option<String> {
+    let path = freeze_file?;
+    if !path.exists() {
+        return None;
+    }
+    let reason = std::fs::read_to_string(path).unwrap_or_default();
+    let reason = reason.trim();
+    Some(if reason.is_empty() {
+        DEFAULT_FREEZE_REASON.to_string()
+    } else {
+        reason.to_string()
+    })
+}
+
+/// The freeze file, if one is configured
+pub fn freeze_file() -> Option<&'static Path> {
+    FREEZE_FILE.as_deref()
+}
+
+/// Return the operator's reason if the server is frozen, or None if executions are allowed
+pub fn frozen_reason() -> Option<String> {
+    frozen_reason_impl(FREEZE_FILE.as_deref())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_not_frozen_without_freeze_file_config() {
+        assert_eq!(frozen_reason_impl(None), None);
+    }
+
+    #[test]
+    fn test_not_frozen_when_file_missing() {
+        let temp_dir = TempDir::new().unwrap();
+        assert_eq!(frozen_reason_impl(Some(&temp_dir.path().join("freeze"))), None);
+    }
+
+    #[test]
+    fn test_frozen_with_reason_from_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("freeze");
+        std::fs::write(&path, "release 4.2 cutover until 18:00 UTC\n").unwrap();
+        assert_eq!(
+            frozen_reason_impl(Some(&path)),
+            Some("release 4.2 cutover until 18:00 UTC".to_string())
+        );
+    }
+
+    #[test]
+    fn test_frozen_with_default_reason_for_empty_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("freeze");
+        std::fs::write(&path, "").unwrap();
+        assert_eq!(frozen_reason_impl(Some(&path)), Some(DEFAULT_FREEZE_REASON.to_string()));
+    }
+}
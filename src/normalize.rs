@@ -0,0 +1,117 @@
+use regex::Regex;
+use std::sync::LazyLock;
+
+/// Built-in rules for output that changes between otherwise identical runs,
+/// applied in order after any custom rules
+const DEFAULT_RULES: &[(&str, &str)] = &[
+    (
+        "timestamp",
+        r"\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?",
+    ),
+    ("tmp", r"(/tmp|/var/folders/[^\s/]+/[^\s/]+/T)/[^\s/:'\x22]+"),
+    ("address", r"\b0x[0-9a-fA-F]{6,}\b"),
+    ("duration", r"\b\d+(\.\d+)?\s?(ms|s)\b"),
+];
+
+/// A rule replacing every match of `pattern` with "<name>"
+pub struct Rule {
+    replacement: String,
+    pattern: Regex,
+}
+
+impl Rule {
+    fn new(name: &str, pattern: &str) -> Option<Self> {
+        match Regex::new(pattern) {
+            Ok(pattern) => Some(Self {
+                replacement: format!("<{}>", name),
+                pattern,
+            }),
+            Err(e) => {
+                tracing::warn!("Ignoring output normalization rule '{}': {}", name, e);
+                None
+            }
+        }
+    }
+}
+
+/// Parse custom rules. Format: semicolon-separated "name=regex" pairs,
+/// e.g., "build-id=build-[0-9a-f]{12};port=localhost:\d+"
+fn parse_rules(spec: &str) -> Vec<Rule> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .filter_map(|(name, pattern)| Rule::new(name.trim(), pattern))
+        .collect()
+}
+
+/// Normalization rules: custom rules from OUTPUT_NORMALIZE_RULES (loaded at startup)
+/// run before the built-in defaults.
+static RULES: LazyLock<Vec<Rule>> = LazyLock::new(|| {
+    let mut rules = parse_rules(&std::env::var("OUTPUT_NORMALIZE_RULES").unwrap_or_default());
+    rules.extend(DEFAULT_RULES.iter().filter_map(|(name, pattern)| Rule::new(name, pattern)));
+    rules
+});
+
+/// Replace volatile parts of command output (timestamps, temp paths, durations, ...)
+/// with placeholders so outputs from different runs can be compared
+pub fn normalize(text: &str) -> String {
+    normalize_impl(text, &RULES)
+}
+
+/// Internal implementation for testability - takes rules as parameter.
+fn normalize_impl(text: &str, rules: &[Rule]) -> String {
+    rules.iter().fold(text.to_string(), |text, rule| {
+        rule.pattern
+            .replace_all(&text, rule.replacement.as_str())
+            .into_owned()
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn default_rules() -> Vec<Rule> {
+        DEFAULT_RULES
+            .iter()
+            .filter_map(|(name, pattern)| Rule::new(name, pattern))
+            .collect()
+    }
+
+    #[test]
+    fn test_default_rules_compile() {
+        assert_eq!(default_rules().len(), DEFAULT_RULES.len());
+    }
+
+    #[test]
+    fn test_normalize_timestamps_and_durations() {
+        let rules = default_rules();
+        assert_eq!(
+            normalize_impl("2026-10-15T12:00:00.123Z INFO ok  pkg/foo 0.42s", &rules),
+            "<timestamp> INFO ok  pkg/foo <duration>"
+        );
+        assert_eq!(
+            normalize_impl("finished in 350 ms", &rules),
+            "finished in <duration>"
+        );
+    }
+
+    #[test]
+    fn test_normalize_temp_paths_and_addresses() {
+        let rules = default_rules();
+        assert_eq!(
+            normalize_impl("wrote /tmp/tmp.Xa81kq/out.txt at 0x7ffd5e8c1a20", &rules),
+            "wrote <tmp>/out.txt at <address>"
+        );
+    }
+
+    #[test]
+    fn test_custom_rules_run_first() {
+        let mut rules = parse_rules("build-id=build-[0-9a-f]{6};broken=[");
+        assert_eq!(rules.len(), 1);
+        rules.extend(default_rules());
+        assert_eq!(
+            normalize_impl("build-a1b2c3 took 3s", &rules),
+            "<build-id> took <duration>"
+        );
+    }
+}
@@ -0,0 +1,398 @@
+use std::cell::Cell;
+use std::io::Write;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+use std::sync::LazyLock;
+use std::time::Duration;
+
+use crate::classify::is_failure;
+use crate::severity::{self, Severity};
+use crate::transcript;
+
+/// Title shown on desktop notifications
+const NOTIFICATION_TITLE: &str = "command-runner";
+
+/// Message posted to the webhook when NOTIFY_WEBHOOK_TEMPLATE is not set
+const DEFAULT_WEBHOOK_TEMPLATE: &str =
+    "{tool} {event} (call {call} in session {session}): {summary}\nTranscript: {transcript}\nOutput: {output}";
+
+/// Seconds to wait for the webhook to respond
+const WEBHOOK_TIMEOUT_SECS: &str = "10";
+
+/// Where completion notifications are delivered
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Target {
+    /// Desktop notification via notify-send (Linux) or osascript (macOS)
+    Desktop,
+    /// One line per notification appended to a file or named pipe
+    Pipe(PathBuf),
+}
+
+/// Notification settings loaded from environment variables at startup.
+/// NOTIFY_AFTER_SECS enables notifications for calls that take at least that long.
+/// NOTIFY_PIPE sends them to a file or named pipe instead of the desktop.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct NotifyConfig {
+    after: Duration,
+    target: Target,
+}
+
+static NOTIFY_CONFIG: LazyLock<Option<NotifyConfig>> = LazyLock::new(|| {
+    let secs = std::env::var("NOTIFY_AFTER_SECS").ok()?.trim().parse::<u64>().ok()?;
+    let target = match std::env::var("NOTIFY_PIPE") {
+        Ok(path) if !path.trim().is_empty() => Target::Pipe(PathBuf::from(path.trim())),
+        _ => Target::Desktop,
+    };
+    Some(NotifyConfig {
+        after: Duration::from_secs(secs),
+        target,
+    })
+});
+
+/// Tool call outcomes that can be posted to a chat webhook
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Event {
+    /// The command ran and failed or timed out
+    Failed,
+    /// The request was rejected by validation, read-only mode, or a maintenance freeze
+    Denied,
+    /// The session's activity passed an anomaly limit
+    Anomaly,
+    /// A download was refused because the scratch area would pass its quota
+    Quota,
+}
+
+impl Event {
+    fn name(self) -> &'static str {
+        match self {
+            Event::Failed => "failed",
+            Event::Denied => "denied",
+            Event::Anomaly => "anomaly",
+            Event::Quota => "quota",
+        }
+    }
+
+    fn parse(name: &str) -> Option<Self> {
+        match name.trim() {
+            "failed" => Some(Event::Failed),
+            "denied" => Some(Event::Denied),
+            "anomaly" => Some(Event::Anomaly),
+            "quota" => Some(Event::Quota),
+            _ => None,
+        }
+    }
+}
+
+/// Chat webhook settings loaded from environment variables at startup.
+/// NOTIFY_WEBHOOK_URL enables posting to a Slack-compatible incoming webhook.
+/// NOTIFY_WEBHOOK_EVENTS is a semicolon-separated list of events to post ("failed;denied;anomaly;quota" by default).
+/// NOTIFY_WEBHOOK_TEMPLATE overrides the message text.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct WebhookConfig {
+    url: String,
+    events: Vec<Event>,
+    template: String,
+}
+
+static WEBHOOK_CONFIG: LazyLock<Option<WebhookConfig>> = LazyLock::new(|| {
+    let url = std::env::var("NOTIFY_WEBHOOK_URL").ok().filter(|s| !s.trim().is_empty())?;
+    let events = match std::env::var("NOTIFY_WEBHOOK_EVENTS") {
+        Ok(list) if !list.trim().is_empty() => list.split(';').filter_map(Event::parse).collect(),
+        _ => vec![Event::Failed, Event::Denied, Event::Anomaly, Event::Quota],
+    };
+    let template = std::env::var("NOTIFY_WEBHOOK_TEMPLATE")
+        .ok()
+        .filter(|s| !s.trim().is_empty())
+        .unwrap_or_else(|| DEFAULT_WEBHOOK_TEMPLATE.to_string());
+    Some(WebhookConfig {
+        url: url.trim().to_string(),
+        events,
+        template,
+    })
+});
+
+thread_local! {
+    /// Set when the current tool call was refused by the scratch quota
+    static QUOTA_EXCEEDED: Cell<bool> = const { Cell::new(false) };
+}
+
+/// Note that the current tool call was refused by the scratch quota, so the webhook posts it as a quota event
+pub fn note_quota_exceeded() {
+    QUOTA_EXCEEDED.with(|exceeded| exceeded.set(true));
+}
+
+/// Notify the developer that a tool call finished if it ran past the configured threshold.
+/// Delivery happens on a background thread so a slow notifier or a pipe without a reader
+/// never delays the tool result.
+pub fn notify_if_long(tool: &str, elapsed: Duration, output: &str) {
+    let Some(config) = NOTIFY_CONFIG.as_ref() else {
+        return;
+    };
+    if elapsed < config.after {
+        return;
+    }
+    let message = completion_message(tool, elapsed, output);
+    let target = config.target.clone();
+    std::thread::spawn(move || {
+        if let Err(e) = deliver(&target, &message) {
+            tracing::warn!("Failed to send completion notification: {}", e);
+        }
+    });
+}
+
+/// Build a one-line message such as "presubmit failed after 12m 5s"
+fn completion_message(tool: &str, elapsed: Duration, output: &str) -> String {
+    let status = if is_failure(output) { "failed" } else { "finished" };
+    format!("{} {} after {}", tool, status, format_elapsed(elapsed))
+}
+
+/// Post a failed, denied or over-quota tool call to the configured chat webhook.
+/// `denied` is true when the request was rejected before running; `spooled` lists the invocations
+/// whose full output the call spooled.
+pub fn notify_webhook(tool: &str, call: u64, output: &str, denied: bool, spooled: &[u64]) {
+    // Taken even without a webhook, so it doesn't carry over to the thread's next call
+    let quota = QUOTA_EXCEEDED.with(Cell::take);
+    let Some(config) = WEBHOOK_CONFIG.as_ref() else {
+        return;
+    };
+    match call_event(output, denied, quota) {
+        Some(event) if config.events.contains(&event) => {
+            post_in_background(config, event, tool, call, output, spooled)
+        }
+        _ => {}
+    }
+}
+
+/// The event a finished tool call is posted as, if any
+fn call_event(output: &str, denied: bool, quota: bool) -> Option<Event> {
+    if denied {
+        Some(Event::Denied)
+    } else if quota {
+        Some(Event::Quota)
+    } else if is_failure(output) {
+        Some(Event::Failed)
+    } else {
+        None
+    }
+}
+
+/// Post an anomaly in a session's activity to the configured chat webhook, with `reason` as its summary
+pub fn notify_anomaly(tool: &str, call: u64, reason: &str) {
+    match WEBHOOK_CONFIG.as_ref() {
+        Some(config) if config.events.contains(&Event::Anomaly) => {
+            post_in_background(config, Event::Anomaly, tool, call, reason, &[])
+        }
+        _ => {}
+    }
+}
+
+fn post_in_background(config: &WebhookConfig, event: Event, tool: &str, call: u64, output: &str, spooled: &[u64]) {
+    let message = render_template(&config.template, event, tool, call, output, spooled);
+    let url = config.url.clone();
+    std::thread::spawn(move || {
+        if let Err(e) = post_webhook(&url, &message) {
+            tracing::warn!("Failed to post webhook notification: {}", e);
+        }
+    });
+}
+
+/// Fill in the {tool}, {event}, {call}, {session}, {summary}, {transcript}, {errors}, {warnings},
+/// {first_error} and {output} placeholders. {output} gives the get_output call for each spooled invocation;
+/// lines with it are left out when the call spooled none.
+fn render_template(template: &str, event: Event, tool: &str, call: u64, output: &str, spooled: &[u64]) -> String {
+    let template = if spooled.is_empty() {
+        template
+            .lines()
+            .filter(|line| !line.contains("{output}"))
+            .collect::<Vec<_>>()
+            .join("\n")
+    } else {
+        template.to_string()
+    };
+    let reads = spooled
+        .iter()
+        .map(|id| format!("get_output {{\"id\": {}}}", id))
+        .collect::<Vec<_>>()
+        .join(", ");
+    let (errors, warnings) = severity::counts(output);
+    let first_error = output
+        .lines()
+        .find(|line| severity::severity(line) == Severity::Error)
+        .unwrap_or_default();
+    template
+        .replace("{tool}", tool)
+        .replace("{event}", event.name())
+        .replace("{call}", &call.to_string())
+        .replace("{session}", transcript::session_id())
+        .replace("{transcript}", &transcript::transcript_uri())
+        .replace("{errors}", &errors.to_string())
+        .replace("{warnings}", &warnings.to_string())
+        .replace("{first_error}", first_error)
+        .replace("{output}", &reads)
+        .replace("{summary}", output.lines().next().unwrap_or_default())
+}
+
+/// Post a message as Slack-compatible JSON ({"text": ...}) using curl.
+/// The body is passed on stdin so the message never appears in the process list.
+fn post_webhook(url: &str, message: &str) -> std::io::Result<()> {
+    let body = serde_json::json!({ "text": message }).to_string();
+    let mut child = Command::new("curl")
+        .args(["--fail", "--silent", "--show-error", "--max-time", WEBHOOK_TIMEOUT_SECS])
+        .args(["--header", "Content-Type: application/json", "--data-binary", "@-"])
+        .arg(url)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn()?;
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(body.as_bytes())?;
+    }
+    let status = child.wait()?;
+    if !status.success() {
+        return Err(std::io::Error::other(format!("curl exited with {}", status)));
+    }
+    Ok(())
+}
+
+/// Elapsed time in its two largest units, e.g., "12m 5s"
+pub fn format_elapsed(elapsed: Duration) -> String {
+    let secs = elapsed.as_secs();
+    match (secs / 3_600, (secs % 3_600) / 60, secs % 60) {
+        (0, 0, s) => format!("{}s", s),
+        (0, m, s) => format!("{}m {}s", m, s),
+        (h, m, _) => format!("{}h {}m", h, m),
+    }
+}
+
+fn deliver(target: &Target, message: &str) -> std::io::Result<()> {
+    match target {
+        Target::Pipe(path) => {
+            let mut pipe = std::fs::OpenOptions::new().append(true).create(true).open(path)?;
+            writeln!(pipe, "{}", message)
+        }
+        Target::Desktop => {
+            let mut cmd = if cfg!(target_os = "macos") {
+                let mut cmd = Command::new("osascript");
+                cmd.arg("-e").arg(format!(
+                    "display notification \"{}\" with title \"{}\"",
+                    message.replace('\\', "\\\\").replace('"', "\\\""),
+                    NOTIFICATION_TITLE
+                ));
+                cmd
+            } else {
+                let mut cmd = Command::new("notify-send");
+                cmd.arg(NOTIFICATION_TITLE).arg(message);
+                cmd
+            };
+            cmd.stdin(Stdio::null()).stdout(Stdio::null()).stderr(Stdio::null());
+            cmd.status().map(|_| ())
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_completion_message() {
+        assert_eq!(
+            completion_message("presubmit", Duration::from_secs(725), "Verdict: PASS"),
+            "presubmit finished after 12m 5s"
+        );
+        assert_eq!(
+            completion_message("git", Duration::from_secs(42), "Error: Command timed out\nFailure category: timeout"),
+            "git failed after 42s"
+        );
+    }
+
+    #[test]
+    fn test_format_elapsed() {
+        assert_eq!(format_elapsed(Duration::from_secs(9)), "9s");
+        assert_eq!(format_elapsed(Duration::from_secs(61)), "1m 1s");
+        assert_eq!(format_elapsed(Duration::from_secs(3_725)), "1h 2m");
+    }
+
+    #[test]
+    fn test_event_parse() {
+        assert_eq!(Event::parse("failed"), Some(Event::Failed));
+        assert_eq!(Event::parse(" denied "), Some(Event::Denied));
+        assert_eq!(Event::parse("anomaly"), Some(Event::Anomaly));
+        assert_eq!(Event::parse("quota"), Some(Event::Quota));
+        assert_eq!(Event::parse("disk"), None);
+    }
+
+    #[test]
+    fn test_call_event() {
+        assert_eq!(call_event("Verdict: PASS", false, false), None);
+        assert_eq!(call_event("Error: Command timed out", false, false), Some(Event::Failed));
+        assert_eq!(call_event("Error: Command not allowed", true, false), Some(Event::Denied));
+        let refused = "Error: Disk quota exceeded: the scratch area would need 2048 bytes but its quota is 1024 bytes.";
+        assert_eq!(call_event(refused, false, true), Some(Event::Quota));
+    }
+
+    #[test]
+    fn test_quota_note_lasts_one_call() {
+        note_quota_exceeded();
+        notify_webhook("download", 1, "Error: Disk quota exceeded", false, &[]);
+        assert!(!QUOTA_EXCEEDED.with(Cell::get));
+    }
+
+    #[test]
+    fn test_render_template() {
+        let message = render_template(
+            DEFAULT_WEBHOOK_TEMPLATE,
+            Event::Failed,
+            "presubmit",
+            7,
+            "Verdict: FAIL\nlint: FAIL",
+            &[12, 13],
+        );
+        assert_eq!(
+            message,
+            format!(
+                "presubmit failed (call 7 in session {}): Verdict: FAIL\nTranscript: transcript://{}\n\
+                 Output: get_output {{\"id\": 12}}, get_output {{\"id\": 13}}",
+                transcript::session_id(),
+                transcript::session_id()
+            )
+        );
+        // Nothing spooled, e.g., a denied call: the output line is left out
+        assert_eq!(
+            render_template(DEFAULT_WEBHOOK_TEMPLATE, Event::Denied, "git", 3, "Error: Command not allowed", &[]),
+            format!(
+                "git denied (call 3 in session {}): Error: Command not allowed\nTranscript: transcript://{}",
+                transcript::session_id(),
+                transcript::session_id()
+            )
+        );
+        assert_eq!(
+            render_template("{event}: {tool}", Event::Denied, "git", 1, "Error: Command not allowed", &[]),
+            "denied: git"
+        );
+        assert_eq!(
+            render_template(
+                "{errors} errors, {warnings} warnings: {first_error}",
+                Event::Failed,
+                "presubmit",
+                2,
+                "Verdict: FAIL\nwarning: unused\nerror[E0308]: mismatched types\nFailure category: compile-error",
+                &[]
+            ),
+            "1 errors, 1 warnings: error[E0308]: mismatched types"
+        );
+    }
+
+    #[test]
+    fn test_deliver_to_pipe_appends_lines() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let path = temp_dir.path().join("notify");
+        let target = Target::Pipe(path.clone());
+        deliver(&target, "build finished after 5m 0s").unwrap();
+        deliver(&target, "test failed after 7m 3s").unwrap();
+        assert_eq!(
+            std::fs::read_to_string(&path).unwrap(),
+            "build finished after 5m 0s\ntest failed after 7m 3s\n"
+        );
+    }
+}
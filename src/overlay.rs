@@ -0,0 +1,375 @@
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::scratch::{scratch_dir, scratch_subdir_in};
+use crate::transcript;
+
+/// Subdirectory of the scratch area holding each overlay's upper and work directories
+const OVERLAY_DIR: &str = "overlays";
+
+/// Most overlays remembered for overlay_diff; the oldest are forgotten first, though their layers stay
+/// on disk until scratch retention removes them
+const MAX_OVERLAYS: usize = 50;
+
+static OVERLAYS: Mutex<Vec<Arc<Overlay>>> = Mutex::new(Vec::new());
+
+static LAST_ID: AtomicU64 = AtomicU64::new(0);
+
+/// A copy-on-write view of a workspace for one tool call. Its commands see the workspace with an
+/// overlayfs mounted over it, in a mount namespace of their own, so what they change goes to the upper
+/// directory and the real checkout is never written.
+#[derive(Debug)]
+pub struct Overlay {
+    pub id: u64,
+    pub tool: String,
+    /// The directory the overlay is mounted over, and the read-only lower layer
+    pub workspace: PathBuf,
+    /// Where changed files go
+    pub upper: PathBuf,
+    /// Scratch space overlayfs needs on the same filesystem as the upper directory
+    pub work: PathBuf,
+    /// When it was created, in seconds since the Unix epoch
+    pub created: u64,
+}
+
+/// A path the commands changed, relative to the workspace
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Change {
+    Added(PathBuf),
+    Modified(PathBuf),
+    Deleted(PathBuf),
+}
+
+impl Change {
+    pub fn path(&self) -> &Path {
+        match self {
+            Change::Added(path) | Change::Modified(path) | Change::Deleted(path) => path,
+        }
+    }
+}
+
+/// Create an overlay of a workspace for a tool call, with its layers under the scratch area
+pub fn create(tool: &str, workspace: &Path) -> io::Result<Arc<Overlay>> {
+    create_in(scratch_dir(), tool, workspace)
+}
+
+/// Internal implementation for testability - takes the scratch root as parameter.
+fn create_in(root: &Path, tool: &str, workspace: &Path) -> io::Result<Arc<Overlay>> {
+    if !cfg!(target_os = "linux") {
+        return Err(io::Error::new(io::ErrorKind::Unsupported, "overlays are only supported on Linux"));
+    }
+    let workspace = workspace.canonicalize()?;
+    if !workspace.is_dir() {
+        return Err(io::Error::new(io::ErrorKind::InvalidInput, "the workspace is not a directory"));
+    }
+    let id = LAST_ID.fetch_add(1, Ordering::Relaxed) + 1;
+    let dir = scratch_subdir_in(root, OVERLAY_DIR)?.join(format!("{}-{}", transcript::session_id(), id));
+    let overlay = Overlay {
+        id,
+        tool: tool.to_string(),
+        workspace,
+        upper: dir.join("upper"),
+        work: dir.join("work"),
+        created: SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs()),
+    };
+    // overlayfs options are comma-separated, with ':' separating lower layers
+    for path in [&overlay.workspace, &overlay.upper, &overlay.work] {
+        if path.to_string_lossy().contains([',', ':', '\\']) {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidInput,
+                format!("{} contains a character overlayfs options can't hold (',', ':' or '\\')", path.display()),
+            ));
+        }
+    }
+    fs::create_dir_all(&overlay.upper)?;
+    fs::create_dir_all(&overlay.work)?;
+    // Commands run as RUN_AS write the upper layer as that user
+    #[cfg(unix)]
+    if let Ok(Some(run_as)) = crate::run_as::configured() {
+        for dir in [&overlay.upper, &overlay.work] {
+            std::os::unix::fs::chown(dir, Some(run_as.uid), Some(run_as.gid))?;
+        }
+    }
+    let overlay = Arc::new(overlay);
+    let mut overlays = OVERLAYS.lock().unwrap_or_else(|e| e.into_inner());
+    if overlays.len() >= MAX_OVERLAYS {
+        overlays.remove(0);
+    }
+    overlays.push(overlay.clone());
+    Ok(overlay)
+}
+
+/// The overlay with the given ID, if it is still remembered
+pub fn get(id: u64) -> Option<Arc<Overlay>> {
+    OVERLAYS.lock().unwrap_or_else(|e| e.into_inner()).iter().find(|overlay| overlay.id == id).cloned()
+}
+
+impl Overlay {
+    /// Run a command on the overlay: as it starts, it enters new user and mount namespaces, where it
+    /// keeps its own user and group IDs, mounts the overlay over the workspace and changes back into
+    /// its working directory, which now resolves through the overlay. Needs unprivileged user
+    /// namespaces and overlayfs's userxattr option (Linux 5.11+).
+    #[cfg(target_os = "linux")]
+    pub fn enter(&self, cmd: &mut Command) {
+        use std::ffi::CString;
+        use std::os::unix::ffi::OsStrExt;
+        use std::os::unix::process::CommandExt;
+
+        let (uid, gid) = match crate::run_as::configured() {
+            Ok(Some(run_as)) => (run_as.uid, run_as.gid),
+            // SAFETY: getuid and getgid can't fail
+            _ => unsafe { (libc::getuid(), libc::getgid()) },
+        };
+        let cwd = cmd
+            .get_current_dir()
+            .map(Path::to_path_buf)
+            .or_else(|| std::env::current_dir().ok())
+            .unwrap_or_else(|| self.workspace.clone());
+        let options = format!(
+            "lowerdir={},upperdir={},workdir={},userxattr",
+            self.workspace.display(),
+            self.upper.display(),
+            self.work.display()
+        );
+        let cstring = |bytes: &[u8]| CString::new(bytes).unwrap_or_default();
+        let target = cstring(self.workspace.as_os_str().as_bytes());
+        let cwd = cstring(cwd.as_os_str().as_bytes());
+        let options = cstring(options.as_bytes());
+        let uid_map = format!("{} {} 1", uid, uid).into_bytes();
+        let gid_map = format!("{} {} 1", gid, gid).into_bytes();
+        // SAFETY: the hook only makes async-signal-safe system calls and doesn't allocate. The child is
+        // single-threaded, as unshare(CLONE_NEWUSER) requires. It runs again harmlessly on a retry.
+        unsafe {
+            cmd.pre_exec(move || {
+                let check = |result: libc::c_int| if result == 0 { Ok(()) } else { Err(io::Error::last_os_error()) };
+                check(libc::unshare(libc::CLONE_NEWUSER | libc::CLONE_NEWNS))?;
+                // Without a setgroups deny, an unprivileged process can't map its group
+                write_proc(c"/proc/self/setgroups", b"deny")?;
+                write_proc(c"/proc/self/uid_map", &uid_map)?;
+                write_proc(c"/proc/self/gid_map", &gid_map)?;
+                // Keep the mount from propagating back to the server's namespace
+                let flags = libc::MS_REC | libc::MS_PRIVATE;
+                check(libc::mount(c"none".as_ptr(), c"/".as_ptr(), std::ptr::null(), flags, std::ptr::null()))?;
+                check(libc::mount(
+                    c"overlay".as_ptr(),
+                    target.as_ptr(),
+                    c"overlay".as_ptr(),
+                    0,
+                    options.as_ptr().cast(),
+                ))?;
+                check(libc::chdir(cwd.as_ptr()))
+            });
+        }
+    }
+
+    #[cfg(not(target_os = "linux"))]
+    pub fn enter(&self, _cmd: &mut Command) {}
+
+    /// What the commands changed in the workspace, sorted by path. Whiteouts, the character devices
+    /// overlayfs leaves for removed paths, and opaque directories, whose lower contents were replaced,
+    /// are reported as deletions of the files they hide.
+    pub fn changes(&self) -> io::Result<Vec<Change>> {
+        // Scratch retention may have removed it
+        fs::metadata(&self.upper)?;
+        let mut changes = Vec::new();
+        collect(&self.upper, &self.workspace, Path::new(""), &mut changes);
+        changes.sort_by(|a, b| a.path().cmp(b.path()));
+        Ok(changes)
+    }
+}
+
+/// Write to a file under /proc from a pre_exec hook
+#[cfg(target_os = "linux")]
+unsafe fn write_proc(path: &std::ffi::CStr, data: &[u8]) -> io::Result<()> {
+    let fd = libc::open(path.as_ptr(), libc::O_WRONLY | libc::O_CLOEXEC);
+    if fd < 0 {
+        return Err(io::Error::last_os_error());
+    }
+    let written = libc::write(fd, data.as_ptr().cast(), data.len());
+    let error = io::Error::last_os_error();
+    libc::close(fd);
+    if written != data.len() as isize {
+        return Err(error);
+    }
+    Ok(())
+}
+
+/// Collect the changes under a directory of the upper layer, given relative to the layers' roots
+fn collect(upper: &Path, lower: &Path, rel: &Path, changes: &mut Vec<Change>) {
+    let dir = upper.join(rel);
+    if is_opaque(&dir) {
+        for name in names(&lower.join(rel)) {
+            if fs::symlink_metadata(dir.join(&name)).is_err() {
+                deleted(lower, &rel.join(name), changes);
+            }
+        }
+    }
+    for name in names(&dir) {
+        let path = rel.join(&name);
+        let Ok(metadata) = fs::symlink_metadata(upper.join(&path)) else {
+            continue;
+        };
+        let below = fs::symlink_metadata(lower.join(&path)).ok();
+        if is_whiteout(&metadata) {
+            deleted(lower, &path, changes);
+        } else if metadata.is_dir() {
+            if below.is_some_and(|below| !below.is_dir()) {
+                changes.push(Change::Deleted(path.clone()));
+            }
+            collect(upper, lower, &path, changes);
+        } else {
+            match below {
+                Some(below) if below.is_dir() => {
+                    deleted(lower, &path, changes);
+                    changes.push(Change::Added(path));
+                }
+                Some(_) => changes.push(Change::Modified(path)),
+                None => changes.push(Change::Added(path)),
+            }
+        }
+    }
+}
+
+/// Report a path of the lower layer as deleted: a file itself, a directory every file under it
+fn deleted(lower: &Path, rel: &Path, changes: &mut Vec<Change>) {
+    match fs::symlink_metadata(lower.join(rel)) {
+        Ok(metadata) if metadata.is_dir() => {
+            for name in names(&lower.join(rel)) {
+                deleted(lower, &rel.join(name), changes);
+            }
+        }
+        Ok(_) => changes.push(Change::Deleted(rel.to_path_buf())),
+        Err(_) => {}
+    }
+}
+
+/// Names in a directory, sorted; none if it can't be read
+fn names(dir: &Path) -> Vec<std::ffi::OsString> {
+    let mut names: Vec<_> = match fs::read_dir(dir) {
+        Ok(entries) => entries.filter_map(|e| e.ok()).map(|e| e.file_name()).collect(),
+        Err(_) => Vec::new(),
+    };
+    names.sort();
+    names
+}
+
+/// Whether an upper-layer entry is a whiteout, a 0/0 character device marking a removed path
+#[cfg(unix)]
+fn is_whiteout(metadata: &fs::Metadata) -> bool {
+    use std::os::unix::fs::{FileTypeExt, MetadataExt};
+    metadata.file_type().is_char_device() && metadata.rdev() == 0
+}
+
+#[cfg(not(unix))]
+fn is_whiteout(_metadata: &fs::Metadata) -> bool {
+    false
+}
+
+/// Whether an upper-layer directory is opaque, hiding the lower directory's contents. With userxattr,
+/// overlayfs marks it with the user.overlay.opaque attribute.
+#[cfg(target_os = "linux")]
+fn is_opaque(dir: &Path) -> bool {
+    use std::os::unix::ffi::OsStrExt;
+    let Ok(path) = std::ffi::CString::new(dir.as_os_str().as_bytes()) else {
+        return false;
+    };
+    let mut value = [0u8; 1];
+    // SAFETY: both strings are NUL-terminated and the buffer is as long as given
+    let len = unsafe {
+        libc::lgetxattr(path.as_ptr(), c"user.overlay.opaque".as_ptr(), value.as_mut_ptr().cast(), value.len())
+    };
+    len == 1 && value[0] == b'y'
+}
+
+#[cfg(not(target_os = "linux"))]
+fn is_opaque(_dir: &Path) -> bool {
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::executor::{run_command, ExecutionResult};
+    use crate::request::ExecutionContext;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_changes_compare_layers() {
+        let workspace = TempDir::new().unwrap();
+        let scratch = TempDir::new().unwrap();
+        fs::create_dir_all(workspace.path().join("src")).unwrap();
+        fs::write(workspace.path().join("src/main.go"), "package main\n").unwrap();
+        fs::write(workspace.path().join("go.mod"), "module example\n").unwrap();
+        let overlay = create_in(scratch.path(), "presubmit", workspace.path()).unwrap();
+        assert!(overlay.upper.starts_with(scratch.path().join(OVERLAY_DIR)));
+        assert!(get(overlay.id).is_some());
+        assert!(overlay.changes().unwrap().is_empty());
+
+        fs::create_dir_all(overlay.upper.join("src/gen")).unwrap();
+        fs::write(overlay.upper.join("src/main.go"), "package main\n\nfunc main() {}\n").unwrap();
+        fs::write(overlay.upper.join("src/gen/api.go"), "package gen\n").unwrap();
+        assert_eq!(
+            overlay.changes().unwrap(),
+            [
+                Change::Added(PathBuf::from("src/gen/api.go")),
+                Change::Modified(PathBuf::from("src/main.go")),
+            ]
+        );
+        // A file replaced by a directory
+        fs::create_dir(overlay.upper.join("go.mod")).unwrap();
+        fs::write(overlay.upper.join("go.mod/x"), "").unwrap();
+        let changes = overlay.changes().unwrap();
+        assert!(changes.contains(&Change::Deleted(PathBuf::from("go.mod"))));
+        assert!(changes.contains(&Change::Added(PathBuf::from("go.mod/x"))));
+    }
+
+    #[test]
+    fn test_create_rejects_paths_overlayfs_cant_take() {
+        let parent = TempDir::new().unwrap();
+        let workspace = parent.path().join("a,b");
+        fs::create_dir(&workspace).unwrap();
+        let err = create_in(parent.path(), "presubmit", &workspace).unwrap_err();
+        assert!(err.to_string().contains("overlayfs options"), "{}", err);
+    }
+
+    /// Whether a command failed to start because this host can't mount an overlay in a user namespace:
+    /// unprivileged user namespaces are off (EPERM, EACCES, ENOSYS), or overlayfs or its userxattr option
+    /// is missing (ENODEV, EINVAL)
+    fn overlay_unavailable(output: &str) -> bool {
+        let started = ["Failed to execute command: ", "Failed to spawn command: "];
+        started.iter().any(|prefix| output.starts_with(prefix))
+            && [1, 13, 38, 19, 22].iter().any(|errno| output.trim_end().ends_with(&format!("(os error {})", errno)))
+    }
+
+    #[test]
+    fn test_commands_change_the_overlay_not_the_workspace() {
+        let workspace = TempDir::new().unwrap();
+        let scratch = TempDir::new().unwrap();
+        fs::write(workspace.path().join("BUILD"), "old\n").unwrap();
+        fs::write(workspace.path().join("stale.txt"), "x\n").unwrap();
+        let overlay = create_in(scratch.path(), "presubmit", workspace.path()).unwrap();
+        let ctx = ExecutionContext {
+            working_dir: Some(workspace.path().to_string_lossy().to_string()),
+            overlay: Some(overlay.clone()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo new > BUILD && rm stale.txt && cat BUILD"]);
+        match run_command(cmd, &ctx) {
+            ExecutionResult::Success(output) => assert_eq!(output.trim(), "new"),
+            ExecutionResult::Error(output) if overlay_unavailable(&output) => return,
+            result => panic!("Expected the command to run on the overlay: {}", result.into_string()),
+        }
+        assert_eq!(fs::read_to_string(workspace.path().join("BUILD")).unwrap(), "old\n");
+        assert!(workspace.path().join("stale.txt").exists());
+        assert_eq!(
+            overlay.changes().unwrap(),
+            [Change::Modified(PathBuf::from("BUILD")), Change::Deleted(PathBuf::from("stale.txt"))]
+        );
+    }
+}
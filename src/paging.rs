@@ -0,0 +1,152 @@
+use sha2::{Digest, Sha256};
+use std::cell::RefCell;
+use std::collections::VecDeque;
+use std::future::Future;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::transcript;
+
+/// Most page tokens kept; the oldest are dropped first, and a dropped token can't be used
+pub const MAX_PAGE_TOKENS: usize = 200;
+
+/// A page still to be read: where it starts in the output of the call that was paged
+struct Page {
+    token: String,
+    tool: String,
+    /// Lines of the call's output, after all transformations, shared by the call's pages
+    lines: Arc<Vec<String>>,
+    start: usize,
+    size: usize,
+}
+
+static PAGES: Mutex<VecDeque<Page>> = Mutex::new(VecDeque::new());
+
+/// Page tokens issued so far, to make each one different
+static ISSUED: AtomicU64 = AtomicU64::new(0);
+
+tokio::task_local! {
+    /// Token of the next page of the tool call running in this task, if its result was paged
+    static NEXT_TOKEN: RefCell<Option<String>>;
+}
+
+/// Run a tool call, returning its result and the token of its next page, if it has one. Tools run
+/// synchronously within the call's task, so the token reaches here from anywhere in the call.
+pub async fn scope<F: Future>(call: F) -> (F::Output, Option<String>) {
+    NEXT_TOKEN
+        .scope(RefCell::new(None), async {
+            let output = call.await;
+            (output, NEXT_TOKEN.with(|token| token.take()))
+        })
+        .await
+}
+
+/// The first page of a call's output, `size` lines long. If more lines follow, the output is kept and
+/// the page ends with a blank line and "nextPageToken: <token>".
+pub fn first_page(tool: &str, output: String, size: usize) -> String {
+    let lines: Vec<String> = output.lines().map(str::to_string).collect();
+    if lines.len() <= size {
+        return output;
+    }
+    page(tool, Arc::new(lines), 0, size)
+}
+
+/// The page a token from an earlier page names, read from the output kept then; nothing is run again.
+/// `size` overrides the page size of the call that was paged.
+pub fn next_page(tool: &str, token: &str, size: Option<usize>) -> String {
+    let found = {
+        let pages = PAGES.lock().unwrap_or_else(|e| e.into_inner());
+        pages
+            .iter()
+            .find(|page| page.token == token)
+            .map(|page| (page.tool.clone(), page.lines.clone(), page.start, page.size))
+    };
+    match found {
+        None => format!(
+            "Error: Unknown or expired page_token '{}'; make the call again without it to start over",
+            token
+        ),
+        Some((paged, _, _, _)) if paged != tool => {
+            format!("Error: page_token '{}' is for a page of a {} call, not {}", token, paged, tool)
+        }
+        Some((_, lines, start, page_size)) => {
+            page(tool, lines, start, size.filter(|&size| size > 0).unwrap_or(page_size))
+        }
+    }
+}
+
+/// The lines from `start`, ending with the token of the next page if any lines follow
+fn page(tool: &str, lines: Arc<Vec<String>>, start: usize, size: usize) -> String {
+    let end = start.saturating_add(size).min(lines.len());
+    let mut text = lines.get(start..end).unwrap_or_default().join("\n");
+    if end < lines.len() {
+        let token = issue(tool, lines, end, size);
+        text.push_str(&format!("\n\nnextPageToken: {}", token));
+        let _ = NEXT_TOKEN.try_with(|next| *next.borrow_mut() = Some(token));
+    }
+    text
+}
+
+/// Keep the page starting at `start`, returning its token. Tokens are opaque: a hash, so a client can
+/// only use the ones it was given.
+fn issue(tool: &str, lines: Arc<Vec<String>>, start: usize, size: usize) -> String {
+    let issued = ISSUED.fetch_add(1, Ordering::Relaxed);
+    let nanos = SystemTime::now().duration_since(UNIX_EPOCH).map(|d| d.as_nanos()).unwrap_or_default();
+    let hash = Sha256::digest(format!("{}-{}-{}", transcript::session_id(), issued, nanos));
+    let token: String = hash[..8].iter().map(|byte| format!("{:02x}", byte)).collect();
+    let mut pages = PAGES.lock().unwrap_or_else(|e| e.into_inner());
+    if pages.len() == MAX_PAGE_TOKENS {
+        pages.pop_front();
+    }
+    pages.push_back(Page {
+        token: token.clone(),
+        tool: tool.to_string(),
+        lines,
+        start,
+        size,
+    });
+    token
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// The token at the end of a page
+    fn token(page: &str) -> &str {
+        page.rsplit_once("nextPageToken: ").unwrap().1
+    }
+
+    #[test]
+    fn test_pages_read_kept_output() {
+        let output = "line1\nline2\nline3\nline4\nline5".to_string();
+        assert_eq!(first_page("ls_tool", "a\nb".to_string(), 2), "a\nb");
+        let first = first_page("ls_tool", output, 2);
+        assert!(first.starts_with("line1\nline2\n\nnextPageToken: "));
+        let second = next_page("ls_tool", token(&first), None);
+        assert!(second.starts_with("line3\nline4\n\nnextPageToken: "));
+        assert_ne!(token(&first), token(&second));
+        assert_eq!(next_page("ls_tool", token(&second), None), "line5");
+        // A token can be used again, e.g., after a lost response, and with another page size
+        assert_eq!(next_page("ls_tool", token(&first), Some(3)), "line3\nline4\nline5");
+    }
+
+    #[test]
+    fn test_page_token_invalid() {
+        assert_eq!(
+            next_page("ls_tool", "abc", Some(2)),
+            "Error: Unknown or expired page_token 'abc'; make the call again without it to start over"
+        );
+        let first = first_page("git", "a\nb\nc".to_string(), 2);
+        assert!(next_page("ls_tool", token(&first), None).starts_with("Error: page_token '"));
+    }
+
+    #[tokio::test]
+    async fn test_scope_returns_next_token() {
+        let (page, next) = scope(async { first_page("git", "a\nb\nc".to_string(), 1) }).await;
+        assert_eq!(next.as_deref(), Some(token(&page)));
+        let (_, next) = scope(async { first_page("git", "a".to_string(), 1) }).await;
+        assert_eq!(next, None);
+    }
+}
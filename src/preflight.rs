@@ -0,0 +1,173 @@
+use std::path::Path;
+use std::sync::OnceLock;
+
+use crate::executor::find_executable;
+use crate::scratch::{ensure_writable, scratch_dir};
+use crate::tools::presubmit;
+
+/// A startup check that failed and the tool it takes out of service
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Problem {
+    /// Tool disabled because of this problem
+    pub tool: &'static str,
+    pub detail: String,
+}
+
+static DISABLED: OnceLock<Vec<Problem>> = OnceLock::new();
+
+/// Programs tools run, other than git, curl and the presubmit stages. A tool is disabled when none
+/// of its programs is installed; license_scan runs go-licenses or bazel, depending on the request.
+const TOOL_PROGRAMS: &[(&str, &[&str])] = &[
+    ("bazel_cache", &["bazel"]),
+    ("repro_check", &["bazel"]),
+    ("debug_test", &["dlv"]),
+    ("license_scan", &["go-licenses", "bazel"]),
+];
+
+/// Programs a tool uses when installed but can do without, and what it loses without them
+const OPTIONAL_PROGRAMS: &[(&str, &str, &str)] = &[
+    ("sbom", "syft", "sbom falls back to go.mod, MODULE.bazel.lock and `go version -m`"),
+    ("sbom", "go", "sbom can't list the modules built into binaries"),
+];
+
+/// Check that the binaries and directories the tools depend on are usable
+pub fn run() -> Vec<Problem> {
+    run_impl(
+        |name| find_executable(name).is_some() || (Path::new(name).is_absolute() && Path::new(name).is_file()),
+        scratch_dir(),
+        &presubmit::configured_programs(),
+    )
+}
+
+/// Internal implementation for testability - takes the executable lookup, scratch root
+/// and presubmit programs as parameters.
+fn run_impl(
+    installed: impl Fn(&str) -> bool,
+    scratch: &Path,
+    presubmit_programs: &[(&'static str, String)],
+) -> Vec<Problem> {
+    let mut problems = Vec::new();
+    if !installed("git") {
+        problems.push(Problem {
+            tool: "git",
+            detail: "git not found on PATH".to_string(),
+        });
+    }
+    if !installed("curl") {
+        problems.push(Problem {
+            tool: "download",
+            detail: "curl not found on PATH".to_string(),
+        });
+    }
+    if let Err(e) = ensure_writable(scratch) {
+        problems.push(Problem {
+            tool: "download",
+            detail: format!("scratch directory {} is not writable: {}", scratch.display(), e),
+        });
+    }
+    for (stage, program) in presubmit_programs {
+        if !installed(program) {
+            problems.push(Problem {
+                tool: "presubmit",
+                detail: format!("{} stage program '{}' not found", stage, program),
+            });
+        }
+    }
+    for (tool, programs) in TOOL_PROGRAMS {
+        if !programs.iter().any(|program| installed(program)) {
+            problems.push(Problem {
+                tool,
+                detail: format!("{} not found on PATH", programs.join(" or ")),
+            });
+        }
+    }
+    problems
+}
+
+/// Warnings for optional programs that aren't installed; their tools stay enabled
+pub fn missing_optional() -> Vec<String> {
+    missing_optional_impl(|name| find_executable(name).is_some())
+}
+
+/// Internal implementation for testability - takes the executable lookup as parameter.
+fn missing_optional_impl(installed: impl Fn(&str) -> bool) -> Vec<String> {
+    OPTIONAL_PROGRAMS
+        .iter()
+        .filter(|(_, program, _)| !installed(program))
+        .map(|(_, program, effect)| format!("{} not found on PATH; {}", program, effect))
+        .collect()
+}
+
+/// Take the tools affected by failed startup checks out of service. Call once at startup.
+pub fn disable_tools(problems: Vec<Problem>) {
+    let _ = DISABLED.set(problems);
+}
+
+/// Problems found at startup, one per disabled tool and cause
+pub fn disabled() -> &'static [Problem] {
+    DISABLED.get().map(Vec::as_slice).unwrap_or_default()
+}
+
+/// Whether a tool was disabled by a failed startup check
+pub fn is_disabled(tool: &str) -> bool {
+    disabled().iter().any(|p| p.tool == tool)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_all_checks_pass() {
+        let temp_dir = TempDir::new().unwrap();
+        let programs = vec![("lint", "cargo".to_string())];
+        assert!(run_impl(|_| true, temp_dir.path(), &programs).is_empty());
+    }
+
+    #[test]
+    fn test_missing_binaries_disable_tools() {
+        let temp_dir = TempDir::new().unwrap();
+        let programs = vec![("lint", "cargo".to_string()), ("test", "bazel".to_string())];
+        let problems = run_impl(|name| name == "cargo", temp_dir.path(), &programs);
+        let tools: Vec<&str> = problems.iter().map(|p| p.tool).collect();
+        assert_eq!(
+            tools,
+            vec!["git", "download", "presubmit", "bazel_cache", "repro_check", "debug_test", "license_scan"]
+        );
+        assert_eq!(problems[2].detail, "test stage program 'bazel' not found");
+        assert_eq!(problems[6].detail, "go-licenses or bazel not found on PATH");
+    }
+
+    #[test]
+    fn test_tool_needs_one_of_its_programs() {
+        let temp_dir = TempDir::new().unwrap();
+        let problems = run_impl(|name| !matches!(name, "go-licenses" | "dlv"), temp_dir.path(), &[]);
+        let tools: Vec<&str> = problems.iter().map(|p| p.tool).collect();
+        // bazel alone is enough for license_scan
+        assert_eq!(tools, vec!["debug_test"]);
+        assert_eq!(problems[0].detail, "dlv not found on PATH");
+    }
+
+    #[test]
+    fn test_missing_optional_programs_only_warn() {
+        assert!(missing_optional_impl(|_| true).is_empty());
+        assert_eq!(
+            missing_optional_impl(|name| name != "syft"),
+            vec!["syft not found on PATH; sbom falls back to go.mod, MODULE.bazel.lock and `go version -m`"]
+        );
+        assert_eq!(missing_optional_impl(|_| false).len(), 2);
+    }
+
+    #[test]
+    fn test_unwritable_scratch_disables_download() {
+        let temp_dir = TempDir::new().unwrap();
+        // A regular file where the scratch directory should be
+        let scratch = temp_dir.path().join("scratch");
+        std::fs::write(&scratch, "").unwrap();
+        let problems = run_impl(|_| true, &scratch, &[]);
+        assert_eq!(problems.len(), 1);
+        assert_eq!(problems[0].tool, "download");
+        assert!(problems[0].detail.contains("is not writable"));
+    }
+}
@@ -0,0 +1,196 @@
+use std::fmt;
+use std::process::Command;
+use std::sync::LazyLock;
+
+/// IO scheduling classes, as set with ioprio_set
+const IOPRIO_CLASS_RT: u32 = 1;
+const IOPRIO_CLASS_BE: u32 = 2;
+const IOPRIO_CLASS_IDLE: u32 = 3;
+
+/// Bits the class is shifted by in an IO priority value
+const IOPRIO_CLASS_SHIFT: u32 = 13;
+
+/// IO priority of a command: the class and, for realtime and best-effort, a level from 0 (highest) to 7
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum IoPriority {
+    Realtime(u8),
+    BestEffort(u8),
+    /// Only gets disk time when no other process wants it
+    Idle,
+}
+
+impl IoPriority {
+    /// Parse "idle", "best-effort", "best-effort:7", "realtime" or "realtime:0"; a class without a level gets 4
+    pub fn parse(spec: &str) -> Option<Self> {
+        let (class, level) = match spec.trim().split_once(':') {
+            Some((class, level)) => (class.trim(), Some(level.trim().parse::<u8>().ok().filter(|&l| l <= 7)?)),
+            None => (spec.trim(), None),
+        };
+        match class {
+            "idle" => Some(IoPriority::Idle),
+            "best-effort" => Some(IoPriority::BestEffort(level.unwrap_or(4))),
+            "realtime" => Some(IoPriority::Realtime(level.unwrap_or(4))),
+            _ => None,
+        }
+    }
+
+    /// The value passed to ioprio_set
+    fn value(self) -> u32 {
+        match self {
+            IoPriority::Realtime(level) => (IOPRIO_CLASS_RT << IOPRIO_CLASS_SHIFT) | u32::from(level),
+            IoPriority::BestEffort(level) => (IOPRIO_CLASS_BE << IOPRIO_CLASS_SHIFT) | u32::from(level),
+            IoPriority::Idle => IOPRIO_CLASS_IDLE << IOPRIO_CLASS_SHIFT,
+        }
+    }
+}
+
+impl fmt::Display for IoPriority {
+    /// As parse takes it, e.g., "best-effort:7"
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            IoPriority::Realtime(level) => write!(f, "realtime:{}", level),
+            IoPriority::BestEffort(level) => write!(f, "best-effort:{}", level),
+            IoPriority::Idle => write!(f, "idle"),
+        }
+    }
+}
+
+/// CPU and IO scheduling priority of a command and its descendants
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Priority {
+    /// Niceness added to the server's own, as with `nice -n`
+    pub nice: Option<i32>,
+    /// IO priority (Linux only)
+    pub io: Option<IoPriority>,
+}
+
+/// Priority of every command, loaded from COMMAND_NICE and COMMAND_IONICE at startup.
+/// A tool's profile can set either in its place.
+static DEFAULT: LazyLock<Priority> = LazyLock::new(|| Priority {
+    nice: std::env::var("COMMAND_NICE").ok().and_then(|s| s.trim().parse().ok()),
+    io: std::env::var("COMMAND_IONICE").ok().and_then(|s| parse_io(&s)),
+});
+
+/// Parse an IO priority from configuration, warning about one that isn't valid
+pub fn parse_io(spec: &str) -> Option<IoPriority> {
+    let priority = IoPriority::parse(spec);
+    if priority.is_none() && !spec.trim().is_empty() {
+        tracing::warn!("Ignoring IO priority '{}'", spec.trim());
+    }
+    priority
+}
+
+/// The priority of a command: its tool's profile's, with the defaults for what the profile doesn't set
+pub fn for_command(profile: Option<Priority>) -> Priority {
+    let profile = profile.unwrap_or_default();
+    Priority {
+        nice: profile.nice.or(DEFAULT.nice),
+        io: profile.io.or(DEFAULT.io),
+    }
+}
+
+impl Priority {
+    /// Set the priority on a command when it is spawned. Raising priority (a negative nice or realtime IO)
+    /// needs privileges; without them the command fails to start.
+    #[cfg(unix)]
+    pub fn set(&self, cmd: &mut Command) {
+        use std::os::unix::process::CommandExt;
+
+        if *self == Priority::default() {
+            return;
+        }
+        let Priority { nice, io } = *self;
+        // SAFETY: the hook only makes system calls, which are async-signal-safe, and doesn't allocate
+        unsafe {
+            cmd.pre_exec(move || {
+                if let Some(nice) = nice {
+                    // The niceness was inherited from the server; getpriority can't fail for the calling process
+                    let current = libc::getpriority(libc::PRIO_PROCESS, 0);
+                    if libc::setpriority(libc::PRIO_PROCESS, 0, (current + nice).clamp(-20, 19)) != 0 {
+                        return Err(std::io::Error::last_os_error());
+                    }
+                }
+                #[cfg(target_os = "linux")]
+                if let Some(io) = io {
+                    const IOPRIO_WHO_PROCESS: libc::c_int = 1;
+                    if libc::syscall(libc::SYS_ioprio_set, IOPRIO_WHO_PROCESS, 0, io.value()) != 0 {
+                        return Err(std::io::Error::last_os_error());
+                    }
+                }
+                #[cfg(not(target_os = "linux"))]
+                let _ = io;
+                Ok(())
+            });
+        }
+    }
+
+    #[cfg(not(unix))]
+    pub fn set(&self, _cmd: &mut Command) {}
+}
+
+impl fmt::Display for Priority {
+    /// The settings that are set, e.g., "nice 5, IO idle"
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let mut settings = Vec::new();
+        if let Some(nice) = self.nice {
+            settings.push(format!("nice {}", nice));
+        }
+        if let Some(io) = self.io {
+            settings.push(format!("IO {}", io));
+        }
+        write!(f, "{}", settings.join(", "))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_io_priority_parse() {
+        assert_eq!(IoPriority::parse("idle"), Some(IoPriority::Idle));
+        assert_eq!(IoPriority::parse(" best-effort:7 "), Some(IoPriority::BestEffort(7)));
+        assert_eq!(IoPriority::parse("best-effort"), Some(IoPriority::BestEffort(4)));
+        assert_eq!(IoPriority::parse("realtime:0"), Some(IoPriority::Realtime(0)));
+        assert_eq!(IoPriority::parse("best-effort:8"), None);
+        assert_eq!(IoPriority::parse("low"), None);
+    }
+
+    #[test]
+    fn test_display() {
+        assert_eq!(IoPriority::parse("best-effort:7").unwrap().to_string(), "best-effort:7");
+        let priority = Priority {
+            nice: Some(5),
+            io: Some(IoPriority::Idle),
+        };
+        assert_eq!(priority.to_string(), "nice 5, IO idle");
+        assert_eq!(Priority::default().to_string(), "");
+    }
+
+    #[test]
+    fn test_io_priority_value() {
+        assert_eq!(IoPriority::Idle.value(), 3 << 13);
+        assert_eq!(IoPriority::BestEffort(7).value(), (2 << 13) | 7);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_set_priority() {
+        let priority = Priority {
+            nice: Some(5),
+            io: Some(IoPriority::Idle),
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "nice; ionice 2>/dev/null || true"]);
+        priority.set(&mut cmd);
+        let output = cmd.output().unwrap();
+        let stdout = String::from_utf8_lossy(&output.stdout);
+        let mut lines = stdout.lines();
+        // nice prints the niceness it runs at, which includes the adjustment
+        assert!(lines.next().unwrap().trim().parse::<i32>().unwrap() >= 5);
+        // ionice, where installed, reports the IO class
+        if let Some(class) = lines.next() {
+            assert_eq!(class.trim(), "idle");
+        }
+    }
+}
@@ -0,0 +1,154 @@
+use std::collections::HashMap;
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::priority::{self, IoPriority, Priority};
+use crate::request::ExecutionContext;
+use crate::rlimits::Rlimits;
+use crate::template::expand;
+
+/// Execution environment applied to every command a tool runs
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Profile {
+    /// Environment variables set before the request's own `env`, e.g., PATH, GOFLAGS or CC.
+    /// Values may use the placeholders supported by `template::expand`.
+    pub env: Vec<(String, String)>,
+    /// Scheduling priority adjustment, as with `nice -n`
+    pub nice: Option<i32>,
+    /// IO scheduling class and level
+    pub ionice: Option<IoPriority>,
+    /// Resource limits, applied by the executor on top of the global ones
+    pub ulimits: Rlimits,
+}
+
+/// Profiles by tool, loaded at startup. TOOL_PROFILES maps tools to profile names, e.g., "presubmit=build;git=strict",
+/// and each profile is configured with PROFILE_<NAME>_ENV, PROFILE_<NAME>_NICE, PROFILE_<NAME>_IONICE and
+/// PROFILE_<NAME>_ULIMITS.
+/// Tools without a profile get only the variables every command inherits (see `executor::sanitize_env`).
+static PROFILES: LazyLock<HashMap<String, Profile>> = LazyLock::new(|| {
+    load_profiles(&std::env::var("TOOL_PROFILES").unwrap_or_default(), |var| std::env::var(var).ok())
+});
+
+/// The profile configured for a tool, if any
+pub fn for_tool(tool: &str) -> Option<&'static Profile> {
+    PROFILES.get(tool)
+}
+
+/// Internal implementation for testability - takes the tool mapping and a variable lookup as parameters.
+fn load_profiles(mapping: &str, var: impl Fn(&str) -> Option<String>) -> HashMap<String, Profile> {
+    mapping
+        .split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .map(|(tool, name)| {
+            let prefix = format!("PROFILE_{}", name.trim().to_uppercase());
+            let profile = Profile {
+                env: parse_env(&var(&format!("{}_ENV", prefix)).unwrap_or_default()),
+                nice: var(&format!("{}_NICE", prefix)).and_then(|s| s.trim().parse().ok()),
+                ionice: var(&format!("{}_IONICE", prefix)).and_then(|s| priority::parse_io(&s)),
+                ulimits: Rlimits::parse(&var(&format!("{}_ULIMITS", prefix)).unwrap_or_default()),
+            };
+            (tool.trim().to_string(), profile)
+        })
+        .collect()
+}
+
+/// Parse semicolon-separated "KEY=VALUE" pairs, e.g., "GOFLAGS=-mod=mod;CC=clang"
+fn parse_env(spec: &str) -> Vec<(String, String)> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .map(|(key, value)| (key.trim().to_string(), value.to_string()))
+        .filter(|(key, _)| !key.is_empty())
+        .collect()
+}
+
+impl Profile {
+    /// Apply the profile's environment to a command. The executor sets its priority and resource limits.
+    pub fn apply(&self, mut cmd: Command, ctx: &ExecutionContext) -> Command {
+        for (key, value) in &self.env {
+            cmd.env(key, expand(value, ctx));
+        }
+        cmd
+    }
+
+    /// The profile's scheduling priority
+    pub fn priority(&self) -> Priority {
+        Priority {
+            nice: self.nice,
+            io: self.ionice,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_load_profiles() {
+        let vars: HashMap<&str, &str> = [
+            ("PROFILE_BUILD_ENV", "GOFLAGS=-mod=mod;CC=clang"),
+            ("PROFILE_STRICT_NICE", "10"),
+            ("PROFILE_STRICT_IONICE", "idle"),
+            ("PROFILE_STRICT_ULIMITS", "cpu=60;memory=1048576;bogus=1"),
+        ]
+        .into_iter()
+        .collect();
+        let profiles = load_profiles("presubmit=build; git = strict", |var| vars.get(var).map(|s| s.to_string()));
+        assert_eq!(
+            profiles["presubmit"],
+            Profile {
+                env: vec![
+                    ("GOFLAGS".to_string(), "-mod=mod".to_string()),
+                    ("CC".to_string(), "clang".to_string())
+                ],
+                nice: None,
+                ionice: None,
+                ulimits: Rlimits::default(),
+            }
+        );
+        assert_eq!(profiles["git"].nice, Some(10));
+        assert_eq!(profiles["git"].ionice, Some(IoPriority::Idle));
+        assert_eq!(
+            profiles["git"].ulimits,
+            Rlimits {
+                cpu: Some(60),
+                memory: Some(1048576),
+                ..Default::default()
+            }
+        );
+        assert!(!profiles.contains_key("ls_tool"));
+    }
+
+    #[test]
+    fn test_load_profiles_empty() {
+        assert!(load_profiles("", |_| None).is_empty());
+    }
+
+    #[test]
+    fn test_apply_expands_placeholders() {
+        let profile = Profile {
+            env: vec![("GOCACHE".to_string(), "${WORKSPACE}/.cache".to_string())],
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo $GOCACHE"]);
+        let ctx = ExecutionContext {
+            working_dir: Some("/src/app".to_string()),
+            ..Default::default()
+        };
+        let output = profile.apply(cmd, &ctx).output().unwrap();
+        assert_eq!(String::from_utf8_lossy(&output.stdout), "/src/app/.cache\n");
+    }
+
+    #[test]
+    fn test_apply_sets_env() {
+        let profile = Profile {
+            env: vec![("PROFILE_TEST_VAR".to_string(), "from-profile".to_string())],
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo $PROFILE_TEST_VAR"]);
+        let output = profile.apply(cmd, &ExecutionContext::default()).output().unwrap();
+        assert_eq!(String::from_utf8_lossy(&output.stdout), "from-profile\n");
+    }
+}
@@ -0,0 +1,66 @@
+use std::future::Future;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+
+/// Sends a tool call's progress to the client that made it
+pub struct Reporter {
+    send: Box<dyn Fn(f64, String) + Send + Sync>,
+    /// Notifications sent so far; progress values must increase, so each one counts up
+    sent: AtomicU64,
+}
+
+impl Reporter {
+    /// A reporter passing each notification's progress value and message to `send`
+    pub fn new(send: impl Fn(f64, String) + Send + Sync + 'static) -> Self {
+        Reporter {
+            send: Box::new(send),
+            sent: AtomicU64::new(0),
+        }
+    }
+}
+
+tokio::task_local! {
+    /// Reporter of the tool call running in this task, if its client asked for progress
+    static REPORTER: Option<Arc<Reporter>>;
+}
+
+/// Run a tool call with its progress sent through the reporter. Tools run synchronously within the
+/// call's task, so `report` reaches the reporter from anywhere in the call except threads it spawns.
+pub async fn scope<F: Future>(reporter: Option<Reporter>, call: F) -> F::Output {
+    REPORTER.scope(reporter.map(Arc::new), call).await
+}
+
+/// Tell the client of the tool call running here what it is doing, e.g., why it is waiting.
+/// Does nothing if the client didn't ask for progress, or outside a tool call.
+pub fn report(message: &str) {
+    let _ = REPORTER.try_with(|reporter| {
+        if let Some(reporter) = reporter {
+            let progress = reporter.sent.fetch_add(1, Ordering::Relaxed) + 1;
+            (reporter.send)(progress as f64, message.to_string());
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    #[tokio::test]
+    async fn test_report_reaches_the_calls_reporter() {
+        let sent = Arc::new(Mutex::new(Vec::new()));
+        let reporter = {
+            let sent = sent.clone();
+            Reporter::new(move |progress, message| sent.lock().unwrap().push((progress, message)))
+        };
+        scope(Some(reporter), async {
+            report("queued");
+            report("running");
+        })
+        .await;
+        // Outside the call, and in calls that didn't ask for progress, nothing is sent
+        report("done");
+        scope(None, async { report("queued") }).await;
+        assert_eq!(*sent.lock().unwrap(), [(1.0, "queued".to_string()), (2.0, "running".to_string())]);
+    }
+}
@@ -1,10 +1,40 @@
+use base64::Engine;
 use regex::Regex;
 use rmcp::schemars::{self, JsonSchema};
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
+use std::sync::{Arc, LazyLock};
 use std::time::Duration;
 
-use crate::security::{validate_absolute_path, validate_argument, validate_env_var, validate_no_traversal, validate_path, Validatable, ValidationError};
+use crate::executor::CommandExecutor;
+use crate::jobs::Job;
+use crate::labels::{validate_label, Labels};
+use crate::overlay::Overlay;
+use crate::profile::Profile;
+use crate::sampler::SAMPLE_INTERVAL;
+use crate::severity::annotate;
+use crate::security::{
+    validate_absolute_path, validate_argument, validate_env_allowed, validate_env_var, validate_no_traversal, validate_path,
+    Validatable, ValidationError,
+};
+
+/// Timeout used when a request doesn't set timeout_ms, loaded from DEFAULT_TIMEOUT_MS environment
+/// variable at startup. Defaults to 180000 (3 minutes).
+static DEFAULT_TIMEOUT_MS: LazyLock<u64> = LazyLock::new(|| {
+    std::env::var("DEFAULT_TIMEOUT_MS")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(180_000)
+});
+
+/// Most bytes a call may pipe to its commands' standard input, loaded from MAX_STDIN_BYTES environment
+/// variable at startup (default: 1048576, 1 MiB)
+static MAX_STDIN_BYTES: LazyLock<usize> = LazyLock::new(|| {
+    std::env::var("MAX_STDIN_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(1024 * 1024)
+});
 
 /// Execution context extracted from ToolRequest for command execution
 #[derive(Debug, Clone, Default)]
@@ -12,10 +42,136 @@ pub struct ExecutionContext {
     pub timeout: Option<Duration>,
     pub working_dir: Option<String>,
     pub env: Option<HashMap<String, String>>,
+    /// Execution profile of the tool being run
+    pub profile: Option<&'static Profile>,
+    /// Data piped to the command's standard input; None gives it an empty stdin
+    pub stdin: Option<Vec<u8>>,
+    /// How binary output is returned
+    pub binary_output: BinaryOutput,
+    /// Leave ANSI escape sequences in text output; they are stripped by default
+    pub keep_ansi: bool,
+    /// Keep only the output lines matching this, before the output limits count them
+    pub filter: Option<Regex>,
+    /// Stop each command once its output has shown the first block of errors
+    pub fail_fast: bool,
+    /// Runs commands under a pseudo-terminal of this size; None runs them with pipes
+    pub pty: Option<PtySize>,
+    /// Lines read from each output stream before the command is stopped, capped by the server's limit
+    pub max_output_lines: Option<usize>,
+    /// Runs the commands; None runs them locally
+    pub executor: Option<Arc<dyn CommandExecutor>>,
+    /// Runs failed commands again; None runs each once
+    pub retry: Option<RetryPolicy>,
+    /// Time between resource samples of each command; None takes none
+    pub sample_interval: Option<Duration>,
+    /// Describe the commands instead of running them
+    pub dry_run: bool,
+    /// Background job the call runs in, which gets the commands' output as it is read
+    pub job: Option<Arc<Job>>,
+    /// Name of the tool being run, for listing its running commands
+    pub tool: Option<String>,
+    /// How long the call and each command wait for a slot under the server's limits on parallel calls
+    /// and commands; None waits the server's default
+    pub queue_timeout: Option<Duration>,
+    /// Copy-on-write view of the workspace the commands run on, so they never change the real one
+    pub overlay: Option<Arc<Overlay>>,
+}
+
+/// How output that isn't text is returned
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BinaryOutput {
+    /// A "[binary output, N bytes]" line
+    #[default]
+    Summary,
+    /// A hex dump of the first bytes
+    Hexdump,
+    /// The whole output, base64-encoded
+    Base64,
+}
+
+/// Attempts a retry policy allows when it doesn't set max_attempts, and the most it can set
+const DEFAULT_RETRY_ATTEMPTS: u32 = 3;
+const MAX_RETRY_ATTEMPTS: u32 = 5;
+
+/// Delay before the first retry when a policy doesn't set backoff_ms, and the longest it can set
+const DEFAULT_RETRY_BACKOFF_MS: u64 = 1000;
+const MAX_RETRY_BACKOFF_MS: u64 = 60_000;
+
+/// Failure categories retried when a policy doesn't list any: the transient infrastructure ones
+const DEFAULT_RETRY_CATEGORIES: &[&str] = &["flaky-infra", "cache-miss-timeout"];
+
+/// When and how often a failed command is run again
+#[derive(Debug, Clone, Default, PartialEq, Eq, Deserialize, Serialize, JsonSchema)]
+pub struct RetryPolicy {
+    /// Total attempts, including the first (default: 3, maximum: 5)
+    #[serde(default)]
+    pub max_attempts: Option<u32>,
+
+    /// Delay before the first retry in milliseconds, doubled before each later one (default: 1000, maximum: 60000)
+    #[serde(default)]
+    pub backoff_ms: Option<u64>,
+
+    /// Failure categories to retry (default: ["flaky-infra", "cache-miss-timeout"]), e.g., add "timeout"
+    #[serde(default)]
+    pub categories: Option<Vec<String>>,
+
+    /// Exit codes to retry whatever their category, e.g., [75] for a wrapper that reports transient failures
+    #[serde(default)]
+    pub exit_codes: Vec<i32>,
+}
+
+impl RetryPolicy {
+    /// Total attempts allowed
+    pub fn attempts(&self) -> u32 {
+        self.max_attempts.unwrap_or(DEFAULT_RETRY_ATTEMPTS).clamp(1, MAX_RETRY_ATTEMPTS)
+    }
+
+    /// Delay after the given failed attempt, counting from 1
+    pub fn backoff(&self, attempt: u32) -> Duration {
+        let base = self.backoff_ms.unwrap_or(DEFAULT_RETRY_BACKOFF_MS).min(MAX_RETRY_BACKOFF_MS);
+        Duration::from_millis(base.saturating_mul(1 << attempt.saturating_sub(1).min(MAX_RETRY_ATTEMPTS)))
+    }
+
+    /// Failure categories to retry
+    pub fn categories(&self) -> Vec<String> {
+        match self.categories {
+            Some(ref categories) => categories.clone(),
+            None => DEFAULT_RETRY_CATEGORIES.iter().map(|c| c.to_string()).collect(),
+        }
+    }
+}
+
+/// Size of a pseudo-terminal when a request doesn't set it
+const DEFAULT_PTY_COLS: u16 = 120;
+const DEFAULT_PTY_ROWS: u16 = 40;
+
+/// Size of the pseudo-terminal commands run under
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, JsonSchema)]
+pub struct PtySize {
+    /// Width in characters (default: 120)
+    #[serde(default)]
+    pub cols: Option<u16>,
+
+    /// Height in lines (default: 40)
+    #[serde(default)]
+    pub rows: Option<u16>,
+}
+
+impl PtySize {
+    /// Width in characters
+    pub fn cols(&self) -> u16 {
+        self.cols.filter(|&cols| cols > 0).unwrap_or(DEFAULT_PTY_COLS)
+    }
+
+    /// Height in lines
+    pub fn rows(&self) -> u16 {
+        self.rows.filter(|&rows| rows > 0).unwrap_or(DEFAULT_PTY_ROWS)
+    }
 }
 
 /// Available transformation operations
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Deserialize, JsonSchema)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Deserialize, Serialize, JsonSchema)]
 #[serde(rename_all = "snake_case")]
 pub enum Transformation {
     Grep,
@@ -36,7 +192,7 @@ const DEFAULT_TRANSFORM_ORDER: &[Transformation] = &[
 
 /// A wrapper that adds common fields to any tool request.
 /// Use `#[serde(flatten)]` on the inner field to merge schemas.
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Deserialize, Serialize, JsonSchema)]
 pub struct ToolRequest<T> {
     /// Optional regex pattern to filter output lines (keeps matching lines)
     #[serde(default)]
@@ -62,7 +218,7 @@ pub struct ToolRequest<T> {
     #[serde(default)]
     pub unique: Option<bool>,
 
-    /// Timeout in milliseconds for command execution (default: 180000 = 3 minutes)
+    /// Timeout in milliseconds for command execution (default: 180000 = 3 minutes, unless the server sets another default)
     #[serde(default)]
     pub timeout_ms: Option<u64>,
 
@@ -74,11 +230,104 @@ pub struct ToolRequest<T> {
     #[serde(default)]
     pub env: Option<HashMap<String, String>>,
 
+    /// Text to pipe to the command's standard input
+    #[serde(default)]
+    pub stdin: Option<String>,
+
+    /// Base64-encoded data to pipe to the command's standard input, for binary input. Cannot be combined with stdin.
+    #[serde(default)]
+    pub stdin_base64: Option<String>,
+
+    /// How to return output that isn't text: "summary" (default) gives its size, "hexdump" a hex dump
+    /// of its start, "base64" all of it base64-encoded
+    #[serde(default)]
+    pub binary_output: Option<BinaryOutput>,
+
+    /// Remove ANSI escape sequences such as colors and progress-line redraws from the output before it
+    /// is capped or filtered (default: true). Set false to see the raw bytes the command wrote.
+    #[serde(default)]
+    pub strip_ansi: Option<bool>,
+
+    /// Regex the command's output lines must match to be kept at all, applied while the output is read, e.g.,
+    /// "ERROR|FAIL" for a bazel build. Unlike grep_pattern, dropped lines never count toward max_output_lines
+    /// or the output caps, so a match after thousands of progress lines isn't cut off.
+    #[serde(default)]
+    pub filter: Option<String>,
+
+    /// Stop a command as soon as its output shows the first error, e.g., for a bazel build or go test whose
+    /// first failure is all you need. The output ends with that error's block of lines, and the command and
+    /// its descendants are killed, so the call fails without waiting for the rest of the build.
+    #[serde(default)]
+    pub fail_fast: Option<bool>,
+
+    /// Run commands under a pseudo-terminal, e.g., {} or {"cols": 200, "rows": 50}, for programs that change
+    /// behavior or refuse to run without a TTY. stdout and stderr are merged, and stdin is typed into the terminal.
+    #[serde(default)]
+    pub pty: Option<PtySize>,
+
+    /// Stop the command once stdout or stderr passes this many lines, e.g., 1000 for a command that
+    /// may print a whole log. Cannot raise the server's limit (default: 100000).
+    #[serde(default)]
+    pub max_output_lines: Option<usize>,
+
+    /// Run each command again when it fails transiently, e.g., {"max_attempts": 3} retries infrastructure
+    /// failures such as connection resets twice, with backoff. Earlier attempts are noted at the end of the result.
+    #[serde(default)]
+    pub retry: Option<RetryPolicy>,
+
+    /// End the result with a "Usage:" line giving the commands run, their wall-clock and CPU time, and
+    /// peak memory, e.g., to tell a slow build from a slow test. When the same tool has run with the same
+    /// parameters and working_dir before, an "Estimated duration:" line follows with the median of those runs.
+    #[serde(default)]
+    pub report_usage: Option<bool>,
+
+    /// End the result with a timeline of each command's CPU use and memory, sampled every few seconds
+    /// while it runs, with a sparkline of each, e.g., to see whether a build is CPU-bound or swapping
+    #[serde(default)]
+    pub resource_timeline: Option<bool>,
+
+    /// Prefix error and warning lines with "[error] " or "[warning] " before any other transformation,
+    /// e.g., combine with grep_pattern "^\\[error\\]" to keep only errors
+    #[serde(default)]
+    pub annotate_severity: Option<bool>,
+
+    /// Don't run anything; return each command the call would run instead, with its working directory,
+    /// environment and the limits and policies applied to it, e.g., to check what a presubmit would do
+    #[serde(default)]
+    pub dry_run: Option<bool>,
+
+    /// Run the commands on a copy-on-write overlay of working_dir, e.g., to let a formatter or code
+    /// generator run without touching the checkout. They see a writable tree, but their changes go to a
+    /// separate layer; the result names the overlay, and overlay_diff shows what changed.
+    #[serde(default)]
+    pub overlay: Option<bool>,
+
+    /// How long the call and each of its commands may wait for a slot when the server limits how many
+    /// run at once, e.g., 0 to fail at once rather than queue behind other builds (default: the server's queue time)
+    #[serde(default)]
+    pub queue_timeout_ms: Option<u64>,
+
+    /// Labels attributing this call, e.g., {"task": "T-43"}, added to the session's labels or replacing them.
+    /// They are recorded with the call in the transcript, audit records and execution events.
+    #[serde(default)]
+    pub labels: Option<Labels>,
+
     /// Order to apply transformations. Default: ["grep", "sort", "unique", "head", "tail"]
     /// Only listed transformations will be applied.
     #[serde(default)]
     pub transform_order: Option<Vec<Transformation>>,
 
+    /// Return output a page of N lines at a time, after all other transformations. When more lines
+    /// follow, the result ends with a "nextPageToken: <token>" line, and its structured content holds the
+    /// token as nextPageToken; pass the token as page_token to get the next page.
+    #[serde(default)]
+    pub page_size: Option<usize>,
+
+    /// Token of the next page, from a previous result. The page is read from the output of the call that
+    /// was paged; nothing is run again, and the call's other parameters are ignored.
+    #[serde(default)]
+    pub page_token: Option<String>,
+
     #[serde(flatten)]
     pub inner: T,
 }
@@ -99,27 +348,87 @@ impl<T: Validatable> Validatable for ToolRequest<T> {
         // Validate environment variables if provided
         // - checks for dangerous env vars (LD_PRELOAD, PATH, etc.)
         // - checks for shell injection in both key and value
+        // - checks the server's ENV_ALLOWLIST, if configured
         if let Some(ref env) = self.env {
             for (key, value) in env {
                 validate_env_var(key, value)?;
+                validate_env_allowed(key)?;
             }
         }
 
+        if let Some(ref labels) = self.labels {
+            for (name, value) in labels {
+                validate_label(name, value)?;
+            }
+        }
+
+        if let Some(ref pattern) = self.filter {
+            if let Err(e) = Regex::new(pattern) {
+                return Err(ValidationError::InvalidFilter(e.to_string()));
+            }
+        }
+
+        // Validate stdin: text or base64, not both
+        if self.stdin.is_some() && self.stdin_base64.is_some() {
+            return Err(ValidationError::InvalidStdin(
+                "stdin and stdin_base64 cannot both be set".to_string(),
+            ));
+        }
+        let size = match (&self.stdin, &self.stdin_base64) {
+            (Some(text), _) => text.len(),
+            (None, Some(data)) => match base64::engine::general_purpose::STANDARD.decode(data) {
+                Ok(bytes) => bytes.len(),
+                Err(e) => {
+                    return Err(ValidationError::InvalidStdin(format!("stdin_base64 is not valid base64: {}", e)));
+                }
+            },
+            (None, None) => 0,
+        };
+        if size > *MAX_STDIN_BYTES {
+            return Err(ValidationError::InvalidStdin(format!(
+                "{} bytes is more than the {} bytes the server accepts (MAX_STDIN_BYTES)",
+                size, *MAX_STDIN_BYTES
+            )));
+        }
+
         Ok(())
     }
 }
 
 impl<T> ToolRequest<T> {
-    /// Default timeout in milliseconds (180 seconds)
-    const DEFAULT_TIMEOUT_MS: u64 = 180_000;
-
     /// Extract execution context for command execution
     pub fn execution_context(&self) -> ExecutionContext {
-        let timeout_ms = self.timeout_ms.unwrap_or(Self::DEFAULT_TIMEOUT_MS);
+        let timeout_ms = self.timeout_ms.unwrap_or(*DEFAULT_TIMEOUT_MS);
         ExecutionContext {
             timeout: Some(Duration::from_millis(timeout_ms)),
             working_dir: self.working_dir.clone(),
             env: self.env.clone(),
+            profile: None,
+            stdin: self.stdin_bytes(),
+            binary_output: self.binary_output.unwrap_or_default(),
+            keep_ansi: !self.strip_ansi.unwrap_or(true),
+            // Invalid patterns are rejected by validation
+            filter: self.filter.as_deref().and_then(|pattern| Regex::new(pattern).ok()),
+            fail_fast: self.fail_fast.unwrap_or(false),
+            pty: self.pty,
+            max_output_lines: self.max_output_lines,
+            executor: None,
+            retry: self.retry.clone(),
+            sample_interval: self.resource_timeline.unwrap_or(false).then(|| *SAMPLE_INTERVAL),
+            dry_run: self.dry_run.unwrap_or(false),
+            job: None,
+            tool: None,
+            queue_timeout: self.queue_timeout_ms.map(Duration::from_millis),
+            overlay: None,
+        }
+    }
+
+    /// The stdin payload as bytes. Undecodable base64 is rejected by validation, so it maps to None here.
+    fn stdin_bytes(&self) -> Option<Vec<u8>> {
+        match (&self.stdin, &self.stdin_base64) {
+            (Some(text), _) => Some(text.clone().into_bytes()),
+            (None, Some(data)) => base64::engine::general_purpose::STANDARD.decode(data).ok(),
+            (None, None) => None,
         }
     }
 
@@ -131,7 +440,11 @@ impl<T> ToolRequest<T> {
             .as_deref()
             .unwrap_or(DEFAULT_TRANSFORM_ORDER);
 
-        let mut result = output;
+        let mut result = if self.annotate_severity.unwrap_or(false) {
+            annotate(&output)
+        } else {
+            output
+        };
 
         for transform in order {
             result = match transform {
@@ -258,9 +571,28 @@ mod tests {
             timeout_ms: None,
             working_dir: None,
             env: None,
+            stdin: None,
+            stdin_base64: None,
+            binary_output: None,
+            strip_ansi: None,
+            filter: None,
+            fail_fast: None,
+            pty: None,
+            max_output_lines: None,
+            report_usage: None,
+            resource_timeline: None,
+            retry: None,
+            annotate_severity: None,
+            dry_run: None,
+            overlay: None,
+            queue_timeout_ms: None,
+            labels: None,
             transform_order,
+            page_size: None,
+            page_token: None,
             inner: LsRequest {
                 path: ".".to_string(),
+                respect_ignore: false,
             },
         }
     }
@@ -356,6 +688,14 @@ mod tests {
     }
 
     // Deserialization tests
+    #[test]
+    fn test_annotate_severity_before_grep() {
+        let mut req = make_request(Some(r"^\[error\]"), None, None, None, None, None);
+        req.annotate_severity = Some(true);
+        let output = "Compiling app\nwarning: unused import\nerror[E0308]: mismatched types\n".to_string();
+        assert_eq!(req.transform_output(output), "[error] error[E0308]: mismatched types");
+    }
+
     #[test]
     fn test_deserialize_with_all_fields() {
         let json = r#"{
@@ -538,4 +878,79 @@ mod tests {
             Err(ValidationError::RelativeWorkingDir(_))
         ));
     }
+
+    // Stdin tests
+    #[test]
+    fn test_stdin_text_and_base64() {
+        let mut req = make_request(None, None, None, None, None, None);
+        assert_eq!(req.execution_context().stdin, None);
+        req.stdin = Some("{\"a\": 1}".to_string());
+        assert_eq!(req.execution_context().stdin.as_deref(), Some(&b"{\"a\": 1}"[..]));
+        req.stdin = None;
+        req.stdin_base64 = Some("AP8K".to_string());
+        assert!(req.validate().is_ok());
+        assert_eq!(req.execution_context().stdin, Some(vec![0x00, 0xff, 0x0a]));
+    }
+
+    #[test]
+    fn test_stdin_validation() {
+        let mut req = make_request(None, None, None, None, None, None);
+        req.stdin_base64 = Some("not base64!".to_string());
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidStdin(_))));
+        req.stdin = Some("text".to_string());
+        req.stdin_base64 = Some("AP8K".to_string());
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidStdin(_))));
+        req.stdin_base64 = None;
+        req.stdin = Some("x".repeat(*MAX_STDIN_BYTES + 1));
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidStdin(_))));
+        req.stdin = Some("x".repeat(*MAX_STDIN_BYTES));
+        assert!(req.validate().is_ok());
+    }
+
+    #[test]
+    fn test_filter_validation() {
+        let mut req = make_request(None, None, None, None, None, None);
+        req.filter = Some("ERROR|FAIL".to_string());
+        assert!(req.validate().is_ok());
+        assert!(req.execution_context().filter.unwrap().is_match("FAIL: //a:test"));
+        req.filter = Some("(unclosed".to_string());
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidFilter(_))));
+    }
+
+    // Pagination tests
+    #[test]
+    fn test_retry_policy_defaults_and_limits() {
+        let policy = RetryPolicy::default();
+        assert_eq!(policy.attempts(), 3);
+        assert_eq!(policy.backoff(1), Duration::from_secs(1));
+        assert_eq!(policy.backoff(3), Duration::from_secs(4));
+        assert_eq!(policy.categories(), vec!["flaky-infra", "cache-miss-timeout"]);
+        let policy = RetryPolicy {
+            max_attempts: Some(100),
+            backoff_ms: Some(10_000_000),
+            ..Default::default()
+        };
+        assert_eq!(policy.attempts(), 5);
+        assert_eq!(policy.backoff(1), Duration::from_secs(60));
+    }
+
+    #[test]
+    fn test_serialize_round_trip() {
+        let json = r#"{
+            "path": "src",
+            "grep_pattern": "\\.rs$",
+            "working_dir": "/tmp",
+            "env": {"FOO": "bar"},
+            "transform_order": ["sort", "grep"]
+        }"#;
+        let req: ToolRequest<LsRequest> = serde_json::from_str(json).unwrap();
+        let value = serde_json::to_value(&req).unwrap();
+        assert_eq!(value["path"], "src");
+        let again: ToolRequest<LsRequest> = serde_json::from_value(value).unwrap();
+        assert_eq!(again.inner.path, "src");
+        assert_eq!(again.grep_pattern.as_deref(), Some("\\.rs$"));
+        assert_eq!(again.working_dir.as_deref(), Some("/tmp"));
+        assert_eq!(again.env.unwrap()["FOO"], "bar");
+        assert_eq!(again.transform_order.unwrap(), vec![Transformation::Sort, Transformation::Grep]);
+    }
 }
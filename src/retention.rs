@@ -0,0 +1,345 @@
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::{LazyLock, Mutex};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::client_log;
+use crate::cron::Schedule;
+use crate::scratch::scratch_dir;
+use crate::tools::host_info::format_bytes;
+
+/// When the background cleanup enforces the retention limits if CLEANUP_SCHEDULE isn't set: every 10 minutes
+const DEFAULT_CLEANUP_SCHEDULE: &str = "*/10 * * * *";
+
+/// Items used more recently than this are never removed, so files being written survive cleanup
+const IN_USE_GRACE: Duration = Duration::from_secs(60);
+
+/// Retention limits for the scratch area
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Retention {
+    /// Total size above which the least recently used items are removed
+    pub max_bytes: Option<u64>,
+    /// Age after which unused items are removed
+    pub max_age: Option<Duration>,
+}
+
+/// Retention limits loaded from SCRATCH_MAX_BYTES and SCRATCH_MAX_AGE_SECS environment variables at startup.
+/// Both are unset by default, which keeps everything.
+static RETENTION: LazyLock<Retention> = LazyLock::new(|| {
+    let parse = |var: &str| std::env::var(var).ok().and_then(|s| s.trim().parse::<u64>().ok());
+    Retention {
+        max_bytes: parse("SCRATCH_MAX_BYTES"),
+        max_age: parse("SCRATCH_MAX_AGE_SECS").map(Duration::from_secs),
+    }
+});
+
+/// Hard limit on the total size of the scratch area, loaded from SCRATCH_QUOTA_BYTES environment variable
+/// at startup. Unlike SCRATCH_MAX_BYTES, nothing is removed to stay under it; new files are refused instead.
+static QUOTA: LazyLock<Option<u64>> =
+    LazyLock::new(|| std::env::var("SCRATCH_QUOTA_BYTES").ok().and_then(|s| s.trim().parse().ok()));
+
+/// The configured scratch quota in bytes, if any
+pub fn quota() -> Option<u64> {
+    *QUOTA
+}
+
+/// Total bytes currently used by the scratch area
+pub fn scratch_usage() -> u64 {
+    scratch_usage_in(scratch_dir())
+}
+
+/// Internal implementation for testability - takes the scratch root as parameter.
+pub fn scratch_usage_in(root: &Path) -> u64 {
+    usage(root).0
+}
+
+/// What a cleanup removed
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct CleanupReport {
+    pub removed: usize,
+    pub freed: u64,
+}
+
+/// What the background cleanup has done since the server started
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct CleanupStats {
+    /// Its cron schedule; None if it isn't running because no retention limits are configured
+    pub schedule: Option<String>,
+    pub runs: u64,
+    pub removed: u64,
+    pub freed: u64,
+    /// When it last ran and will next run, in seconds since the Unix epoch
+    pub last_run: Option<u64>,
+    pub next_run: Option<u64>,
+}
+
+static STATS: Mutex<CleanupStats> = Mutex::new(CleanupStats {
+    schedule: None,
+    runs: 0,
+    removed: 0,
+    freed: 0,
+    last_run: None,
+    next_run: None,
+});
+
+/// What the background cleanup has done since the server started
+pub fn cleanup_stats() -> CleanupStats {
+    STATS.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// A unit of retention: an entry directly under a scratch subdirectory, e.g., downloads/<sha256>
+#[derive(Debug)]
+struct Item {
+    path: PathBuf,
+    bytes: u64,
+    last_used: SystemTime,
+}
+
+/// Enforce the configured retention limits on the scratch area
+pub fn enforce_retention() -> CleanupReport {
+    enforce_retention_in(scratch_dir(), &RETENTION, SystemTime::now())
+}
+
+/// Internal implementation for testability - takes the scratch root, limits and current time as parameters.
+fn enforce_retention_in(root: &Path, retention: &Retention, now: SystemTime) -> CleanupReport {
+    let mut items = items_in(root);
+    // Least recently used first
+    items.sort_by_key(|item| item.last_used);
+    let mut total: u64 = items.iter().map(|item| item.bytes).sum();
+    let mut report = CleanupReport::default();
+
+    for item in items {
+        let age = now.duration_since(item.last_used).unwrap_or_default();
+        if age < IN_USE_GRACE {
+            continue;
+        }
+        let expired = retention.max_age.is_some_and(|max_age| age > max_age);
+        let over_size = retention.max_bytes.is_some_and(|max_bytes| total > max_bytes);
+        if (expired || over_size) && remove(&item.path).is_ok() {
+            total -= item.bytes;
+            report.removed += 1;
+            report.freed += item.bytes;
+        }
+    }
+    report
+}
+
+/// Remove scratch items unused for longer than `older_than`, or every item if None
+pub fn purge(older_than: Option<Duration>) -> CleanupReport {
+    purge_in(scratch_dir(), older_than, SystemTime::now())
+}
+
+/// Internal implementation for testability - takes the scratch root and current time as parameters.
+fn purge_in(root: &Path, older_than: Option<Duration>, now: SystemTime) -> CleanupReport {
+    let mut report = CleanupReport::default();
+    for item in items_in(root) {
+        let age = now.duration_since(item.last_used).unwrap_or_default();
+        let selected = match older_than {
+            Some(older_than) => age > older_than,
+            None => true,
+        };
+        if selected && remove(&item.path).is_ok() {
+            report.removed += 1;
+            report.freed += item.bytes;
+        }
+    }
+    report
+}
+
+/// Enforce the retention limits on a background thread, if any are configured, at the times the cron
+/// expression in the CLEANUP_SCHEDULE environment variable gives, in UTC (default: every 10 minutes).
+/// Returns an error if the expression is invalid.
+pub fn start_cleanup() -> Result<(), String> {
+    if *RETENTION == Retention::default() {
+        return Ok(());
+    }
+    let expression = std::env::var("CLEANUP_SCHEDULE").unwrap_or_else(|_| DEFAULT_CLEANUP_SCHEDULE.to_string());
+    let schedule =
+        Schedule::parse(&expression).map_err(|e| format!("Invalid CLEANUP_SCHEDULE {:?}: {}", expression, e))?;
+    STATS.lock().unwrap_or_else(|e| e.into_inner()).schedule = Some(expression);
+    std::thread::spawn(move || loop {
+        let now = SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs());
+        let Some(next) = schedule.next_after(now) else {
+            tracing::warn!("CLEANUP_SCHEDULE never matches; scheduled cleanup stopped");
+            return;
+        };
+        STATS.lock().unwrap_or_else(|e| e.into_inner()).next_run = Some(next);
+        std::thread::sleep(Duration::from_secs(next - now));
+        let report = enforce_retention();
+        record_run(&mut STATS.lock().unwrap_or_else(|e| e.into_inner()), &report, next);
+        if report.removed > 0 {
+            let message = format!(
+                "Scheduled cleanup removed {} scratch items, freeing {}",
+                report.removed,
+                format_bytes(report.freed)
+            );
+            client_log::info("cleanup", &message);
+        }
+    });
+    Ok(())
+}
+
+/// Add a cleanup run at `time` to the stats
+fn record_run(stats: &mut CleanupStats, report: &CleanupReport, time: u64) {
+    stats.runs += 1;
+    stats.removed += report.removed as u64;
+    stats.freed += report.freed;
+    stats.last_run = Some(time);
+}
+
+/// Collect the retention items under each subdirectory of the scratch root
+fn items_in(root: &Path) -> Vec<Item> {
+    let mut items = Vec::new();
+    for area in read_dir_paths(root).into_iter().filter(|p| p.is_dir()) {
+        for path in read_dir_paths(&area) {
+            let (bytes, last_used) = usage(&path);
+            items.push(Item { path, bytes, last_used });
+        }
+    }
+    items
+}
+
+fn read_dir_paths(dir: &Path) -> Vec<PathBuf> {
+    match fs::read_dir(dir) {
+        Ok(entries) => entries.filter_map(|e| e.ok()).map(|e| e.path()).collect(),
+        Err(_) => Vec::new(),
+    }
+}
+
+/// Total size and latest modification time of a file or directory tree. Symlinks are not followed.
+fn usage(path: &Path) -> (u64, SystemTime) {
+    let metadata = match fs::symlink_metadata(path) {
+        Ok(metadata) => metadata,
+        Err(_) => return (0, SystemTime::UNIX_EPOCH),
+    };
+    let modified = metadata.modified().unwrap_or(SystemTime::UNIX_EPOCH);
+    if !metadata.is_dir() {
+        return (metadata.len(), modified);
+    }
+    read_dir_paths(path)
+        .iter()
+        .map(|child| usage(child))
+        .fold((0, modified), |(bytes, latest), (child_bytes, child_modified)| {
+            (bytes + child_bytes, latest.max(child_modified))
+        })
+}
+
+fn remove(path: &Path) -> std::io::Result<()> {
+    if fs::symlink_metadata(path)?.is_dir() {
+        fs::remove_dir_all(path)
+    } else {
+        fs::remove_file(path)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    const HOUR: Duration = Duration::from_secs(3600);
+
+    #[test]
+    fn test_record_run() {
+        let mut stats = CleanupStats::default();
+        record_run(&mut stats, &CleanupReport { removed: 2, freed: 300 }, 1_000);
+        record_run(&mut stats, &CleanupReport::default(), 1_600);
+        assert_eq!((stats.runs, stats.removed, stats.freed, stats.last_run), (2, 2, 300, Some(1_600)));
+    }
+
+    /// Create downloads/<name>/file with `bytes` bytes, last modified `age` before `now`
+    fn make_item(root: &Path, name: &str, bytes: usize, now: SystemTime, age: Duration) -> PathBuf {
+        let dir = root.join("downloads").join(name);
+        fs::create_dir_all(&dir).unwrap();
+        let file = dir.join("file");
+        fs::write(&file, vec![0u8; bytes]).unwrap();
+        let mtime = now - age;
+        fs::File::options().write(true).open(&file).unwrap().set_modified(mtime).unwrap();
+        fs::File::open(&dir).unwrap().set_modified(mtime).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_max_age_removes_expired_items() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        let old = make_item(temp_dir.path(), "old", 10, now, 48 * HOUR);
+        let fresh = make_item(temp_dir.path(), "fresh", 10, now, HOUR);
+        let retention = Retention {
+            max_bytes: None,
+            max_age: Some(24 * HOUR),
+        };
+        let report = enforce_retention_in(temp_dir.path(), &retention, now);
+        assert_eq!(report, CleanupReport { removed: 1, freed: 10 });
+        assert!(!old.exists());
+        assert!(fresh.exists());
+    }
+
+    #[test]
+    fn test_max_bytes_removes_least_recently_used() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        let oldest = make_item(temp_dir.path(), "a", 100, now, 3 * HOUR);
+        let middle = make_item(temp_dir.path(), "b", 100, now, 2 * HOUR);
+        let newest = make_item(temp_dir.path(), "c", 100, now, HOUR);
+        let retention = Retention {
+            max_bytes: Some(250),
+            max_age: None,
+        };
+        let report = enforce_retention_in(temp_dir.path(), &retention, now);
+        assert_eq!(report.removed, 1);
+        assert!(!oldest.exists());
+        assert!(middle.exists());
+        assert!(newest.exists());
+    }
+
+    #[test]
+    fn test_items_in_use_are_kept() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        let in_use = make_item(temp_dir.path(), "partial", 1000, now, Duration::from_secs(5));
+        let retention = Retention {
+            max_bytes: Some(0),
+            max_age: Some(Duration::ZERO),
+        };
+        assert_eq!(enforce_retention_in(temp_dir.path(), &retention, now).removed, 0);
+        assert!(in_use.exists());
+    }
+
+    #[test]
+    fn test_no_limits_keeps_everything() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        make_item(temp_dir.path(), "old", 10, now, 1000 * HOUR);
+        assert_eq!(
+            enforce_retention_in(temp_dir.path(), &Retention::default(), now),
+            CleanupReport::default()
+        );
+    }
+
+    #[test]
+    fn test_scratch_usage_in() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        assert_eq!(scratch_usage_in(temp_dir.path()), 0);
+        make_item(temp_dir.path(), "a", 10, now, HOUR);
+        make_item(temp_dir.path(), "b", 32, now, HOUR);
+        assert_eq!(scratch_usage_in(temp_dir.path()), 42);
+        assert_eq!(scratch_usage_in(&temp_dir.path().join("missing")), 0);
+    }
+
+    #[test]
+    fn test_purge() {
+        let temp_dir = TempDir::new().unwrap();
+        let now = SystemTime::now();
+        let old = make_item(temp_dir.path(), "old", 10, now, 48 * HOUR);
+        let fresh = make_item(temp_dir.path(), "fresh", 20, now, HOUR);
+        assert_eq!(
+            purge_in(temp_dir.path(), Some(24 * HOUR), now),
+            CleanupReport { removed: 1, freed: 10 }
+        );
+        assert!(!old.exists());
+        assert_eq!(purge_in(temp_dir.path(), None, now), CleanupReport { removed: 1, freed: 20 });
+        assert!(!fresh.exists());
+    }
+}
@@ -0,0 +1,183 @@
+use std::fmt;
+use std::process::Command;
+use std::sync::LazyLock;
+
+/// Resource limits for a command and its descendants. Every limit is set as both the soft and the hard
+/// limit, so the command can't raise it again.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Rlimits {
+    /// CPU time in seconds (RLIMIT_CPU)
+    pub cpu: Option<u64>,
+    /// Virtual memory in KiB (RLIMIT_AS)
+    pub memory: Option<u64>,
+    /// Open file descriptors (RLIMIT_NOFILE)
+    pub files: Option<u64>,
+    /// Largest file the command can write, in KiB (RLIMIT_FSIZE)
+    pub file_size: Option<u64>,
+    /// Processes the command's user can have (RLIMIT_NPROC)
+    pub processes: Option<u64>,
+}
+
+/// Limits for every command, loaded from COMMAND_ULIMITS at startup, e.g., "cpu=3600;memory=16777216".
+/// A tool's profile can tighten them but not loosen them.
+static GLOBAL: LazyLock<Rlimits> =
+    LazyLock::new(|| Rlimits::parse(&std::env::var("COMMAND_ULIMITS").unwrap_or_default()));
+
+/// The limits for a command: the global ones, tightened by its tool's profile
+pub fn for_command(profile: Option<&Rlimits>) -> Rlimits {
+    match profile {
+        Some(profile) => GLOBAL.tightest(profile),
+        None => *GLOBAL,
+    }
+}
+
+impl Rlimits {
+    /// Parse semicolon-separated "limit=value" pairs, e.g., "cpu=600;memory=8388608;processes=512".
+    /// The limits are cpu, memory, files, filesize and processes.
+    pub fn parse(spec: &str) -> Self {
+        let mut limits = Rlimits::default();
+        for (name, value) in spec.split(';').filter_map(|entry| entry.split_once('=')) {
+            let field = match name.trim() {
+                "cpu" => &mut limits.cpu,
+                "memory" => &mut limits.memory,
+                "files" => &mut limits.files,
+                "filesize" => &mut limits.file_size,
+                "processes" => &mut limits.processes,
+                _ => {
+                    tracing::warn!("Ignoring resource limit '{}={}'", name.trim(), value.trim());
+                    continue;
+                }
+            };
+            match value.trim().parse::<u64>() {
+                Ok(value) => *field = Some(value),
+                Err(_) => tracing::warn!("Ignoring resource limit '{}={}'", name.trim(), value.trim()),
+            }
+        }
+        limits
+    }
+
+    /// The lower of each limit
+    fn tightest(&self, other: &Rlimits) -> Rlimits {
+        let min = |a: Option<u64>, b: Option<u64>| match (a, b) {
+            (Some(a), Some(b)) => Some(a.min(b)),
+            _ => a.or(b),
+        };
+        Rlimits {
+            cpu: min(self.cpu, other.cpu),
+            memory: min(self.memory, other.memory),
+            files: min(self.files, other.files),
+            file_size: min(self.file_size, other.file_size),
+            processes: min(self.processes, other.processes),
+        }
+    }
+
+    /// Set the limits on a command when it is spawned. Limits above the server's own hard limits,
+    /// which only root could raise, are lowered to them.
+    #[cfg(unix)]
+    pub fn set(&self, cmd: &mut Command) {
+        use std::os::unix::process::CommandExt;
+
+        let kib = |value: u64| value.saturating_mul(1024);
+        let resources: Vec<_> = [
+            (libc::RLIMIT_CPU, self.cpu),
+            (libc::RLIMIT_AS, self.memory.map(kib)),
+            (libc::RLIMIT_NOFILE, self.files),
+            (libc::RLIMIT_FSIZE, self.file_size.map(kib)),
+            (libc::RLIMIT_NPROC, self.processes),
+        ]
+        .into_iter()
+        .filter_map(|(resource, value)| Some((resource, value? as libc::rlim_t)))
+        .collect();
+        if resources.is_empty() {
+            return;
+        }
+        // SAFETY: the hook only calls getrlimit and setrlimit, which are async-signal-safe, and doesn't allocate
+        unsafe {
+            cmd.pre_exec(move || {
+                for &(resource, value) in &resources {
+                    let mut limit = libc::rlimit {
+                        rlim_cur: 0,
+                        rlim_max: 0,
+                    };
+                    if libc::getrlimit(resource, &mut limit) != 0 {
+                        return Err(std::io::Error::last_os_error());
+                    }
+                    let value = value.min(limit.rlim_max);
+                    let limit = libc::rlimit {
+                        rlim_cur: value,
+                        rlim_max: value,
+                    };
+                    if libc::setrlimit(resource, &limit) != 0 {
+                        return Err(std::io::Error::last_os_error());
+                    }
+                }
+                Ok(())
+            });
+        }
+    }
+
+    #[cfg(not(unix))]
+    pub fn set(&self, _cmd: &mut Command) {}
+}
+
+impl fmt::Display for Rlimits {
+    /// The limits that are set, as parse takes them
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let limits: Vec<String> = [
+            ("cpu", self.cpu),
+            ("memory", self.memory),
+            ("files", self.files),
+            ("filesize", self.file_size),
+            ("processes", self.processes),
+        ]
+        .iter()
+        .filter_map(|(name, value)| value.map(|value| format!("{}={}", name, value)))
+        .collect();
+        write!(f, "{}", limits.join(";"))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse() {
+        assert_eq!(
+            Rlimits::parse("cpu=60; memory=1048576;filesize=2048;processes=256;files=64;bogus=1;cpu=x"),
+            Rlimits {
+                cpu: Some(60),
+                memory: Some(1048576),
+                files: Some(64),
+                file_size: Some(2048),
+                processes: Some(256),
+            }
+        );
+        assert_eq!(Rlimits::parse(""), Rlimits::default());
+    }
+
+    #[test]
+    fn test_display() {
+        let limits = Rlimits::parse("processes=256;cpu=60;filesize=2048");
+        assert_eq!(limits.to_string(), "cpu=60;filesize=2048;processes=256");
+        assert_eq!(Rlimits::parse(&limits.to_string()), limits);
+    }
+
+    #[test]
+    fn test_tightest() {
+        let global = Rlimits::parse("cpu=3600;memory=1048576");
+        let profile = Rlimits::parse("cpu=600;memory=4194304;files=64");
+        assert_eq!(global.tightest(&profile), Rlimits::parse("cpu=600;memory=1048576;files=64"));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_set_limits_command() {
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "ulimit -n; ulimit -f; ulimit -t"]);
+        Rlimits::parse("files=64;filesize=1024;cpu=30").set(&mut cmd);
+        let output = cmd.output().unwrap();
+        // sh reports file sizes in 512-byte blocks
+        assert_eq!(String::from_utf8_lossy(&output.stdout), "64\n2048\n30\n");
+    }
+}
@@ -0,0 +1,179 @@
+use std::process::Command;
+use std::sync::LazyLock;
+
+/// An account from the password database
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Account {
+    name: String,
+    uid: u32,
+    gid: u32,
+    home: String,
+}
+
+/// The user and group commands run as
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RunAs {
+    pub uid: u32,
+    pub gid: u32,
+    /// The user's account, when the password database has one; its home directory and name are
+    /// given to commands in place of the server's
+    account: Option<Account>,
+}
+
+/// The user commands run as, loaded from RUN_AS at startup: a username, a uid, or uid:gid (a
+/// username or uid without a gid uses the account's primary group). Unset, commands run as the
+/// server's own user. An invalid value is an error so commands never silently keep the server's privileges.
+static RUN_AS: LazyLock<Result<Option<RunAs>, String>> = LazyLock::new(|| match std::env::var("RUN_AS") {
+    Ok(spec) if !spec.trim().is_empty() => parse_run_as(&spec, lookup).map(Some),
+    _ => Ok(None),
+});
+
+/// The configured user, or why RUN_AS is invalid. Checked at startup, which refuses to run with an invalid one.
+pub fn configured() -> Result<Option<&'static RunAs>, String> {
+    match RUN_AS.as_ref() {
+        Ok(run_as) if run_as.is_some() && !cfg!(unix) => Err("RUN_AS is only supported on Unix".to_string()),
+        Ok(run_as) => Ok(run_as.as_ref()),
+        Err(e) => Err(format!("Invalid RUN_AS: {}", e)),
+    }
+}
+
+/// Run a command as the configured user, with that user's HOME, USER and LOGNAME
+pub fn apply(cmd: &mut Command) {
+    if let Ok(Some(run_as)) = RUN_AS.as_ref() {
+        apply_to(cmd, run_as);
+    }
+}
+
+/// Run a command as the configured user, leaving its environment alone. For commands rebuilt from
+/// one `apply` already set up, which keeps the variables but not the user.
+pub fn set_ids(cmd: &mut Command) {
+    if let Ok(Some(run_as)) = RUN_AS.as_ref() {
+        set_ids_to(cmd, run_as);
+    }
+}
+
+fn apply_to(cmd: &mut Command, run_as: &RunAs) {
+    set_ids_to(cmd, run_as);
+    if let Some(ref account) = run_as.account {
+        cmd.env("HOME", &account.home);
+        cmd.env("USER", &account.name);
+        cmd.env("LOGNAME", &account.name);
+    }
+}
+
+#[cfg(unix)]
+fn set_ids_to(cmd: &mut Command, run_as: &RunAs) {
+    use std::os::unix::process::CommandExt;
+    // When the server runs as root, std also drops its supplementary groups before switching
+    cmd.uid(run_as.uid).gid(run_as.gid);
+}
+
+#[cfg(not(unix))]
+fn set_ids_to(_cmd: &mut Command, _run_as: &RunAs) {}
+
+/// Internal implementation for testability - takes the account lookup as parameter.
+fn parse_run_as(spec: &str, lookup: impl Fn(&str) -> Option<Account>) -> Result<RunAs, String> {
+    let (user, group) = match spec.trim().split_once(':') {
+        Some((user, group)) => (user.trim(), Some(group.trim())),
+        None => (spec.trim(), None),
+    };
+    let account = lookup(user);
+    let uid = match (user.parse::<u32>(), &account) {
+        (Ok(uid), _) => uid,
+        (Err(_), Some(account)) => account.uid,
+        (Err(_), None) => return Err(format!("unknown user {}", user)),
+    };
+    let gid = match group {
+        Some(group) => group.parse::<u32>().map_err(|_| format!("invalid group ID {}", group))?,
+        None => account.as_ref().map_or(uid, |account| account.gid),
+    };
+    Ok(RunAs { uid, gid, account })
+}
+
+/// Look up an account by name or uid in the password database
+#[cfg(unix)]
+fn lookup(user: &str) -> Option<Account> {
+    use std::ffi::{CStr, CString};
+
+    let mut buf = vec![0 as libc::c_char; 16 * 1024];
+    // SAFETY: passwd is plain data, filled in by the lookup before it is read
+    let mut pwd: libc::passwd = unsafe { std::mem::zeroed() };
+    let mut result = std::ptr::null_mut();
+    // SAFETY: every pointer is to a live local, and buf's length is passed with it
+    let rc = match user.parse::<u32>() {
+        Ok(uid) => unsafe { libc::getpwuid_r(uid, &mut pwd, buf.as_mut_ptr(), buf.len(), &mut result) },
+        Err(_) => {
+            let name = CString::new(user).ok()?;
+            unsafe { libc::getpwnam_r(name.as_ptr(), &mut pwd, buf.as_mut_ptr(), buf.len(), &mut result) }
+        }
+    };
+    if rc != 0 || result.is_null() {
+        return None;
+    }
+    // SAFETY: a successful lookup points the strings into buf, which is still alive
+    let text = |s: *const libc::c_char| unsafe { CStr::from_ptr(s) }.to_string_lossy().into_owned();
+    Some(Account {
+        name: text(pwd.pw_name),
+        uid: pwd.pw_uid,
+        gid: pwd.pw_gid,
+        home: text(pwd.pw_dir),
+    })
+}
+
+#[cfg(not(unix))]
+fn lookup(_user: &str) -> Option<Account> {
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn builder(user: &str) -> Option<Account> {
+        matches!(user, "builder" | "1500").then(|| Account {
+            name: "builder".to_string(),
+            uid: 1500,
+            gid: 1600,
+            home: "/home/builder".to_string(),
+        })
+    }
+
+    #[test]
+    fn test_parse_run_as() {
+        let by_name = parse_run_as("builder", builder).unwrap();
+        assert_eq!((by_name.uid, by_name.gid), (1500, 1600));
+        assert_eq!(by_name.account, builder("builder"));
+        let by_uid = parse_run_as("1500", builder).unwrap();
+        assert_eq!((by_uid.uid, by_uid.gid), (1500, 1600));
+        let with_group = parse_run_as("builder:100", builder).unwrap();
+        assert_eq!((with_group.uid, with_group.gid), (1500, 100));
+        // A uid without an account uses the same number for its group
+        let unknown_uid = parse_run_as(" 2000 ", builder).unwrap();
+        assert_eq!((unknown_uid.uid, unknown_uid.gid, unknown_uid.account), (2000, 2000, None));
+    }
+
+    #[test]
+    fn test_parse_run_as_rejects_unknown() {
+        assert_eq!(parse_run_as("nobody-here", builder), Err("unknown user nobody-here".to_string()));
+        assert_eq!(parse_run_as("builder:staff", builder), Err("invalid group ID staff".to_string()));
+    }
+
+    #[test]
+    fn test_apply_sets_account_environment() {
+        let mut cmd = Command::new("true");
+        cmd.env("HOME", "/root");
+        apply_to(&mut cmd, &parse_run_as("builder", builder).unwrap());
+        let envs: Vec<_> = cmd.get_envs().map(|(k, v)| (k.to_owned(), v.map(|v| v.to_owned()))).collect();
+        assert!(envs.contains(&("HOME".into(), Some("/home/builder".into()))));
+        assert!(envs.contains(&("USER".into(), Some("builder".into()))));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_lookup() {
+        let root = lookup("root").unwrap();
+        assert_eq!((root.uid, root.gid), (0, 0));
+        assert_eq!(lookup("0").map(|a| a.name), Some("root".to_string()));
+        assert_eq!(lookup("no-such-user-here"), None);
+    }
+}
@@ -0,0 +1,246 @@
+use std::sync::mpsc;
+use std::sync::LazyLock;
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant};
+
+use crate::tools::host_info::format_bytes;
+
+/// Default time between resource samples of a running command (5 seconds)
+const DEFAULT_SAMPLE_INTERVAL_MS: u64 = 5000;
+
+/// Time between resource samples loaded from SAMPLE_INTERVAL_MS environment variable at startup
+pub static SAMPLE_INTERVAL: LazyLock<Duration> = LazyLock::new(|| {
+    let ms = std::env::var("SAMPLE_INTERVAL_MS")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .filter(|&ms| ms > 0)
+        .unwrap_or(DEFAULT_SAMPLE_INTERVAL_MS);
+    Duration::from_millis(ms)
+});
+
+/// Most rows in a rendered timeline; longer timelines merge neighbouring samples
+const MAX_ROWS: usize = 40;
+
+/// Bars of a sparkline, lowest to highest
+const BARS: &[char] = &['▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'];
+
+/// Resources used by a command's process group at one moment
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Sample {
+    /// Time since the command started
+    pub elapsed: Duration,
+    /// CPU time used so far by the processes still running, in seconds
+    pub cpu_secs: f64,
+    /// Total resident set size of the processes, in bytes
+    pub rss: u64,
+    pub processes: usize,
+}
+
+/// The samples taken while one command ran
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Timeline {
+    /// The program the command ran
+    pub program: String,
+    pub interval: Duration,
+    pub samples: Vec<Sample>,
+}
+
+/// Samples a process group from a background thread until stopped
+pub struct Sampler {
+    stop: mpsc::Sender<()>,
+    handle: JoinHandle<Vec<Sample>>,
+}
+
+impl Sampler {
+    /// Start sampling the process group led by `pgid` every `interval`
+    pub fn start(pgid: u32, interval: Duration) -> Self {
+        let (stop, stopped) = mpsc::channel();
+        let started = Instant::now();
+        let handle = thread::spawn(move || {
+            let mut samples = Vec::new();
+            while let Err(mpsc::RecvTimeoutError::Timeout) = stopped.recv_timeout(interval) {
+                if let Some(sample) = sample_group(pgid, started.elapsed()) {
+                    samples.push(sample);
+                }
+            }
+            samples
+        });
+        Sampler { stop, handle }
+    }
+
+    /// Stop sampling and return the samples taken
+    pub fn stop(self) -> Vec<Sample> {
+        let _ = self.stop.send(());
+        self.handle.join().unwrap_or_default()
+    }
+}
+
+/// Sum the CPU time and memory of the processes in a group, from /proc. None where /proc isn't
+/// available or the group has no processes left.
+#[cfg(unix)]
+fn sample_group(pgid: u32, elapsed: Duration) -> Option<Sample> {
+    // SAFETY: sysconf only reads system configuration
+    let (ticks, page_size) = unsafe { (libc::sysconf(libc::_SC_CLK_TCK), libc::sysconf(libc::_SC_PAGESIZE)) };
+    if ticks <= 0 || page_size <= 0 {
+        return None;
+    }
+    let mut sample = Sample {
+        elapsed,
+        cpu_secs: 0.0,
+        rss: 0,
+        processes: 0,
+    };
+    for entry in std::fs::read_dir("/proc").ok()?.flatten() {
+        let stat = match std::fs::read_to_string(entry.path().join("stat")) {
+            Ok(stat) => stat,
+            Err(_) => continue,
+        };
+        if let Some((group, cpu_ticks, rss_pages)) = parse_stat(&stat) {
+            if group == pgid {
+                sample.processes += 1;
+                sample.cpu_secs += cpu_ticks as f64 / ticks as f64;
+                sample.rss += rss_pages * page_size as u64;
+            }
+        }
+    }
+    (sample.processes > 0).then_some(sample)
+}
+
+#[cfg(not(unix))]
+fn sample_group(_pgid: u32, _elapsed: Duration) -> Option<Sample> {
+    None
+}
+
+/// The process group, CPU ticks (user + system) and resident pages from a /proc/<pid>/stat line
+fn parse_stat(stat: &str) -> Option<(u32, u64, u64)> {
+    // The command name is in parentheses and may contain spaces, so fields are counted after it
+    let fields: Vec<&str> = stat.get(stat.rfind(')')? + 1..)?.split_whitespace().collect();
+    let field = |i: usize| fields.get(i).and_then(|f| f.parse::<u64>().ok());
+    Some((field(2)? as u32, field(11)? + field(12)?, field(21)?))
+}
+
+/// A compact table of CPU use and memory over time, with a sparkline of each
+pub fn render(timeline: &Timeline) -> String {
+    let header = format!("Timeline of {} (every {}s):", timeline.program, timeline.interval.as_secs_f64());
+    if timeline.samples.is_empty() {
+        return format!("{} no samples (the command finished first or /proc is unavailable)", header);
+    }
+    let rows = merge(&timeline.samples, MAX_ROWS);
+    let mut lines = vec![header, format!("  {:>5} {:>7} {:>10} {:>5}", "time", "cpu", "rss", "procs")];
+    let mut previous = (Duration::ZERO, 0.0);
+    let mut cpu = Vec::new();
+    for sample in &rows {
+        // CPU of one core, averaged since the previous sample
+        let seconds = (sample.elapsed - previous.0).as_secs_f64();
+        let percent = if seconds > 0.0 { ((sample.cpu_secs - previous.1) / seconds * 100.0).max(0.0) } else { 0.0 };
+        previous = (sample.elapsed, sample.cpu_secs);
+        cpu.push(percent);
+        lines.push(format!(
+            "  {:>5} {:>6.0}% {:>10} {:>5}",
+            clock(sample.elapsed),
+            percent,
+            format_bytes(sample.rss),
+            sample.processes
+        ));
+    }
+    let rss: Vec<f64> = rows.iter().map(|s| s.rss as f64).collect();
+    lines.push(format!("  cpu {}", sparkline(&cpu)));
+    lines.push(format!("  rss {}", sparkline(&rss)));
+    lines.join("\n")
+}
+
+/// At most `max` samples, each merged run keeping its last time and CPU total and its peak memory
+fn merge(samples: &[Sample], max: usize) -> Vec<Sample> {
+    let size = samples.len().div_ceil(max);
+    samples
+        .chunks(size)
+        .map(|chunk| {
+            let last = chunk[chunk.len() - 1];
+            Sample {
+                rss: chunk.iter().map(|s| s.rss).max().unwrap_or(0),
+                processes: chunk.iter().map(|s| s.processes).max().unwrap_or(0),
+                ..last
+            }
+        })
+        .collect()
+}
+
+/// Elapsed time as minutes:seconds
+fn clock(elapsed: Duration) -> String {
+    let secs = elapsed.as_secs();
+    format!("{}:{:02}", secs / 60, secs % 60)
+}
+
+/// One bar per value, scaled to the largest
+fn sparkline(values: &[f64]) -> String {
+    let max = values.iter().copied().fold(0.0, f64::max);
+    values
+        .iter()
+        .map(|&v| if max > 0.0 { BARS[((v / max) * (BARS.len() - 1) as f64).round() as usize] } else { BARS[0] })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn sample(secs: u64, cpu_secs: f64, rss_mib: u64) -> Sample {
+        Sample {
+            elapsed: Duration::from_secs(secs),
+            cpu_secs,
+            rss: rss_mib * 1024 * 1024,
+            processes: 2,
+        }
+    }
+
+    #[test]
+    fn test_parse_stat() {
+        let stat = "4242 (bazel (server)) S 1 4242 4242 0 -1 4194560 1 0 0 0 150 30 0 0 20 0 9 0 1 1000 2560 0 0";
+        assert_eq!(parse_stat(stat), Some((4242, 180, 2560)));
+        assert_eq!(parse_stat("garbage"), None);
+    }
+
+    #[test]
+    fn test_render() {
+        let timeline = Timeline {
+            program: "bazel".to_string(),
+            interval: Duration::from_secs(5),
+            samples: vec![sample(5, 5.0, 100), sample(10, 15.0, 400), sample(15, 15.5, 200)],
+        };
+        assert_eq!(
+            render(&timeline),
+            "Timeline of bazel (every 5s):\n   time     cpu        rss procs\n   \
+             0:05    100%  100.0 MiB     2\n   \
+             0:10    200%  400.0 MiB     2\n   \
+             0:15     10%  200.0 MiB     2\n  \
+             cpu ▅█▁\n  \
+             rss ▃█▅"
+        );
+    }
+
+    #[test]
+    fn test_merge_keeps_peaks() {
+        let samples: Vec<Sample> = (1..=6).map(|i| sample(i, i as f64, if i == 2 { 900 } else { 10 })).collect();
+        let merged = merge(&samples, 3);
+        assert_eq!(merged.len(), 3);
+        assert_eq!(merged[0].elapsed, Duration::from_secs(2));
+        assert_eq!(merged[0].rss, 900 * 1024 * 1024);
+        assert_eq!(merged[2].cpu_secs, 6.0);
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn test_sampler_samples_process_group() {
+        use std::os::unix::process::CommandExt;
+        let mut child = std::process::Command::new("sh")
+            .args(["-c", "sleep 1"])
+            .process_group(0)
+            .spawn()
+            .unwrap();
+        let sampler = Sampler::start(child.id(), Duration::from_millis(100));
+        child.wait().unwrap();
+        let samples = sampler.stop();
+        assert!(!samples.is_empty());
+        assert!(samples.iter().all(|s| s.processes >= 1 && s.rss > 0));
+    }
+}
@@ -0,0 +1,65 @@
+use std::path::{Path, PathBuf};
+use std::sync::LazyLock;
+
+/// Root of the scratch area loaded from SCRATCH_DIR environment variable at startup.
+/// Defaults to "command-runner-scratch" under the system temp directory.
+static SCRATCH_DIR: LazyLock<PathBuf> = LazyLock::new(|| {
+    std::env::var("SCRATCH_DIR")
+        .ok()
+        .filter(|s| !s.trim().is_empty())
+        .map(|s| PathBuf::from(s.trim()))
+        .unwrap_or_else(|| std::env::temp_dir().join("command-runner-scratch"))
+});
+
+/// Root of the scratch area where tools may write files
+pub fn scratch_dir() -> &'static Path {
+    &SCRATCH_DIR
+}
+
+/// Return a subdirectory of a scratch root, creating it if needed
+pub fn scratch_subdir_in(root: &Path, name: &str) -> std::io::Result<PathBuf> {
+    let dir = root.join(name);
+    std::fs::create_dir_all(&dir)?;
+    Ok(dir)
+}
+
+/// Name of the file written to check that a directory is writable
+const PROBE_FILE: &str = ".write-probe";
+
+/// Create a directory if needed and check that files can be written to it
+pub fn ensure_writable(dir: &Path) -> std::io::Result<()> {
+    std::fs::create_dir_all(dir)?;
+    let probe = dir.join(PROBE_FILE);
+    std::fs::write(&probe, b"ok")?;
+    std::fs::remove_file(&probe)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_scratch_subdir_in_creates_directory() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let dir = scratch_subdir_in(temp_dir.path(), "downloads").unwrap();
+        assert!(dir.is_dir());
+        assert_eq!(dir, temp_dir.path().join("downloads"));
+        // Existing directories are reused
+        assert_eq!(scratch_subdir_in(temp_dir.path(), "downloads").unwrap(), dir);
+    }
+
+    #[test]
+    fn test_ensure_writable() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let dir = temp_dir.path().join("scratch");
+        ensure_writable(&dir).unwrap();
+        assert!(dir.is_dir());
+        assert!(!dir.join(PROBE_FILE).exists());
+        assert!(ensure_writable(&temp_dir.path().join("missing/\0")).is_err());
+    }
+
+    #[test]
+    fn test_scratch_dir_is_absolute() {
+        assert!(scratch_dir().is_absolute());
+    }
+}
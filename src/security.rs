@@ -1,6 +1,12 @@
-use std::path::Path;
+#[cfg(test)]
+use std::cell::RefCell;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
 use std::sync::LazyLock;
 
+use crate::grants;
+use crate::maintenance;
+
 /// Characters that could be used for shell injection
 const SHELL_INJECTION_CHARS: &[char] = &[
     ';', '|', '&', '$', '`', '(', ')', '{', '}', '[', ']', '<', '>', '\n', '\r', '\'', '"', '\\',
@@ -24,6 +30,53 @@ static BLOCKED_PATHS: LazyLock<Vec<String>> = LazyLock::new(|| {
         .collect()
 });
 
+#[cfg(test)]
+thread_local! {
+    /// Blocked paths of the current test's thread, used instead of BLOCKED_PATHS when set
+    static TEST_BLOCKED_PATHS: RefCell<Option<Vec<String>>> = const { RefCell::new(None) };
+}
+
+/// Run `f` with the blocked paths: BLOCKED_PATHS, or those a test set for its thread
+fn with_blocked_paths<T>(f: impl FnOnce(&[String]) -> T) -> T {
+    #[cfg(test)]
+    if let Some(paths) = TEST_BLOCKED_PATHS.with(|paths| paths.borrow().clone()) {
+        return f(&paths);
+    }
+    f(&BLOCKED_PATHS)
+}
+
+/// Block paths for the rest of the current test's thread, in place of BLOCKED_PATHS
+#[cfg(test)]
+pub fn set_blocked_paths_for_test(paths: &[String]) {
+    TEST_BLOCKED_PATHS.with(|test_paths| *test_paths.borrow_mut() = Some(paths.to_vec()));
+}
+
+/// Environment variable names a request's env may set, loaded from ENV_ALLOWLIST environment
+/// variable at startup. Format: semicolon-separated names, e.g., "BAZEL_TEST_ENV;GOFLAGS".
+/// When not set, any variable that isn't dangerous may be set.
+static ENV_ALLOWLIST: LazyLock<Vec<String>> = LazyLock::new(|| {
+    std::env::var("ENV_ALLOWLIST")
+        .unwrap_or_default()
+        .split(';')
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+        .collect()
+});
+
+/// Read-only mode, set once at startup from the --read-only flag.
+/// When enabled, tools that modify the filesystem are disabled.
+static READ_ONLY: AtomicBool = AtomicBool::new(false);
+
+/// Enable or disable read-only mode
+pub fn set_read_only(enabled: bool) {
+    READ_ONLY.store(enabled, Ordering::Relaxed);
+}
+
+/// Whether the server is running in read-only mode
+pub fn is_read_only() -> bool {
+    READ_ONLY.load(Ordering::Relaxed)
+}
+
 /// Environment variable names that could be used for code injection or privilege escalation
 const DANGEROUS_ENV_VARS: &[&str] = &[
     "LD_PRELOAD",
@@ -53,9 +106,24 @@ pub enum ValidationError {
     BlockedPath(String),
     FlagInjection(String),
     DangerousEnvVar(String),
+    DisallowedEnvVar { name: String, allowed: String },
     PathTraversal(String),
+    AbsolutePath(String),
     RelativeWorkingDir(String),
     DisallowedSubcommand { subcommand: String, allowed: String },
+    DisallowedCommand { command: String, allowed: String },
+    InvalidPipeline(String),
+    DisallowedUrl(String),
+    InvalidChecksum(String),
+    InvalidFilename(String),
+    InvalidStdin(String),
+    InvalidFilter(String),
+    InvalidLabel(String),
+    InvalidDebuggerCommand(String),
+    ServerFrozen(String),
+    ServerBusy(String),
+    SessionBlocked(String),
+    ReadOnlyMode(String),
 }
 
 impl std::fmt::Display for ValidationError {
@@ -85,6 +153,13 @@ impl std::fmt::Display for ValidationError {
                     var
                 )
             }
+            ValidationError::DisallowedEnvVar { name, allowed } => {
+                write!(
+                    f,
+                    "Error: Setting environment variable '{}' is not allowed. Allowed variables: {}",
+                    name, allowed
+                )
+            }
             ValidationError::PathTraversal(path) => {
                 write!(
                     f,
@@ -92,6 +167,9 @@ impl std::fmt::Display for ValidationError {
                     path
                 )
             }
+            ValidationError::AbsolutePath(path) => {
+                write!(f, "Error: Path '{}' must be relative to working_dir.", path)
+            }
             ValidationError::RelativeWorkingDir(dir) => {
                 write!(
                     f,
@@ -106,6 +184,81 @@ impl std::fmt::Display for ValidationError {
                     subcommand, allowed
                 )
             }
+            ValidationError::DisallowedCommand { command, allowed } => {
+                write!(
+                    f,
+                    "Error: Command '{}' is not allowed in a pipeline. Allowed commands: {}",
+                    command, allowed
+                )
+            }
+            ValidationError::InvalidPipeline(reason) => {
+                write!(f, "Error: Invalid pipeline: {}", reason)
+            }
+            ValidationError::DisallowedUrl(url) => {
+                write!(
+                    f,
+                    "Error: URL '{}' is not allowed. Only URLs under the prefixes in DOWNLOAD_ALLOWED_URLS can be downloaded.",
+                    url
+                )
+            }
+            ValidationError::InvalidChecksum(checksum) => {
+                write!(
+                    f,
+                    "Error: '{}' is not a valid SHA-256 checksum (expected 64 hexadecimal characters).",
+                    checksum
+                )
+            }
+            ValidationError::InvalidStdin(reason) => {
+                write!(f, "Error: Invalid stdin: {}", reason)
+            }
+            ValidationError::InvalidFilter(reason) => {
+                write!(f, "Error: Invalid filter pattern: {}", reason)
+            }
+            ValidationError::InvalidLabel(reason) => {
+                write!(f, "Error: Invalid label: {}", reason)
+            }
+            ValidationError::InvalidDebuggerCommand(arg) => {
+                write!(
+                    f,
+                    "Error: '{}' is not a valid debugger location or expression. It must be a single, non-empty line.",
+                    arg.escape_debug()
+                )
+            }
+            ValidationError::InvalidFilename(name) => {
+                write!(
+                    f,
+                    "Error: '{}' is not a valid file name. File names must be a single path component.",
+                    name
+                )
+            }
+            ValidationError::ServerFrozen(reason) => {
+                write!(
+                    f,
+                    "Error: SERVER_FROZEN: New executions are paused by the server operator ({}). Try again later.",
+                    reason
+                )
+            }
+            ValidationError::ServerBusy(reason) => {
+                write!(
+                    f,
+                    "Error: BUSY: The host is too loaded to start this execution ({}). Try again later.",
+                    reason
+                )
+            }
+            ValidationError::SessionBlocked(reason) => {
+                write!(
+                    f,
+                    "Error: SESSION_BLOCKED: This session is blocked after unusual activity ({}). Start a new session.",
+                    reason
+                )
+            }
+            ValidationError::ReadOnlyMode(action) => {
+                write!(
+                    f,
+                    "Error: {} modifies files and is disabled because the server is running in read-only mode.",
+                    action
+                )
+            }
         }
     }
 }
@@ -144,6 +297,15 @@ pub fn validate_not_flag(arg: &str) -> Result<(), ValidationError> {
     Ok(())
 }
 
+/// Validate a location or expression written into a debugger script, one per line.
+/// A line break would let it add commands of its own.
+pub fn validate_debugger_command(arg: &str) -> Result<(), ValidationError> {
+    if arg.trim().is_empty() || arg.contains(['\n', '\r', '\0']) {
+        return Err(ValidationError::InvalidDebuggerCommand(arg.to_string()));
+    }
+    Ok(())
+}
+
 /// Check if a path contains ".." (parent directory traversal)
 pub fn contains_traversal(path: &str) -> bool {
     path.contains("..")
@@ -189,6 +351,22 @@ pub fn validate_env_var(name: &str, value: &str) -> Result<(), ValidationError>
     Ok(())
 }
 
+/// Validate that a request may set an environment variable under the ENV_ALLOWLIST, if one is configured
+pub fn validate_env_allowed(name: &str) -> Result<(), ValidationError> {
+    validate_env_allowed_impl(name, &ENV_ALLOWLIST)
+}
+
+/// Internal implementation for testability - takes the allowlist as parameter.
+fn validate_env_allowed_impl(name: &str, allowlist: &[String]) -> Result<(), ValidationError> {
+    if allowlist.is_empty() || allowlist.iter().any(|allowed| allowed == name) {
+        return Ok(());
+    }
+    Err(ValidationError::DisallowedEnvVar {
+        name: name.to_string(),
+        allowed: allowlist.join(", "),
+    })
+}
+
 /// Internal implementation for testability - takes blocked_paths as parameter.
 /// Resolves a path and checks if it matches or is under any blocked path.
 fn find_blocked_path_impl(path: &str, blocked_paths: &[String]) -> Option<String> {
@@ -208,10 +386,16 @@ fn find_blocked_path_impl(path: &str, blocked_paths: &[String]) -> Option<String
         Err(_) => resolved_path, // Path might not exist yet, use as-is
     };
 
+    if let Some(control) = find_control_file(&canonical_path) {
+        return Some(control);
+    }
+
     // Check if path is or is under any blocked path
     let path_str = canonical_path.to_string_lossy();
     for blocked in blocked_paths {
-        if path_str == *blocked || path_str.starts_with(&format!("{}/", blocked)) {
+        if (path_str == *blocked || path_str.starts_with(&format!("{}/", blocked)))
+            && !grants::path_granted(&canonical_path)
+        {
             return Some(blocked.clone());
         }
     }
@@ -221,7 +405,30 @@ fn find_blocked_path_impl(path: &str, blocked_paths: &[String]) -> Option<String
 /// Resolve a path and check if it matches or is under any blocked path.
 /// Uses the global BLOCKED_PATHS from environment variable.
 fn find_blocked_path(path: &str) -> Option<String> {
-    find_blocked_path_impl(path, &BLOCKED_PATHS)
+    with_blocked_paths(|blocked_paths| find_blocked_path_impl(path, blocked_paths))
+}
+
+/// A path resolved as far as it exists: canonical if it exists, or its canonical parent joined with its name
+fn resolve_existing(path: &Path) -> PathBuf {
+    if let Ok(canonical) = path.canonicalize() {
+        return canonical;
+    }
+    match (path.parent().and_then(|parent| parent.canonicalize().ok()), path.file_name()) {
+        (Some(parent), Some(name)) => parent.join(name),
+        _ => path.to_path_buf(),
+    }
+}
+
+/// The server's control file a resolved path names, if any: GRANTS_FILE and FREEZE_FILE are blocked
+/// whatever BLOCKED_PATHS says, and no grant covers them. A tool that could write the grants file
+/// could grant itself any path, and one that could write the freeze file could freeze the server.
+fn find_control_file(path: &Path) -> Option<String> {
+    let path = resolve_existing(path);
+    grants::grants_file()
+        .into_iter()
+        .chain(maintenance::freeze_file().map(Path::to_path_buf))
+        .find(|control| resolve_existing(control) == path)
+        .map(|control| control.display().to_string())
 }
 
 /// Validate that a path is not blocked
@@ -250,9 +457,15 @@ fn validate_path_with_working_dir_impl(path: &str, working_dir: &str, blocked_pa
         Err(_) => resolved, // Path might not exist, use as-is
     };
 
+    if let Some(control) = find_control_file(&canonical) {
+        return Err(ValidationError::BlockedPath(control));
+    }
+
     let path_str = canonical.to_string_lossy();
     for blocked in blocked_paths {
-        if path_str == *blocked || path_str.starts_with(&format!("{}/", blocked)) {
+        if (path_str == *blocked || path_str.starts_with(&format!("{}/", blocked)))
+            && !grants::path_granted(&canonical)
+        {
             return Err(ValidationError::BlockedPath(blocked.clone()));
         }
     }
@@ -263,7 +476,7 @@ fn validate_path_with_working_dir_impl(path: &str, working_dir: &str, blocked_pa
 /// This handles the case where a relative path combined with working_dir could
 /// access a blocked location.
 pub fn validate_path_with_working_dir(path: &str, working_dir: &str) -> Result<(), ValidationError> {
-    validate_path_with_working_dir_impl(path, working_dir, &BLOCKED_PATHS)
+    with_blocked_paths(|blocked_paths| validate_path_with_working_dir_impl(path, working_dir, blocked_paths))
 }
 
 #[cfg(test)]
@@ -311,6 +524,24 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_control_files_are_blocked_despite_grants() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let grants_file = temp_dir.path().join("grants");
+        // A grant covering everything, which would otherwise let the grants file be rewritten
+        std::fs::write(&grants_file, format!("{} * path:/ everything\n", u64::MAX)).unwrap();
+        grants::set_grants_file_for_test(&grants_file);
+        let blocked = vec![temp_dir.path().to_string_lossy().to_string()];
+        let path = grants_file.to_string_lossy();
+        assert_eq!(find_blocked_path_impl(&path, &[]), Some(path.to_string()));
+        assert!(matches!(
+            validate_path_with_working_dir_impl("grants", &temp_dir.path().to_string_lossy(), &[]),
+            Err(ValidationError::BlockedPath(_))
+        ));
+        // Other blocked paths can still be granted
+        assert_eq!(find_blocked_path_impl(&temp_dir.path().join("other").to_string_lossy(), &blocked), None);
+    }
+
     #[test]
     fn test_find_blocked_path_blocks_subpath() {
         let blocked = vec!["/blocked".to_string()];
@@ -454,6 +685,21 @@ mod tests {
         ));
     }
 
+    #[test]
+    fn test_validate_env_allowed() {
+        let allowlist = vec!["BAZEL_TEST_ENV".to_string(), "GOFLAGS".to_string()];
+        assert!(validate_env_allowed_impl("GOFLAGS", &allowlist).is_ok());
+        assert_eq!(
+            validate_env_allowed_impl("NODE_OPTIONS", &allowlist),
+            Err(ValidationError::DisallowedEnvVar {
+                name: "NODE_OPTIONS".to_string(),
+                allowed: "BAZEL_TEST_ENV, GOFLAGS".to_string(),
+            })
+        );
+        // Without an allowlist, only the dangerous variable check applies
+        assert!(validate_env_allowed_impl("NODE_OPTIONS", &[]).is_ok());
+    }
+
     #[test]
     fn test_validate_env_var_allows_safe_vars() {
         assert!(validate_env_var("MY_VAR", "safe_value").is_ok());
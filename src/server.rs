@@ -1,23 +1,81 @@
 use rmcp::{
-    handler::server::{router::tool::ToolRouter, wrapper::Parameters},
-    model::{Implementation, ProtocolVersion, ServerCapabilities, ServerInfo},
-    tool, ServerHandler,
+    handler::server::{router::tool::ToolRouter, tool::ToolCallContext, wrapper::Parameters},
+    model::{
+        AnnotateAble, CallToolRequestParam, CallToolResult, Content, Implementation, ListResourcesResult, ListToolsResult,
+        LoggingLevel, LoggingMessageNotificationParam, PaginatedRequestParam, ProgressNotificationParam, ProgressToken,
+        ProtocolVersion, RawResource, ReadResourceRequestParam, ReadResourceResult, ResourceContents,
+        ServerCapabilities, ServerInfo, SetLevelRequestParam,
+    },
+    service::RequestContext,
+    tool, ErrorData, Peer, RoleServer, ServerHandler,
 };
+use serde::{de::DeserializeOwned, Serialize};
+use serde_json::Value;
+use std::any::Any;
+use std::panic::{self, AssertUnwindSafe};
+use std::path::PathBuf;
+use std::sync::Arc;
+use std::time::Instant;
 
+use crate::accounting;
+use crate::admission;
+use crate::anomaly;
+use crate::audit;
+use crate::client_log;
+use crate::durations;
+use crate::events;
+use crate::executor;
+use crate::grants;
+use crate::history::{self, history_uri};
+use crate::jobs;
+use crate::labels;
+use crate::maintenance::frozen_reason;
+use crate::notify::{notify_anomaly, notify_if_long, notify_webhook};
+use crate::overlay;
+use crate::paging;
+use crate::preflight;
+use crate::profile;
+use crate::progress::{self, Reporter};
 use crate::request::ToolRequest;
-use crate::security::Validatable;
-use crate::tools::{git, ls, GitRequest, LsRequest};
+use crate::sampler;
+use crate::security::{is_read_only, Validatable, ValidationError};
+use crate::spool;
+use crate::timeline::{self, timeline_svg_uri, timeline_uri};
+use crate::tools::{
+    bazel_cache, binary_info, cancel, change_summary, debug_test, diff_outputs, digest, doctor, download, exists,
+    explain_failure, get_output, git, golden, gpu_info, history as history_tool, host_info, job_cancel, job_logs,
+    job_start, job_status, library_deps, license_scan, locate_file, ls, overlay_diff, owners, pipeline, presubmit,
+    ps_jobs, purge_scratch, repro_check, rerun, sbom, symbolicate, transcript as transcript_tool, usage_report,
+    BazelCacheRequest, BinaryInfoRequest, CancelRequest, ChangeSummaryRequest, DebugTestRequest, DiffOutputsRequest,
+    DigestRequest, DoctorRequest, DownloadRequest, ExistsRequest, ExplainFailureRequest, GetOutputRequest, GitRequest,
+    GoldenRequest, GpuInfoRequest, HistoryRequest, HostInfoRequest, JobCancelRequest, JobLogsRequest, JobStartRequest,
+    JobStatusRequest, LibraryDepsRequest, LicenseScanRequest, LocateFileRequest, LsRequest, OverlayDiffRequest,
+    OwnersRequest, PipelineRequest, PresubmitRequest, PsJobsRequest, PurgeScratchRequest, ReproCheckRequest,
+    RerunRequest, SbomRequest, SymbolicateRequest, TranscriptRequest, UsageReportRequest,
+};
+use crate::transcript::{self, transcript_uri};
 
 #[derive(Clone)]
 pub struct CommandRunnerServer {
     tool_router: ToolRouter<Self>,
 }
 
+/// Tools that modify the filesystem. These are not advertised in read-only mode, unless they may be
+/// granted, in which case calls without a grant are refused.
+const MUTATING_TOOLS: &[&str] = &["debug_test", "download", "golden", "presubmit", "purge_scratch", "repro_check"];
+
 impl CommandRunnerServer {
     pub fn new() -> Self {
-        Self {
-            tool_router: Self::tool_router(),
+        let mut tool_router = Self::tool_router();
+        if is_read_only() && !grants::enabled() {
+            for name in MUTATING_TOOLS {
+                tool_router.remove_route(name);
+            }
         }
+        for problem in preflight::disabled() {
+            tool_router.remove_route(problem.tool);
+        }
+        Self { tool_router }
     }
 }
 
@@ -31,19 +89,228 @@ use crate::request::ExecutionContext;
 
 /// Execute a tool request, validating it first and applying output transformations.
 /// This enforces at compile time that all requests must implement Validatable.
-fn run_tool<R: Validatable>(
+/// Every call, including rejected ones, is recorded in the session transcript.
+/// A panic while handling the call is returned as an internal error instead of
+/// taking down the server.
+fn run_tool<R: Validatable + Serialize>(
+    tool: &str,
     req: &ToolRequest<R>,
     execute: impl FnOnce(&R, &ExecutionContext) -> String,
 ) -> String {
-    if let Err(e) = req.validate() {
-        return e.to_string();
+    let started = Instant::now();
+    // Drop grants and spooled invocations noted on this thread outside a tool call
+    grants::take_used();
+    spool::take_spooled();
+    let call = transcript::next_call();
+    let labels = labels::for_call(req.labels.as_ref());
+    events::publish_started(tool, call, &labels);
+    let input = serde_json::to_value(req).unwrap_or_default();
+    // Blocks the session, so run_validated rejects this call and every later one
+    if let Some(reason) = anomaly::trip_honeypot(&input) {
+        tracing::error!("Session {} blocked: {}", transcript::session_id(), reason);
+        notify_anomaly(tool, call, &reason);
+    }
+    let (output, denied) = match panic::catch_unwind(AssertUnwindSafe(|| run_validated(tool, req, execute))) {
+        Ok(Ok(output)) => (output, false),
+        Ok(Err(rejection)) => (rejection, true),
+        Err(payload) => {
+            let correlation_id = format!("{}-{}", transcript::session_id(), call);
+            tracing::error!(
+                "Tool {} panicked (correlation ID {}): {}",
+                tool,
+                correlation_id,
+                panic_message(payload.as_ref())
+            );
+            let output = format!(
+                "Error: INTERNAL: The {} tool failed unexpectedly. Report correlation ID {} to the server operator.",
+                tool, correlation_id
+            );
+            (output, false)
+        }
+    };
+    let spooled = spool::take_spooled();
+    let entry = transcript::record(call, tool, input.clone(), &output, labels.clone());
+    audit::export(&entry, denied, &grants::take_used());
+    for reason in anomaly::observe(&input, denied) {
+        tracing::warn!("Unusual activity in session {}: {}", transcript::session_id(), reason);
+        notify_anomaly(tool, call, &reason);
+    }
+    history::record(call, tool, input, &output);
+    notify_if_long(tool, started.elapsed(), &output);
+    notify_webhook(tool, call, &output, denied, &spooled);
+    events::publish_finished(tool, call, started.elapsed(), &output, denied, &labels);
+    output
+}
+
+/// Text of a panic payload, which is a &str or String for panic!() with a message
+fn panic_message(payload: &(dyn Any + Send)) -> &str {
+    payload
+        .downcast_ref::<&str>()
+        .copied()
+        .or_else(|| payload.downcast_ref::<String>().map(String::as_str))
+        .unwrap_or("unknown panic payload")
+}
+
+/// Run a request that passes the freeze and validation checks.
+/// Returns Err with the rejection message if it does not.
+fn run_validated<R: Validatable + Serialize>(
+    tool: &str,
+    req: &ToolRequest<R>,
+    execute: impl FnOnce(&R, &ExecutionContext) -> String,
+) -> Result<String, String> {
+    // Reject new executions while frozen; calls already running are unaffected
+    if let Some(reason) = frozen_reason() {
+        return Err(ValidationError::ServerFrozen(reason).to_string());
+    }
+    if let Some(reason) = anomaly::blocked_reason() {
+        return Err(ValidationError::SessionBlocked(reason).to_string());
+    }
+    if is_read_only() && MUTATING_TOOLS.contains(&tool) && !grants::tool_granted(tool) {
+        return Err(ValidationError::ReadOnlyMode(format!("The {} tool", tool)).to_string());
     }
-    let ctx = req.execution_context();
+    req.validate().map_err(|e| e.to_string())?;
+    // Later pages are read from the output of the call that was paged, so nothing runs again
+    if let Some(ref token) = req.page_token {
+        return Ok(paging::next_page(tool, token, req.page_size));
+    }
+    let mut ctx = ExecutionContext {
+        profile: profile::for_tool(tool),
+        job: jobs::current(),
+        tool: Some(tool.to_string()),
+        ..req.execution_context()
+    };
+    // Heavy executions wait for, or are turned away from, a loaded host, and calls of a tool with a
+    // limit of its own wait for a slot; a dry run runs nothing
+    let _slot = if ctx.dry_run {
+        None
+    } else {
+        admission::admit(tool).map_err(|reason| ValidationError::ServerBusy(reason).to_string())?;
+        let slot = admission::acquire_tool_slot(tool, ctx.queue_timeout);
+        Some(slot.map_err(|reason| ValidationError::ServerBusy(reason).to_string())?)
+    };
+    // Created once the call is admitted, so rejected calls leave no layers behind
+    if req.overlay.unwrap_or(false) && !ctx.dry_run {
+        let workspace = match req.working_dir {
+            Some(ref dir) => PathBuf::from(dir),
+            None => std::env::current_dir().unwrap_or_default(),
+        };
+        let overlay = overlay::create(tool, &workspace)
+            .map_err(|e| format!("Error: Failed to create an overlay of {}: {}", workspace.display(), e))?;
+        ctx.overlay = Some(overlay);
+    }
+    let params = serde_json::to_value(&req.inner).unwrap_or_default();
+    let target = durations::target(req.working_dir.as_deref(), &params);
+    let estimate = durations::estimate(tool, &target);
+    if let Some(estimate) = estimate {
+        tracing::info!("Running {}: {}", tool, estimate);
+    }
+    // Drop usage left on this thread by anything run outside a tool call
+    executor::take_usage();
+    let started = Instant::now();
     let output = execute(&req.inner, &ctx);
-    req.transform_output(output)
+    let output_bytes = output.len() as u64;
+    let mut output = req.transform_output(output);
+    // A dry run's time says nothing about how long the call takes
+    if !ctx.dry_run {
+        durations::record(tool, &target, started.elapsed());
+    }
+    // Both after the transformations, so grep and head can't drop them
+    let usage = executor::take_usage();
+    if !ctx.dry_run {
+        let labels = labels::for_call(req.labels.as_ref());
+        accounting::record(tool, labels, started.elapsed(), usage.user + usage.sys, output_bytes);
+    }
+    if let Some(ref overlay) = ctx.overlay {
+        output = format!(
+            "{}\n\n[ran on overlay {}; {} is unchanged. See what changed with overlay_diff {{\"id\": {}}}]",
+            output.trim_end(),
+            overlay.id,
+            overlay.workspace.display(),
+            overlay.id
+        );
+    }
+    if req.resource_timeline.unwrap_or(false) {
+        for timeline in &usage.timelines {
+            output = format!("{}\n\n{}", output.trim_end(), sampler::render(timeline));
+        }
+    }
+    if req.report_usage.unwrap_or(false) {
+        output = format!("{}\n\n{}", output.trim_end(), usage);
+        if let Some(estimate) = estimate {
+            output = format!("{}\n{}", output, estimate);
+        }
+    }
+    // Last, so every page, including those of failed calls, comes from this run's output
+    if let Some(size) = req.page_size.filter(|&size| size > 0) {
+        output = paging::first_page(tool, output, size);
+    }
+    Ok(output)
+}
+
+/// Run a tool by name with a recorded request, applying the current policy.
+/// Returns None for unknown tools, tools disabled in read-only mode, and the tools that dispatch.
+fn dispatch(tool: &str, input: Value) -> Option<String> {
+    // run_validated refuses tools read-only mode disables that may be granted but aren't
+    if (is_read_only() && !grants::enabled() && MUTATING_TOOLS.contains(&tool)) || preflight::is_disabled(tool) {
+        return None;
+    }
+    fn replay<R: DeserializeOwned + Validatable + Serialize>(
+        tool: &str,
+        input: Value,
+        execute: impl FnOnce(&R, &ExecutionContext) -> String,
+    ) -> Option<String> {
+        let req: ToolRequest<R> = serde_json::from_value(input).ok()?;
+        Some(run_tool(tool, &req, execute))
+    }
+    match tool {
+        "ls_tool" => replay(tool, input, ls::execute),
+        "exists" => replay(tool, input, exists::execute),
+        "locate_file" => replay(tool, input, locate_file::execute),
+        "digest" => replay(tool, input, digest::execute),
+        "git" => replay(tool, input, git::execute),
+        "pipeline" => replay(tool, input, pipeline::execute),
+        "presubmit" => replay(tool, input, presubmit::execute),
+        "repro_check" => replay(tool, input, repro_check::execute),
+        "owners" => replay(tool, input, owners::execute),
+        "host_info" => replay(tool, input, host_info::execute),
+        "gpu_info" => replay(tool, input, gpu_info::execute),
+        "bazel_cache" => replay(tool, input, bazel_cache::execute),
+        "binary_info" => replay(tool, input, binary_info::execute),
+        "library_deps" => replay(tool, input, library_deps::execute),
+        "debug_test" => replay(tool, input, debug_test::execute),
+        "symbolicate" => replay(tool, input, symbolicate::execute),
+        "sbom" => replay(tool, input, sbom::execute),
+        "license_scan" => replay(tool, input, license_scan::execute),
+        "change_summary" => replay(tool, input, change_summary::execute),
+        "download" => replay(tool, input, download::execute),
+        "purge_scratch" => replay(tool, input, purge_scratch::execute),
+        "transcript" => replay(tool, input, transcript_tool::execute),
+        "diff_outputs" => replay(tool, input, diff_outputs::execute),
+        "explain_failure" => replay(tool, input, explain_failure::execute),
+        "doctor" => replay(tool, input, doctor::execute),
+        "job_status" => replay(tool, input, job_status::execute),
+        "job_logs" => replay(tool, input, job_logs::execute),
+        "get_output" => replay(tool, input, get_output::execute),
+        "history" => replay(tool, input, history_tool::execute),
+        "overlay_diff" => replay(tool, input, overlay_diff::execute),
+        "ps_jobs" => replay(tool, input, ps_jobs::execute),
+        "usage_report" => replay(tool, input, usage_report::execute),
+        _ => None,
+    }
 }
 
-const SERVER_INSTRUCTIONS: &str = r#"A command runner MCP server that provides ls_tool for listing directory contents and git for running git commands.
+const SERVER_INSTRUCTIONS: &str = r#"A command runner MCP server. Its tools, by task:
+- Files: ls_tool lists a directory, exists checks many paths in one call, locate_file finds files by partial or fuzzy name, digest tells whether anything under a directory changed, owners resolves CODEOWNERS/OWNERS ownership of a path
+- Commands: git runs git commands; pipeline filters files through cat/grep/sort/head and similar commands without a shell
+- Builds and tests: presubmit runs the configured format/lint/build/test checks in one call, debug_test inspects a Go test under delve, repro_check builds targets twice and finds outputs that differ, bazel_cache measures bazel's caches and collects garbage under disk pressure, golden checks a tool's output against a golden file
+- Failures: explain_failure reports on a failed call, symbolicate resolves a pasted stack trace to workspace source
+- Binaries: binary_info inspects a built binary, library_deps finds the shared libraries it is missing
+- Host: host_info reports OS/CPU/memory/load/clock details, gpu_info the GPUs, doctor the health of the execution environment
+- Downloads: download fetches allowlisted files with checksum verification, purge_scratch frees space in the scratch area
+- Background work: job_start/job_status/job_logs/job_cancel run a long call such as a bazel build in the background, ps_jobs lists the commands still running, cancel stops one of them
+- Earlier calls: history lists finished commands with their exit codes, get_output reads a command's full output after its call returned, rerun repeats a call and diffs its output, diff_outputs compares the outputs of two calls, overlay_diff shows what a call run with overlay changed
+- Session: transcript exports this session's tool calls, usage_report totals CPU time, wall time and output by tenant or label for chargeback
+- Compliance: sbom produces a software bill of materials of the workspace or a built artifact, license_scan checks dependencies' licenses against the server's policy, change_summary gathers the diff, changed targets and test results to draft a commit message from
 
 All tools support these optional parameters:
 - grep_pattern: regex to filter lines (invert_grep: true to exclude matches)
@@ -53,19 +320,42 @@ All tools support these optional parameters:
 - timeout_ms: command timeout in milliseconds
 - working_dir: directory to run command in (must be an absolute path starting with '/')
 - env: environment variables as {"KEY": "value"}
+- stdin: text piped to the command's standard input (stdin_base64 for binary data)
+- binary_output: how to return output that isn't text: "summary" (default), "hexdump", or "base64"
+- strip_ansi: remove color codes and other ANSI escape sequences from the output (default true)
+- filter: regex output lines must match to be kept, applied as the output is read so dropped lines don't count toward limits, e.g., "ERROR|FAIL" for a bazel build
+- pty: run commands under a pseudo-terminal, e.g., {} or {"cols": 200, "rows": 50}, for programs that need a TTY; stdout and stderr are merged
+- fail_fast: stop the command after the first block of error lines and return it, instead of waiting for the whole build or test run
+- retry: rerun commands that fail transiently, e.g., {"max_attempts": 3}; also "backoff_ms", "categories" (default ["flaky-infra", "cache-miss-timeout"]) and "exit_codes"
+- report_usage: end the result with a "Usage:" line: commands run, wall-clock and CPU time, and peak memory, plus an "Estimated duration:" line from earlier runs of the same call; use it to decide whether to run a long build in the background next time
+- dry_run: don't run anything; return each command the call would run, with its working directory, environment (request and workspace env values redacted) and the policies applied to it: timeout, run-as user, resource limits, priority, cgroup and retries
+- labels: labels attributing the call, e.g., {"task": "T-43"}, added to the session's labels (SESSION_LABELS) or replacing them; recorded in the transcript, audit records, execution events and the usage ledger
+- resource_timeline: end the result with each command's CPU and memory sampled over time, as a table and sparklines
+- max_output_lines: stop the command once stdout or stderr passes N lines; the result ends with "[output truncated at N lines; the command was stopped]"
 - transform_order: array specifying order of transformations ["grep", "sort", "unique", "head", "tail"]
+- page_size/page_token: page through long output N lines at a time; a result with more lines ends with "nextPageToken: <token>" (also in its structured content), passed back as page_token to read the next page without running anything again
 
 Default transform order: grep -> sort -> unique -> head -> tail
 
 Security constraints:
 - Paths must not contain ".." (parent directory traversal is not allowed)
 - working_dir must be an absolute path (starting with '/')
-- Certain paths may be blocked by the server configuration (BLOCKED_PATHS env var)"#;
+- Certain paths may be blocked by the server configuration (BLOCKED_PATHS env var)
+
+Every tool call is recorded in a per-session transcript, readable as the transcript://{session} resource or via the transcript tool. The history://{session} resource lists the commands that have finished, with their exit codes, durations and the end of their output. The timeline://{session} resource shows what is running and queued now, as Gantt chart JSON (timeline://{session}.svg renders it)."#;
+
+const READ_ONLY_INSTRUCTIONS: &str = "
+
+The server is running in read-only mode: tools that modify the filesystem are disabled and git only allows the status subcommand.";
 
 #[rmcp::tool_router]
 impl CommandRunnerServer {
     #[tool(description = "Default/preferred tool for directory listing. Use this instead of terminal commands or list_dir for all ls/directory listing operations.
 
+Parameters:
+- path: directory to list (default \".\")
+- respect_ignore: leave out entries ignored by the repository's .gitignore/.bazelignore files (e.g., bazel-out, node_modules)
+
 Supports output transformations:
 - grep_pattern: filter lines matching regex (e.g., \"\\.rs$\" for Rust files)
 - invert_grep: exclude matching lines instead
@@ -77,7 +367,46 @@ Security: path must not contain \"..\" and working_dir must be an absolute path.
 
 Example - list only .rs files, sorted: {\"path\": \"src\", \"grep_pattern\": \"\\\\.rs$\", \"sort\": true}")]
     fn ls_tool(&self, Parameters(req): Parameters<ToolRequest<LsRequest>>) -> String {
-        run_tool(&req, ls::execute)
+        run_tool("ls_tool", &req, ls::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for checking whether files or directories exist. Takes many paths and reports each one's type and size in a single call, ending with how many exist. Use this instead of one ls_tool call per path, e.g., when waiting for generated files to appear.
+
+Security: paths must not contain \"..\" and working_dir must be an absolute path.
+
+Example - check two generated files: {\"paths\": [\"gen/api.pb.go\", \"gen/api_grpc.pb.go\"], \"working_dir\": \"/src/app\"}")]
+    fn exists(&self, Parameters(req): Parameters<ToolRequest<ExistsRequest>>) -> String {
+        run_tool("exists", &req, exists::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for finding a file when you know roughly what it is called but not where it is. Matches case-insensitively and ignoring separators, so \"foo_service_test\" finds FooServiceTest.java, and falls back to fuzzy matching; returns paths ranked best first. Skips what .gitignore/.bazelignore ignore unless include_ignored is set. Use this instead of find or repeated ls_tool calls.
+
+Parameters:
+- query: part of the file name
+- path: directory to search under (default \".\")
+- max_results: most matches to return (default 20)
+- include_ignored: also search ignored trees such as bazel-out
+
+Security: path must not contain \"..\" and working_dir must be an absolute path.
+
+Example - find a test file: {\"query\": \"foo_service_test\", \"working_dir\": \"/src/app\"}")]
+    fn locate_file(&self, Parameters(req): Parameters<ToolRequest<LocateFileRequest>>) -> String {
+        run_tool("locate_file", &req, locate_file::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for detecting changes under a directory. Returns a Merkle-style digest of every file's name and content (skipping .git and any ignore patterns); pass an earlier digest as previous to learn whether anything changed since, e.g., since the last build.
+
+Parameters:
+- path: directory to digest (default \".\")
+- ignore: gitignore-style patterns to leave out, e.g., [\"bazel-*\", \"*.log\"]
+- respect_ignore: also leave out what the repository's .gitignore/.bazelignore files ignore
+- previous: digest from an earlier call to compare against
+
+Security: path must not contain \"..\" and working_dir must be an absolute path.
+
+Example - did src change since the last build?: {\"path\": \"src\", \"previous\": \"<digest from the last call>\"}")]
+    fn digest(&self, Parameters(req): Parameters<ToolRequest<DigestRequest>>) -> String {
+        run_tool("digest", &req, digest::execute)
     }
 
     #[tool(description = "Default/preferred tool for running git commands (status, add, commit, checkout). Use this instead of terminal commands for all git operations.
@@ -93,18 +422,614 @@ Security: working_dir must be an absolute path and must not contain \"..\".
 
 Example - show only modified files: {\"subcommand\": \"status\", \"grep_pattern\": \"modified:\"}")]
     fn git(&self, Parameters(req): Parameters<ToolRequest<GitRequest>>) -> String {
-        run_tool(&req, git::execute)
+        run_tool("git", &req, git::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for shell-style pipelines such as `cat build.log | grep err | head -50`. Runs the stages in order, each reading the previous one's output, wired together directly rather than through a shell. Succeeds or fails as its last stage does, like a shell pipeline.
+
+Parameters:
+- stages: array of {\"command\": ..., \"args\": [...]}, at most 8. Commands: cat, cut, grep, head, sort, tail, tr, uniq, wc
+
+Every stage is validated: its command must be allowed, its arguments must not contain shell characters, paths must not be blocked or contain \"..\", and options that write files (sort -o, uniq's output operand) are rejected. stdin, if given, is the first stage's input; pty doesn't apply, and retries rerun the whole pipeline.
+
+Example - first 50 errors in a log: {\"stages\": [{\"command\": \"cat\", \"args\": [\"build.log\"]}, {\"command\": \"grep\", \"args\": [\"err\"]}, {\"command\": \"head\", \"args\": [\"-50\"]}], \"working_dir\": \"/src/app\"}")]
+    fn pipeline(&self, Parameters(req): Parameters<ToolRequest<PipelineRequest>>) -> String {
+        run_tool("pipeline", &req, pipeline::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for presubmit checks. Runs the server-configured stages in order (format check -> lint -> build -> test) and returns an aggregated verdict with per-stage PASS/FAIL/SKIPPED status. Use this instead of running each check separately.
+
+Parameters:
+- targets: impacted targets to build and test (appended to the build and test commands)
+- on_failure: \"stop\" (default) skips remaining stages after a failure, \"continue\" runs them anyway
+
+Example - build and test two targets, running every stage: {\"targets\": [\"//src:lib\", \"//src:lib_test\"], \"on_failure\": \"continue\"}")]
+    fn presubmit(&self, Parameters(req): Parameters<ToolRequest<PresubmitRequest>>) -> String {
+        run_tool("presubmit", &req, presubmit::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for finding who owns a file or directory. Resolves the path against the repository's CODEOWNERS file (last matching rule wins) and OWNERS files in the path's directory and its parents, returning one owner per line with the rule or file it came from.
+
+Security: path must not contain \"..\" and working_dir must be an absolute path.
+
+Example - owners of a source file: {\"path\": \"src/server.rs\"}")]
+    fn owners(&self, Parameters(req): Parameters<ToolRequest<OwnersRequest>>) -> String {
+        run_tool("owners", &req, owners::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for host details. Reports OS and kernel, architecture, CPU count, total memory, load average, hostname, and the current time with timezone. Use this instead of guessing the machine's capacity or local time.
+
+Example - current time in UTC: {\"env\": {\"TZ\": \"UTC\"}, \"grep_pattern\": \"^time:\"}")]
+    fn host_info(&self, Parameters(req): Parameters<ToolRequest<HostInfoRequest>>) -> String {
+        run_tool("host_info", &req, host_info::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for checking GPU availability. Reports each GPU with its memory usage and utilization (via nvidia-smi or rocm-smi when installed), or that no GPUs are present. Use this to decide whether CUDA/ROCm test targets can run on this host.")]
+    fn gpu_info(&self, Parameters(req): Parameters<ToolRequest<GpuInfoRequest>>) -> String {
+        run_tool("gpu_info", &req, gpu_info::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for inspecting a built binary (ELF or Mach-O). Reports format and architecture, size, SHA-256, build ID, linked libraries, and embedded Go build info (Go version, module versions, VCS revision). Use this to verify the artifact under test is the one you built.
+
+Security: path must not contain \"..\" and working_dir must be an absolute path.
+
+Example - check the revision a binary was built from: {\"path\": \"bazel-bin/cmd/server/server_/server\", \"grep_pattern\": \"vcs\\\\.revision\"}")]
+    fn binary_info(&self, Parameters(req): Parameters<ToolRequest<BinaryInfoRequest>>) -> String {
+        run_tool("binary_info", &req, binary_info::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for checking that a binary's shared libraries can be found on this host, like ldd but without running the binary. Resolves every needed library, and the libraries those need, through RPATH/RUNPATH, the ldconfig cache and the default directories, and lists the missing ones first. Use this before rerunning a test that fails to start with a loader error.
+
+Security: path must not contain \"..\" and working_dir must be an absolute path.
+
+Example - check a test binary: {\"path\": \"bazel-bin/pkg/lib_test\", \"working_dir\": \"/src/app\"}")]
+    fn library_deps(&self, Parameters(req): Parameters<ToolRequest<LibraryDepsRequest>>) -> String {
+        run_tool("library_deps", &req, library_deps::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for debugging a Go test. Runs the test under delve (dlv) without an interactive session: sets the breakpoints and trace points, resumes the test up to max_stops times, and returns how often each was hit followed by delve's output. Each breakpoint stop shows the print expressions, or the function's arguments and locals when there are none. Use this instead of adding print statements to find out what a test sees.
+
+Parameters:
+- package: Go package holding the test, e.g., \"./pkg/parser\"
+- test: run only tests matching this regular expression
+- breakpoints/trace_points: delve locations, e.g., \"parser.go:42\" or \"parser.(*Parser).next\"
+- print: expressions to print at every breakpoint stop
+- max_stops: times to resume after a stop (default 10, maximum 100)
+
+Example - watch a token at a line: {\"package\": \"./parser\", \"test\": \"TestParse\", \"breakpoints\": [\"parser.go:42\"], \"print\": [\"tok\"], \"working_dir\": \"/src/app\"}")]
+    fn debug_test(&self, Parameters(req): Parameters<ToolRequest<DebugTestRequest>>) -> String {
+        run_tool("debug_test", &req, debug_test::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for diagnosing the execution environment. Checks required binaries and their versions (git, curl, the configured presubmit commands), bazel and its server, that the scratch area is writable and has free space, clock synchronization, and whether the server is frozen. Returns one [OK]/[WARN]/[FAIL]/[SKIP] line per check with a remediation hint for each problem. Run this first when commands fail for unclear reasons.
+
+Example - show only problems: {\"grep_pattern\": \"WARN|FAIL|hint\"}")]
+    fn doctor(&self, Parameters(req): Parameters<ToolRequest<DoctorRequest>>) -> String {
+        run_tool("doctor", &req, doctor::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for fetching toolchains and fixtures. Downloads a file from a server-allowlisted URL into the scratch area, verifies it against the given SHA-256, and returns the local path. Use this instead of curl/wget.
+
+The file is discarded if the checksum does not match or it exceeds the server's size limit. Files already downloaded with the same checksum are reused.
+
+Example: {\"url\": \"https://go.dev/dl/go1.22.0.linux-amd64.tar.gz\", \"sha256\": \"f6c8a87aa03b92c4b0bf3d558e28ea03006eb29db78917daec5cfb6ec1046265\"}")]
+    fn download(&self, Parameters(req): Parameters<ToolRequest<DownloadRequest>>) -> String {
+        run_tool("download", &req, download::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for freeing space in the server's scratch area (downloaded toolchains and fixtures). Removes every item, or only items unused for longer than older_than_secs, and reports how much space was freed.
+
+Example - remove items unused for a week: {\"older_than_secs\": 604800}")]
+    fn purge_scratch(&self, Parameters(req): Parameters<ToolRequest<PurgeScratchRequest>>) -> String {
+        run_tool("purge_scratch", &req, purge_scratch::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for exporting this session's transcript: every tool call made so far with its arguments (environment variable values redacted), status, and a one-line result summary. The same transcript is available as the transcript:// resource.
+
+Parameters:
+- format: \"json\" (default) or \"markdown\"
+- label: only include calls with this label, as \"name=value\" or just \"name\", e.g., \"task=T-42\"
+
+Example - export as Markdown: {\"format\": \"markdown\"}")]
+    fn transcript(&self, Parameters(req): Parameters<ToolRequest<TranscriptRequest>>) -> String {
+        run_tool("transcript", &req, transcript_tool::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for reporting build usage for chargeback. Totals the CPU time, wall time and output bytes of tool calls across every session sharing the server's usage ledger, by the value of a label per time window. The tenant is the \"tenant\" label, normally set for a session through SESSION_LABELS; calls without the label are totalled as \"(none)\".
+
+Parameters:
+- group_by: label to total by (default \"tenant\"), e.g., \"task\" or \"agent\"
+- window: \"hour\", \"day\" (default), \"week\" (starting Monday, UTC) or \"all\"
+- days: how many days back the report covers (default 30, at most 366)
+- format: \"text\" (default) or \"csv\", with columns window_start, the label, calls, wall_seconds, cpu_seconds and output_bytes
+
+Example - weekly CSV by task: {\"group_by\": \"task\", \"window\": \"week\", \"format\": \"csv\"}")]
+    fn usage_report(&self, Parameters(req): Parameters<ToolRequest<UsageReportRequest>>) -> String {
+        run_tool("usage_report", &req, usage_report::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for repeating an earlier call from this session. Re-runs the call with its original arguments, working_dir and env, subject to the server's current policy, and optionally diffs the new output against the original. Call numbers come from the transcript tool.
+
+Parameters:
+- call: call number of the earlier execution
+- diff: true to append a unified diff against the original output
+
+Example - re-run call 12 and show what changed: {\"call\": 12, \"diff\": true}")]
+    fn rerun(&self, Parameters(req): Parameters<ToolRequest<RerunRequest>>) -> String {
+        run_tool("rerun", &req, |inner, ctx| {
+            // A dry run replays the call as a dry run too
+            let dry_run = ctx.dry_run;
+            rerun::execute(inner, |tool, mut input| {
+                if dry_run {
+                    input["dry_run"] = Value::Bool(true);
+                }
+                dispatch(tool, input)
+            })
+        })
+    }
+
+    #[tool(description = "Default/preferred tool for seeing what changed between two earlier calls in this session, e.g., a passing and a failing build. Returns a unified diff of their outputs. Timestamps, temp paths, memory addresses and durations are normalized first so only meaningful differences remain.
+
+Parameters:
+- before: call number of the earlier execution
+- after: call number of the later execution
+- raw: true to compare the outputs exactly
+
+Example: {\"before\": 3, \"after\": 9}")]
+    fn diff_outputs(&self, Parameters(req): Parameters<ToolRequest<DiffOutputsRequest>>) -> String {
+        run_tool("diff_outputs", &req, diff_outputs::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for investigating a failed call in this session. Returns one focused report instead of re-reading the whole output: the failure category, the error lines, an excerpt of the output around the first error, and the source line of each file referenced in the output.
+
+Parameters:
+- call: call number of the failed execution (default: the most recent failure)
+
+Example: {} or {\"call\": 12}")]
+    fn explain_failure(&self, Parameters(req): Parameters<ToolRequest<ExplainFailureRequest>>) -> String {
+        run_tool("explain_failure", &req, explain_failure::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for triaging a crash from its stack trace. Finds the file:line references in a Go panic, C++ sanitizer, gdb or similar trace, resolves them against the workspace, and returns each frame with the surrounding source. Prefixes from CI machines and bazel sandboxes are stripped, and bazel external repositories are found through the execution root. Use this instead of opening each frame's file separately.
+
+Parameters:
+- trace: the stack trace, as printed
+- context_lines: source lines before and after each frame's line (default 3, maximum 20)
+
+Example - resolve a Go panic: {\"trace\": \"main.main()\\n\\t/home/ci/src/app/main.go:12 +0x25\", \"working_dir\": \"/src/app\"}")]
+    fn symbolicate(&self, Parameters(req): Parameters<ToolRequest<SymbolicateRequest>>) -> String {
+        run_tool("symbolicate", &req, symbolicate::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for snapshot-style checks. Runs another tool with the given arguments and compares its output to a golden file, returning PASS or FAIL with a unified diff. Timestamps, temp paths, memory addresses and durations are normalized first.
+
+Parameters:
+- tool: tool to run, e.g., \"git\" or \"presubmit\"
+- arguments: arguments for that tool, as they would be passed to it directly
+- golden: golden file path, relative to working_dir
+- update: true to write the current output to the golden file instead of comparing
+- raw: true to compare the output exactly
+
+Example: {\"tool\": \"git\", \"arguments\": {\"subcommand\": \"status\", \"args\": [\"--short\"]}, \"golden\": \"testdata/status.golden\", \"working_dir\": \"/repo\"}")]
+    fn golden(&self, Parameters(req): Parameters<ToolRequest<GoldenRequest>>) -> String {
+        run_tool("golden", &req, |inner, ctx| golden::execute(inner, ctx, dispatch))
+    }
+
+    #[tool(description = "Default/preferred tool for long tool calls such as bazel builds and full presubmits. Starts another tool with the given arguments in the background and returns a job ID immediately, instead of blocking until the call finishes. The call runs under the same policy as a direct one, including its timeout_ms. At most 4 jobs run at once.
+
+Parameters:
+- tool: tool to run, e.g., \"presubmit\"
+- arguments: arguments for that tool, as they would be passed to it directly
+
+Example: {\"tool\": \"presubmit\", \"arguments\": {\"timeout_ms\": 3600000}, \"working_dir\": \"/repo\"}")]
+    fn job_start(&self, Parameters(req): Parameters<ToolRequest<JobStartRequest>>) -> String {
+        run_tool("job_start", &req, |inner, ctx| {
+            job_start::execute(inner, ctx, req.labels.as_ref(), dispatch)
+        })
+    }
+
+    #[tool(description = "Default/preferred tool for checking on background jobs started with job_start. Returns whether a job is running, succeeded, failed or was cancelled, how long it has run, and how much output it has written; for a finished job, also the tool call's result. Without job_id, lists every job.
+
+Parameters:
+- job_id: ID returned by job_start (optional)
+
+Example: {\"job_id\": 3}")]
+    fn job_status(&self, Parameters(req): Parameters<ToolRequest<JobStatusRequest>>) -> String {
+        run_tool("job_status", &req, job_status::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for reading the output of a background job while it runs or after it finishes. Returns the output of the job's commands from a byte offset, ending with a line giving the job's state and the offset to read from next.
+
+Parameters:
+- job_id: ID returned by job_start
+- offset: byte offset to read from (default 0); pass the previous call's next offset to follow the output
+- max_bytes: most bytes to return (default and maximum 65536)
+
+Example: {\"job_id\": 3, \"offset\": 65536}")]
+    fn job_logs(&self, Parameters(req): Parameters<ToolRequest<JobLogsRequest>>) -> String {
+        run_tool("job_logs", &req, job_logs::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for stopping a background job. The command the job is running is sent SIGTERM, then SIGKILL after the grace period, and nothing more runs.
+
+Parameters:
+- job_id: ID returned by job_start
+
+Example: {\"job_id\": 3}")]
+    fn job_cancel(&self, Parameters(req): Parameters<ToolRequest<JobCancelRequest>>) -> String {
+        run_tool("job_cancel", &req, job_cancel::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for seeing what is still running. Lists every command in flight in this session, in the foreground or in background jobs: its invocation ID, which cancel takes, the tool that ran it, its job if it runs in one, its command line, when it started, how long it has run, and how many output lines it has produced so far.
+
+Parameters:
+- tool: only list commands run by this tool, e.g., \"presubmit\"
+
+Example: {}")]
+    fn ps_jobs(&self, Parameters(req): Parameters<ToolRequest<PsJobsRequest>>) -> String {
+        run_tool("ps_jobs", &req, ps_jobs::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for stopping a running command, such as a build started by mistake or one that has hung. Sends SIGTERM to the command's process group, then SIGKILL after the server's grace period, and stops it from being retried. The call that ran the command returns the output read so far, ending with \"[command #N was cancelled after ...]\". To stop a background job and everything it would run next, use job_cancel instead.
+
+Parameters:
+- id: invocation ID of the command, as listed by ps_jobs (the number after '#')
+
+Example: {\"id\": 4}")]
+    fn cancel(&self, Parameters(req): Parameters<ToolRequest<CancelRequest>>) -> String {
+        run_tool("cancel", &req, cancel::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for reading the full output of a command after its call returned. Each command's stdout and stderr are kept on disk as they are read, interleaved, under its invocation ID; a result whose output was cut to fit ends by naming it. Returns the output from a byte offset, ending with a line giving the command, whether it is still running, and the offset to read from next. Output past the server's per-command cap isn't kept, and the oldest commands' output is removed to stay within its total cap.
+
+Parameters:
+- id: invocation ID of the command, as named at the end of a cut-off result or listed by ps_jobs
+- offset: byte offset to read from (default 0); pass the previous call's next offset to follow the output
+- max_bytes: most bytes to return (default and maximum 65536)
+
+Example: {\"id\": 12, \"offset\": 65536}")]
+    fn get_output(&self, Parameters(req): Parameters<ToolRequest<GetOutputRequest>>) -> String {
+        run_tool("get_output", &req, get_output::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for looking back at the commands this session ran. Lists the commands that have finished, newest first: each one's invocation ID, tool, command line, exit code, duration and start time, followed by the last lines of its result. The server keeps the last 200 commands; the same history, with the last 4 KiB of each result, is the history:// resource. For a command's full output, use get_output with its invocation ID.
+
+Parameters:
+- tool: only list commands run by this tool, e.g., \"presubmit\"
+- exit_code: only list commands that exited with this code
+- limit: most commands to list (default: 20)
+
+Example: {\"tool\": \"presubmit\", \"exit_code\": 1}")]
+    fn history(&self, Parameters(req): Parameters<ToolRequest<HistoryRequest>>) -> String {
+        run_tool("history", &req, history_tool::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for checking whether a bazel build is reproducible, e.g., when chasing remote cache poisoning. Builds the targets twice, without taking outputs from the remote or disk cache, hashes every output file after each build, and lists the outputs whose hashes differ or that only one build produced. Between builds the workspace's output base is cleaned, or with clean_output_bases each build runs in a new output base that is removed afterwards. Use working_dir to pick the workspace.
+
+Parameters:
+- targets: targets to build, e.g., [\"//src:server\"]
+- clean_output_bases: build in new output bases, leaving the workspace's alone (default: false)
+
+Example: {\"working_dir\": \"/src/app\", \"targets\": [\"//src:server\"], \"clean_output_bases\": true}")]
+    fn repro_check(&self, Parameters(req): Parameters<ToolRequest<ReproCheckRequest>>) -> String {
+        run_tool("repro_check", &req, repro_check::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for producing a software bill of materials (SBOM) of the workspace or a built artifact, e.g., for license or vulnerability compliance. Returns the SBOM as structured content, as SPDX 2.3 or CycloneDX 1.5 JSON. Uses syft when it is installed; otherwise reads the modules required by go.mod and resolved in MODULE.bazel.lock, or for an artifact, the modules built into a Go binary.
+
+Parameters:
+- path: directory or artifact to describe, relative to working_dir (default: working_dir)
+- format: \"spdx\" (default) or \"cyclonedx\"
+
+Example: {\"working_dir\": \"/src/app\", \"path\": \"bazel-bin/cmd/server/server_/server\", \"format\": \"cyclonedx\"}")]
+    fn sbom(&self, Parameters(req): Parameters<ToolRequest<SbomRequest>>) -> CallToolResult {
+        structured_result(run_tool("sbom", &req, sbom::execute))
+    }
+
+    #[tool(description = "Default/preferred tool for checking dependencies' licenses before a release. Scans the Go packages' dependencies with go-licenses, or with targets, the rules_license license() targets the Bazel targets depend on, and returns a structured report: a verdict (pass, review when some licenses are unknown, or fail when the server's policy denies some), the flagged dependencies, and every dependency with its license and status. Use working_dir to pick the workspace.
+
+Parameters:
+- packages: Go packages to scan with go-licenses (default: \"./...\")
+- targets: Bazel targets to scan instead, e.g., [\"//cmd/server\"]
+
+Example: {\"working_dir\": \"/src/app\", \"targets\": [\"//cmd/server\"]}")]
+    fn license_scan(&self, Parameters(req): Parameters<ToolRequest<LicenseScanRequest>>) -> CallToolResult {
+        structured_result(run_tool("license_scan", &req, license_scan::execute))
+    }
+
+    #[tool(description = "Default/preferred tool for gathering what is needed to draft a commit message or changelog entry. Returns a structured bundle: git diff --stat against base, each changed file with its status (including untracked files), the Bazel packages the files belong to, and the bazel test and go test runs in this session's command history with the result lines of their output and a verdict from the most recent run. Use working_dir to pick the repository.
+
+Parameters:
+- base: revision to compare with, e.g., \"origin/main\" (default: \"HEAD\")
+- staged: only cover staged changes (default: false)
+
+Example: {\"working_dir\": \"/src/app\", \"base\": \"origin/main\"}")]
+    fn change_summary(&self, Parameters(req): Parameters<ToolRequest<ChangeSummaryRequest>>) -> CallToolResult {
+        structured_result(run_tool("change_summary", &req, change_summary::execute))
+    }
+
+    #[tool(description = "Default/preferred tool for finding out how much disk bazel uses. Reports the size of the workspace's output base, repository cache and disk cache (from --disk_cache in .bazelrc), and the free space on the disk holding the output base. With gc, collects garbage if the disk is under pressure, as far as the server's policy allows: trims the least recently used entries of the disk and repository caches, then runs bazel clean --expunge_async if space is still short. Use working_dir to pick the workspace.
+
+Parameters:
+- gc: collect garbage when the disk is under pressure (default: false)
+
+Example: {\"working_dir\": \"/src/app\", \"gc\": true}")]
+    fn bazel_cache(&self, Parameters(req): Parameters<ToolRequest<BazelCacheRequest>>) -> String {
+        run_tool("bazel_cache", &req, bazel_cache::execute)
+    }
+
+    #[tool(description = "Default/preferred tool for seeing what a call run with overlay: true changed. Such a call's commands run on a copy-on-write overlay of working_dir: they see a writable tree, but their changes go to a separate layer and the checkout is never touched. Lists each changed path as A (added), M (modified) or D (deleted), then their unified diffs, ready for git apply or patch -p1.
+
+Parameters:
+- id: ID of the overlay, as named at the end of the call's result
+- name_only: only list the changed paths (default: false)
+
+Example: {\"id\": 2}")]
+    fn overlay_diff(&self, Parameters(req): Parameters<ToolRequest<OverlayDiffRequest>>) -> String {
+        run_tool("overlay_diff", &req, overlay_diff::execute)
+    }
+}
+
+/// A tool's result as structured content if it is a JSON object, e.g., an SBOM document. Anything else, such
+/// as an error, a dry run or output reshaped by grep_pattern or page_size, isn't what the tool's output schema
+/// describes, so it is returned as text and marked as an error, as the protocol requires.
+fn structured_result(output: String) -> CallToolResult {
+    match serde_json::from_str::<Value>(&output) {
+        Ok(value) if value.is_object() => CallToolResult::structured(value),
+        _ => CallToolResult::error(vec![Content::text(output)]),
+    }
+}
+
+/// Output schema of a tool whose results are structured content
+fn output_schema(tool: &str) -> Option<Value> {
+    match tool {
+        "sbom" => Some(sbom::output_schema()),
+        "license_scan" => Some(license_scan::output_schema()),
+        "change_summary" => Some(change_summary::output_schema()),
+        _ => None,
+    }
+}
+
+/// Server instructions, noting tools disabled by read-only mode or failed startup checks
+fn instructions() -> String {
+    let mut instructions = SERVER_INSTRUCTIONS.to_string();
+    if is_read_only() {
+        instructions.push_str(READ_ONLY_INSTRUCTIONS);
+        if grants::enabled() {
+            instructions.push_str(" An operator can grant this session temporary access to them.");
+        }
+    }
+    let disabled = preflight::disabled();
+    if !disabled.is_empty() {
+        instructions.push_str("\n\nThese tools are unavailable because startup checks failed:");
+        for problem in disabled {
+            instructions.push_str(&format!("\n- {}: {}", problem.tool, problem.detail));
+        }
+    }
+    instructions
+}
+
+/// Sends a call's progress to its client as MCP progress notifications with the call's progress token
+fn progress_reporter(peer: Peer<RoleServer>, progress_token: ProgressToken) -> Reporter {
+    Reporter::new(move |progress, message| {
+        let peer = peer.clone();
+        let notification = ProgressNotificationParam {
+            progress_token: progress_token.clone(),
+            progress,
+            total: None,
+            message: Some(message),
+        };
+        // Tools run synchronously, so the notification is sent by another task while the call waits
+        tokio::spawn(async move {
+            if let Err(e) = peer.notify_progress(notification).await {
+                tracing::warn!("Failed to send a progress notification: {}", e);
+            }
+        });
+    })
+}
+
+/// Sends messages from the server's own work to the client as MCP logging notifications, for client_log
+pub fn client_logger(peer: Peer<RoleServer>) -> impl Fn(&str, &str) + Send + Sync + 'static {
+    // Messages come from the server's own threads, outside the runtime
+    let runtime = tokio::runtime::Handle::current();
+    move |logger, message| {
+        let peer = peer.clone();
+        let notification = LoggingMessageNotificationParam {
+            level: LoggingLevel::Info,
+            logger: Some(logger.to_string()),
+            data: Value::String(message.to_string()),
+        };
+        runtime.spawn(async move {
+            if let Err(e) = peer.notify_logging_message(notification).await {
+                tracing::warn!("Failed to send a log message to the client: {}", e);
+            }
+        });
     }
 }
 
-#[rmcp::tool_handler]
 impl ServerHandler for CommandRunnerServer {
     fn get_info(&self) -> ServerInfo {
         ServerInfo {
-            protocol_version: ProtocolVersion::V_2024_11_05,
-            capabilities: ServerCapabilities::builder().enable_tools().build(),
+            // The first version with structured content and output schemas
+            protocol_version: ProtocolVersion::V_2025_06_18,
+            capabilities: ServerCapabilities::builder().enable_tools().enable_resources().enable_logging().build(),
             server_info: Implementation::from_build_env(),
-            instructions: Some(SERVER_INSTRUCTIONS.to_string()),
+            instructions: Some(instructions()),
         }
     }
+
+    async fn set_level(
+        &self,
+        SetLevelRequestParam { level }: SetLevelRequestParam,
+        _context: RequestContext<RoleServer>,
+    ) -> Result<(), ErrorData> {
+        client_log::set_info_enabled(matches!(level, LoggingLevel::Debug | LoggingLevel::Info));
+        Ok(())
+    }
+
+    async fn list_tools(
+        &self,
+        _request: Option<PaginatedRequestParam>,
+        _context: RequestContext<RoleServer>,
+    ) -> Result<ListToolsResult, ErrorData> {
+        let tools = self
+            .tool_router
+            .list_all()
+            .into_iter()
+            .map(|mut tool| {
+                if let Some(Value::Object(schema)) = output_schema(&tool.name) {
+                    tool.output_schema = Some(Arc::new(schema));
+                }
+                tool
+            })
+            .collect();
+        Ok(ListToolsResult::with_all_items(tools))
+    }
+
+    async fn call_tool(
+        &self,
+        request: CallToolRequestParam,
+        context: RequestContext<RoleServer>,
+    ) -> Result<CallToolResult, ErrorData> {
+        // A client that sends a progress token hears why its call is queued while it waits
+        let reporter = context.meta.get_progress_token().map(|token| progress_reporter(context.peer.clone(), token));
+        let call = ToolCallContext::new(self, request, context);
+        let (result, next_page_token) = progress::scope(reporter, paging::scope(self.tool_router.call(call))).await;
+        let mut result = result?;
+        // The text of a paged result ends with the same token, for clients that don't read structured content
+        if let Some(token) = next_page_token {
+            result.structured_content = Some(serde_json::json!({ "nextPageToken": token }));
+        }
+        Ok(result)
+    }
+
+    async fn list_resources(
+        &self,
+        _request: Option<PaginatedRequestParam>,
+        _context: RequestContext<RoleServer>,
+    ) -> Result<ListResourcesResult, ErrorData> {
+        let mut resource = RawResource::new(transcript_uri(), "Session transcript");
+        resource.description = Some("Tool calls made in this session, with redacted inputs and result summaries".to_string());
+        resource.mime_type = Some("text/markdown".to_string());
+        let mut history = RawResource::new(history_uri(), "Command history");
+        history.description = Some(
+            "Commands that have finished, with their argv, exit code, duration and the end of their output".to_string(),
+        );
+        history.mime_type = Some("application/json".to_string());
+        let mut timeline = RawResource::new(timeline_uri(), "Execution timeline");
+        timeline.description = Some(
+            "Running commands, background jobs and calls queued by admission control, as Gantt chart JSON".to_string(),
+        );
+        timeline.mime_type = Some("application/json".to_string());
+        let mut timeline_svg = RawResource::new(timeline_svg_uri(), "Execution timeline chart");
+        timeline_svg.description = Some("The execution timeline rendered as an SVG Gantt chart".to_string());
+        timeline_svg.mime_type = Some("image/svg+xml".to_string());
+        Ok(ListResourcesResult::with_all_items(vec![
+            resource.no_annotation(),
+            history.no_annotation(),
+            timeline.no_annotation(),
+            timeline_svg.no_annotation(),
+        ]))
+    }
+
+    async fn read_resource(
+        &self,
+        ReadResourceRequestParam { uri }: ReadResourceRequestParam,
+        _context: RequestContext<RoleServer>,
+    ) -> Result<ReadResourceResult, ErrorData> {
+        // Read when asked for, so the timeline is live
+        let text = if uri == transcript_uri() {
+            transcript::to_markdown(transcript::session_id(), &transcript::entries())
+        } else if uri == history_uri() {
+            history::commands_to_json(&history::commands())
+        } else if uri == timeline_uri() {
+            timeline::snapshot().to_json()
+        } else if uri == timeline_svg_uri() {
+            timeline::snapshot().to_svg()
+        } else {
+            return Err(ErrorData::resource_not_found(
+                "resource_not_found",
+                Some(serde_json::json!({ "uri": uri })),
+            ));
+        };
+        Ok(ReadResourceResult {
+            contents: vec![ResourceContents::text(text, uri)],
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::security;
+    use crate::tools::HostInfoRequest;
+    use std::fs;
+    use std::time::{SystemTime, UNIX_EPOCH};
+    use tempfile::TempDir;
+
+    fn make_request() -> ToolRequest<HostInfoRequest> {
+        serde_json::from_str("{}").unwrap()
+    }
+
+    #[test]
+    fn test_run_tool_recovers_from_panic() {
+        let output = run_tool("host_info", &make_request(), |_, _| -> String { panic!("boom") });
+        assert!(output.starts_with("Error: INTERNAL: The host_info tool failed unexpectedly."));
+        assert!(output.contains(&format!("correlation ID {}-", transcript::session_id())));
+    }
+
+    #[test]
+    fn test_run_tool_records_call() {
+        let output = run_tool("host_info", &make_request(), |_, _| "test_run_tool_records_call".to_string());
+        assert_eq!(output, "test_run_tool_records_call");
+        let entry = transcript::entries()
+            .into_iter()
+            .find(|e| e.summary == "test_run_tool_records_call")
+            .unwrap();
+        assert_eq!(history::get(entry.call).unwrap().output, output);
+    }
+
+    #[test]
+    fn test_run_validated_rejects_expired_and_other_session_grants() {
+        let temp_dir = TempDir::new().unwrap();
+        let secret = temp_dir.path().canonicalize().unwrap().join("secret");
+        fs::create_dir(&secret).unwrap();
+        security::set_blocked_paths_for_test(&[secret.to_string_lossy().to_string()]);
+        let grants_file = temp_dir.path().join("grants");
+        grants::set_grants_file_for_test(&grants_file);
+        let req: ToolRequest<LsRequest> = serde_json::from_value(serde_json::json!({ "path": secret })).unwrap();
+        let list = |_: &LsRequest, _: &ExecutionContext| "listed".to_string();
+
+        let now = SystemTime::now().duration_since(UNIX_EPOCH).unwrap().as_secs();
+        let session = transcript::session_id();
+        let grant = |expires: u64, session: &str| format!("{} {} path:{} audit\n", expires, session, secret.display());
+        fs::write(&grants_file, grant(now - 60, session) + &grant(now + 600, "other-session")).unwrap();
+        let rejection = run_validated("ls_tool", &req, list).unwrap_err();
+        assert!(rejection.contains(&secret.to_string_lossy().to_string()), "{}", rejection);
+
+        fs::write(&grants_file, grant(now + 600, session)).unwrap();
+        assert_eq!(run_validated("ls_tool", &req, list), Ok("listed".to_string()));
+    }
+
+    #[test]
+    fn test_structured_result() {
+        let result = structured_result(r#"{"verdict": "pass"}"#.to_string());
+        assert_eq!(result.structured_content, Some(serde_json::json!({"verdict": "pass"})));
+        assert_ne!(result.is_error, Some(true));
+        let result = structured_result("Error: go-licenses failed".to_string());
+        assert_eq!(result.structured_content, None);
+        assert_eq!(result.is_error, Some(true));
+        for tool in ["sbom", "license_scan", "change_summary"] {
+            assert_eq!(output_schema(tool).unwrap()["type"], "object");
+        }
+        assert!(output_schema("ls_tool").is_none());
+    }
+
+    #[test]
+    fn test_panic_message() {
+        let payload = panic::catch_unwind(|| panic!("static message")).unwrap_err();
+        assert_eq!(panic_message(payload.as_ref()), "static message");
+        let payload = panic::catch_unwind(|| panic!("formatted {}", 42)).unwrap_err();
+        assert_eq!(panic_message(payload.as_ref()), "formatted 42");
+    }
 }
@@ -0,0 +1,184 @@
+use regex::Regex;
+use std::sync::LazyLock;
+
+/// Severity of an output line
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Severity {
+    Info,
+    Warning,
+    Error,
+}
+
+impl Severity {
+    fn parse(name: &str) -> Option<Self> {
+        match name {
+            "error" => Some(Severity::Error),
+            "warning" => Some(Severity::Warning),
+            "info" => Some(Severity::Info),
+            _ => None,
+        }
+    }
+
+    pub fn name(self) -> &'static str {
+        match self {
+            Severity::Info => "info",
+            Severity::Warning => "warning",
+            Severity::Error => "error",
+        }
+    }
+}
+
+/// Built-in rules, checked in order after any custom rules. Lines matching none are info.
+const DEFAULT_RULES: &[(Severity, &str)] = &[
+    (
+        Severity::Error,
+        r"(?i)(^|[^a-z])(error|fatal|failed|panicked)([^a-z]|$)|^FAIL\b|^--- FAIL:",
+    ),
+    (Severity::Warning, r"(?i)(^|[^a-z])(warning|warn|deprecated)([^a-z]|$)"),
+];
+
+/// A rule giving lines that match `pattern` a severity
+struct Rule {
+    severity: Severity,
+    pattern: Regex,
+}
+
+impl Rule {
+    fn new(severity: Severity, pattern: &str) -> Option<Self> {
+        match Regex::new(pattern) {
+            Ok(pattern) => Some(Self { severity, pattern }),
+            Err(e) => {
+                tracing::warn!("Ignoring severity rule '{}': {}", severity.name(), e);
+                None
+            }
+        }
+    }
+}
+
+/// Parse custom rules. Format: semicolon-separated "severity=regex" pairs where severity is
+/// error, warning or info, e.g., "info=0 errors;error=^ERROR:"
+fn parse_rules(spec: &str) -> Vec<Rule> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .filter_map(|(name, pattern)| match Severity::parse(name.trim()) {
+            Some(severity) => Rule::new(severity, pattern),
+            None => {
+                tracing::warn!("Ignoring severity rule with unknown severity '{}'", name.trim());
+                None
+            }
+        })
+        .collect()
+}
+
+/// Severity rules: custom rules from SEVERITY_RULES (loaded at startup) take precedence
+/// over the built-in defaults.
+static RULES: LazyLock<Vec<Rule>> = LazyLock::new(|| {
+    let mut rules = parse_rules(&std::env::var("SEVERITY_RULES").unwrap_or_default());
+    rules.extend(
+        DEFAULT_RULES
+            .iter()
+            .filter_map(|(severity, pattern)| Rule::new(*severity, pattern)),
+    );
+    rules
+});
+
+/// Severity of a single output line
+pub fn severity(line: &str) -> Severity {
+    severity_impl(line, &RULES)
+}
+
+/// Internal implementation for testability - takes rules as parameter.
+fn severity_impl(line: &str, rules: &[Rule]) -> Severity {
+    rules
+        .iter()
+        .find(|rule| rule.pattern.is_match(line))
+        .map(|rule| rule.severity)
+        .unwrap_or(Severity::Info)
+}
+
+/// Prefix error and warning lines with "[error] " or "[warning] "; info lines are unchanged.
+/// A leading "Error" line and "Failure category:" lines are the server's own status lines
+/// and are left as they are, so results still read as failures.
+pub fn annotate(output: &str) -> String {
+    annotate_impl(output, &RULES)
+}
+
+/// Internal implementation for testability - takes rules as parameter.
+fn annotate_impl(output: &str, rules: &[Rule]) -> String {
+    output
+        .lines()
+        .enumerate()
+        .map(|(i, line)| {
+            if (i == 0 && line.starts_with("Error")) || line.starts_with("Failure category: ") {
+                return line.to_string();
+            }
+            match severity_impl(line, rules) {
+                Severity::Info => line.to_string(),
+                severity => format!("[{}] {}", severity.name(), line),
+            }
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Number of error and warning lines in an output, not counting "Failure category:" lines
+pub fn counts(output: &str) -> (usize, usize) {
+    let lines = output.lines().filter(|line| !line.starts_with("Failure category: "));
+    lines.fold((0, 0), |(errors, warnings), line| match severity(line) {
+        Severity::Error => (errors + 1, warnings),
+        Severity::Warning => (errors, warnings + 1),
+        Severity::Info => (errors, warnings),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn defaults() -> Vec<Rule> {
+        DEFAULT_RULES
+            .iter()
+            .filter_map(|(severity, pattern)| Rule::new(*severity, pattern))
+            .collect()
+    }
+
+    #[test]
+    fn test_default_rules() {
+        let rules = defaults();
+        assert_eq!(severity_impl("error[E0308]: mismatched types", &rules), Severity::Error);
+        assert_eq!(severity_impl("--- FAIL: TestParse (0.00s)", &rules), Severity::Error);
+        assert_eq!(severity_impl("fatal: not a git repository", &rules), Severity::Error);
+        assert_eq!(severity_impl("warning: unused variable: `x`", &rules), Severity::Warning);
+        assert_eq!(severity_impl("   Compiling app v0.1.0", &rules), Severity::Info);
+        // Words merely containing a keyword don't count
+        assert_eq!(severity_impl("terrorist_detector.rs compiled", &rules), Severity::Info);
+    }
+
+    #[test]
+    fn test_custom_rules_take_precedence() {
+        let mut rules = parse_rules("info=0 errors;error=^ERROR:;bogus=x");
+        rules.extend(defaults());
+        assert_eq!(severity_impl("Build finished with 0 errors", &rules), Severity::Info);
+        assert_eq!(severity_impl("ERROR: //src:lib failed to build", &rules), Severity::Error);
+        assert_eq!(rules.len(), 4);
+    }
+
+    #[test]
+    fn test_annotate() {
+        assert_eq!(
+            annotate_impl("Compiling\nwarning: unused\nerror: aborting", &defaults()),
+            "Compiling\n[warning] warning: unused\n[error] error: aborting"
+        );
+        assert_eq!(
+            annotate_impl("Error: error: aborting\nFailure category: compile-error", &defaults()),
+            "Error: error: aborting\nFailure category: compile-error"
+        );
+    }
+
+    #[test]
+    fn test_counts() {
+        assert_eq!(counts("ok\nwarning: a\nwarning: b\nerror: c"), (1, 2));
+        assert_eq!(counts("Error: boom\nFailure category: compile-error"), (1, 0));
+        assert_eq!(counts(""), (0, 0));
+    }
+}
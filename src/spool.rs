@@ -0,0 +1,229 @@
+use std::cell::RefCell;
+use std::collections::VecDeque;
+use std::fs::File;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, LazyLock, Mutex};
+
+use crate::jobs::read_file;
+use crate::scratch::scratch_dir;
+use crate::transcript;
+
+/// Most invocations whose output is kept for get_output; the oldest finished ones are removed first
+pub const MAX_SPOOLED: usize = 200;
+
+/// Default cap on each invocation's spooled output (16 MiB)
+const DEFAULT_SPOOL_MAX_BYTES: u64 = 16 * 1024 * 1024;
+
+/// Cap on each invocation's spooled output loaded from SPOOL_MAX_BYTES environment variable at startup.
+/// Output past it is left out of the spool; 0 turns spooling off.
+static SPOOL_MAX_BYTES: LazyLock<u64> = LazyLock::new(|| {
+    std::env::var("SPOOL_MAX_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_SPOOL_MAX_BYTES)
+});
+
+/// Default cap on all spooled output (256 MiB)
+const DEFAULT_SPOOL_TOTAL_BYTES: u64 = 256 * 1024 * 1024;
+
+/// Cap on all spooled output loaded from SPOOL_TOTAL_BYTES environment variable at startup.
+/// Past it, the files of the oldest finished invocations are removed.
+static SPOOL_TOTAL_BYTES: LazyLock<u64> = LazyLock::new(|| {
+    std::env::var("SPOOL_TOTAL_BYTES")
+        .ok()
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_SPOOL_TOTAL_BYTES)
+});
+
+/// Spooled invocations, oldest first
+static SPOOLED: Mutex<VecDeque<Arc<Spooled>>> = Mutex::new(VecDeque::new());
+
+thread_local! {
+    /// Invocations spooled on this thread since the last take_spooled
+    static SPOOLED_HERE: RefCell<Vec<u64>> = const { RefCell::new(Vec::new()) };
+}
+
+/// The output of a command invocation, stdout and stderr interleaved as they were read, written to a file
+/// in the spool directory so it can be read back after the call returns
+#[derive(Debug)]
+pub struct Spooled {
+    /// Invocation ID of the command
+    pub id: u64,
+    pub tool: String,
+    pub command: String,
+    /// When the command started, in seconds since the Unix epoch
+    pub started: u64,
+    path: PathBuf,
+    file: Mutex<Option<File>>,
+    /// Cap on the bytes written to the file
+    max: u64,
+    /// Bytes of output so far, including any past the cap
+    len: AtomicU64,
+    /// Bytes of output in the file, from the start
+    written: AtomicU64,
+    finished: AtomicBool,
+}
+
+impl Spooled {
+    /// Add output the command wrote
+    pub fn append(&self, data: &[u8]) {
+        let mut file = self.file.lock().unwrap_or_else(|e| e.into_inner());
+        if let Some(ref mut f) = *file {
+            let written = self.written.load(Ordering::Relaxed);
+            let n = (self.max.saturating_sub(written) as usize).min(data.len());
+            match f.write_all(&data[..n]) {
+                Ok(()) => {
+                    self.written.fetch_add(n as u64, Ordering::Relaxed);
+                }
+                Err(e) => {
+                    tracing::warn!("Failed to write spooled output of invocation {}: {}", self.id, e);
+                    *file = None;
+                }
+            }
+        }
+        self.len.fetch_add(data.len() as u64, Ordering::Relaxed);
+    }
+
+    /// Bytes of output so far, including any not spooled
+    pub fn output_len(&self) -> u64 {
+        self.len.load(Ordering::Relaxed)
+    }
+
+    /// Bytes of output that can be read back
+    pub fn spooled(&self) -> u64 {
+        self.written.load(Ordering::Relaxed)
+    }
+
+    pub fn is_finished(&self) -> bool {
+        self.finished.load(Ordering::Relaxed)
+    }
+
+    /// Up to `max` bytes of spooled output from `offset`
+    pub fn read(&self, offset: u64, max: usize) -> std::io::Result<Vec<u8>> {
+        let len = max.min(self.spooled().saturating_sub(offset) as usize);
+        read_file(&self.path, offset, len)
+    }
+
+    /// Note that the command has exited and its output is complete, then remove old output past the caps
+    pub fn finish(&self) {
+        self.file.lock().unwrap_or_else(|e| e.into_inner()).take();
+        self.finished.store(true, Ordering::Relaxed);
+        rotate(&mut SPOOLED.lock().unwrap_or_else(|e| e.into_inner()), MAX_SPOOLED, *SPOOL_TOTAL_BYTES);
+    }
+}
+
+/// Start spooling the output of an invocation. None if spooling is off or its file can't be created.
+pub fn create(id: u64, tool: &str, command: &str, started: u64) -> Option<Arc<Spooled>> {
+    if *SPOOL_MAX_BYTES == 0 {
+        return None;
+    }
+    let spooled = create_in(&scratch_dir().join("spool"), id, tool, command, started, *SPOOL_MAX_BYTES)?;
+    let mut all = SPOOLED.lock().unwrap_or_else(|e| e.into_inner());
+    all.push_back(spooled.clone());
+    rotate(&mut all, MAX_SPOOLED, *SPOOL_TOTAL_BYTES);
+    SPOOLED_HERE.with(|here| here.borrow_mut().push(id));
+    Some(spooled)
+}
+
+/// Invocation IDs spooled on this thread since the last call, oldest first. A tool call runs its commands
+/// on the calling thread, so taking them before and after the call gives the call's invocations.
+pub fn take_spooled() -> Vec<u64> {
+    SPOOLED_HERE.with(|here| here.take())
+}
+
+/// Internal implementation for testability - takes the spool directory and the cap on the file as parameters.
+fn create_in(dir: &Path, id: u64, tool: &str, command: &str, started: u64, max: u64) -> Option<Arc<Spooled>> {
+    let path = dir.join(format!("{}-{}.log", transcript::session_id(), id));
+    let file = match std::fs::create_dir_all(dir).and_then(|()| File::create(&path)) {
+        Ok(file) => file,
+        Err(e) => {
+            tracing::warn!("Failed to create spool file {}; not keeping its output: {}", path.display(), e);
+            return None;
+        }
+    };
+    Some(Arc::new(Spooled {
+        id,
+        tool: tool.to_string(),
+        command: command.to_string(),
+        started,
+        path,
+        file: Mutex::new(Some(file)),
+        max,
+        len: AtomicU64::new(0),
+        written: AtomicU64::new(0),
+        finished: AtomicBool::new(false),
+    }))
+}
+
+/// Spooled output of a "bazel test //..." invocation that isn't kept, for tests
+#[cfg(test)]
+pub fn detached(dir: &Path, id: u64, tool: &str, max: u64) -> Arc<Spooled> {
+    create_in(dir, id, tool, "bazel test //...", 0, max).unwrap()
+}
+
+/// The spooled output of an invocation, if it is still kept
+pub fn get(id: u64) -> Option<Arc<Spooled>> {
+    let all = SPOOLED.lock().unwrap_or_else(|e| e.into_inner());
+    all.iter().find(|spooled| spooled.id == id).cloned()
+}
+
+/// Remove the oldest finished invocations, and their files, until at most `max_count` are kept and their
+/// files hold at most `max_bytes`. Output of commands still running is never removed.
+fn rotate(all: &mut VecDeque<Arc<Spooled>>, max_count: usize, max_bytes: u64) {
+    let mut total: u64 = all.iter().map(|spooled| spooled.spooled()).sum();
+    let mut i = 0;
+    while i < all.len() && (all.len() > max_count || total > max_bytes) {
+        if !all[i].is_finished() {
+            i += 1;
+            continue;
+        }
+        if let Some(spooled) = all.remove(i) {
+            total -= spooled.spooled();
+            let _ = std::fs::remove_file(&spooled.path);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_append_stops_at_cap() {
+        let dir = TempDir::new().unwrap();
+        let spooled = create_in(dir.path(), 7, "bazel_test", "bazel test //...", 0, 10).unwrap();
+        spooled.append(b"PASSED: //a\n");
+        spooled.append(b"PASSED: //b\n");
+        spooled.finish();
+        assert_eq!(spooled.output_len(), 24);
+        assert_eq!(spooled.spooled(), 10);
+        assert_eq!(spooled.read(0, 100).unwrap(), b"PASSED: //");
+        assert_eq!(spooled.read(8, 100).unwrap(), b"//");
+        assert!(spooled.read(10, 100).unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_rotate_removes_oldest_finished() {
+        let dir = TempDir::new().unwrap();
+        let mut all: VecDeque<_> = (1..=4)
+            .map(|id| {
+                let spooled = create_in(dir.path(), id, "ls", "ls", 0, 100).unwrap();
+                spooled.append(&[b'x'; 10]);
+                spooled
+            })
+            .collect();
+        // The first is still running, so it is kept though it is the oldest
+        for spooled in all.iter().skip(1) {
+            spooled.finish();
+        }
+        let oldest_finished = all[1].path.clone();
+        rotate(&mut all, 3, 25);
+        let ids: Vec<u64> = all.iter().map(|spooled| spooled.id).collect();
+        assert_eq!(ids, [1, 4]);
+        assert!(!oldest_finished.exists());
+        assert!(all[1].path.exists());
+    }
+}
@@ -0,0 +1,59 @@
+use crate::request::ExecutionContext;
+use crate::scratch::scratch_dir;
+use crate::transcript::session_id;
+
+/// Expand `${WORKSPACE}`, `${SCRATCH}` and `${SESSION_ID}` in a server-configured value at execution time.
+/// WORKSPACE is the call's working_dir, or the server's own working directory if none was given.
+/// Unknown placeholders are left as they are.
+pub fn expand(template: &str, ctx: &ExecutionContext) -> String {
+    if !template.contains("${") {
+        return template.to_string();
+    }
+    let workspace = match ctx.working_dir {
+        Some(ref dir) => dir.clone(),
+        None => std::env::current_dir()
+            .map(|dir| dir.display().to_string())
+            .unwrap_or_default(),
+    };
+    expand_with(
+        template,
+        &[
+            ("WORKSPACE", workspace),
+            ("SCRATCH", scratch_dir().display().to_string()),
+            ("SESSION_ID", session_id().to_string()),
+        ],
+    )
+}
+
+/// Internal implementation for testability - takes the placeholder values as parameter.
+fn expand_with(template: &str, vars: &[(&str, String)]) -> String {
+    vars.iter().fold(template.to_string(), |text, (name, value)| {
+        text.replace(&format!("${{{}}}", name), value)
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_expand_with() {
+        let vars = [("WORKSPACE", "/src/app".to_string()), ("SCRATCH", "/tmp/scratch".to_string())];
+        assert_eq!(
+            expand_with("--output_base=${SCRATCH}/bazel --config=${WORKSPACE}/.bazelrc", &vars),
+            "--output_base=/tmp/scratch/bazel --config=/src/app/.bazelrc"
+        );
+        assert_eq!(expand_with("${UNKNOWN} $WORKSPACE", &vars), "${UNKNOWN} $WORKSPACE");
+    }
+
+    #[test]
+    fn test_expand_uses_working_dir_and_session() {
+        let ctx = ExecutionContext {
+            working_dir: Some("/src/app".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(expand("${WORKSPACE}", &ctx), "/src/app");
+        assert_eq!(expand("cache-${SESSION_ID}", &ctx), format!("cache-{}", session_id()));
+        assert_eq!(expand("plain", &ctx), "plain");
+    }
+}
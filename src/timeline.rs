@@ -0,0 +1,242 @@
+use serde::Serialize;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::admission;
+use crate::executor;
+use crate::jobs::{self, MAX_RUNNING_JOBS};
+use crate::transcript;
+
+/// URI scheme of the execution timeline resource
+const TIMELINE_URI_SCHEME: &str = "timeline://";
+
+/// Width of the rendered timeline, and of the column of bar names, in pixels
+const SVG_WIDTH: u64 = 960;
+const SVG_LABEL_WIDTH: u64 = 320;
+
+/// Height of a bar's row in the rendered timeline, in pixels
+const SVG_ROW_HEIGHT: u64 = 22;
+
+/// A bar of the timeline: something that is running, queued, or was run by a job
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Bar {
+    /// What it is: "command", "job" or "queued"
+    pub kind: &'static str,
+    /// Identifies it among its kind: a command's invocation ID, a job ID, or a queued call's place in the queue
+    pub id: u64,
+    pub tool: String,
+    /// "running" or "queued", or how a job ended
+    pub state: String,
+    /// When it started or was queued, in milliseconds since the Unix epoch
+    pub start_ms: u64,
+    /// When it ended, for finished jobs
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub end_ms: Option<u64>,
+    /// The command line of a command, or why a queued call is waiting
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub detail: String,
+}
+
+/// The session's executions at a point in time, as a Gantt chart
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Timeline {
+    pub session: String,
+    /// When the timeline was taken, in milliseconds since the Unix epoch
+    pub now_ms: u64,
+    pub running_commands: usize,
+    pub running_jobs: usize,
+    pub max_running_jobs: usize,
+    pub queued: usize,
+    pub bars: Vec<Bar>,
+}
+
+/// URI of the current session's execution timeline, as JSON
+pub fn timeline_uri() -> String {
+    format!("{}{}", TIMELINE_URI_SCHEME, transcript::session_id())
+}
+
+/// URI of the current session's execution timeline, rendered as SVG
+pub fn timeline_svg_uri() -> String {
+    format!("{}.svg", timeline_uri())
+}
+
+/// The session's running commands, its jobs, and the calls admission control is holding back
+pub fn snapshot() -> Timeline {
+    let now = SystemTime::now().duration_since(UNIX_EPOCH).unwrap_or_default();
+    let now_ms = now.as_millis() as u64;
+    let started = |elapsed: Duration| now_ms.saturating_sub(elapsed.as_millis() as u64);
+    let mut bars = Vec::new();
+    for queued in admission::queued() {
+        bars.push(Bar {
+            kind: "queued",
+            id: queued.id,
+            tool: queued.tool,
+            state: "queued".to_string(),
+            start_ms: started(queued.since.elapsed()),
+            end_ms: None,
+            detail: queued.reason,
+        });
+    }
+    let jobs = jobs::all();
+    for job in &jobs {
+        let start_ms = started(job.age());
+        let finished = job.result().is_some();
+        bars.push(Bar {
+            kind: "job",
+            id: job.id,
+            tool: job.tool.clone(),
+            state: job.state().to_string(),
+            start_ms,
+            end_ms: finished.then(|| start_ms + job.elapsed().as_millis() as u64),
+            detail: String::new(),
+        });
+    }
+    let commands = executor::running_commands();
+    for command in &commands {
+        bars.push(Bar {
+            kind: "command",
+            id: command.id,
+            tool: command.tool.clone(),
+            state: "running".to_string(),
+            start_ms: started(command.elapsed()),
+            end_ms: None,
+            detail: command.command.clone(),
+        });
+    }
+    Timeline {
+        session: transcript::session_id().to_string(),
+        now_ms,
+        running_commands: commands.len(),
+        running_jobs: jobs.iter().filter(|job| job.result().is_none()).count(),
+        max_running_jobs: MAX_RUNNING_JOBS,
+        queued: bars.iter().filter(|bar| bar.kind == "queued").count(),
+        bars,
+    }
+}
+
+impl Timeline {
+    pub fn to_json(&self) -> String {
+        serde_json::to_string_pretty(self).unwrap_or_default()
+    }
+
+    /// Render the timeline as a Gantt chart: a row per bar, from the earliest start to now
+    pub fn to_svg(&self) -> String {
+        let earliest = self.bars.iter().map(|bar| bar.start_ms).min().unwrap_or(self.now_ms);
+        let span = self.now_ms.saturating_sub(earliest).max(1);
+        let chart_width = SVG_WIDTH - SVG_LABEL_WIDTH;
+        let x = |ms: u64| SVG_LABEL_WIDTH + ms.saturating_sub(earliest).min(span) * chart_width / span;
+        let height = SVG_ROW_HEIGHT * (self.bars.len() as u64 + 1);
+        let mut svg = format!(
+            "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"{}\" height=\"{}\" font-family=\"monospace\" \
+             font-size=\"12\">\n",
+            SVG_WIDTH, height
+        );
+        svg.push_str(&format!(
+            "<text x=\"4\" y=\"15\">{} command{} running, {} of {} jobs running, {} queued; last {}s</text>\n",
+            self.running_commands,
+            if self.running_commands == 1 { "" } else { "s" },
+            self.running_jobs,
+            self.max_running_jobs,
+            self.queued,
+            span.div_ceil(1000)
+        ));
+        for (row, bar) in self.bars.iter().enumerate() {
+            let y = SVG_ROW_HEIGHT * (row as u64 + 1);
+            let (start, end) = (x(bar.start_ms), x(bar.end_ms.unwrap_or(self.now_ms)));
+            let name = match bar.kind {
+                "command" => format!("#{} {}", bar.id, bar.tool),
+                kind => format!("{} {} {}", kind, bar.id, bar.tool),
+            };
+            svg.push_str(&format!(
+                "<text x=\"4\" y=\"{}\">{}</text>\n<rect x=\"{}\" y=\"{}\" width=\"{}\" height=\"{}\" fill=\"{}\">\
+                 <title>{}</title></rect>\n",
+                y + 15,
+                escape(&name),
+                start,
+                y + 3,
+                (end - start).max(2),
+                SVG_ROW_HEIGHT - 6,
+                color(&bar.state),
+                escape(&format!("{}: {} {}", name, bar.state, bar.detail).trim_end())
+            ));
+        }
+        svg.push_str("</svg>\n");
+        svg
+    }
+}
+
+/// Fill of a bar by its state: running green, queued amber, and finished jobs grey or red
+fn color(state: &str) -> &'static str {
+    match state {
+        "running" => "#2e7d32",
+        "queued" => "#f9a825",
+        "succeeded" => "#9e9e9e",
+        _ => "#c62828",
+    }
+}
+
+/// Escape text for XML
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;").replace('<', "&lt;").replace('>', "&gt;").replace('"', "&quot;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn bar(kind: &'static str, id: u64, state: &str, start_ms: u64, end_ms: Option<u64>) -> Bar {
+        Bar {
+            kind,
+            id,
+            tool: "presubmit".to_string(),
+            state: state.to_string(),
+            start_ms,
+            end_ms,
+            detail: "bazel test //... --config=<ci>".to_string(),
+        }
+    }
+
+    fn timeline(bars: Vec<Bar>) -> Timeline {
+        Timeline {
+            session: "abc".to_string(),
+            now_ms: 10_000,
+            running_commands: 1,
+            running_jobs: 1,
+            max_running_jobs: MAX_RUNNING_JOBS,
+            queued: 1,
+            bars,
+        }
+    }
+
+    #[test]
+    fn test_to_json() {
+        let bars = vec![bar("job", 1, "succeeded", 0, Some(4_000)), bar("queued", 1, "queued", 8_000, None)];
+        let timeline = timeline(bars);
+        let json: serde_json::Value = serde_json::from_str(&timeline.to_json()).unwrap();
+        assert_eq!(json["session"], "abc");
+        assert_eq!(json["bars"][0]["end_ms"], 4_000);
+        assert!(json["bars"][1].get("end_ms").is_none());
+        assert_eq!(json["bars"][1]["state"], "queued");
+    }
+
+    #[test]
+    fn test_to_svg() {
+        let svg = timeline(vec![bar("job", 1, "failed", 0, Some(5_000)), bar("command", 7, "running", 5_000, None)])
+            .to_svg();
+        assert!(svg.starts_with("<svg "));
+        assert!(svg.ends_with("</svg>\n"));
+        assert!(svg.contains("1 command running, 1 of 4 jobs running, 1 queued; last 10s"));
+        // The failed job fills the first half of the chart and the command the second
+        let half = SVG_LABEL_WIDTH + (SVG_WIDTH - SVG_LABEL_WIDTH) / 2;
+        assert!(svg.contains(&format!("x=\"{}\" y=\"25\" width=\"{}\"", SVG_LABEL_WIDTH, half - SVG_LABEL_WIDTH)));
+        assert!(svg.contains(&format!("x=\"{}\" y=\"47\"", half)));
+        assert!(svg.contains("fill=\"#c62828\""));
+        assert!(svg.contains("<title>#7 presubmit: running bazel test //... --config=&lt;ci&gt;</title>"));
+    }
+
+    #[test]
+    fn test_snapshot_is_this_session() {
+        let timeline = snapshot();
+        assert_eq!(timeline.session, transcript::session_id());
+        assert_eq!(timeline.max_running_jobs, MAX_RUNNING_JOBS);
+    }
+}
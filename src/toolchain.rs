@@ -0,0 +1,143 @@
+use regex::Regex;
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+
+/// An expected toolchain version
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Pin {
+    pub program: String,
+    pub version: String,
+}
+
+/// Pinned toolchain versions loaded from TOOLCHAIN_PINS environment variable at startup.
+/// Format: semicolon-separated "program=version" pairs, e.g., "bazel=7.x;go=1.22.x"
+static PINS: LazyLock<Vec<Pin>> = LazyLock::new(|| parse_pins(&std::env::var("TOOLCHAIN_PINS").unwrap_or_default()));
+
+static VERSION: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"\d+(\.\d+)+").unwrap());
+
+/// The configured toolchain pins
+pub fn pins() -> &'static [Pin] {
+    &PINS
+}
+
+fn parse_pins(spec: &str) -> Vec<Pin> {
+    spec.split(';')
+        .filter_map(|entry| entry.split_once('='))
+        .map(|(program, version)| Pin {
+            program: program.trim().to_string(),
+            version: version.trim().to_string(),
+        })
+        .filter(|pin| !pin.program.is_empty() && !pin.version.is_empty())
+        .collect()
+}
+
+/// Probe the pinned toolchains in the execution context's environment and describe each
+/// one whose version differs from its pin
+pub fn mismatches(ctx: &ExecutionContext) -> Vec<String> {
+    mismatches_impl(&PINS, |program| probe(program, ctx))
+}
+
+/// Internal implementation for testability - takes the pins and version probe as parameters.
+fn mismatches_impl(pins: &[Pin], probe: impl Fn(&str) -> Option<String>) -> Vec<String> {
+    pins.iter()
+        .filter_map(|pin| match probe(&pin.program) {
+            Some(actual) if version_matches(&pin.version, &actual) => None,
+            Some(actual) => Some(format!(
+                "Warning: toolchain mismatch: {} {} found, {} expected",
+                pin.program, actual, pin.version
+            )),
+            None => Some(format!(
+                "Warning: toolchain mismatch: could not determine the version of {}, {} expected",
+                pin.program, pin.version
+            )),
+        })
+        .collect()
+}
+
+/// Whether a version satisfies a pin. The pin matches at component boundaries,
+/// with an optional ".x" suffix: "1.22" and "1.22.x" match 1.22.3 but not 1.2.
+fn version_matches(pin: &str, actual: &str) -> bool {
+    let pin = pin.strip_suffix(".x").unwrap_or(pin);
+    actual == pin || actual.strip_prefix(pin).is_some_and(|rest| rest.starts_with('.'))
+}
+
+/// Run `<program> --version`, falling back to `<program> version` (as go needs), and extract the version
+fn probe(program: &str, ctx: &ExecutionContext) -> Option<String> {
+    ["--version", "version"].iter().find_map(|arg| {
+        let mut cmd = Command::new(program);
+        cmd.arg(arg);
+        match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) => extract_version(&output),
+            _ => None,
+        }
+    })
+}
+
+/// The first dotted version number in a program's version output
+fn extract_version(output: &str) -> Option<String> {
+    VERSION.find(output).map(|m| m.as_str().to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_pins() {
+        assert_eq!(
+            parse_pins("bazel=7.x; go = 1.22 ;bogus;=1"),
+            vec![
+                Pin {
+                    program: "bazel".to_string(),
+                    version: "7.x".to_string()
+                },
+                Pin {
+                    program: "go".to_string(),
+                    version: "1.22".to_string()
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_version_matches() {
+        assert!(version_matches("7.x", "7.1.0"));
+        assert!(version_matches("1.22", "1.22.3"));
+        assert!(version_matches("1.22.3", "1.22.3"));
+        assert!(!version_matches("1.2", "1.22.3"));
+        assert!(!version_matches("7", "6.5.0"));
+    }
+
+    #[test]
+    fn test_extract_version() {
+        assert_eq!(extract_version("bazel 7.1.0\n").as_deref(), Some("7.1.0"));
+        assert_eq!(extract_version("go version go1.22.3 linux/amd64\n").as_deref(), Some("1.22.3"));
+        assert_eq!(extract_version("no version here"), None);
+    }
+
+    #[test]
+    fn test_mismatches() {
+        let pins = parse_pins("bazel=7.x;go=1.22.x;cargo=1");
+        let warnings = mismatches_impl(&pins, |program| match program {
+            "bazel" => Some("6.5.0".to_string()),
+            "go" => Some("1.22.3".to_string()),
+            _ => None,
+        });
+        assert_eq!(
+            warnings,
+            vec![
+                "Warning: toolchain mismatch: bazel 6.5.0 found, 7.x expected",
+                "Warning: toolchain mismatch: could not determine the version of cargo, 1 expected",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_probe() {
+        assert!(probe("git", &ExecutionContext::default()).is_some());
+        assert_eq!(probe("definitely-not-a-real-binary", &ExecutionContext::default()), None);
+    }
+}
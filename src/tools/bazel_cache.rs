@@ -0,0 +1,514 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::LazyLock;
+use std::time::{Duration, SystemTime};
+
+use crate::executor::{run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::retention::{scratch_usage_in, CleanupReport};
+use crate::security::{is_read_only, validate_path, Validatable, ValidationError};
+use crate::tools::doctor::parse_df_available;
+use crate::tools::host_info::format_bytes;
+
+/// Free space below which the disk is under pressure, when BAZEL_GC_MIN_FREE_MB isn't set (10 GiB)
+const DEFAULT_GC_MIN_FREE_MB: u64 = 10 * 1024;
+
+/// Content-addressed directories of bazel's caches: a disk cache's action cache and CAS, and a repository
+/// cache's content_addressable. Trimming only removes files under these.
+const CACHE_DIRS: &[&str] = &["ac", "cas", "content_addressable"];
+
+/// Cache files written more recently than this are never trimmed, so a running build keeps its outputs
+const IN_USE_GRACE: Duration = Duration::from_secs(60);
+
+/// Garbage collection the server allows, and when
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+struct GcPolicy {
+    /// Run `bazel clean --expunge_async` to remove the workspace's output base
+    expunge: bool,
+    /// Remove the least recently used entries of the disk and repository caches
+    trim: bool,
+    /// Free space below which garbage collection runs, in bytes
+    min_free: u64,
+    /// Size each cache is trimmed to, in bytes
+    max_cache_bytes: Option<u64>,
+    /// Directories caches must be under to be trimmed. Workspaces choose their cache paths in .bazelrc,
+    /// so only the operator's roots are trusted.
+    cache_roots: Vec<PathBuf>,
+}
+
+/// Garbage collection policy loaded at startup. BAZEL_GC lists the actions allowed, "expunge" and
+/// "trim", separated by semicolons; unset, nothing is collected. They run only while the disk holding
+/// the output base has less than BAZEL_GC_MIN_FREE_MB free. Trimming needs BAZEL_CACHE_MAX_BYTES, and
+/// only trims caches under the semicolon-separated directories in BAZEL_CACHE_ROOTS.
+static POLICY: LazyLock<GcPolicy> = LazyLock::new(|| load_policy(|var| std::env::var(var).ok()));
+
+/// Internal implementation for testability - takes a variable lookup as parameter.
+fn load_policy(var: impl Fn(&str) -> Option<String>) -> GcPolicy {
+    let actions = var("BAZEL_GC").unwrap_or_default();
+    let allowed = |action: &str| actions.split(';').any(|a| a.trim() == action);
+    let min_free_mb = var("BAZEL_GC_MIN_FREE_MB")
+        .and_then(|s| s.trim().parse().ok())
+        .unwrap_or(DEFAULT_GC_MIN_FREE_MB);
+    GcPolicy {
+        expunge: allowed("expunge"),
+        trim: allowed("trim"),
+        min_free: min_free_mb * 1024 * 1024,
+        max_cache_bytes: var("BAZEL_CACHE_MAX_BYTES").and_then(|s| s.trim().parse().ok()),
+        cache_roots: var("BAZEL_CACHE_ROOTS")
+            .unwrap_or_default()
+            .split(';')
+            .map(str::trim)
+            .filter(|root| !root.is_empty())
+            .map(PathBuf::from)
+            .collect(),
+    }
+}
+
+/// Request parameters for the bazel_cache tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct BazelCacheRequest {
+    /// Collect garbage if the disk is under pressure, as far as the server's policy allows
+    #[serde(default)]
+    pub gc: Option<bool>,
+}
+
+impl Validatable for BazelCacheRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if self.gc == Some(true) && is_read_only() {
+            return Err(ValidationError::ReadOnlyMode("Bazel garbage collection".to_string()));
+        }
+        Ok(())
+    }
+}
+
+/// Where a workspace's bazel caches are
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+struct Caches {
+    output_base: Option<PathBuf>,
+    repository_cache: Option<PathBuf>,
+    disk_cache: Option<PathBuf>,
+}
+
+/// Report, and optionally collect, the bazel caches of the workspace with a validated request and execution context
+pub fn execute(req: &BazelCacheRequest, ctx: &ExecutionContext) -> String {
+    execute_with_policy(req, ctx, &POLICY)
+}
+
+/// Internal implementation for testability - takes the garbage collection policy as parameter.
+fn execute_with_policy(req: &BazelCacheRequest, ctx: &ExecutionContext, policy: &GcPolicy) -> String {
+    let workspace = ctx
+        .working_dir
+        .as_ref()
+        .map(PathBuf::from)
+        .unwrap_or_else(|| std::env::current_dir().unwrap_or_default());
+    let mut cmd = Command::new("bazel");
+    cmd.args(["info", "output_base", "repository_cache"]);
+    let info = match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) if ctx.dry_run => {
+            let gc = if req.gc == Some(true) { ", and collect garbage under disk pressure" } else { "" };
+            return format!("{}\nThen measures the output base, repository cache and disk cache{}", output, gc);
+        }
+        ExecutionResult::Success(output) => output,
+        result => return format!("Error: bazel info failed in {}:\n{}", workspace.display(), result.into_string()),
+    };
+    let home = std::env::var_os("HOME").map(PathBuf::from);
+    let caches = Caches {
+        output_base: info_value(&info, "output_base").map(PathBuf::from),
+        repository_cache: info_value(&info, "repository_cache").map(PathBuf::from),
+        disk_cache: disk_cache(&workspace, home.as_deref()),
+    };
+
+    let mut lines = vec![format!("Workspace: {}", workspace.display())];
+    lines.push(describe("Output base", caches.output_base.as_deref()));
+    lines.push(describe("Repository cache", caches.repository_cache.as_deref()));
+    lines.push(describe("Disk cache", caches.disk_cache.as_deref()));
+    let Some(ref output_base) = caches.output_base else {
+        return lines.join("\n");
+    };
+    let free = free_space(ctx, output_base);
+    let pressure = free.is_some_and(|free| free < policy.min_free);
+    lines.push(match free {
+        Some(free) => format!(
+            "Free space: {} on the disk holding the output base; disk pressure below {}{}",
+            format_bytes(free),
+            format_bytes(policy.min_free),
+            if pressure { " (under pressure)" } else { "" }
+        ),
+        None => "Free space: unknown".to_string(),
+    });
+    if req.gc == Some(true) {
+        lines.push(String::new());
+        lines.extend(collect(ctx, policy, &caches, pressure));
+    }
+    lines.join("\n")
+}
+
+/// A cache's path and size, or that it isn't configured
+fn describe(name: &str, path: Option<&Path>) -> String {
+    match path {
+        Some(path) if path.exists() => {
+            format!("{}: {} ({})", name, path.display(), format_bytes(scratch_usage_in(path)))
+        }
+        Some(path) => format!("{}: {} (not created yet)", name, path.display()),
+        None => format!("{}: not configured", name),
+    }
+}
+
+/// Collect garbage as the policy allows: trim the caches first, and expunge the output base only if
+/// the disk is still under pressure after that, since it costs a full rebuild
+fn collect(ctx: &ExecutionContext, policy: &GcPolicy, caches: &Caches, pressure: bool) -> Vec<String> {
+    if !policy.expunge && !policy.trim {
+        return vec!["Garbage collection: not run; the server doesn't allow it (BAZEL_GC)".to_string()];
+    }
+    if !pressure {
+        return vec!["Garbage collection: not needed; the disk isn't under pressure".to_string()];
+    }
+    let mut lines = Vec::new();
+    let mut pressure = pressure;
+    if policy.trim {
+        match policy.max_cache_bytes {
+            Some(max_bytes) => {
+                let trimmed = [("disk cache", &caches.disk_cache), ("repository cache", &caches.repository_cache)];
+                for (name, cache) in trimmed {
+                    let Some(cache) = cache else {
+                        continue;
+                    };
+                    let cache = match trimmable(cache, &policy.cache_roots) {
+                        Ok(cache) => cache,
+                        Err(reason) => {
+                            lines.push(format!("Trimming the {} skipped: {}", name, reason));
+                            continue;
+                        }
+                    };
+                    let report = trim(&cache, max_bytes, SystemTime::now());
+                    lines.push(format!(
+                        "Trimmed the {} to {}: removed {} files, freeing {}",
+                        name,
+                        format_bytes(max_bytes),
+                        report.removed,
+                        format_bytes(report.freed)
+                    ));
+                }
+            }
+            None => lines.push("Trimming skipped: BAZEL_CACHE_MAX_BYTES isn't set".to_string()),
+        }
+        if let Some(output_base) = caches.output_base.as_deref() {
+            pressure = free_space(ctx, output_base).is_none_or(|free| free < policy.min_free);
+        }
+    }
+    if policy.expunge && pressure {
+        let mut cmd = Command::new("bazel");
+        cmd.args(["clean", "--expunge_async"]);
+        lines.push(match run_command(cmd, ctx) {
+            ExecutionResult::Success(_) => {
+                "Ran bazel clean --expunge_async: the output base is being removed in the background".to_string()
+            }
+            result => format!("Error: bazel clean --expunge_async failed:\n{}", result.into_string()),
+        });
+    } else if policy.expunge {
+        lines.push("Expunge skipped: trimming relieved the disk pressure".to_string());
+    }
+    lines
+}
+
+/// Value of a key in `bazel info` output, e.g., "output_base: /home/dev/.cache/bazel/_bazel_dev/1f2e"
+fn info_value(info: &str, key: &str) -> Option<String> {
+    info.lines()
+        .filter_map(|line| line.split_once(": "))
+        .find(|(name, _)| name.trim() == key)
+        .map(|(_, value)| value.trim().to_string())
+        .filter(|value| !value.is_empty())
+}
+
+/// The disk cache configured by the workspace's .bazelrc or the user's ~/.bazelrc, the later one read winning
+fn disk_cache(workspace: &Path, home: Option<&Path>) -> Option<PathBuf> {
+    let bazelrcs = [Some(workspace.join(".bazelrc")), home.map(|home| home.join(".bazelrc"))];
+    let path = bazelrcs
+        .into_iter()
+        .flatten()
+        .filter_map(|bazelrc| fs::read_to_string(bazelrc).ok())
+        .filter_map(|content| parse_disk_cache(&content))
+        .last()?;
+    let path = match (path.strip_prefix("~/"), home) {
+        (Some(rest), Some(home)) => home.join(rest),
+        _ => PathBuf::from(path.replace("%workspace%", &workspace.to_string_lossy())),
+    };
+    Some(path)
+}
+
+/// The last --disk_cache a bazelrc sets, e.g., "build --disk_cache=~/.cache/bazel-disk"
+fn parse_disk_cache(bazelrc: &str) -> Option<String> {
+    bazelrc
+        .lines()
+        .filter(|line| !line.trim_start().starts_with('#'))
+        .flat_map(|line| line.split_whitespace())
+        .filter_map(|word| word.strip_prefix("--disk_cache="))
+        .last()
+        .map(|path| path.trim_matches('"').to_string())
+        .filter(|path| !path.is_empty())
+}
+
+/// Free space on the filesystem holding a path, from POSIX df
+fn free_space(ctx: &ExecutionContext, path: &Path) -> Option<u64> {
+    let mut cmd = Command::new("df");
+    cmd.arg("-Pk").arg(path);
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => parse_df_available(&output),
+        _ => None,
+    }
+}
+
+/// The resolved path of a cache that may be trimmed: under one of the operator's cache roots, not a
+/// blocked path, and laid out like a bazel cache. A .bazelrc can point --disk_cache anywhere, e.g., at
+/// the user's home, so neither its path nor the bazel info it shapes is trusted alone.
+fn trimmable(cache: &Path, roots: &[PathBuf]) -> Result<PathBuf, String> {
+    if roots.is_empty() {
+        return Err("BAZEL_CACHE_ROOTS isn't set".to_string());
+    }
+    let resolved = cache
+        .canonicalize()
+        .map_err(|e| format!("can't resolve {}: {}", cache.display(), e))?;
+    if !roots
+        .iter()
+        .filter_map(|root| root.canonicalize().ok())
+        .any(|root| resolved.starts_with(root))
+    {
+        return Err(format!("{} isn't under BAZEL_CACHE_ROOTS", resolved.display()));
+    }
+    validate_path(&resolved.to_string_lossy()).map_err(|e| e.to_string())?;
+    if !CACHE_DIRS.iter().any(|dir| resolved.join(dir).is_dir()) {
+        return Err(format!(
+            "{} doesn't look like a bazel cache (no ac/, cas/ or content_addressable/)",
+            resolved.display()
+        ));
+    }
+    Ok(resolved)
+}
+
+/// Remove the least recently modified files of a cache's content-addressed directories until it fits in
+/// `max_bytes`. Bazel's disk and repository caches are content-addressed, so any such file can go; it is
+/// fetched or built again when next needed.
+fn trim(root: &Path, max_bytes: u64, now: SystemTime) -> CleanupReport {
+    let mut files = Vec::new();
+    for dir in CACHE_DIRS {
+        files_in(&root.join(dir), &mut files);
+    }
+    // Least recently used first
+    files.sort_by_key(|(_, _, modified)| *modified);
+    let mut total: u64 = files.iter().map(|(_, bytes, _)| bytes).sum();
+    let mut report = CleanupReport::default();
+    for (path, bytes, modified) in files {
+        if total <= max_bytes {
+            break;
+        }
+        if now.duration_since(modified).unwrap_or_default() < IN_USE_GRACE {
+            continue;
+        }
+        if fs::remove_file(&path).is_ok() {
+            total -= bytes;
+            report.removed += 1;
+            report.freed += bytes;
+        }
+    }
+    report
+}
+
+/// Every file under a directory, with its size and modification time. Symlinks are not followed.
+fn files_in(dir: &Path, files: &mut Vec<(PathBuf, u64, SystemTime)>) {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return;
+    };
+    for entry in entries.filter_map(|e| e.ok()) {
+        let Ok(metadata) = entry.path().symlink_metadata() else {
+            continue;
+        };
+        if metadata.is_dir() {
+            files_in(&entry.path(), files);
+        } else if metadata.is_file() {
+            let modified = metadata.modified().unwrap_or(SystemTime::UNIX_EPOCH);
+            files.push((entry.path(), metadata.len(), modified));
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::executor::FakeExecutor;
+    use std::sync::Arc;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_load_policy() {
+        assert_eq!(
+            load_policy(|_| None),
+            GcPolicy {
+                min_free: DEFAULT_GC_MIN_FREE_MB * 1024 * 1024,
+                ..Default::default()
+            }
+        );
+        let policy = load_policy(|var| match var {
+            "BAZEL_GC" => Some("trim; expunge".to_string()),
+            "BAZEL_GC_MIN_FREE_MB" => Some("2048".to_string()),
+            "BAZEL_CACHE_MAX_BYTES" => Some("1000".to_string()),
+            "BAZEL_CACHE_ROOTS" => Some("/var/cache/bazel; /home/dev/.cache".to_string()),
+            _ => None,
+        });
+        assert!(policy.expunge && policy.trim);
+        assert_eq!(policy.cache_roots, [PathBuf::from("/var/cache/bazel"), PathBuf::from("/home/dev/.cache")]);
+        assert_eq!(policy.min_free, 2048 * 1024 * 1024);
+        assert_eq!(policy.max_cache_bytes, Some(1000));
+    }
+
+    #[test]
+    fn test_info_value() {
+        let info = "output_base: /home/dev/.cache/bazel/_bazel_dev/1f2e\nrepository_cache: /home/dev/.cache/repo\n";
+        assert_eq!(info_value(info, "output_base").as_deref(), Some("/home/dev/.cache/bazel/_bazel_dev/1f2e"));
+        assert_eq!(info_value(info, "repository_cache").as_deref(), Some("/home/dev/.cache/repo"));
+        assert_eq!(info_value(info, "execution_root"), None);
+    }
+
+    #[test]
+    fn test_disk_cache() {
+        assert_eq!(
+            parse_disk_cache("# build --disk_cache=/old\nbuild --disk_cache=/a\ncommon:ci --disk_cache=\"/b\""),
+            Some("/b".to_string())
+        );
+        assert_eq!(parse_disk_cache("build --disk_cache=\nbuild -c opt"), None);
+
+        let workspace = TempDir::new().unwrap();
+        let home = TempDir::new().unwrap();
+        assert_eq!(disk_cache(workspace.path(), Some(home.path())), None);
+        fs::write(workspace.path().join(".bazelrc"), "build --disk_cache=%workspace%/.cache\n").unwrap();
+        assert_eq!(disk_cache(workspace.path(), None), Some(workspace.path().join(".cache")));
+        // The user's bazelrc is read after the workspace's
+        fs::write(home.path().join(".bazelrc"), "build --disk_cache=~/bazel-disk\n").unwrap();
+        assert_eq!(disk_cache(workspace.path(), Some(home.path())), Some(home.path().join("bazel-disk")));
+    }
+
+    #[test]
+    fn test_trim_removes_least_recently_used() {
+        let cache = TempDir::new().unwrap();
+        let cas = cache.path().join("cas/ab");
+        fs::create_dir_all(&cas).unwrap();
+        for name in ["old", "mid", "new"] {
+            fs::write(cas.join(name), [0u8; 100]).unwrap();
+        }
+        let set_age = |name: &str, secs: u64| {
+            let file = fs::File::options().write(true).open(cas.join(name)).unwrap();
+            file.set_modified(SystemTime::now() - Duration::from_secs(secs)).unwrap();
+        };
+        set_age("old", 3000);
+        set_age("mid", 2000);
+        set_age("new", 1000);
+        let report = trim(cache.path(), 150, SystemTime::now());
+        assert_eq!(report, CleanupReport { removed: 2, freed: 200 });
+        assert!(cas.join("new").exists());
+        assert!(!cas.join("old").exists());
+        // Files just written are kept
+        fs::write(cas.join("fresh"), [0u8; 100]).unwrap();
+        assert_eq!(trim(cache.path(), 0, SystemTime::now()).removed, 1);
+        assert!(cas.join("fresh").exists());
+    }
+
+    #[test]
+    fn test_trim_refuses_hostile_bazelrc() {
+        let workspace = TempDir::new().unwrap();
+        let home = TempDir::new().unwrap();
+        let old = SystemTime::now() - Duration::from_secs(3600);
+        for file in [".bashrc", ".ssh/id_ed25519"] {
+            let path = home.path().join(file);
+            fs::create_dir_all(path.parent().unwrap()).unwrap();
+            fs::write(&path, [0u8; 100]).unwrap();
+            fs::File::options().write(true).open(&path).unwrap().set_modified(old).unwrap();
+        }
+        fs::write(workspace.path().join(".bazelrc"), "build --disk_cache=~/\n").unwrap();
+        let cache = disk_cache(workspace.path(), Some(home.path())).unwrap();
+        assert_eq!(cache, home.path());
+
+        let executor = Arc::new(FakeExecutor::default());
+        let ctx = ExecutionContext {
+            executor: Some(executor),
+            ..Default::default()
+        };
+        let caches = Caches {
+            disk_cache: Some(cache),
+            ..Default::default()
+        };
+        let policy = |roots: Vec<PathBuf>| GcPolicy {
+            trim: true,
+            max_cache_bytes: Some(0),
+            cache_roots: roots,
+            ..Default::default()
+        };
+        // Not under the operator's roots, or with none configured
+        let lines = collect(&ctx, &policy(vec![]), &caches, true);
+        assert_eq!(lines, ["Trimming the disk cache skipped: BAZEL_CACHE_ROOTS isn't set"]);
+        let lines = collect(&ctx, &policy(vec![workspace.path().to_path_buf()]), &caches, true);
+        assert!(lines[0].ends_with("isn't under BAZEL_CACHE_ROOTS"), "{:?}", lines);
+        // Under a root, but not laid out like a bazel cache
+        let lines = collect(&ctx, &policy(vec![home.path().to_path_buf()]), &caches, true);
+        assert!(lines[0].ends_with("doesn't look like a bazel cache (no ac/, cas/ or content_addressable/)"));
+        assert!(home.path().join(".bashrc").exists());
+        assert!(home.path().join(".ssh/id_ed25519").exists());
+        // Files outside the content-addressed directories are kept even in a real cache
+        fs::create_dir(home.path().join("cas")).unwrap();
+        let lines = collect(&ctx, &policy(vec![home.path().to_path_buf()]), &caches, true);
+        assert!(lines[0].starts_with("Trimmed the disk cache"), "{:?}", lines);
+        assert!(home.path().join(".bashrc").exists());
+    }
+
+    #[test]
+    fn test_gc_not_allowed_by_default() {
+        let executor = Arc::new(FakeExecutor::default());
+        let ctx = ExecutionContext {
+            executor: Some(executor.clone()),
+            ..Default::default()
+        };
+        let caches = Caches {
+            output_base: Some(PathBuf::from("/nonexistent/output_base")),
+            ..Default::default()
+        };
+        let lines = collect(&ctx, &load_policy(|_| None), &caches, true);
+        assert_eq!(lines, ["Garbage collection: not run; the server doesn't allow it (BAZEL_GC)"]);
+        assert!(executor.commands().is_empty());
+    }
+
+    #[test]
+    fn test_gc_expunges_under_pressure() {
+        let executor = Arc::new(FakeExecutor::default());
+        let ctx = ExecutionContext {
+            executor: Some(executor.clone()),
+            ..Default::default()
+        };
+        let policy = GcPolicy {
+            expunge: true,
+            min_free: 1024,
+            ..Default::default()
+        };
+        let caches = Caches::default();
+        assert!(collect(&ctx, &policy, &caches, false)[0].contains("not needed"));
+        let lines = collect(&ctx, &policy, &caches, true);
+        assert!(lines[0].starts_with("Ran bazel clean --expunge_async"), "{:?}", lines);
+        assert_eq!(executor.commands(), ["bazel clean --expunge_async"]);
+    }
+
+    #[test]
+    fn test_report_without_bazel_info() {
+        let executor = Arc::new(FakeExecutor {
+            failing: vec!["bazel".to_string()],
+            ..Default::default()
+        });
+        let ctx = ExecutionContext {
+            executor: Some(executor),
+            working_dir: Some("/src/app".to_string()),
+            ..Default::default()
+        };
+        let req = BazelCacheRequest { gc: None };
+        let output = execute_with_policy(&req, &ctx, &GcPolicy::default());
+        assert!(output.starts_with("Error: bazel info failed in /src/app"), "{}", output);
+    }
+}
@@ -0,0 +1,304 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs::File;
+use std::io::Read;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{
+    validate_no_traversal, validate_not_flag, validate_path, validate_path_with_working_dir, Validatable,
+    ValidationError,
+};
+use crate::tools::download::sha256_file;
+
+/// "Shared library: [libc.so.6]" in `readelf -d` output
+pub static ELF_NEEDED: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"\(NEEDED\).*\[(.+)\]").unwrap());
+
+/// "Build ID: be63..." in `readelf -n` output
+static ELF_BUILD_ID: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"Build ID: ([0-9a-f]+)").unwrap());
+
+/// "uuid 1A2B..." in `otool -l` output
+static MACHO_UUID: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"uuid ([0-9A-Fa-f-]+)").unwrap());
+
+/// Request parameters for the binary_info tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct BinaryInfoRequest {
+    /// The binary to inspect, relative to working_dir if not absolute
+    pub path: String,
+}
+
+impl Validatable for BinaryInfoRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_not_flag(&self.path)?;
+        validate_no_traversal(&self.path)?;
+        validate_path(&self.path)?;
+        Ok(())
+    }
+}
+
+/// Executable formats recognized from a file's first bytes
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Format {
+    Elf { bits: u8, little_endian: bool, machine: u16 },
+    MachO { bits: u8, cpu_type: u32 },
+    MachOUniversal,
+}
+
+/// Inspect a binary with a validated request and execution context
+pub fn execute(req: &BinaryInfoRequest, ctx: &ExecutionContext) -> String {
+    if let Some(ref working_dir) = ctx.working_dir {
+        if let Err(e) = validate_path_with_working_dir(&req.path, working_dir) {
+            return e.to_string();
+        }
+    }
+    let path = match ctx.working_dir {
+        Some(ref dir) => Path::new(dir).join(&req.path),
+        None => PathBuf::from(&req.path),
+    };
+    let format = match read_format(&path) {
+        Ok(Some(format)) => format,
+        Ok(None) => return format!("Error: '{}' is not an ELF or Mach-O binary", req.path),
+        Err(e) => return format!("Error: Failed to read '{}': {}", req.path, e),
+    };
+
+    let mut lines = vec![format!("format: {}", describe(format))];
+    if let Ok(metadata) = path.metadata() {
+        lines.push(format!("size: {} bytes", metadata.len()));
+    }
+    if let Ok(sha256) = sha256_file(&path) {
+        lines.push(format!("sha256: {}", sha256));
+    }
+    let (build_id, libraries) = match format {
+        Format::Elf { .. } => (
+            probe(ctx, "readelf", &["-n"], &path, |out| capture(&ELF_BUILD_ID, out).into_iter().collect()),
+            probe(ctx, "readelf", &["-d"], &path, |out| capture_all(&ELF_NEEDED, out)),
+        ),
+        Format::MachO { .. } | Format::MachOUniversal => (
+            probe(ctx, "otool", &["-l"], &path, |out| capture(&MACHO_UUID, out).into_iter().collect()),
+            // The first line is the file name, and each library is followed by its versions
+            probe(ctx, "otool", &["-L"], &path, |out| {
+                out.lines()
+                    .skip(1)
+                    .filter_map(|line| line.split(" (").next())
+                    .map(|name| name.trim().to_string())
+                    .filter(|name| !name.is_empty())
+                    .collect()
+            }),
+        ),
+    };
+    lines.push(format!("build_id: {}", build_id.map(|ids| ids.join(" ")).unwrap_or_else(|e| e)));
+    lines.push(section("libraries", libraries));
+    lines.push(section("go", probe(ctx, "go", &["version", "-m"], &path, go_build_info)));
+    lines.join("\n")
+}
+
+/// The executable format of a file, or None if it isn't an ELF or Mach-O binary
+pub fn read_format(path: &Path) -> std::io::Result<Option<Format>> {
+    let mut header = [0u8; 20];
+    let read = File::open(path)?.read(&mut header)?;
+    Ok(detect(&header[..read]))
+}
+
+/// Recognize ELF and Mach-O binaries by their magic numbers
+fn detect(header: &[u8]) -> Option<Format> {
+    match header {
+        [0x7f, b'E', b'L', b'F', class, data, ..] if header.len() >= 20 => {
+            let little_endian = *data == 1;
+            let bytes = [header[18], header[19]];
+            let machine = if little_endian { u16::from_le_bytes(bytes) } else { u16::from_be_bytes(bytes) };
+            Some(Format::Elf {
+                bits: if *class == 2 { 64 } else { 32 },
+                little_endian,
+                machine,
+            })
+        }
+        [0xcf, 0xfa, 0xed, 0xfe, a, b, c, d, ..] => Some(Format::MachO {
+            bits: 64,
+            cpu_type: u32::from_le_bytes([*a, *b, *c, *d]),
+        }),
+        [0xce, 0xfa, 0xed, 0xfe, a, b, c, d, ..] => Some(Format::MachO {
+            bits: 32,
+            cpu_type: u32::from_le_bytes([*a, *b, *c, *d]),
+        }),
+        [0xca, 0xfe, 0xba, 0xbe, ..] => Some(Format::MachOUniversal),
+        _ => None,
+    }
+}
+
+/// Human-readable format and architecture
+pub fn describe(format: Format) -> String {
+    match format {
+        Format::Elf {
+            bits,
+            little_endian,
+            machine,
+        } => {
+            let arch = match machine {
+                0x03 => "x86".to_string(),
+                0x3e => "x86_64".to_string(),
+                0x28 => "arm".to_string(),
+                0xb7 => "aarch64".to_string(),
+                0xf3 => "riscv".to_string(),
+                0x15 => "ppc64".to_string(),
+                0x16 => "s390x".to_string(),
+                other => format!("machine {:#x}", other),
+            };
+            let endian = if little_endian { "LSB" } else { "MSB" };
+            format!("ELF {}-bit {}, {}", bits, endian, arch)
+        }
+        Format::MachO { bits, cpu_type } => {
+            let arch = match cpu_type {
+                0x0100_0007 => "x86_64".to_string(),
+                0x0100_000c => "arm64".to_string(),
+                7 => "x86".to_string(),
+                12 => "arm".to_string(),
+                other => format!("cpu type {:#x}", other),
+            };
+            format!("Mach-O {}-bit, {}", bits, arch)
+        }
+        Format::MachOUniversal => "Mach-O universal binary".to_string(),
+    }
+}
+
+/// Run an inspection program on the binary and extract values from its output.
+/// Err describes why nothing could be extracted.
+fn probe(
+    ctx: &ExecutionContext,
+    program: &str,
+    args: &[&str],
+    path: &Path,
+    extract: impl Fn(&str) -> Vec<String>,
+) -> Result<Vec<String>, String> {
+    let program = match find_executable(program) {
+        Some(program) => program,
+        None => return Err(format!("unavailable ({} is not installed)", program)),
+    };
+    let mut cmd = Command::new(program);
+    cmd.args(args).arg(path);
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => {
+            let values = extract(&output);
+            if values.is_empty() {
+                Err("none".to_string())
+            } else {
+                Ok(values)
+            }
+        }
+        _ => Err("none".to_string()),
+    }
+}
+
+fn capture(regex: &Regex, output: &str) -> Option<String> {
+    regex.captures(output).map(|captures| captures[1].to_string())
+}
+
+fn capture_all(regex: &Regex, output: &str) -> Vec<String> {
+    regex.captures_iter(output).map(|captures| captures[1].to_string()).collect()
+}
+
+/// `go version -m` output without the file name, e.g., "go1.22.3", "mod example.com/app v1.2.0",
+/// "build vcs.revision=abc123"
+fn go_build_info(output: &str) -> Vec<String> {
+    let mut lines = output.lines();
+    let version = lines.next().and_then(|line| line.rsplit(": ").next()).map(str::to_string);
+    version
+        .into_iter()
+        .chain(lines.map(|line| line.split_whitespace().collect::<Vec<_>>().join(" ")))
+        .filter(|line| !line.is_empty())
+        .collect()
+}
+
+/// A "name:" line followed by indented values, or "name: reason" when there are none
+fn section(name: &str, values: Result<Vec<String>, String>) -> String {
+    match values {
+        Ok(values) => {
+            let indented: Vec<String> = values.iter().map(|value| format!("  {}", value)).collect();
+            format!("{}:\n{}", name, indented.join("\n"))
+        }
+        Err(reason) => format!("{}: {}", name, reason),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_detect_elf() {
+        let mut header = [0u8; 20];
+        header[..6].copy_from_slice(&[0x7f, b'E', b'L', b'F', 2, 1]);
+        header[18] = 0xb7;
+        let format = detect(&header).unwrap();
+        assert_eq!(describe(format), "ELF 64-bit LSB, aarch64");
+    }
+
+    #[test]
+    fn test_detect_macho() {
+        let header = [0xcf, 0xfa, 0xed, 0xfe, 0x0c, 0x00, 0x00, 0x01];
+        assert_eq!(describe(detect(&header).unwrap()), "Mach-O 64-bit, arm64");
+        assert_eq!(detect(&[0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 2]), Some(Format::MachOUniversal));
+        assert_eq!(detect(b"#!/bin/sh\n"), None);
+    }
+
+    #[test]
+    fn test_go_build_info() {
+        let output = "/tmp/app: go1.22.3\n\tpath\texample.com/app\n\tmod\texample.com/app\t(devel)\t\n\tbuild\tvcs.revision=abc123\n";
+        assert_eq!(
+            go_build_info(output),
+            vec![
+                "go1.22.3",
+                "path example.com/app",
+                "mod example.com/app (devel)",
+                "build vcs.revision=abc123"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_section() {
+        assert_eq!(
+            section("libraries", Ok(vec!["libc.so.6".to_string(), "libm.so.6".to_string()])),
+            "libraries:\n  libc.so.6\n  libm.so.6"
+        );
+        assert_eq!(section("go", Err("none".to_string())), "go: none");
+    }
+
+    #[test]
+    fn test_execute_rejects_non_binary() {
+        let temp_dir = TempDir::new().unwrap();
+        std::fs::write(temp_dir.path().join("script.sh"), "#!/bin/sh\necho hi\n").unwrap();
+        let req = BinaryInfoRequest {
+            path: "script.sh".to_string(),
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        assert_eq!(execute(&req, &ctx), "Error: 'script.sh' is not an ELF or Mach-O binary");
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn test_execute_inspects_system_binary() {
+        let req = BinaryInfoRequest {
+            path: "/bin/sh".to_string(),
+        };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.starts_with("format: ELF "), "{}", result);
+        assert!(result.contains("\nsha256: "), "{}", result);
+        assert!(result.contains("\nbuild_id: "), "{}", result);
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = BinaryInfoRequest {
+            path: "../bin/app".to_string(),
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+}
@@ -0,0 +1,50 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::executor::running_command;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Request parameters for the cancel tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct CancelRequest {
+    /// Invocation ID of the command, as listed by ps_jobs
+    pub id: u64,
+}
+
+impl Validatable for CancelRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Cancel a running command with a validated request and execution context
+pub fn execute(req: &CancelRequest, _ctx: &ExecutionContext) -> String {
+    let Some(command) = running_command(req.id) else {
+        return format!("Error: No command #{} is running; ps_jobs lists those that are", req.id);
+    };
+    if command.cancel() {
+        format!(
+            "Cancelling #{} ({}): {}\nIts process group was asked to exit; the call that ran it returns the {} \
+             line{} of output read so far.",
+            command.id,
+            command.tool,
+            command.command,
+            command.lines(),
+            if command.lines() == 1 { "" } else { "s" }
+        )
+    } else {
+        format!("#{} ({}) is already being cancelled", command.id, command.tool)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_missing_command() {
+        let req = CancelRequest { id: u64::MAX };
+        assert!(execute(&req, &ExecutionContext::default()).starts_with("Error: No command #"));
+    }
+}
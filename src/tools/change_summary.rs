@@ -0,0 +1,326 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::collections::BTreeSet;
+use std::path::Path;
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{quote, run_command, ExecutionResult};
+use crate::history::{self, CommandEntry};
+use crate::request::ExecutionContext;
+use crate::security::{validate_argument, validate_not_flag, Validatable, ValidationError};
+use crate::transcript::{format_utc, session_id};
+
+/// Most recent test runs included in the bundle
+const MAX_TEST_RUNS: usize = 20;
+
+/// Files marking the root of a Bazel workspace
+const WORKSPACE_FILES: &[&str] = &["MODULE.bazel", "WORKSPACE", "WORKSPACE.bazel"];
+
+/// Files marking a Bazel package
+const BUILD_FILES: &[&str] = &["BUILD.bazel", "BUILD"];
+
+/// A line of a test run's output that reports a result: bazel's per-target and summary lines, and
+/// go test's per-package and per-test lines
+static TEST_RESULT_LINE: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(concat!(
+        r"^(//\S+\s+(PASSED|FAILED|FLAKY|TIMEOUT|NO STATUS|INCOMPLETE)\b",
+        r"|Executed \d+ out of \d+ tests?|(ok|FAIL)\s+\S+\s|--- FAIL: )",
+    ))
+    .unwrap()
+});
+
+/// Request parameters for the change_summary tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct ChangeSummaryRequest {
+    /// Revision to compare the working tree with, e.g., "origin/main" (default: "HEAD")
+    #[serde(default)]
+    pub base: Option<String>,
+    /// Only cover staged changes, leaving out unstaged and untracked files (default: false)
+    #[serde(default)]
+    pub staged: Option<bool>,
+}
+
+impl Validatable for ChangeSummaryRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if let Some(ref base) = self.base {
+            validate_argument(base)?;
+            validate_not_flag(base)?;
+        }
+        Ok(())
+    }
+}
+
+/// A changed file and how it changed
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct ChangedFile {
+    /// Path relative to the repository root; the new path of a renamed file
+    path: String,
+    /// git's status letter: A (added), M (modified), D (deleted), R (renamed) and so on, or ? (untracked)
+    status: String,
+}
+
+/// Gather the changes and the session's test results with a validated request and execution context. The
+/// result is a JSON bundle, which the server returns as structured content described by `output_schema`.
+pub fn execute(req: &ChangeSummaryRequest, ctx: &ExecutionContext) -> String {
+    let base = req.base.as_deref().unwrap_or("HEAD");
+    let staged = req.staged.unwrap_or(false);
+    let diff = |format: &str| {
+        let mut args = vec!["diff", format];
+        if staged {
+            args.push("--cached");
+        }
+        args.push(base);
+        git(ctx, &args)
+    };
+    let stat = match diff("--stat") {
+        Ok(stat) if ctx.dry_run => {
+            return format!("{}\nThen lists the changed files and the session's test runs", stat);
+        }
+        Ok(stat) => stat,
+        Err(e) => return format!("Error: git diff failed:\n{}", e),
+    };
+    let mut files = match diff("--name-status") {
+        Ok(output) => parse_name_status(&output),
+        Err(e) => return format!("Error: git diff failed:\n{}", e),
+    };
+    if !staged {
+        match git(ctx, &["ls-files", "--others", "--exclude-standard"]) {
+            Ok(output) => files.extend(output.lines().filter(|line| !line.is_empty()).map(|path| ChangedFile {
+                path: path.to_string(),
+                status: "?".to_string(),
+            })),
+            Err(e) => return format!("Error: git ls-files failed:\n{}", e),
+        }
+    }
+    let root = match git(ctx, &["rev-parse", "--show-toplevel"]) {
+        Ok(root) => root.trim().to_string(),
+        Err(e) => return format!("Error: git rev-parse failed:\n{}", e),
+    };
+    let commands = history::commands();
+    bundle(base, staged, &stat, &files, Path::new(&root), &test_runs(&commands))
+}
+
+/// Run git, returning its output, or its result as the error if it failed
+fn git(ctx: &ExecutionContext, args: &[&str]) -> Result<String, String> {
+    let mut cmd = Command::new("git");
+    cmd.args(args);
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => Ok(output),
+        result => Err(result.into_string()),
+    }
+}
+
+/// Changed files in `git diff --name-status` output: a status, then a tab and a path, or two for a rename or copy
+fn parse_name_status(output: &str) -> Vec<ChangedFile> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let mut fields = line.split('\t');
+            let status = fields.next()?.chars().next()?;
+            let path = fields.next_back()?;
+            Some(ChangedFile {
+                path: path.to_string(),
+                status: status.to_string(),
+            })
+        })
+        .collect()
+}
+
+/// The Bazel package owning a file under `root`, as a label matching its targets, e.g., "//cmd/server:all".
+/// None when the file is in no package or `root` isn't a Bazel workspace.
+fn package_of(root: &Path, file: &str) -> Option<String> {
+    if !WORKSPACE_FILES.iter().any(|name| root.join(name).is_file()) {
+        return None;
+    }
+    let mut dir = Path::new(file).parent();
+    while let Some(package) = dir {
+        if BUILD_FILES.iter().any(|name| root.join(package).join(name).is_file()) {
+            return Some(format!("//{}:all", package.display()));
+        }
+        dir = package.parent();
+    }
+    None
+}
+
+/// Whether a command ran tests: bazel test, or go test, in any stage
+fn is_test_run(command: &CommandEntry) -> bool {
+    command.argv.iter().any(|argv| {
+        let program = argv.first().map(|program| program.rsplit('/').next().unwrap_or(program));
+        let subcommand = argv.iter().skip(1).find(|arg| !arg.starts_with('-'));
+        matches!(program, Some("bazel" | "bazelisk" | "go")) && subcommand.is_some_and(|arg| arg == "test")
+    })
+}
+
+/// The most recent test runs in the command history, oldest first
+fn test_runs(commands: &[CommandEntry]) -> Vec<&CommandEntry> {
+    let runs: Vec<&CommandEntry> = commands.iter().filter(|command| is_test_run(command)).collect();
+    runs[runs.len().saturating_sub(MAX_TEST_RUNS)..].to_vec()
+}
+
+/// The bundle as JSON: the diff's stat and files, the Bazel packages they touch, and the session's test runs
+/// with the result lines of their output. The test verdict is that of the most recent run.
+fn bundle(base: &str, staged: bool, stat: &str, files: &[ChangedFile], root: &Path, tests: &[&CommandEntry]) -> String {
+    let mut targets = BTreeSet::new();
+    let mut outside_packages = Vec::new();
+    for file in files {
+        match package_of(root, &file.path) {
+            Some(package) => {
+                targets.insert(package);
+            }
+            None => outside_packages.push(file.path.as_str()),
+        }
+    }
+    let test_verdict = match tests.last() {
+        Some(run) if run.exit_code == Some(0) => "passed",
+        Some(_) => "failed",
+        None => "not run",
+    };
+    let tests: Vec<_> = tests
+        .iter()
+        .map(|run| {
+            let stages: Vec<String> = run
+                .argv
+                .iter()
+                .map(|argv| argv.iter().map(|word| quote(word)).collect::<Vec<_>>().join(" "))
+                .collect();
+            let results: Vec<&str> = run
+                .output
+                .lines()
+                .map(str::trim)
+                .filter(|line| TEST_RESULT_LINE.is_match(line))
+                .collect();
+            json!({
+                "id": run.id,
+                "tool": run.tool,
+                "command": stages.join(" | "),
+                "started": format_utc(run.started),
+                "exit_code": run.exit_code,
+                "results": results,
+            })
+        })
+        .collect();
+    let bundle = json!({
+        "session": session_id(),
+        "base": base,
+        "staged": staged,
+        "diff_stat": stat.trim_end(),
+        "files": files.iter().map(|file| json!({"path": file.path, "status": file.status})).collect::<Vec<_>>(),
+        "targets": targets,
+        "files_outside_packages": outside_packages,
+        "test_verdict": test_verdict,
+        "tests": tests,
+    });
+    serde_json::to_string_pretty(&bundle).unwrap_or_default()
+}
+
+/// JSON Schema of the bundle, declared as the tool's output schema
+pub fn output_schema() -> Value {
+    let strings = json!({"type": "array", "items": {"type": "string"}});
+    json!({
+        "type": "object",
+        "properties": {
+            "session": {"type": "string"},
+            "base": {"type": "string"},
+            "staged": {"type": "boolean"},
+            "diff_stat": {"type": "string"},
+            "files": {
+                "type": "array",
+                "items": {
+                    "type": "object",
+                    "properties": {"path": {"type": "string"}, "status": {"type": "string"}},
+                    "required": ["path", "status"],
+                },
+            },
+            "targets": strings,
+            "files_outside_packages": strings,
+            "test_verdict": {"type": "string", "enum": ["passed", "failed", "not run"]},
+            "tests": {
+                "type": "array",
+                "items": {
+                    "type": "object",
+                    "properties": {
+                        "id": {"type": "integer"},
+                        "tool": {"type": "string"},
+                        "command": {"type": "string"},
+                        "started": {"type": "string"},
+                        "exit_code": {"type": ["integer", "null"]},
+                        "results": strings,
+                    },
+                    "required": ["id", "tool", "command", "started", "exit_code", "results"],
+                },
+            },
+        },
+        "required": [
+            "session", "base", "staged", "diff_stat", "files", "targets", "files_outside_packages", "test_verdict",
+            "tests",
+        ],
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn run(id: u64, argv: &[&str], exit_code: Option<i32>, output: &str) -> CommandEntry {
+        CommandEntry {
+            id,
+            tool: "presubmit".to_string(),
+            argv: vec![argv.iter().map(|s| s.to_string()).collect()],
+            started: 1_792_071_900,
+            duration_ms: 1_000,
+            exit_code,
+            output: output.to_string(),
+            output_truncated: false,
+        }
+    }
+
+    #[test]
+    fn test_parse_name_status() {
+        let files = parse_name_status("M\tsrc/main.go\nR087\told.go\tnew.go\nD\tgone.go\n");
+        let paths: Vec<(&str, &str)> = files.iter().map(|f| (f.path.as_str(), f.status.as_str())).collect();
+        assert_eq!(paths, [("src/main.go", "M"), ("new.go", "R"), ("gone.go", "D")]);
+    }
+
+    #[test]
+    fn test_package_of() {
+        let root = TempDir::new().unwrap();
+        fs::create_dir_all(root.path().join("cmd/server/testdata")).unwrap();
+        fs::write(root.path().join("cmd/server/BUILD.bazel"), "").unwrap();
+        assert_eq!(package_of(root.path(), "cmd/server/main.go"), None);
+        fs::write(root.path().join("MODULE.bazel"), "").unwrap();
+        assert_eq!(package_of(root.path(), "cmd/server/testdata/in.txt").as_deref(), Some("//cmd/server:all"));
+        assert_eq!(package_of(root.path(), "README.md"), None);
+    }
+
+    #[test]
+    fn test_bundle() {
+        let commands = [
+            run(1, &["bazel", "test", "//..."], Some(3), "//cmd/server:server_test  FAILED in 1.2s\nnoise\n"),
+            run(2, &["git", "status"], Some(0), ""),
+            run(3, &["go", "test", "./..."], Some(0), "ok  \texample.com/app\t0.01s\n"),
+        ];
+        let tests = test_runs(&commands);
+        assert_eq!(tests.len(), 2);
+        let files = [ChangedFile {
+            path: "main.go".to_string(),
+            status: "M".to_string(),
+        }];
+        let stat = " main.go | 2 +-\n 1 file changed\n";
+        let output = bundle("HEAD", false, stat, &files, Path::new("/nonexistent"), &tests);
+        let parsed: Value = serde_json::from_str(&output).unwrap();
+        assert_eq!(parsed["test_verdict"], "passed");
+        assert_eq!(parsed["files_outside_packages"][0], "main.go");
+        assert_eq!(parsed["tests"][0]["command"], "bazel test //...");
+        assert_eq!(parsed["tests"][0]["results"][0], "//cmd/server:server_test  FAILED in 1.2s");
+        assert_eq!(parsed["tests"][1]["results"].as_array().unwrap().len(), 1);
+        assert_eq!(parsed["diff_stat"], " main.go | 2 +-\n 1 file changed");
+        for field in output_schema()["required"].as_array().unwrap() {
+            assert!(parsed.get(field.as_str().unwrap()).is_some(), "missing {}", field);
+        }
+    }
+}
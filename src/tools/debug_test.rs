@@ -0,0 +1,238 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+use std::process::Command;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::LazyLock;
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::scratch::{scratch_dir, scratch_subdir_in};
+use crate::security::{
+    validate_argument, validate_debugger_command, validate_no_traversal, validate_not_flag, Validatable,
+    ValidationError,
+};
+
+/// Times the test is resumed from a breakpoint when the request doesn't set max_stops
+const DEFAULT_MAX_STOPS: usize = 10;
+
+/// Most times the test can be resumed, so a breakpoint in a hot loop can't run until the timeout
+const MAX_STOPS: usize = 100;
+
+/// Most breakpoints, trace points or expressions per request
+const MAX_POINTS: usize = 20;
+
+/// Init scripts written so far, to give each call its own file
+static SCRIPTS: AtomicUsize = AtomicUsize::new(0);
+
+/// "> [bp1] main.f() ./main.go:8 (hits goroutine(1):1 total:1)" when a breakpoint stops the test,
+/// and "> goroutine(1): [tp1] main.f(1)" when a trace point is hit
+static HIT: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"(?m)^> .*?\[((?:bp|tp)\d+)\]").unwrap());
+
+/// Request parameters for the debug_test tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct DebugTestRequest {
+    /// Go package holding the test, e.g., "./pkg/parser"
+    pub package: String,
+
+    /// Run only tests matching this regular expression, as go test -run, e.g., "TestParse"
+    #[serde(default)]
+    pub test: Option<String>,
+
+    /// Locations to stop at, in delve syntax, e.g., ["parser.go:42", "parser.(*Parser).next"]
+    #[serde(default)]
+    pub breakpoints: Vec<String>,
+
+    /// Locations to count hits of without stopping, e.g., ["parser.parseExpr"]. Each hit is logged with its arguments.
+    #[serde(default)]
+    pub trace_points: Vec<String>,
+
+    /// Expressions printed at every breakpoint stop, e.g., ["tok", "len(p.stack)"]. When empty, each stop
+    /// shows the function's arguments and local variables instead.
+    #[serde(default)]
+    pub print: Vec<String>,
+
+    /// Times to resume the test after a breakpoint stops it (default: 10, maximum: 100)
+    #[serde(default)]
+    pub max_stops: Option<usize>,
+}
+
+impl Validatable for DebugTestRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_argument(&self.package)?;
+        validate_not_flag(&self.package)?;
+        validate_no_traversal(&self.package)?;
+        if let Some(ref test) = self.test {
+            // Passed to the test binary as a single argument, so regular expression syntax is fine
+            validate_not_flag(test)?;
+            validate_debugger_command(test)?;
+        }
+        for point in self.breakpoints.iter().chain(&self.trace_points).chain(&self.print) {
+            validate_debugger_command(point)?;
+        }
+        Ok(())
+    }
+}
+
+/// Debug a Go test under delve with a validated request and execution context
+pub fn execute(req: &DebugTestRequest, ctx: &ExecutionContext) -> String {
+    if req.breakpoints.is_empty() && req.trace_points.is_empty() {
+        return "Error: Set at least one of breakpoints or trace_points".to_string();
+    }
+    if [&req.breakpoints, &req.trace_points, &req.print].iter().any(|points| points.len() > MAX_POINTS) {
+        return format!("Error: At most {} breakpoints, trace points and expressions each are allowed", MAX_POINTS);
+    }
+    if find_executable("dlv").is_none() {
+        return "Error: dlv is not installed. Install it with: go install github.com/go-delve/delve/cmd/dlv@latest"
+            .to_string();
+    }
+    let script = match write_script(req) {
+        Ok(script) => script,
+        Err(e) => return format!("Error: Failed to write the delve init script: {}", e),
+    };
+
+    let mut cmd = Command::new("dlv");
+    cmd.arg("test")
+        .arg(&req.package)
+        .arg("--init")
+        .arg(&script)
+        // The server's stdin isn't a terminal; the init script drives the session instead
+        .arg("--allow-non-terminal-interactive=true");
+    if let Some(ref test) = req.test {
+        cmd.args(["--", "-test.run", test]);
+    }
+    let result = run_command(cmd, ctx);
+    let _ = std::fs::remove_file(&script);
+
+    let output = match result {
+        ExecutionResult::Success(output) => output,
+        other => return other.into_string(),
+    };
+    format!("{}\n\n--- dlv output ---\n{}", hit_counts(req, &output), output)
+}
+
+/// Write the delve commands for a request to a new file in the scratch area
+fn write_script(req: &DebugTestRequest) -> std::io::Result<PathBuf> {
+    let dir = scratch_subdir_in(scratch_dir(), "debug_test")?;
+    let name = format!("{}-{}.dlv", std::process::id(), SCRIPTS.fetch_add(1, Ordering::Relaxed));
+    let path = dir.join(name);
+    std::fs::write(&path, script(req))?;
+    Ok(path)
+}
+
+/// Delve commands that set the requested breakpoints and trace points, resume the test up to
+/// max_stops times, and exit. Breakpoints are named bp1, bp2, ... and trace points tp1, tp2, ...
+/// so their hits can be told apart in the output.
+fn script(req: &DebugTestRequest) -> String {
+    let mut lines = Vec::new();
+    for (i, location) in req.breakpoints.iter().enumerate() {
+        lines.push(format!("break bp{} {}", i + 1, location));
+        for expr in &req.print {
+            lines.push(format!("on bp{} print {}", i + 1, expr));
+        }
+    }
+    for (i, location) in req.trace_points.iter().enumerate() {
+        lines.push(format!("trace tp{} {}", i + 1, location));
+    }
+    // Trace points don't stop the test, so one continue runs it to the end
+    let stops = if req.breakpoints.is_empty() { 1 } else { req.max_stops.unwrap_or(DEFAULT_MAX_STOPS).min(MAX_STOPS) };
+    for _ in 0..stops {
+        lines.push("continue".to_string());
+        if !req.breakpoints.is_empty() && req.print.is_empty() {
+            lines.push("args".to_string());
+            lines.push("locals".to_string());
+        }
+    }
+    lines.push("exit".to_string());
+    lines.join("\n") + "\n"
+}
+
+/// A "Hits:" section with how often each breakpoint and trace point was hit, counted from the stop
+/// and trace lines in delve's output
+fn hit_counts(req: &DebugTestRequest, output: &str) -> String {
+    let hits: Vec<String> = HIT.captures_iter(output).map(|captures| captures[1].to_string()).collect();
+    let count = |name: String| hits.iter().filter(|hit| **hit == name).count();
+    let mut lines = vec!["Hits:".to_string()];
+    for (i, location) in req.breakpoints.iter().enumerate() {
+        lines.push(format!("  break {}: {}", location, count(format!("bp{}", i + 1))));
+    }
+    for (i, location) in req.trace_points.iter().enumerate() {
+        lines.push(format!("  trace {}: {}", location, count(format!("tp{}", i + 1))));
+    }
+    lines.join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(breakpoints: &[&str], trace_points: &[&str], print: &[&str]) -> DebugTestRequest {
+        DebugTestRequest {
+            package: "./parser".to_string(),
+            test: Some("TestParse$".to_string()),
+            breakpoints: breakpoints.iter().map(|s| s.to_string()).collect(),
+            trace_points: trace_points.iter().map(|s| s.to_string()).collect(),
+            print: print.iter().map(|s| s.to_string()).collect(),
+            max_stops: Some(2),
+        }
+    }
+
+    #[test]
+    fn test_script_with_print_expressions() {
+        let req = request(&["parser.go:42"], &["parser.parseExpr"], &["tok", "len(p.stack)"]);
+        assert_eq!(
+            script(&req),
+            "break bp1 parser.go:42\non bp1 print tok\non bp1 print len(p.stack)\ntrace tp1 parser.parseExpr\n\
+             continue\ncontinue\nexit\n"
+        );
+    }
+
+    #[test]
+    fn test_script_snapshots_locals_without_print() {
+        let req = request(&["parser.go:42"], &[], &[]);
+        assert_eq!(
+            script(&req),
+            "break bp1 parser.go:42\ncontinue\nargs\nlocals\ncontinue\nargs\nlocals\nexit\n"
+        );
+        // Trace points alone run the test through once
+        assert_eq!(script(&request(&[], &["f"], &[])), "trace tp1 f\ncontinue\nexit\n");
+    }
+
+    #[test]
+    fn test_hit_counts() {
+        let req = request(&["parser.go:42"], &["parser.parseExpr"], &[]);
+        let output = "> [bp1] parser.(*Parser).next() ./parser.go:42 (hits goroutine(6):1 total:1) (PC: 0x5a1f3c)\n\
+                      > goroutine(6): [tp1] parser.parseExpr(\"1+2\")\n\
+                      >> goroutine(6): => (\"1+2\")\n\
+                      > goroutine(6): [tp1] parser.parseExpr(\"2\")\n\
+                      > [bp1] parser.(*Parser).next() ./parser.go:42 (hits goroutine(6):2 total:2) (PC: 0x5a1f3c)\n";
+        assert_eq!(
+            hit_counts(&req, output),
+            "Hits:\n  break parser.go:42: 2\n  trace parser.parseExpr: 2"
+        );
+    }
+
+    #[test]
+    fn test_execute_requires_a_point() {
+        let req = request(&[], &[], &["x"]);
+        assert_eq!(
+            execute(&req, &ExecutionContext::default()),
+            "Error: Set at least one of breakpoints or trace_points"
+        );
+    }
+
+    #[test]
+    fn test_validate_rejects_script_injection() {
+        let req = request(&["parser.go:42\ncall os.Exit(0)"], &[], &[]);
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidDebuggerCommand(_))));
+        assert!(request(&["parser.go:42"], &[], &["p.stack[0]"]).validate().is_ok());
+    }
+
+    #[test]
+    fn test_validate_blocks_flag_package() {
+        let mut req = request(&["parser.go:42"], &[], &[]);
+        req.package = "-exec=sh".to_string();
+        assert!(req.validate().is_err());
+    }
+}
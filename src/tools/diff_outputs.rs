@@ -0,0 +1,100 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::diff::unified_diff;
+use crate::history::{self, HistoryEntry, MAX_HISTORY_ENTRIES};
+use crate::normalize::normalize;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Request parameters for the diff_outputs tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct DiffOutputsRequest {
+    /// Call number of the earlier execution, e.g., the passing build
+    pub before: u64,
+    /// Call number of the later execution, e.g., the failing build
+    pub after: u64,
+    /// Compare the outputs exactly, without normalizing timestamps, temp paths and durations
+    #[serde(default)]
+    pub raw: bool,
+}
+
+impl Validatable for DiffOutputsRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Diff the outputs of two recorded calls with a validated request and execution context
+pub fn execute(req: &DiffOutputsRequest, _ctx: &ExecutionContext) -> String {
+    match (history::get(req.before), history::get(req.after)) {
+        (Some(before), Some(after)) => diff_entries(&before, &after, req.raw),
+        (None, _) => missing_call(req.before),
+        (_, None) => missing_call(req.after),
+    }
+}
+
+fn missing_call(call: u64) -> String {
+    format!(
+        "Error: Call {} is not in the execution history (only the last {} calls are kept)",
+        call, MAX_HISTORY_ENTRIES
+    )
+}
+
+fn diff_entries(before: &HistoryEntry, after: &HistoryEntry, raw: bool) -> String {
+    let (old, new) = if raw {
+        (before.output.clone(), after.output.clone())
+    } else {
+        (normalize(&before.output), normalize(&after.output))
+    };
+    let old_label = format!("call {} ({})", before.call, before.tool);
+    let new_label = format!("call {} ({})", after.call, after.tool);
+    match unified_diff(&old, &new, &old_label, &new_label) {
+        Some(diff) if diff.is_empty() => format!("No differences between call {} and call {}", before.call, after.call),
+        Some(diff) => diff,
+        None => format!(
+            "Error: Outputs of call {} and call {} differ in too many lines to diff",
+            before.call, after.call
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn entry(call: u64, output: &str) -> HistoryEntry {
+        HistoryEntry {
+            call,
+            tool: "presubmit".to_string(),
+            input: json!({}),
+            output: output.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_diff_entries_normalizes_by_default() {
+        let before = entry(1, "ok  pkg/a 0.12s\nok  pkg/b 0.40s\n");
+        let after = entry(2, "ok  pkg/a 0.15s\nFAIL pkg/b 0.41s\n");
+        let diff = diff_entries(&before, &after, false);
+        assert!(diff.starts_with("--- call 1 (presubmit)\n+++ call 2 (presubmit)\n"));
+        assert!(diff.contains(" ok  pkg/a <duration>\n-ok  pkg/b <duration>\n+FAIL pkg/b <duration>\n"));
+    }
+
+    #[test]
+    fn test_diff_entries_raw() {
+        let before = entry(1, "took 1s");
+        let after = entry(2, "took 2s");
+        assert_eq!(diff_entries(&before, &after, false), "No differences between call 1 and call 2");
+        assert!(diff_entries(&before, &after, true).contains("-took 1s\n+took 2s\n"));
+    }
+
+    #[test]
+    fn test_execute_missing_call() {
+        history::record(3_000_001, "git", json!({}), "clean");
+        let req = DiffOutputsRequest { before: 3_000_001, after: 3_000_999, raw: false };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.starts_with("Error: Call 3000999 is not in the execution history"));
+    }
+}
@@ -0,0 +1,254 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::ignore::IgnoreRules;
+use crate::request::ExecutionContext;
+use crate::security::{validate_no_traversal, validate_path, validate_path_with_working_dir, Validatable, ValidationError};
+use crate::tools::download::sha256_file;
+use crate::tools::owners::pattern_to_regex;
+
+/// Request parameters for the digest tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct DigestRequest {
+    /// The directory to digest. Defaults to "." if not provided.
+    #[serde(default = "default_path")]
+    pub path: String,
+
+    /// Gitignore-style patterns for files and directories to leave out, relative to path, e.g., ["bazel-*", "*.log"]
+    #[serde(default)]
+    pub ignore: Vec<String>,
+
+    /// Also leave out what the repository's .gitignore and .bazelignore files ignore
+    #[serde(default)]
+    pub respect_ignore: bool,
+
+    /// A digest returned by an earlier call, to report whether anything changed since
+    #[serde(default)]
+    pub previous: Option<String>,
+}
+
+fn default_path() -> String {
+    ".".to_string()
+}
+
+impl Validatable for DigestRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_no_traversal(&self.path)?;
+        validate_path(&self.path)?;
+        Ok(())
+    }
+}
+
+/// Totals gathered while digesting a tree
+#[derive(Debug, Default, PartialEq)]
+struct Totals {
+    files: usize,
+    bytes: u64,
+}
+
+/// Digest a directory with a validated request and execution context
+pub fn execute(req: &DigestRequest, ctx: &ExecutionContext) -> String {
+    if let Some(ref working_dir) = ctx.working_dir {
+        if let Err(e) = validate_path_with_working_dir(&req.path, working_dir) {
+            return e.to_string();
+        }
+    }
+    let root = match ctx.working_dir {
+        Some(ref dir) => Path::new(dir).join(&req.path),
+        None => PathBuf::from(&req.path),
+    };
+    if !root.is_dir() {
+        return format!("Error: '{}' is not a directory", req.path);
+    }
+
+    let mut ignore = Vec::new();
+    for pattern in &req.ignore {
+        match pattern_to_regex(pattern) {
+            Some(regex) => ignore.push(regex),
+            None => return format!("Error: Invalid ignore pattern '{}'", pattern),
+        }
+    }
+
+    let mut ignore_files = req.respect_ignore.then(|| IgnoreRules::for_dir(&root));
+    let mut totals = Totals::default();
+    let digest = match digest_dir(&root, "", &ignore, ignore_files.as_mut(), &mut totals) {
+        Ok(digest) => digest,
+        Err(e) => return format!("Error: Failed to digest '{}': {}", req.path, e),
+    };
+
+    let mut lines = vec![
+        format!("Digest: {}", digest),
+        format!("Files: {} ({} bytes)", totals.files, totals.bytes),
+    ];
+    if let Some(ref previous) = req.previous {
+        lines.push(if previous.trim() == digest {
+            "Unchanged since the previous digest".to_string()
+        } else {
+            "Changed since the previous digest".to_string()
+        });
+    }
+    lines.join("\n")
+}
+
+/// Merkle-style digest of a directory: each entry is hashed by type, name and content digest, in name
+/// order, so a change anywhere below changes every digest up to the root. Symlinks are hashed by their
+/// target rather than followed, and `.git` directories are always skipped.
+fn digest_dir(
+    dir: &Path,
+    relative: &str,
+    ignore: &[Regex],
+    mut ignore_files: Option<&mut IgnoreRules>,
+    totals: &mut Totals,
+) -> std::io::Result<String> {
+    let mut entries: Vec<_> = fs::read_dir(dir)?.collect::<Result<_, _>>()?;
+    entries.sort_by_key(|entry| entry.file_name());
+
+    let mut hasher = Sha256::new();
+    for entry in entries {
+        let name = entry.file_name().to_string_lossy().to_string();
+        let path = if relative.is_empty() { name.clone() } else { format!("{}/{}", relative, name) };
+        if ignore.iter().any(|regex| regex.is_match(&path))
+            || ignore_files.as_ref().is_some_and(|rules| rules.is_ignored(&entry.path()))
+        {
+            continue;
+        }
+        let file_type = entry.file_type()?;
+        let (kind, digest) = if file_type.is_symlink() {
+            ("link", format!("{}", fs::read_link(entry.path())?.display()))
+        } else if file_type.is_dir() {
+            if name == ".git" {
+                continue;
+            }
+            if let Some(rules) = ignore_files.as_mut() {
+                rules.enter(&entry.path());
+            }
+            ("dir", digest_dir(&entry.path(), &path, ignore, ignore_files.as_deref_mut(), totals)?)
+        } else if file_type.is_file() {
+            totals.files += 1;
+            totals.bytes += entry.metadata()?.len();
+            ("file", sha256_file(&entry.path())?)
+        } else {
+            continue;
+        };
+        hasher.update(format!("{} {} {}\n", kind, name, digest));
+    }
+    Ok(format!("{:x}", hasher.finalize()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn setup_tree() -> TempDir {
+        let temp_dir = TempDir::new().unwrap();
+        fs::create_dir_all(temp_dir.path().join("src/pkg")).unwrap();
+        fs::write(temp_dir.path().join("src/main.go"), "package main\n").unwrap();
+        fs::write(temp_dir.path().join("src/pkg/lib.go"), "package pkg\n").unwrap();
+        temp_dir
+    }
+
+    fn run(temp_dir: &TempDir, ignore: &[&str], previous: Option<&str>) -> String {
+        let req = DigestRequest {
+            path: "src".to_string(),
+            ignore: ignore.iter().map(|s| s.to_string()).collect(),
+            respect_ignore: false,
+            previous: previous.map(String::from),
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        execute(&req, &ctx)
+    }
+
+    fn digest_of(output: &str) -> String {
+        output.lines().next().unwrap().trim_start_matches("Digest: ").to_string()
+    }
+
+    #[test]
+    fn test_digest_detects_nested_change() {
+        let temp_dir = setup_tree();
+        let first = run(&temp_dir, &[], None);
+        assert!(first.ends_with("\nFiles: 2 (25 bytes)"), "{}", first);
+        let digest = digest_of(&first);
+        assert!(run(&temp_dir, &[], Some(&digest)).ends_with("Unchanged since the previous digest"));
+
+        fs::write(temp_dir.path().join("src/pkg/lib.go"), "package pkg // edited\n").unwrap();
+        assert!(run(&temp_dir, &[], Some(&digest)).ends_with("Changed since the previous digest"));
+    }
+
+    #[test]
+    fn test_digest_respects_ignore_patterns() {
+        let temp_dir = setup_tree();
+        let digest = digest_of(&run(&temp_dir, &["*.log", "out/"], None));
+        fs::write(temp_dir.path().join("src/build.log"), "noise").unwrap();
+        fs::create_dir(temp_dir.path().join("src/out")).unwrap();
+        fs::write(temp_dir.path().join("src/out/app"), "binary").unwrap();
+        fs::create_dir(temp_dir.path().join("src/.git")).unwrap();
+        assert_eq!(digest_of(&run(&temp_dir, &["*.log", "out/"], None)), digest);
+        assert_ne!(digest_of(&run(&temp_dir, &[], None)), digest);
+    }
+
+    #[test]
+    fn test_digest_respects_ignore_files() {
+        let temp_dir = setup_tree();
+        fs::create_dir(temp_dir.path().join(".git")).unwrap();
+        fs::write(temp_dir.path().join(".gitignore"), "*.o\n").unwrap();
+        fs::write(temp_dir.path().join("src/pkg/.gitignore"), "testdata/\n").unwrap();
+        let req = DigestRequest {
+            path: "src".to_string(),
+            ignore: vec![],
+            respect_ignore: true,
+            previous: None,
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        let digest = digest_of(&execute(&req, &ctx));
+        fs::write(temp_dir.path().join("src/main.o"), "object").unwrap();
+        fs::create_dir(temp_dir.path().join("src/pkg/testdata")).unwrap();
+        fs::write(temp_dir.path().join("src/pkg/testdata/in.txt"), "data").unwrap();
+        assert_eq!(digest_of(&execute(&req, &ctx)), digest);
+    }
+
+    #[test]
+    fn test_digest_renamed_file_changes_digest() {
+        let temp_dir = setup_tree();
+        let digest = digest_of(&run(&temp_dir, &[], None));
+        fs::rename(temp_dir.path().join("src/main.go"), temp_dir.path().join("src/app.go")).unwrap();
+        assert_ne!(digest_of(&run(&temp_dir, &[], None)), digest);
+    }
+
+    #[test]
+    fn test_digest_not_a_directory() {
+        let temp_dir = setup_tree();
+        let req = DigestRequest {
+            path: "src/main.go".to_string(),
+            ignore: vec![],
+            respect_ignore: false,
+            previous: None,
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        assert_eq!(execute(&req, &ctx), "Error: 'src/main.go' is not a directory");
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = DigestRequest {
+            path: "../src".to_string(),
+            ignore: vec![],
+            respect_ignore: false,
+            previous: None,
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+}
@@ -0,0 +1,386 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+use crate::executor::{find_executable, run_command, watchdog_stats, ExecutionResult};
+use crate::maintenance::frozen_reason;
+use crate::profile;
+use crate::request::ExecutionContext;
+use crate::retention::{cleanup_stats, quota, scratch_usage, CleanupStats};
+use crate::scratch::{ensure_writable, scratch_dir};
+use crate::security::{Validatable, ValidationError};
+use crate::tools::host_info::format_bytes;
+use crate::toolchain::{mismatches, pins};
+use crate::transcript::format_utc;
+use crate::tools::presubmit;
+
+/// Free space below which the scratch area is reported as a problem
+const MIN_FREE_BYTES: u64 = 1024 * 1024 * 1024;
+
+/// Share of the scratch quota above which usage is reported as a problem, in percent
+const QUOTA_WARN_PERCENT: u64 = 90;
+
+/// Request parameters for the doctor tool (takes no tool-specific parameters)
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct DoctorRequest {}
+
+impl Validatable for DoctorRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Outcome of a single check, ordered from best to worst
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum Status {
+    /// Not applicable on this host
+    Skip,
+    Ok,
+    Warn,
+    Fail,
+}
+
+impl Status {
+    fn label(self) -> &'static str {
+        match self {
+            Status::Skip => "SKIP",
+            Status::Ok => "OK",
+            Status::Warn => "WARN",
+            Status::Fail => "FAIL",
+        }
+    }
+}
+
+/// Result of checking one part of the environment
+#[derive(Debug)]
+struct Check {
+    name: String,
+    status: Status,
+    detail: String,
+    /// How to fix a problem, shown for WARN and FAIL
+    hint: Option<String>,
+}
+
+impl Check {
+    fn new(name: &str, status: Status, detail: impl Into<String>) -> Self {
+        Self {
+            name: name.to_string(),
+            status,
+            detail: detail.into(),
+            hint: None,
+        }
+    }
+
+    fn hint(mut self, hint: impl Into<String>) -> Self {
+        self.hint = Some(hint.into());
+        self
+    }
+}
+
+/// Check the health of the execution environment with a validated request and execution context
+pub fn execute(_req: &DoctorRequest, ctx: &ExecutionContext) -> String {
+    let mut checks = vec![
+        check_binary(ctx, "git", Status::Fail, "install git; the git tool needs it"),
+        check_binary(ctx, "curl", Status::Warn, "install curl; the download tool needs it"),
+    ];
+    for (stage, program) in presubmit::configured_programs() {
+        let hint = format!("install {} or fix PRESUBMIT_{}_CMD", program, stage.to_uppercase());
+        checks.push(check_binary(ctx, &program, Status::Fail, &hint));
+    }
+    checks.push(check_bazel(ctx));
+    checks.push(check_toolchains(ctx));
+    checks.push(check_writable(scratch_dir()));
+    checks.push(check_disk_space(ctx, scratch_dir()));
+    checks.push(check_quota(scratch_usage(), quota()));
+    checks.push(check_cleanup(&cleanup_stats()));
+    checks.push(check_clock(ctx));
+    checks.push(check_executor());
+    checks.push(match frozen_reason() {
+        Some(reason) => Check::new("freeze", Status::Warn, format!("frozen: {}", reason))
+            .hint("wait for the operator to remove FREEZE_FILE"),
+        None => Check::new("freeze", Status::Ok, "not frozen"),
+    });
+    render(&checks)
+}
+
+/// Format the checks as a report headed by the worst status
+fn render(checks: &[Check]) -> String {
+    let overall = checks.iter().map(|c| c.status).max().unwrap_or(Status::Ok).max(Status::Ok);
+    let mut lines = vec![format!("Overall: {}", overall.label())];
+    for check in checks {
+        lines.push(format!("[{}] {}: {}", check.status.label(), check.name, check.detail));
+        if let (Status::Warn | Status::Fail, Some(hint)) = (check.status, &check.hint) {
+            lines.push(format!("  hint: {}", hint));
+        }
+    }
+    lines.join("\n")
+}
+
+/// Check that a program is on PATH and report its version.
+/// `missing` is the status reported when it is not installed.
+fn check_binary(ctx: &ExecutionContext, name: &str, missing: Status, hint: &str) -> Check {
+    // Presubmit commands may name their program by absolute path
+    let found = if Path::new(name).is_absolute() {
+        Some(PathBuf::from(name)).filter(|p| p.is_file())
+    } else {
+        find_executable(name)
+    };
+    let path = match found {
+        Some(path) => path,
+        None => return Check::new(name, missing, "not found on PATH").hint(hint),
+    };
+    let mut cmd = Command::new(&path);
+    cmd.arg("--version");
+    let version = match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => output.lines().next().unwrap_or_default().trim().to_string(),
+        _ => String::new(),
+    };
+    let detail = if version.is_empty() {
+        path.display().to_string()
+    } else {
+        format!("{} ({})", version, path.display())
+    };
+    Check::new(name, Status::Ok, detail)
+}
+
+/// Report the bazel version and whether a bazel server is already running.
+/// `bazel info` would start a server, so the process list is checked instead.
+fn check_bazel(ctx: &ExecutionContext) -> Check {
+    if find_executable("bazel").is_none() {
+        return Check::new("bazel", Status::Skip, "not installed");
+    }
+    let check = check_binary(ctx, "bazel", Status::Warn, "");
+    let mut cmd = Command::new("pgrep");
+    cmd.args(["-f", "A-server.jar"]);
+    let server = match run_command(cmd, ctx) {
+        ExecutionResult::Success(_) => "server running",
+        _ => "server not running; the first build will start it",
+    };
+    Check::new("bazel", check.status, format!("{}, {}", check.detail, server))
+}
+
+/// Check the pinned toolchain versions in the environment presubmit runs in
+fn check_toolchains(ctx: &ExecutionContext) -> Check {
+    let name = "toolchain";
+    if pins().is_empty() {
+        return Check::new(name, Status::Skip, "no versions pinned");
+    }
+    let ctx = ExecutionContext {
+        profile: profile::for_tool("presubmit"),
+        ..ctx.clone()
+    };
+    let warnings = mismatches(&ctx);
+    if warnings.is_empty() {
+        let programs: Vec<&str> = pins().iter().map(|pin| pin.program.as_str()).collect();
+        return Check::new(name, Status::Ok, format!("{} match their pins", programs.join(", ")));
+    }
+    let details: Vec<&str> = warnings
+        .iter()
+        .map(|w| w.trim_start_matches("Warning: toolchain mismatch: "))
+        .collect();
+    Check::new(name, Status::Warn, details.join("; "))
+        .hint("install the pinned versions or update TOOLCHAIN_PINS")
+}
+
+/// Check that the scratch area exists and files can be written to it
+fn check_writable(dir: &Path) -> Check {
+    let name = "scratch";
+    let hint = format!("make {} writable by the server user or set SCRATCH_DIR", dir.display());
+    match ensure_writable(dir) {
+        Ok(()) => Check::new(name, Status::Ok, format!("{} is writable", dir.display())),
+        Err(e) => Check::new(name, Status::Fail, format!("cannot write to {}: {}", dir.display(), e)).hint(hint),
+    }
+}
+
+/// Check free space on the filesystem holding the scratch area
+fn check_disk_space(ctx: &ExecutionContext, dir: &Path) -> Check {
+    let name = "disk";
+    let mut cmd = Command::new("df");
+    cmd.arg("-Pk").arg(dir);
+    let available = match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => parse_df_available(&output),
+        _ => None,
+    };
+    match available {
+        None => Check::new(name, Status::Skip, format!("could not determine free space for {}", dir.display())),
+        Some(bytes) if bytes < MIN_FREE_BYTES => Check::new(
+            name,
+            Status::Warn,
+            format!("{} free on {}", format_bytes(bytes), dir.display()),
+        )
+        .hint(format!(
+            "free up space or point SCRATCH_DIR at a larger disk (at least {} recommended)",
+            format_bytes(MIN_FREE_BYTES)
+        )),
+        Some(bytes) => Check::new(name, Status::Ok, format!("{} free on {}", format_bytes(bytes), dir.display())),
+    }
+}
+
+/// Parse the available space in bytes from POSIX `df -Pk` output
+pub fn parse_df_available(output: &str) -> Option<u64> {
+    let fields: Vec<&str> = output.lines().nth(1)?.split_whitespace().collect();
+    fields.get(3)?.parse::<u64>().ok().map(|kb| kb * 1024)
+}
+
+/// Report what the scheduled scratch cleanup has removed, and when it runs next
+fn check_cleanup(stats: &CleanupStats) -> Check {
+    let name = "cleanup";
+    let Some(ref schedule) = stats.schedule else {
+        return Check::new(name, Status::Ok, "not scheduled; no retention limits are set");
+    };
+    let mut detail = format!(
+        "schedule \"{}\"; {} runs removed {} items, freeing {}",
+        schedule,
+        stats.runs,
+        stats.removed,
+        format_bytes(stats.freed)
+    );
+    if let Some(last_run) = stats.last_run {
+        detail.push_str(&format!("; last run {}", format_utc(last_run)));
+    }
+    match stats.next_run {
+        Some(next_run) => {
+            detail.push_str(&format!("; next run {}", format_utc(next_run)));
+            Check::new(name, Status::Ok, detail)
+        }
+        None => Check::new(name, Status::Warn, detail).hint("fix CLEANUP_SCHEDULE; it never matches"),
+    }
+}
+
+/// Report scratch usage against the quota, if one is configured
+fn check_quota(used: u64, quota: Option<u64>) -> Check {
+    let name = "quota";
+    let quota = match quota {
+        Some(quota) => quota,
+        None => return Check::new(name, Status::Ok, format!("{} used, no quota", format_bytes(used))),
+    };
+    let detail = format!("{} used of {}", format_bytes(used), format_bytes(quota));
+    if used.saturating_mul(100) >= quota.saturating_mul(QUOTA_WARN_PERCENT) {
+        Check::new(name, Status::Warn, detail)
+            .hint("remove old downloads with the purge_scratch tool or raise SCRATCH_QUOTA_BYTES")
+    } else {
+        Check::new(name, Status::Ok, detail)
+    }
+}
+
+/// Report running commands and reader threads abandoned after killing a command
+fn check_executor() -> Check {
+    let stats = watchdog_stats();
+    let detail = format!("{} running, {} leaked", stats.running, stats.leaked);
+    if stats.leaked > 0 {
+        Check::new("executor", Status::Warn, detail).hint(
+            "a timed-out command left descendants holding its output open; find them with ps and kill them, or restart the server",
+        )
+    } else {
+        Check::new("executor", Status::Ok, detail)
+    }
+}
+
+/// Check that the system clock is synchronized, since skew breaks remote caches and TLS
+fn check_clock(ctx: &ExecutionContext) -> Check {
+    let name = "clock";
+    let mut cmd = Command::new("timedatectl");
+    cmd.args(["show", "--property=NTPSynchronized", "--value"]);
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) if output.trim() == "yes" => {
+            Check::new(name, Status::Ok, "synchronized with NTP")
+        }
+        ExecutionResult::Success(output) if output.trim() == "no" => {
+            Check::new(name, Status::Warn, "not synchronized with NTP")
+                .hint("enable time synchronization, e.g., timedatectl set-ntp true")
+        }
+        _ => Check::new(name, Status::Skip, "synchronization status unavailable"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_render_reports_worst_status_and_hints() {
+        let checks = vec![
+            Check::new("git", Status::Ok, "git version 2.43.0").hint("unused"),
+            Check::new("curl", Status::Warn, "not found on PATH").hint("install curl"),
+            Check::new("bazel", Status::Skip, "not installed"),
+        ];
+        assert_eq!(
+            render(&checks),
+            "Overall: WARN\n[OK] git: git version 2.43.0\n[WARN] curl: not found on PATH\n  hint: install curl\n[SKIP] bazel: not installed"
+        );
+    }
+
+    #[test]
+    fn test_render_skipped_checks_do_not_lower_overall() {
+        let checks = vec![Check::new("bazel", Status::Skip, "not installed")];
+        assert!(render(&checks).starts_with("Overall: OK"));
+    }
+
+    #[test]
+    fn test_check_binary() {
+        let ctx = ExecutionContext::default();
+        assert_eq!(check_binary(&ctx, "sh", Status::Fail, "").status, Status::Ok);
+        let missing = check_binary(&ctx, "no-such-binary-for-doctor", Status::Fail, "install it");
+        assert_eq!(missing.status, Status::Fail);
+        assert_eq!(missing.hint.as_deref(), Some("install it"));
+    }
+
+    #[test]
+    fn test_check_writable() {
+        let temp_dir = TempDir::new().unwrap();
+        let dir = temp_dir.path().join("scratch");
+        let check = check_writable(&dir);
+        assert_eq!(check.status, Status::Ok);
+        assert!(dir.is_dir());
+    }
+
+    #[test]
+    fn test_parse_df_available() {
+        let output = "Filesystem     1024-blocks      Used Available Capacity Mounted on\n/dev/sda1        102400000  51200000  51200000      50% /\n";
+        assert_eq!(parse_df_available(output), Some(51_200_000 * 1024));
+        assert_eq!(parse_df_available("Filesystem\n"), None);
+    }
+
+    #[test]
+    fn test_check_quota() {
+        assert_eq!(check_quota(1024, None).detail, "1.0 KiB used, no quota");
+        let check = check_quota(512 * 1024, Some(1024 * 1024));
+        assert_eq!(check.status, Status::Ok);
+        assert_eq!(check.detail, "512.0 KiB used of 1.0 MiB");
+        assert_eq!(check_quota(950, Some(1000)).status, Status::Warn);
+    }
+
+    #[test]
+    fn test_check_cleanup() {
+        assert_eq!(check_cleanup(&CleanupStats::default()).detail, "not scheduled; no retention limits are set");
+        let stats = CleanupStats {
+            schedule: Some("0 3 * * *".to_string()),
+            runs: 2,
+            removed: 3,
+            freed: 2048,
+            last_run: Some(1_792_033_200),
+            next_run: Some(1_792_119_600),
+        };
+        let check = check_cleanup(&stats);
+        assert_eq!(check.status, Status::Ok);
+        assert_eq!(
+            check.detail,
+            "schedule \"0 3 * * *\"; 2 runs removed 3 items, freeing 2.0 KiB; last run 2026-10-15T03:00:00Z; \
+             next run 2026-10-16T03:00:00Z"
+        );
+    }
+
+    #[test]
+    fn test_doctor_reports_core_checks() {
+        let result = execute(&DoctorRequest {}, &ExecutionContext::default());
+        assert!(result.starts_with("Overall: "));
+        let names = [
+            "] git:", "] toolchain:", "] scratch:", "] disk:", "] quota:", "] clock:", "] executor:", "] freeze:",
+        ];
+        for name in names {
+            assert!(result.contains(name), "missing {} in {}", name, result);
+        }
+    }
+}
@@ -0,0 +1,114 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::Path;
+
+use crate::request::ExecutionContext;
+use crate::security::{validate_no_traversal, validate_path, validate_path_with_working_dir, Validatable, ValidationError};
+
+/// Request parameters for the exists tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct ExistsRequest {
+    /// The paths to check, relative to working_dir if not absolute
+    pub paths: Vec<String>,
+}
+
+impl Validatable for ExistsRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        for path in &self.paths {
+            validate_no_traversal(path)?;
+            validate_path(path)?;
+        }
+        Ok(())
+    }
+}
+
+/// Check the paths with a validated request and execution context
+pub fn execute(req: &ExistsRequest, ctx: &ExecutionContext) -> String {
+    if let Some(ref working_dir) = ctx.working_dir {
+        for path in &req.paths {
+            if let Err(e) = validate_path_with_working_dir(path, working_dir) {
+                return e.to_string();
+            }
+        }
+    }
+
+    let mut found = 0;
+    let mut lines = Vec::with_capacity(req.paths.len() + 1);
+    for path in &req.paths {
+        let full = match ctx.working_dir {
+            Some(ref dir) => Path::new(dir).join(path),
+            None => Path::new(path).to_path_buf(),
+        };
+        let status = describe(&full);
+        if status != "missing" {
+            found += 1;
+        }
+        lines.push(format!("{}: {}", path, status));
+    }
+    lines.push(format!("{} of {} exist", found, req.paths.len()));
+    lines.join("\n")
+}
+
+/// Type and size of a path, without following a final symlink; "missing" if it doesn't exist
+fn describe(path: &Path) -> String {
+    let metadata = match fs::symlink_metadata(path) {
+        Ok(metadata) => metadata,
+        Err(_) => return "missing".to_string(),
+    };
+    let file_type = metadata.file_type();
+    if file_type.is_file() {
+        format!("file, {} bytes", metadata.len())
+    } else if file_type.is_dir() {
+        "directory".to_string()
+    } else if file_type.is_symlink() {
+        match fs::read_link(path) {
+            Ok(target) => format!("symlink to {}", target.display()),
+            Err(_) => "symlink".to_string(),
+        }
+    } else {
+        "other".to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_exists_reports_each_path() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("gen.pb.go"), "package gen\n").unwrap();
+        fs::create_dir(temp_dir.path().join("out")).unwrap();
+        let req = ExistsRequest {
+            paths: vec!["gen.pb.go".to_string(), "out".to_string(), "gen_test.pb.go".to_string()],
+        };
+        assert!(req.validate().is_ok());
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        assert_eq!(
+            execute(&req, &ctx),
+            "gen.pb.go: file, 12 bytes\nout: directory\ngen_test.pb.go: missing\n2 of 3 exist"
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_exists_reports_symlink_target() {
+        let temp_dir = TempDir::new().unwrap();
+        let link = temp_dir.path().join("latest");
+        std::os::unix::fs::symlink("build-42", &link).unwrap();
+        assert_eq!(describe(&link), "symlink to build-42");
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = ExistsRequest {
+            paths: vec!["out".to_string(), "../secret".to_string()],
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+}
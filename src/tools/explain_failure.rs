@@ -0,0 +1,222 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::Path;
+use std::sync::LazyLock;
+
+use crate::classify::{classify, is_failure};
+use crate::history::{self, HistoryEntry, MAX_HISTORY_ENTRIES};
+use crate::request::ExecutionContext;
+use crate::security::{
+    validate_no_traversal, validate_path, validate_path_with_working_dir, Validatable, ValidationError,
+};
+use crate::severity::{severity, Severity};
+
+/// Most diagnostic lines included in a report
+const MAX_DIAGNOSTICS: usize = 20;
+
+/// Most referenced files included in a report
+const MAX_FILES: usize = 10;
+
+/// Lines of output shown before and after the first diagnostic
+const EXCERPT_BEFORE: usize = 3;
+const EXCERPT_AFTER: usize = 8;
+
+/// File references such as "src/lib.rs:10:5" or "pkg/foo_test.go:42"
+pub static FILE_REFERENCE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"([A-Za-z0-9_./-]*[A-Za-z0-9_-]\.[A-Za-z0-9]+):(\d+)").unwrap());
+
+/// Request parameters for the explain_failure tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct ExplainFailureRequest {
+    /// Call number of the failed execution, as shown in the session transcript. Defaults to the most recent failure.
+    #[serde(default)]
+    pub call: Option<u64>,
+}
+
+impl Validatable for ExplainFailureRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Explain a failed call with a validated request and execution context
+pub fn execute(req: &ExplainFailureRequest, _ctx: &ExecutionContext) -> String {
+    let entry = match req.call {
+        Some(call) => history::get(call),
+        None => history::latest(|e| e.tool != "explain_failure" && is_failure(&e.output)),
+    };
+    match entry {
+        Some(entry) => explain(&entry),
+        None => match req.call {
+            Some(call) => format!(
+                "Error: Call {} is not in the execution history (only the last {} calls are kept)",
+                call, MAX_HISTORY_ENTRIES
+            ),
+            None => "No failed calls in the execution history".to_string(),
+        },
+    }
+}
+
+/// Assemble the failure report for a recorded call
+fn explain(entry: &HistoryEntry) -> String {
+    if !is_failure(&entry.output) {
+        return format!("Call {} ({}) did not fail", entry.call, entry.tool);
+    }
+    let lines: Vec<&str> = entry.output.lines().collect();
+    let diagnostics: Vec<usize> = (0..lines.len())
+        .filter(|&i| !lines[i].starts_with("Failure category: ") && severity(lines[i]) == Severity::Error)
+        .collect();
+
+    let mut report = vec![
+        format!("Call {} ({}) failed", entry.call, entry.tool),
+        format!("Category: {}", category(&entry.output)),
+    ];
+
+    if !diagnostics.is_empty() {
+        report.push(String::new());
+        report.push("Diagnostics:".to_string());
+        for &i in diagnostics.iter().take(MAX_DIAGNOSTICS) {
+            report.push(format!("  {}", lines[i].trim_end()));
+        }
+        if diagnostics.len() > MAX_DIAGNOSTICS {
+            report.push(format!("  ... {} more", diagnostics.len() - MAX_DIAGNOSTICS));
+        }
+    }
+
+    // The output around the first diagnostic, or the end of the output if none was found
+    let (start, end) = match diagnostics.first() {
+        Some(&first) => (first.saturating_sub(EXCERPT_BEFORE), (first + EXCERPT_AFTER + 1).min(lines.len())),
+        None => (lines.len().saturating_sub(EXCERPT_BEFORE + EXCERPT_AFTER + 1), lines.len()),
+    };
+    report.push(String::new());
+    report.push(format!("Excerpt (lines {}-{} of {}):", start + 1, end, lines.len()));
+    for line in &lines[start..end] {
+        report.push(format!("  {}", line.trim_end()));
+    }
+
+    let working_dir = entry.input.get("working_dir").and_then(|v| v.as_str());
+    // Compilers often give the location on a line of its own, so the whole output is searched
+    let files = referenced_files(lines.iter().copied(), working_dir);
+    if !files.is_empty() {
+        report.push(String::new());
+        report.push("Referenced files:".to_string());
+        report.extend(files.iter().map(|file| format!("  {}", file)));
+    }
+    report.join("\n")
+}
+
+/// The failure category reported in the output, or a fresh classification if it has none
+fn category(output: &str) -> &str {
+    output
+        .lines()
+        .rev()
+        .find_map(|line| line.strip_prefix("Failure category: "))
+        .unwrap_or_else(|| classify(output))
+}
+
+/// Files referenced in the output that exist and may be read, each with the referenced line
+fn referenced_files<'a>(lines: impl Iterator<Item = &'a str>, working_dir: Option<&str>) -> Vec<String> {
+    let mut files: Vec<String> = Vec::new();
+    for line in lines {
+        for capture in FILE_REFERENCE.captures_iter(line) {
+            let (path, number) = (&capture[1], &capture[2]);
+            let reference = format!("{}:{}", path, number);
+            if files.len() >= MAX_FILES || files.iter().any(|f| f.starts_with(&format!("{}: ", reference))) {
+                continue;
+            }
+            if let Some(source) = read_line(path, number.parse().unwrap_or(0), working_dir) {
+                files.push(format!("{}: {}", reference, source.trim()));
+            }
+        }
+    }
+    files
+}
+
+/// Read one line of a referenced file, applying the same path restrictions as the other tools
+fn read_line(path: &str, number: usize, working_dir: Option<&str>) -> Option<String> {
+    validate_no_traversal(path).ok()?;
+    let full = match working_dir {
+        Some(dir) if !Path::new(path).is_absolute() => {
+            validate_path_with_working_dir(path, dir).ok()?;
+            Path::new(dir).join(path)
+        }
+        _ => {
+            validate_path(path).ok()?;
+            Path::new(path).to_path_buf()
+        }
+    };
+    let content = fs::read_to_string(full).ok()?;
+    content.lines().nth(number.checked_sub(1)?).map(str::to_string)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+    use tempfile::TempDir;
+
+    fn entry(call: u64, tool: &str, input: serde_json::Value, output: &str) -> HistoryEntry {
+        HistoryEntry {
+            call,
+            tool: tool.to_string(),
+            input,
+            output: output.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_explain_compile_error() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::create_dir(temp_dir.path().join("src")).unwrap();
+        fs::write(temp_dir.path().join("src/lib.rs"), "fn a() {}\nfn b() -> u32 { \"x\" }\n").unwrap();
+        let output = "Verdict: FAIL\nbuild: FAIL\n\n--- build output ---\nError:    Compiling app v0.1.0\nerror[E0308]: mismatched types\n --> src/lib.rs:2:17\n\nFailure category: compile-error";
+        let input = json!({"working_dir": temp_dir.path().to_string_lossy()});
+        let report = explain(&entry(7, "presubmit", input, output));
+
+        assert!(report.starts_with("Call 7 (presubmit) failed\nCategory: compile-error\n"), "{}", report);
+        assert!(report.contains("Diagnostics:\n  Error:    Compiling app v0.1.0\n  error[E0308]: mismatched types\n"));
+        assert!(report.contains("Excerpt (lines 2-"));
+        assert!(report.ends_with("Referenced files:\n  src/lib.rs:2: fn b() -> u32 { \"x\" }"), "{}", report);
+    }
+
+    #[test]
+    fn test_explain_skips_missing_and_traversing_files() {
+        let output = "Error: pkg/missing.go:3: undefined: x\n../../etc/passwd:1: error\nFailure category: unknown";
+        let report = explain(&entry(8, "presubmit", json!({}), output));
+        assert!(!report.contains("Referenced files:"), "{}", report);
+    }
+
+    #[test]
+    fn test_explain_successful_call() {
+        assert_eq!(
+            explain(&entry(9, "git", json!({}), "On branch main")),
+            "Call 9 (git) did not fail"
+        );
+    }
+
+    #[test]
+    fn test_category_prefers_reported_category() {
+        assert_eq!(category("Error: boom\nFailure category: flaky-infra"), "flaky-infra");
+        assert_eq!(category("Error: No space left on device"), "disk-full");
+    }
+
+    #[test]
+    fn test_execute_defaults_to_latest_failure() {
+        history::record(4_000_001, "git", json!({}), "Error: fatal: not a git repository\nFailure category: not-found");
+        history::record(4_000_002, "ls_tool", json!({}), "a\nb");
+        let report = execute(&ExplainFailureRequest { call: None }, &ExecutionContext::default());
+        // Other tests record failures concurrently, so only check the report's shape
+        assert!(report.starts_with("Call "), "{}", report);
+
+        let report = execute(&ExplainFailureRequest { call: Some(4_000_001) }, &ExecutionContext::default());
+        assert!(report.starts_with("Call 4000001 (git) failed\nCategory: not-found"), "{}", report);
+    }
+
+    #[test]
+    fn test_execute_unknown_call() {
+        let report = execute(&ExplainFailureRequest { call: Some(4_999_999) }, &ExecutionContext::default());
+        assert!(report.starts_with("Error: Call 4999999 is not in the execution history"));
+    }
+}
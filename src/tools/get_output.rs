@@ -0,0 +1,112 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::spool::{self, Spooled, MAX_SPOOLED};
+
+/// Default and maximum bytes of output returned by one call (64 KiB)
+const MAX_OUTPUT_BYTES: usize = 64 * 1024;
+
+/// Request parameters for the get_output tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct GetOutputRequest {
+    /// Invocation ID of the command, as named in a cut-off result or listed by ps_jobs
+    pub id: u64,
+    /// Byte offset to read from: 0 for the start, or the next offset of the previous call
+    #[serde(default)]
+    pub offset: u64,
+    /// Most bytes to return (default and maximum 65536)
+    pub max_bytes: Option<usize>,
+}
+
+impl Validatable for GetOutputRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Read the spooled output of a command with a validated request and execution context
+pub fn execute(req: &GetOutputRequest, _ctx: &ExecutionContext) -> String {
+    let Some(spooled) = spool::get(req.id) else {
+        return format!(
+            "Error: No output kept for invocation {}; only the last {} are kept, within the spool's size cap",
+            req.id, MAX_SPOOLED
+        );
+    };
+    let max = req.max_bytes.unwrap_or(MAX_OUTPUT_BYTES).clamp(1, MAX_OUTPUT_BYTES);
+    read(&spooled, req.offset, max)
+}
+
+/// Up to `max` bytes of an invocation's output from `offset`, ending with where the next read starts
+fn read(spooled: &Spooled, offset: u64, max: usize) -> String {
+    // Taken first, so a finished command's output is all in the spool
+    let finished = spooled.is_finished();
+    let mut data = match spooled.read(offset, max) {
+        Ok(data) => data,
+        Err(e) => return format!("Error: Failed to read the output of invocation {}: {}", spooled.id, e),
+    };
+    // A character cut off at the end is left for the next read, unless max is too small to ever fit it
+    if let Err(e) = std::str::from_utf8(&data) {
+        if e.error_len().is_none() && (e.valid_up_to() > 0 || max >= 4) {
+            data.truncate(e.valid_up_to());
+        }
+    }
+    let start = offset.min(spooled.spooled());
+    let end = start + data.len() as u64;
+
+    let mut output = String::from_utf8_lossy(&data).into_owned();
+    if !output.is_empty() && !output.ends_with('\n') {
+        output.push('\n');
+    }
+    let len = spooled.output_len();
+    if finished && end == spooled.spooled() && len > end {
+        output.push_str(&format!("[bytes {}-{} were past the spool's cap and are not kept]\n", end, len));
+    }
+    output.push_str(&format!(
+        "[invocation {} ({}: {}) {}; bytes {}-{} of {}; next offset {}]",
+        spooled.id,
+        spooled.tool,
+        spooled.command,
+        if finished { "finished" } else { "running" },
+        start,
+        end,
+        len,
+        end
+    ));
+    output
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_missing_invocation() {
+        let req = GetOutputRequest {
+            id: u64::MAX,
+            offset: 0,
+            max_bytes: None,
+        };
+        assert!(execute(&req, &ExecutionContext::default()).starts_with("Error: No output kept for invocation"));
+    }
+
+    #[test]
+    fn test_read_pages_through_output() {
+        let dir = tempfile::TempDir::new().unwrap();
+        let spooled = spool::detached(dir.path(), 5, "bazel_test", 18);
+        spooled.append("building\n✓ done\n".as_bytes());
+        spooled.append(b"past the cap\n");
+        // The check mark is three bytes; a read ending inside it stops before it
+        assert_eq!(
+            read(&spooled, 0, 11),
+            "building\n[invocation 5 (bazel_test: bazel test //...) running; bytes 0-9 of 31; next offset 9]"
+        );
+        spooled.finish();
+        assert_eq!(
+            read(&spooled, 9, 100),
+            "✓ done\n[bytes 18-31 were past the spool's cap and are not kept]\n\
+             [invocation 5 (bazel_test: bazel test //...) finished; bytes 9-18 of 31; next offset 18]"
+        );
+    }
+}
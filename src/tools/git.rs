@@ -1,16 +1,19 @@
 use rmcp::schemars;
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
 use std::process::Command;
 
 use crate::executor::run_command;
 use crate::request::ExecutionContext;
-use crate::security::{validate_argument, Validatable, ValidationError};
+use crate::security::{is_read_only, validate_argument, Validatable, ValidationError};
 
 /// Allowed git subcommands
 const ALLOWED_GIT_SUBCOMMANDS: &[&str] = &["status", "add", "commit", "checkout"];
 
+/// Git subcommands allowed in read-only mode
+const READ_ONLY_GIT_SUBCOMMANDS: &[&str] = &["status"];
+
 /// Request parameters for the git tool
-#[derive(Debug, Deserialize, schemars::JsonSchema)]
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
 pub struct GitRequest {
     /// The git subcommand to run (status, add, commit, checkout)
     pub subcommand: String,
@@ -21,11 +24,24 @@ pub struct GitRequest {
 
 impl Validatable for GitRequest {
     fn validate(&self) -> Result<(), ValidationError> {
+        self.validate_with_mode(is_read_only())
+    }
+}
+
+impl GitRequest {
+    /// Internal implementation for testability - takes read-only mode as parameter.
+    fn validate_with_mode(&self, read_only: bool) -> Result<(), ValidationError> {
+        let allowed = if read_only {
+            READ_ONLY_GIT_SUBCOMMANDS
+        } else {
+            ALLOWED_GIT_SUBCOMMANDS
+        };
+
         // Validate subcommand is allowed
-        if !ALLOWED_GIT_SUBCOMMANDS.contains(&self.subcommand.as_str()) {
+        if !allowed.contains(&self.subcommand.as_str()) {
             return Err(ValidationError::DisallowedSubcommand {
                 subcommand: self.subcommand.clone(),
-                allowed: ALLOWED_GIT_SUBCOMMANDS.join(", "),
+                allowed: allowed.join(", "),
             });
         }
 
@@ -92,6 +108,28 @@ mod tests {
         ));
     }
 
+    #[test]
+    fn test_validate_read_only_allows_only_status() {
+        let status = GitRequest {
+            subcommand: "status".to_string(),
+            args: vec![],
+        };
+        assert!(status.validate_with_mode(true).is_ok());
+
+        let commit = GitRequest {
+            subcommand: "commit".to_string(),
+            args: vec![],
+        };
+        assert!(commit.validate_with_mode(false).is_ok());
+        assert_eq!(
+            commit.validate_with_mode(true),
+            Err(ValidationError::DisallowedSubcommand {
+                subcommand: "commit".to_string(),
+                allowed: "status".to_string(),
+            })
+        );
+    }
+
     #[test]
     fn test_validate_rejects_shell_injection_in_subcommand() {
         let req = GitRequest {
@@ -0,0 +1,282 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::diff::unified_diff;
+use crate::normalize::normalize;
+use crate::request::ExecutionContext;
+use crate::security::{
+    is_read_only, validate_argument, validate_no_traversal, validate_path, validate_path_with_working_dir,
+    Validatable, ValidationError,
+};
+
+/// Request parameters for the golden tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct GoldenRequest {
+    /// Tool to run, e.g., "git" or "presubmit"
+    pub tool: String,
+    /// Arguments for the tool, exactly as they would be passed to it directly
+    #[serde(default)]
+    pub arguments: Map<String, Value>,
+    /// Golden file holding the expected output, relative to working_dir and staying under it
+    pub golden: String,
+    /// Write the output to the golden file instead of comparing against it
+    #[serde(default)]
+    pub update: bool,
+    /// Compare the output exactly, without normalizing timestamps, temp paths and durations
+    #[serde(default)]
+    pub raw: bool,
+}
+
+impl Validatable for GoldenRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        self.validate_with_mode(is_read_only())
+    }
+}
+
+impl GoldenRequest {
+    /// Internal implementation for testability - takes read-only mode as parameter.
+    fn validate_with_mode(&self, read_only: bool) -> Result<(), ValidationError> {
+        validate_argument(&self.golden)?;
+        if Path::new(&self.golden).is_absolute() {
+            return Err(ValidationError::AbsolutePath(self.golden.clone()));
+        }
+        validate_no_traversal(&self.golden)?;
+        validate_path(&self.golden)?;
+        if self.update && read_only {
+            return Err(ValidationError::ReadOnlyMode("Updating a golden file".to_string()));
+        }
+        Ok(())
+    }
+}
+
+/// Run a tool and check its output against a golden file. `dispatch` runs a tool by name
+/// under the current policy, returning None if the tool cannot be run this way.
+pub fn execute(
+    req: &GoldenRequest,
+    ctx: &ExecutionContext,
+    dispatch: impl FnOnce(&str, Value) -> Option<String>,
+) -> String {
+    let base = match ctx.working_dir {
+        Some(ref working_dir) => {
+            if let Err(e) = validate_path_with_working_dir(&req.golden, working_dir) {
+                return e.to_string();
+            }
+            PathBuf::from(working_dir)
+        }
+        None => std::env::current_dir().unwrap_or_default(),
+    };
+    let golden = base.join(&req.golden);
+    if let Err(e) = validate_within(&golden, &base, &req.golden) {
+        return e;
+    }
+
+    // The tool runs in the same working_dir unless its arguments say otherwise
+    let mut arguments = req.arguments.clone();
+    if let Some(ref working_dir) = ctx.working_dir {
+        arguments
+            .entry("working_dir")
+            .or_insert_with(|| Value::String(working_dir.clone()));
+    }
+    // A dry run of the tool describes what it would run, with nothing to compare or write
+    if ctx.dry_run {
+        arguments.insert("dry_run".to_string(), Value::Bool(true));
+    }
+    let output = match dispatch(&req.tool, Value::Object(arguments)) {
+        Some(output) => output,
+        None => return format!("Error: Tool '{}' cannot be run from the golden tool", req.tool),
+    };
+    if ctx.dry_run {
+        return output;
+    }
+    let actual = if req.raw { output } else { normalize(&output) };
+
+    if req.update {
+        return update_golden(&golden, &req.golden, &actual);
+    }
+    compare_golden(&golden, &req.golden, &actual)
+}
+
+/// Check that a golden file stays under `base` once symlinks are resolved: the file itself if it exists,
+/// or else the closest of its directories that does, since the rest is created as plain directories.
+/// A symlink to a missing path can't be resolved, so it is refused too.
+fn validate_within(path: &Path, base: &Path, display: &str) -> Result<(), String> {
+    let base = base
+        .canonicalize()
+        .map_err(|e| format!("Error: Failed to resolve working_dir {}: {}", base.display(), e))?;
+    let resolved = match path.ancestors().find(|ancestor| ancestor.symlink_metadata().is_ok()) {
+        Some(existing) => existing
+            .canonicalize()
+            .map_err(|e| format!("Error: Failed to resolve golden file '{}': {}", display, e))?,
+        None => PathBuf::new(),
+    };
+    if !resolved.starts_with(&base) {
+        return Err(format!(
+            "Error: Golden file '{}' resolves to {}, outside working_dir {}",
+            display,
+            resolved.display(),
+            base.display()
+        ));
+    }
+    validate_path(&resolved.to_string_lossy()).map_err(|e| e.to_string())
+}
+
+fn update_golden(path: &Path, display: &str, actual: &str) -> String {
+    if let Some(parent) = path.parent().filter(|p| !p.as_os_str().is_empty()) {
+        if let Err(e) = fs::create_dir_all(parent) {
+            return format!("Error: Failed to create directory for golden file '{}': {}", display, e);
+        }
+    }
+    match fs::write(path, actual) {
+        Ok(()) => format!("Updated golden file {} ({} lines)", display, actual.lines().count()),
+        Err(e) => format!("Error: Failed to write golden file '{}': {}", display, e),
+    }
+}
+
+fn compare_golden(path: &Path, display: &str, actual: &str) -> String {
+    let expected = match fs::read_to_string(path) {
+        Ok(expected) => expected,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+            return format!(
+                "Error: Golden file '{}' does not exist. Run again with update: true to create it.",
+                display
+            )
+        }
+        Err(e) => return format!("Error: Failed to read golden file '{}': {}", display, e),
+    };
+    match unified_diff(&expected, actual, display, "actual") {
+        Some(diff) if diff.is_empty() => format!("PASS: output matches {}", display),
+        Some(diff) => format!("FAIL: output differs from {}\n{}", display, diff),
+        None => format!("FAIL: output differs from {} in too many lines to diff", display),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn make_request(golden: &str, update: bool) -> GoldenRequest {
+        GoldenRequest {
+            tool: "git".to_string(),
+            arguments: Map::new(),
+            golden: golden.to_string(),
+            update,
+            raw: false,
+        }
+    }
+
+    fn context(dir: &TempDir) -> ExecutionContext {
+        ExecutionContext {
+            working_dir: Some(dir.path().to_string_lossy().into_owned()),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_update_then_pass() {
+        let temp_dir = TempDir::new().unwrap();
+        let ctx = context(&temp_dir);
+        let run = |_: &str, _: Value| Some("ok  pkg/a 0.12s\n".to_string());
+
+        let result = execute(&make_request("testdata/status.golden", true), &ctx, run);
+        assert_eq!(result, "Updated golden file testdata/status.golden (1 lines)");
+        assert_eq!(
+            fs::read_to_string(temp_dir.path().join("testdata/status.golden")).unwrap(),
+            "ok  pkg/a <duration>\n"
+        );
+
+        let run = |_: &str, _: Value| Some("ok  pkg/a 0.57s\n".to_string());
+        let result = execute(&make_request("testdata/status.golden", false), &ctx, run);
+        assert_eq!(result, "PASS: output matches testdata/status.golden");
+    }
+
+    #[test]
+    fn test_mismatch_reports_diff() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("out.golden"), "a\nb\n").unwrap();
+        let result = execute(&make_request("out.golden", false), &context(&temp_dir), |_, _| {
+            Some("a\nc\n".to_string())
+        });
+        assert!(result.starts_with("FAIL: output differs from out.golden\n--- out.golden\n+++ actual\n"));
+        assert!(result.contains("-b\n+c\n"));
+    }
+
+    #[test]
+    fn test_dry_run_passes_through_without_writing() {
+        let temp_dir = TempDir::new().unwrap();
+        let ctx = ExecutionContext {
+            dry_run: true,
+            ..context(&temp_dir)
+        };
+        let run = |_: &str, arguments: Value| {
+            assert_eq!(arguments["dry_run"], Value::Bool(true));
+            Some("Dry run, not executed: git status\n".to_string())
+        };
+        let result = execute(&make_request("out.golden", true), &ctx, run);
+        assert_eq!(result, "Dry run, not executed: git status\n");
+        assert!(!temp_dir.path().join("out.golden").exists());
+    }
+
+    #[test]
+    fn test_missing_golden_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let result = execute(&make_request("missing.golden", false), &context(&temp_dir), |_, _| {
+            Some(String::new())
+        });
+        assert!(result.starts_with("Error: Golden file 'missing.golden' does not exist"));
+    }
+
+    #[test]
+    fn test_tool_runs_in_working_dir() {
+        let temp_dir = TempDir::new().unwrap();
+        let ctx = context(&temp_dir);
+        let mut seen = None;
+        execute(&make_request("out.golden", true), &ctx, |tool, input| {
+            seen = Some((tool.to_string(), input));
+            Some(String::new())
+        });
+        let (tool, input) = seen.unwrap();
+        assert_eq!(tool, "git");
+        assert_eq!(input["working_dir"], ctx.working_dir.unwrap());
+    }
+
+    #[test]
+    fn test_validate_rejects_update_in_read_only_mode() {
+        assert!(matches!(
+            make_request("out.golden", true).validate_with_mode(true),
+            Err(ValidationError::ReadOnlyMode(_))
+        ));
+        assert!(make_request("out.golden", false).validate_with_mode(true).is_ok());
+    }
+
+    #[test]
+    fn test_rejects_paths_outside_working_dir() {
+        assert!(matches!(
+            make_request("/home/dev/.bashrc", true).validate_with_mode(false),
+            Err(ValidationError::AbsolutePath(_))
+        ));
+        let temp_dir = TempDir::new().unwrap();
+        let outside = TempDir::new().unwrap();
+        std::os::unix::fs::symlink(outside.path(), temp_dir.path().join("escape")).unwrap();
+        std::os::unix::fs::symlink(outside.path().join(".bashrc"), temp_dir.path().join("link.golden")).unwrap();
+        for golden in ["escape/.bashrc", "escape/new/dir/out.golden", "link.golden"] {
+            let result = execute(&make_request(golden, true), &context(&temp_dir), |_, _| Some("x".to_string()));
+            assert!(result.starts_with("Error: "), "{}: {}", golden, result);
+        }
+        let result = execute(&make_request("escape/x.golden", true), &context(&temp_dir), |_, _| None);
+        assert!(result.contains("outside working_dir"), "{}", result);
+        assert!(!outside.path().join(".bashrc").exists());
+        assert!(!outside.path().join("new").exists());
+    }
+
+    #[test]
+    fn test_validate_rejects_traversal() {
+        assert!(matches!(
+            make_request("../secrets.golden", false).validate_with_mode(false),
+            Err(ValidationError::PathTraversal(_))
+        ));
+    }
+}
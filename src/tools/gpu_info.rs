@@ -0,0 +1,148 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::path::Path;
+use std::process::Command;
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Fields queried from nvidia-smi, in CSV column order
+const NVIDIA_QUERY: &str = "index,name,memory.total,memory.used,utilization.gpu";
+
+/// Request parameters for the gpu_info tool (takes no tool-specific parameters)
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct GpuInfoRequest {}
+
+impl Validatable for GpuInfoRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Report available GPUs with a validated request and execution context
+pub fn execute(_req: &GpuInfoRequest, ctx: &ExecutionContext) -> String {
+    inventory(
+        ctx,
+        find_executable("nvidia-smi").as_deref(),
+        find_executable("rocm-smi").as_deref(),
+    )
+}
+
+/// Internal implementation for testability - takes the vendor tool paths as parameters.
+fn inventory(ctx: &ExecutionContext, nvidia_smi: Option<&Path>, rocm_smi: Option<&Path>) -> String {
+    if nvidia_smi.is_none() && rocm_smi.is_none() {
+        return "No GPUs found (neither nvidia-smi nor rocm-smi is installed)".to_string();
+    }
+
+    let mut sections = Vec::new();
+
+    if let Some(program) = nvidia_smi {
+        let mut cmd = Command::new(program);
+        cmd.arg(format!("--query-gpu={}", NVIDIA_QUERY))
+            .arg("--format=csv,noheader,nounits");
+        sections.push(match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) => {
+                let gpus = parse_nvidia_csv(&output);
+                if gpus.is_empty() {
+                    "nvidia-smi: no GPUs reported".to_string()
+                } else {
+                    gpus.join("\n")
+                }
+            }
+            result => format!("nvidia-smi: {}", result.into_string()),
+        });
+    }
+
+    if let Some(program) = rocm_smi {
+        let mut cmd = Command::new(program);
+        cmd.args(["--showproductname", "--showmeminfo", "vram", "--showuse", "--csv"]);
+        sections.push(match run_command(cmd, ctx) {
+            // rocm-smi's CSV columns vary by version, so pass it through as-is
+            ExecutionResult::Success(output) => format!("rocm-smi:\n{}", output.trim_end()),
+            result => format!("rocm-smi: {}", result.into_string()),
+        });
+    }
+
+    sections.join("\n")
+}
+
+/// Format nvidia-smi CSV rows as one line per GPU
+fn parse_nvidia_csv(output: &str) -> Vec<String> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let fields: Vec<&str> = line.split(',').map(str::trim).collect();
+            match fields.as_slice() {
+                [index, name, total, used, utilization] => Some(format!(
+                    "gpu {}: {} (nvidia), memory {}/{} MiB used, utilization {}%",
+                    index, name, used, total, utilization
+                )),
+                _ => None,
+            }
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::os::unix::fs::PermissionsExt;
+    use std::path::PathBuf;
+    use tempfile::TempDir;
+
+    /// Write an executable shell script standing in for a vendor tool
+    fn fake_tool(dir: &Path, name: &str, script: &str) -> PathBuf {
+        let path = dir.join(name);
+        std::fs::write(&path, format!("#!/bin/sh\n{}\n", script)).unwrap();
+        std::fs::set_permissions(&path, std::fs::Permissions::from_mode(0o755)).unwrap();
+        path
+    }
+
+    #[test]
+    fn test_no_vendor_tools() {
+        let result = inventory(&ExecutionContext::default(), None, None);
+        assert!(result.starts_with("No GPUs found"));
+    }
+
+    #[test]
+    fn test_nvidia_inventory() {
+        let temp_dir = TempDir::new().unwrap();
+        let nvidia = fake_tool(
+            temp_dir.path(),
+            "nvidia-smi",
+            "echo '0, NVIDIA A100-SXM4-40GB, 40960, 1024, 7'\necho '1, NVIDIA A100-SXM4-40GB, 40960, 0, 0'",
+        );
+        let result = inventory(&ExecutionContext::default(), Some(&nvidia), None);
+        assert_eq!(
+            result,
+            "gpu 0: NVIDIA A100-SXM4-40GB (nvidia), memory 1024/40960 MiB used, utilization 7%\n\
+             gpu 1: NVIDIA A100-SXM4-40GB (nvidia), memory 0/40960 MiB used, utilization 0%"
+        );
+    }
+
+    #[test]
+    fn test_nvidia_driver_failure_is_reported() {
+        let temp_dir = TempDir::new().unwrap();
+        let nvidia = fake_tool(
+            temp_dir.path(),
+            "nvidia-smi",
+            "echo 'NVIDIA-SMI has failed because it could not communicate with the NVIDIA driver.' >&2\nexit 9",
+        );
+        let result = inventory(&ExecutionContext::default(), Some(&nvidia), None);
+        assert!(result.starts_with("nvidia-smi: Error: NVIDIA-SMI has failed"));
+    }
+
+    #[test]
+    fn test_rocm_output_passed_through() {
+        let temp_dir = TempDir::new().unwrap();
+        let rocm = fake_tool(temp_dir.path(), "rocm-smi", "echo 'device,Card series,GPU use (%)'\necho 'card0,Instinct MI250X,3'");
+        let result = inventory(&ExecutionContext::default(), None, Some(&rocm));
+        assert_eq!(result, "rocm-smi:\ndevice,Card series,GPU use (%)\ncard0,Instinct MI250X,3");
+    }
+
+    #[test]
+    fn test_parse_nvidia_csv_skips_malformed_rows() {
+        assert!(parse_nvidia_csv("garbage\n").is_empty());
+    }
+}
@@ -0,0 +1,132 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::executor::quote;
+use crate::history::{self, CommandEntry, MAX_COMMAND_ENTRIES};
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::transcript::{format_utc, session_id};
+
+/// Commands listed when the request doesn't say
+const DEFAULT_LIMIT: usize = 20;
+
+/// Lines of each command's output shown, from its end
+const OUTPUT_LINES: usize = 3;
+
+/// Request parameters for the history tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct HistoryRequest {
+    /// Only list commands run by this tool, e.g., "presubmit"
+    pub tool: Option<String>,
+    /// Only list commands that exited with this code, e.g., 1
+    pub exit_code: Option<i32>,
+    /// Most commands to list, most recent first (default: 20)
+    pub limit: Option<usize>,
+}
+
+impl Validatable for HistoryRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// List the commands that have finished with a validated request and execution context
+pub fn execute(req: &HistoryRequest, _ctx: &ExecutionContext) -> String {
+    let limit = req.limit.unwrap_or(DEFAULT_LIMIT).clamp(1, MAX_COMMAND_ENTRIES);
+    render(&history::commands(), req.tool.as_deref(), req.exit_code, limit)
+}
+
+/// A summary line, then the most recent `limit` matching commands, newest first, each with the end of its output
+fn render(commands: &[CommandEntry], tool: Option<&str>, exit_code: Option<i32>, limit: usize) -> String {
+    let matching: Vec<&CommandEntry> = commands
+        .iter()
+        .rev()
+        .filter(|command| tool.is_none_or(|tool| command.tool == tool))
+        .filter(|command| exit_code.is_none_or(|code| command.exit_code == Some(code)))
+        .collect();
+    if matching.is_empty() {
+        return format!("Session {}: no matching commands in the history", session_id());
+    }
+    let shown = matching.len().min(limit);
+    let mut lines = vec![format!(
+        "Session {}: {} of {} matching command{}, newest first",
+        session_id(),
+        shown,
+        matching.len(),
+        if matching.len() == 1 { "" } else { "s" }
+    )];
+    for command in &matching[..shown] {
+        lines.push(describe(command));
+        let output: Vec<&str> = command.output.trim_end().lines().collect();
+        let skipped = output.len().saturating_sub(OUTPUT_LINES);
+        if skipped > 0 || command.output_truncated {
+            lines.push("    ...".to_string());
+        }
+        lines.extend(output[skipped..].iter().map(|line| format!("    {}", line)));
+    }
+    lines.push("[full output of a command: get_output {\"id\": N}]".to_string());
+    lines.join("\n")
+}
+
+/// One line about a finished command, e.g.,
+/// "#4 presubmit: bazel test //... - exit 1 after 12.3s, started 2026-10-15T13:45:00Z"
+fn describe(command: &CommandEntry) -> String {
+    let stages: Vec<String> = command
+        .argv
+        .iter()
+        .map(|argv| argv.iter().map(|word| quote(word)).collect::<Vec<_>>().join(" "))
+        .collect();
+    let status = match command.exit_code {
+        Some(code) => format!("exit {}", code),
+        None => "no exit code".to_string(),
+    };
+    format!(
+        "#{} {}: {} - {} after {:.1}s, started {}",
+        command.id,
+        command.tool,
+        stages.join(" | "),
+        status,
+        command.duration_ms as f64 / 1000.0,
+        format_utc(command.started)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(id: u64, tool: &str, exit_code: Option<i32>, output: &str) -> CommandEntry {
+        CommandEntry {
+            id,
+            tool: tool.to_string(),
+            argv: vec![vec!["bazel".to_string(), "test".to_string(), "//...".to_string()]],
+            started: 1_792_071_900,
+            duration_ms: 12_345,
+            exit_code,
+            output: output.to_string(),
+            output_truncated: false,
+        }
+    }
+
+    #[test]
+    fn test_render_filters_newest_first() {
+        let commands = [
+            entry(1, "presubmit", Some(1), "a\nb\nc\nd\nFAILED\n"),
+            entry(2, "git", Some(0), "clean\n"),
+            entry(3, "presubmit", Some(0), "ok\n"),
+            entry(4, "presubmit", None, "Error: Command timed out and was killed after 5s"),
+        ];
+        let output = render(&commands, Some("presubmit"), None, 2);
+        assert!(output.contains(": 2 of 3 matching commands, newest first\n"));
+        assert!(output.contains(
+            "\n#4 presubmit: bazel test //... - no exit code after 12.3s, started 2026-10-15T13:45:00Z\n"
+        ));
+        assert!(output.contains("\n#3 presubmit:"));
+        assert!(!output.contains("#1 "));
+
+        let output = render(&commands, None, Some(1), 20);
+        assert!(output.contains("\n#1 presubmit: bazel test //... - exit 1 after 12.3s"));
+        assert!(output.contains("\n    ...\n    c\n    d\n    FAILED\n"));
+        assert!(render(&commands, Some("git"), Some(1), 20).contains("no matching commands"));
+    }
+}
@@ -0,0 +1,146 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::process::Command;
+
+use crate::executor::{run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Placeholder for values that could not be determined on this host
+const UNKNOWN: &str = "unknown";
+
+/// Request parameters for the host_info tool (takes no tool-specific parameters)
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct HostInfoRequest {}
+
+impl Validatable for HostInfoRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Report host details with a validated request and execution context
+pub fn execute(_req: &HostInfoRequest, ctx: &ExecutionContext) -> String {
+    let kernel = command_output(ctx, "uname", &["-sr"]);
+    let cpus = std::thread::available_parallelism()
+        .map(|n| n.get().to_string())
+        .unwrap_or_else(|_| UNKNOWN.to_string());
+    let memory = total_memory_bytes(ctx)
+        .map(format_bytes)
+        .unwrap_or_else(|| UNKNOWN.to_string());
+
+    [
+        format!("os: {} ({})", std::env::consts::OS, kernel),
+        format!("arch: {}", std::env::consts::ARCH),
+        format!("cpus: {}", cpus),
+        format!("memory_total: {}", memory),
+        format!("load_average: {}", load_average(ctx)),
+        format!("hostname: {}", command_output(ctx, "hostname", &[])),
+        format!("time: {}", command_output(ctx, "date", &["+%Y-%m-%dT%H:%M:%S%z %Z"])),
+    ]
+    .join("\n")
+}
+
+/// Run a command and return its trimmed output, or "unknown" if it fails
+fn command_output(ctx: &ExecutionContext, program: &str, args: &[&str]) -> String {
+    let mut cmd = Command::new(program);
+    cmd.args(args);
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) if !output.trim().is_empty() => output.trim().to_string(),
+        _ => UNKNOWN.to_string(),
+    }
+}
+
+/// Total physical memory from /proc/meminfo (Linux) or sysctl (macOS)
+fn total_memory_bytes(ctx: &ExecutionContext) -> Option<u64> {
+    if let Ok(meminfo) = std::fs::read_to_string("/proc/meminfo") {
+        return parse_meminfo_total(&meminfo);
+    }
+    command_output(ctx, "sysctl", &["-n", "hw.memsize"]).parse().ok()
+}
+
+/// Parse the MemTotal line of /proc/meminfo into bytes
+fn parse_meminfo_total(meminfo: &str) -> Option<u64> {
+    meminfo
+        .lines()
+        .find(|line| line.starts_with("MemTotal:"))
+        .and_then(|line| line.split_whitespace().nth(1))
+        .and_then(|kb| kb.parse::<u64>().ok())
+        .map(|kb| kb * 1024)
+}
+
+/// 1, 5 and 15 minute load averages from /proc/loadavg (Linux) or sysctl (macOS)
+fn load_average(ctx: &ExecutionContext) -> String {
+    let raw = match std::fs::read_to_string("/proc/loadavg") {
+        Ok(loadavg) => loadavg,
+        // macOS reports "{ 1.23 1.45 1.67 }"
+        Err(_) => command_output(ctx, "sysctl", &["-n", "vm.loadavg"]).replace(['{', '}'], ""),
+    };
+    let values: Vec<&str> = raw.split_whitespace().take(3).collect();
+    if values.len() == 3 {
+        values.join(" ")
+    } else {
+        UNKNOWN.to_string()
+    }
+}
+
+/// Format a byte count with a binary unit suffix, e.g., "15.5 GiB"
+pub fn format_bytes(bytes: u64) -> String {
+    const UNITS: &[&str] = &["B", "KiB", "MiB", "GiB", "TiB"];
+    let mut value = bytes as f64;
+    let mut unit = 0;
+    while value >= 1024.0 && unit < UNITS.len() - 1 {
+        value /= 1024.0;
+        unit += 1;
+    }
+    if unit == 0 {
+        format!("{} B", bytes)
+    } else {
+        format!("{:.1} {}", value, UNITS[unit])
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_host_info_reports_all_fields() {
+        let result = execute(&HostInfoRequest {}, &ExecutionContext::default());
+        for field in ["os:", "arch:", "cpus:", "memory_total:", "load_average:", "hostname:", "time:"] {
+            assert!(result.contains(field), "missing {} in {}", field, result);
+        }
+        assert!(result.contains(&format!("arch: {}", std::env::consts::ARCH)));
+    }
+
+    #[test]
+    fn test_host_info_time_uses_env_timezone() {
+        let mut env = std::collections::HashMap::new();
+        env.insert("TZ".to_string(), "UTC".to_string());
+        let ctx = ExecutionContext {
+            env: Some(env),
+            ..Default::default()
+        };
+        let result = execute(&HostInfoRequest {}, &ctx);
+        assert!(result.contains("+0000 UTC"));
+    }
+
+    #[test]
+    fn test_parse_meminfo_total() {
+        let meminfo = "MemTotal:       16303412 kB\nMemFree:         1234567 kB\n";
+        assert_eq!(parse_meminfo_total(meminfo), Some(16303412 * 1024));
+        assert_eq!(parse_meminfo_total("MemFree: 1 kB"), None);
+    }
+
+    #[test]
+    fn test_format_bytes() {
+        assert_eq!(format_bytes(512), "512 B");
+        assert_eq!(format_bytes(1536), "1.5 KiB");
+        assert_eq!(format_bytes(16 * 1024 * 1024 * 1024), "16.0 GiB");
+    }
+
+    #[test]
+    fn test_deserialize_empty() {
+        let _: HostInfoRequest = serde_json::from_str("{}").unwrap();
+    }
+}
@@ -0,0 +1,47 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::jobs;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::tools::job_status::missing_job;
+
+/// Request parameters for the job_cancel tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct JobCancelRequest {
+    /// ID of the job, as returned by job_start
+    pub job_id: u64,
+}
+
+impl Validatable for JobCancelRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Cancel a background job with a validated request and execution context
+pub fn execute(req: &JobCancelRequest, _ctx: &ExecutionContext) -> String {
+    let Some(job) = jobs::get(req.job_id) else {
+        return missing_job(req.job_id);
+    };
+    if job.cancel() {
+        format!(
+            "Cancelling job {} ({}): its command was asked to exit and nothing more will run. \
+             job_status shows it as cancelled once it has stopped.",
+            job.id, job.tool
+        )
+    } else {
+        format!("Job {} ({}) has already finished ({})", job.id, job.tool, job.state())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_missing_job() {
+        let req = JobCancelRequest { job_id: u64::MAX };
+        assert!(execute(&req, &ExecutionContext::default()).starts_with("Error: Job "));
+    }
+}
@@ -0,0 +1,87 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::jobs::{self, Job};
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::tools::job_status::missing_job;
+
+/// Default and maximum bytes of log returned by one call (64 KiB)
+const MAX_LOG_BYTES: usize = 64 * 1024;
+
+/// Request parameters for the job_logs tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct JobLogsRequest {
+    /// ID of the job, as returned by job_start
+    pub job_id: u64,
+    /// Byte offset to read from: 0 for the start, or the next offset of the previous call
+    #[serde(default)]
+    pub offset: u64,
+    /// Most bytes to return (default and maximum 65536)
+    pub max_bytes: Option<usize>,
+}
+
+impl Validatable for JobLogsRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Read a background job's output with a validated request and execution context
+pub fn execute(req: &JobLogsRequest, _ctx: &ExecutionContext) -> String {
+    let Some(job) = jobs::get(req.job_id) else {
+        return missing_job(req.job_id);
+    };
+    let max = req.max_bytes.unwrap_or(MAX_LOG_BYTES).clamp(1, MAX_LOG_BYTES);
+    read(&job, req.offset, max)
+}
+
+/// Up to `max` bytes of a job's log from `offset`, ending with where the next read starts
+fn read(job: &Job, offset: u64, max: usize) -> String {
+    // Taken first, so a finished job's output is all in the log
+    let state = job.state();
+    let (start, mut data) = job.read_log(offset, max);
+    // A character cut off at the end is left for the next read, unless max is too small to ever fit it
+    if let Err(e) = std::str::from_utf8(&data) {
+        if e.error_len().is_none() && (e.valid_up_to() > 0 || max >= 4) {
+            data.truncate(e.valid_up_to());
+        }
+    }
+    let end = start + data.len() as u64;
+    let len = job.log_len();
+
+    let mut output = String::new();
+    if start > offset {
+        output.push_str(&format!("[bytes {}-{} are no longer kept]\n", offset, start));
+    }
+    output.push_str(&String::from_utf8_lossy(&data));
+    if !output.is_empty() && !output.ends_with('\n') {
+        output.push('\n');
+    }
+    output.push_str(&format!("[job {} {}; bytes {}-{} of {}; next offset {}]", job.id, state, start, end, len, end));
+    output
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_missing_job() {
+        let req = JobLogsRequest {
+            job_id: u64::MAX,
+            offset: 0,
+            max_bytes: None,
+        };
+        assert!(execute(&req, &ExecutionContext::default()).starts_with("Error: Job "));
+    }
+
+    #[test]
+    fn test_read_pages_through_log() {
+        let job = Job::detached(3, "presubmit");
+        job.append("building\n✓ done\n".as_bytes());
+        // The check mark is three bytes; a read ending inside it stops before it
+        assert_eq!(read(&job, 0, 11), "building\n[job 3 running; bytes 0-9 of 18; next offset 9]");
+        assert_eq!(read(&job, 9, 100), "✓ done\n[job 3 running; bytes 9-18 of 18; next offset 18]");
+    }
+}
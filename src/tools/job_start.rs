@@ -0,0 +1,55 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value};
+
+use crate::jobs;
+use crate::labels::Labels;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Request parameters for the job_start tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct JobStartRequest {
+    /// Tool to run in the background, e.g., "presubmit"
+    pub tool: String,
+    /// Arguments for the tool, exactly as they would be passed to it directly
+    #[serde(default)]
+    pub arguments: Map<String, Value>,
+}
+
+impl Validatable for JobStartRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Start a tool call as a background job with a validated request and execution context. The call has
+/// the job_start call's labels unless its arguments set some. `dispatch` runs a tool by name under the
+/// current policy, returning None if the tool cannot be run this way.
+pub fn execute(
+    req: &JobStartRequest,
+    ctx: &ExecutionContext,
+    labels: Option<&Labels>,
+    dispatch: impl FnOnce(&str, Value) -> Option<String> + Send + 'static,
+) -> String {
+    // The tool runs in the same working_dir unless its arguments say otherwise
+    let mut arguments = req.arguments.clone();
+    if let Some(ref working_dir) = ctx.working_dir {
+        arguments
+            .entry("working_dir")
+            .or_insert_with(|| Value::String(working_dir.clone()));
+    }
+    if let Some(labels) = labels {
+        arguments.entry("labels").or_insert_with(|| serde_json::json!(labels));
+    }
+    if ctx.dry_run {
+        arguments.insert("dry_run".to_string(), Value::Bool(true));
+    }
+    match jobs::start(&req.tool, Value::Object(arguments), dispatch) {
+        Ok(job) => format!(
+            "Started job {} ({}). Check on it with job_status and read its output with job_logs.",
+            job.id, job.tool
+        ),
+        Err(e) => e,
+    }
+}
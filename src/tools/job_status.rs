@@ -0,0 +1,75 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::jobs::{self, Job, MAX_JOBS};
+use crate::notify::format_elapsed;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Request parameters for the job_status tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct JobStatusRequest {
+    /// ID of the job, as returned by job_start; omit to list every job
+    pub job_id: Option<u64>,
+}
+
+impl Validatable for JobStatusRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Report on background jobs with a validated request and execution context
+pub fn execute(req: &JobStatusRequest, _ctx: &ExecutionContext) -> String {
+    let Some(id) = req.job_id else {
+        let jobs = jobs::all();
+        if jobs.is_empty() {
+            return "No jobs".to_string();
+        }
+        return jobs.iter().map(|job| summary(job)).collect::<Vec<_>>().join("\n");
+    };
+    let Some(job) = jobs::get(id) else {
+        return missing_job(id);
+    };
+    let mut status = format!("{}\nOutput: {} bytes; read it with job_logs", summary(&job), job.log_len());
+    if let Some(result) = job.result() {
+        status.push_str(&format!("\n\nResult:\n{}", result.output));
+    }
+    status
+}
+
+/// One line about a job, e.g., "Job 3 (presubmit): running for 2m 5s"
+fn summary(job: &Job) -> String {
+    let state = job.state();
+    let elapsed = format_elapsed(job.elapsed());
+    match state {
+        "running" => format!("Job {} ({}): running for {}", job.id, job.tool, elapsed),
+        _ => format!("Job {} ({}): {} after {}", job.id, job.tool, state, elapsed),
+    }
+}
+
+/// Error for a job ID that isn't kept
+pub fn missing_job(id: u64) -> String {
+    format!(
+        "Error: Job {} doesn't exist or was dropped (only the last {} jobs are kept)",
+        id, MAX_JOBS
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_summary() {
+        let job = Job::detached(3, "presubmit");
+        assert_eq!(summary(&job), "Job 3 (presubmit): running for 0s");
+    }
+
+    #[test]
+    fn test_missing_job() {
+        let req = JobStatusRequest { job_id: Some(u64::MAX) };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.starts_with("Error: Job 18446744073709551615 doesn't exist"), "{}", result);
+    }
+}
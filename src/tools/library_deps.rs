@@ -0,0 +1,327 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{
+    validate_no_traversal, validate_not_flag, validate_path, validate_path_with_working_dir, Validatable,
+    ValidationError,
+};
+use crate::tools::binary_info::{read_format, Format, ELF_NEEDED};
+
+/// Directories the dynamic linker searches after the cache
+const DEFAULT_LIBRARY_DIRS: &[&str] = &[
+    "/lib",
+    "/usr/lib",
+    "/lib64",
+    "/usr/lib64",
+    "/lib/x86_64-linux-gnu",
+    "/usr/lib/x86_64-linux-gnu",
+    "/lib/aarch64-linux-gnu",
+    "/usr/lib/aarch64-linux-gnu",
+];
+
+/// Most libraries resolved, counting transitive dependencies
+const MAX_LIBRARIES: usize = 500;
+
+/// "Library runpath: [$ORIGIN/../lib]" or "Library rpath: [...]" in `readelf -d` output
+static ELF_SEARCH_PATH: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"\((RPATH|RUNPATH)\)\s+Library r(?:un)?path: \[(.*)\]").unwrap());
+
+/// "libz.so.1 (libc6,x86-64) => /lib/x86_64-linux-gnu/libz.so.1" in `ldconfig -p` output
+static LDCONFIG_ENTRY: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"^\s+(\S+) \(.*\) => (\S+)$").unwrap());
+
+/// Request parameters for the library_deps tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct LibraryDepsRequest {
+    /// The binary or shared library to check, relative to working_dir if not absolute
+    pub path: String,
+}
+
+impl Validatable for LibraryDepsRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_not_flag(&self.path)?;
+        validate_no_traversal(&self.path)?;
+        validate_path(&self.path)?;
+        Ok(())
+    }
+}
+
+/// The dynamic section of an ELF file: libraries it needs and where it asks for them to be found
+#[derive(Debug, Default, PartialEq)]
+struct Dynamic {
+    needed: Vec<String>,
+    rpath: Vec<String>,
+    runpath: Vec<String>,
+}
+
+/// Check a binary's shared library dependencies with a validated request and execution context
+pub fn execute(req: &LibraryDepsRequest, ctx: &ExecutionContext) -> String {
+    if let Some(ref working_dir) = ctx.working_dir {
+        if let Err(e) = validate_path_with_working_dir(&req.path, working_dir) {
+            return e.to_string();
+        }
+    }
+    let path = match ctx.working_dir {
+        Some(ref dir) => Path::new(dir).join(&req.path),
+        None => PathBuf::from(&req.path),
+    };
+    let format = match read_format(&path) {
+        Ok(Some(format @ Format::Elf { .. })) => format,
+        Ok(Some(_)) => return format!("Error: '{}' is a Mach-O binary; only ELF binaries can be checked", req.path),
+        Ok(None) => return format!("Error: '{}' is not an ELF binary", req.path),
+        Err(e) => return format!("Error: Failed to read '{}': {}", req.path, e),
+    };
+    if find_executable("readelf").is_none() {
+        return "Error: readelf is not installed".to_string();
+    }
+    let cache = ldconfig_cache(ctx);
+    let readelf = |file: &Path| -> Option<Dynamic> {
+        let mut cmd = Command::new("readelf");
+        cmd.arg("-d").arg(file);
+        match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) => Some(parse_dynamic(&output)),
+            _ => None,
+        }
+    };
+    report(&resolve_all(&path, format, &readelf, &cache))
+}
+
+/// A needed library and where it resolved, in the order they were found
+#[derive(Debug, PartialEq)]
+struct Resolution {
+    name: String,
+    needed_by: String,
+    found: Option<PathBuf>,
+}
+
+/// Resolve the binary's libraries and, like ldd, the libraries those need in turn.
+/// The binary is never executed; only `readelf` reads it.
+fn resolve_all(
+    binary: &Path,
+    format: Format,
+    readelf: &dyn Fn(&Path) -> Option<Dynamic>,
+    cache: &[(String, PathBuf)],
+) -> Vec<Resolution> {
+    let mut resolutions: Vec<Resolution> = Vec::new();
+    let mut seen = HashSet::new();
+    let mut pending = vec![binary.to_path_buf()];
+    while let Some(file) = pending.pop() {
+        let dynamic = match readelf(&file) {
+            Some(dynamic) => dynamic,
+            None => continue,
+        };
+        let needed_by = file.file_name().map(|n| n.to_string_lossy().to_string()).unwrap_or_default();
+        for name in dynamic.needed.iter() {
+            if !seen.insert(name.clone()) || resolutions.len() >= MAX_LIBRARIES {
+                continue;
+            }
+            let found = resolve(name, &file, &dynamic, format, cache);
+            if let Some(ref lib) = found {
+                pending.push(lib.clone());
+            }
+            resolutions.push(Resolution {
+                name: name.clone(),
+                needed_by: needed_by.clone(),
+                found,
+            });
+        }
+    }
+    resolutions
+}
+
+/// Find a needed library the way the dynamic linker does: DT_RPATH (when there is no DT_RUNPATH),
+/// DT_RUNPATH, the ldconfig cache, then the default directories. Only a library of the same
+/// class and architecture as the binary counts, so a 32-bit or foreign library isn't mistaken for it.
+fn resolve(name: &str, from: &Path, dynamic: &Dynamic, format: Format, cache: &[(String, PathBuf)]) -> Option<PathBuf> {
+    let matches_format = |candidate: &Path| match (read_format(candidate), format) {
+        (Ok(Some(Format::Elf { bits, machine, .. })), Format::Elf { bits: b, machine: m, .. }) => bits == b && machine == m,
+        _ => false,
+    };
+    if name.contains('/') {
+        let path = from.parent().unwrap_or(Path::new("")).join(name);
+        return matches_format(&path).then_some(path);
+    }
+    let origin = from.parent().map(|dir| dir.to_string_lossy().to_string()).unwrap_or_default();
+    let expand = |dirs: &[String]| -> Vec<PathBuf> {
+        dirs.iter()
+            .map(|dir| PathBuf::from(dir.replace("${ORIGIN}", &origin).replace("$ORIGIN", &origin)))
+            .collect()
+    };
+    let rpath = if dynamic.runpath.is_empty() { expand(&dynamic.rpath) } else { Vec::new() };
+    let candidates = rpath
+        .into_iter()
+        .chain(expand(&dynamic.runpath))
+        .map(|dir| dir.join(name))
+        .chain(cache.iter().filter(|(lib, _)| lib == name).map(|(_, path)| path.clone()))
+        .chain(DEFAULT_LIBRARY_DIRS.iter().map(|dir| Path::new(dir).join(name)));
+    candidates.into_iter().find(|candidate| matches_format(candidate))
+}
+
+/// Parse the NEEDED, RPATH and RUNPATH entries of `readelf -d` output
+fn parse_dynamic(output: &str) -> Dynamic {
+    let mut dynamic = Dynamic {
+        needed: ELF_NEEDED.captures_iter(output).map(|c| c[1].to_string()).collect(),
+        ..Default::default()
+    };
+    for captures in ELF_SEARCH_PATH.captures_iter(output) {
+        let dirs = captures[2].split(':').filter(|d| !d.is_empty()).map(str::to_string);
+        match &captures[1] {
+            "RPATH" => dynamic.rpath.extend(dirs),
+            _ => dynamic.runpath.extend(dirs),
+        }
+    }
+    dynamic
+}
+
+/// The ldconfig cache as (library name, path) pairs, empty if ldconfig isn't available
+fn ldconfig_cache(ctx: &ExecutionContext) -> Vec<(String, PathBuf)> {
+    let program = ["ldconfig", "/sbin/ldconfig"]
+        .iter()
+        .find_map(|name| find_executable(name).or_else(|| Some(PathBuf::from(name)).filter(|p| p.is_file())));
+    let program = match program {
+        Some(program) => program,
+        None => return Vec::new(),
+    };
+    let mut cmd = Command::new(program);
+    cmd.arg("-p");
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => parse_ldconfig(&output),
+        _ => Vec::new(),
+    }
+}
+
+fn parse_ldconfig(output: &str) -> Vec<(String, PathBuf)> {
+    output
+        .lines()
+        .filter_map(|line| LDCONFIG_ENTRY.captures(line))
+        .map(|captures| (captures[1].to_string(), PathBuf::from(&captures[2])))
+        .collect()
+}
+
+/// One line per library like ldd, with missing ones listed first in a summary
+fn report(resolutions: &[Resolution]) -> String {
+    let missing: Vec<&Resolution> = resolutions.iter().filter(|r| r.found.is_none()).collect();
+    let mut lines = vec![if missing.is_empty() {
+        format!("All {} shared libraries found", resolutions.len())
+    } else {
+        let names: Vec<String> = missing
+            .iter()
+            .map(|r| format!("{} (needed by {})", r.name, r.needed_by))
+            .collect();
+        format!("Missing {} of {} shared libraries: {}", missing.len(), resolutions.len(), names.join(", "))
+    }];
+    for resolution in resolutions {
+        lines.push(match resolution.found {
+            Some(ref path) => format!("  {} => {}", resolution.name, path.display()),
+            None => format!("  {} => not found", resolution.name),
+        });
+    }
+    lines.join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_parse_dynamic() {
+        let output = " 0x0000000000000001 (NEEDED)             Shared library: [libfoo.so.1]\n \
+                      0x0000000000000001 (NEEDED)             Shared library: [libc.so.6]\n \
+                      0x000000000000001d (RUNPATH)            Library runpath: [$ORIGIN/../lib:/opt/foo/lib]\n";
+        assert_eq!(
+            parse_dynamic(output),
+            Dynamic {
+                needed: vec!["libfoo.so.1".to_string(), "libc.so.6".to_string()],
+                rpath: vec![],
+                runpath: vec!["$ORIGIN/../lib".to_string(), "/opt/foo/lib".to_string()],
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_ldconfig() {
+        let output = "2 libs found in cache `/etc/ld.so.cache'\n\tlibz.so.1 (libc6,x86-64) => /lib/x86_64-linux-gnu/libz.so.1\n";
+        assert_eq!(
+            parse_ldconfig(output),
+            vec![("libz.so.1".to_string(), PathBuf::from("/lib/x86_64-linux-gnu/libz.so.1"))]
+        );
+    }
+
+    /// Write a file with an ELF header for a 64-bit x86_64 or aarch64 library
+    fn fake_elf(path: &Path, machine: u8) {
+        let mut header = vec![0x7f, b'E', b'L', b'F', 2, 1];
+        header.resize(20, 0);
+        header[18] = machine;
+        std::fs::write(path, header).unwrap();
+    }
+
+    #[test]
+    fn test_resolve_all_finds_origin_relative_and_reports_missing() {
+        let temp_dir = TempDir::new().unwrap();
+        let bin = temp_dir.path().join("bin");
+        let lib = temp_dir.path().join("lib");
+        std::fs::create_dir_all(&bin).unwrap();
+        std::fs::create_dir_all(&lib).unwrap();
+        fake_elf(&bin.join("app"), 0x3e);
+        fake_elf(&lib.join("libfoo.so.1"), 0x3e);
+        // Same name, wrong architecture: must not count as found
+        fake_elf(&lib.join("libbar.so.2"), 0xb7);
+
+        let app = bin.join("app");
+        let readelf = |file: &Path| -> Option<Dynamic> {
+            match file.file_name()?.to_str()? {
+                "app" => Some(Dynamic {
+                    needed: vec!["libfoo.so.1".to_string(), "libbar.so.2".to_string()],
+                    runpath: vec!["$ORIGIN/../lib".to_string()],
+                    ..Default::default()
+                }),
+                "libfoo.so.1" => Some(Dynamic {
+                    needed: vec!["libmissing.so.3".to_string()],
+                    ..Default::default()
+                }),
+                _ => None,
+            }
+        };
+        let format = read_format(&app).unwrap().unwrap();
+        let resolutions = resolve_all(&app, format, &readelf, &[]);
+        let result = report(&resolutions);
+        assert!(
+            result.starts_with(
+                "Missing 2 of 3 shared libraries: libbar.so.2 (needed by app), libmissing.so.3 (needed by libfoo.so.1)\n"
+            ),
+            "{}",
+            result
+        );
+        assert!(result.contains("  libfoo.so.1 => "), "{}", result);
+        assert!(result.contains("/bin/../lib/libfoo.so.1"), "{}", result);
+        assert!(result.contains("  libbar.so.2 => not found"));
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn test_execute_system_binary() {
+        if find_executable("readelf").is_none() {
+            return;
+        }
+        let req = LibraryDepsRequest {
+            path: "/bin/sh".to_string(),
+        };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.starts_with("All "), "{}", result);
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = LibraryDepsRequest {
+            path: "../bin/app".to_string(),
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+}
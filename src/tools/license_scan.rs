@@ -0,0 +1,363 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{validate_argument, validate_not_flag, Validatable, ValidationError};
+
+/// Which licenses the server allows in dependencies
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+struct LicensePolicy {
+    /// Licenses no dependency may have
+    deny: Vec<String>,
+    /// If not empty, the only licenses dependencies may have
+    allow: Vec<String>,
+}
+
+/// License policy loaded from LICENSE_DENY and LICENSE_ALLOW environment variables at startup: SPDX
+/// identifiers separated by semicolons, each matched case-insensitively and ending with `*` to match
+/// a prefix, e.g., LICENSE_DENY="AGPL-*;GPL-3.0*;SSPL-1.0". Unset, no license is denied.
+static POLICY: LazyLock<LicensePolicy> = LazyLock::new(|| load_policy(|var| std::env::var(var).ok()));
+
+/// Internal implementation for testability - takes a variable lookup as parameter.
+fn load_policy(var: impl Fn(&str) -> Option<String>) -> LicensePolicy {
+    let list = |name: &str| -> Vec<String> {
+        var(name)
+            .unwrap_or_default()
+            .split(';')
+            .map(str::trim)
+            .filter(|s| !s.is_empty())
+            .map(str::to_string)
+            .collect()
+    };
+    LicensePolicy {
+        deny: list("LICENSE_DENY"),
+        allow: list("LICENSE_ALLOW"),
+    }
+}
+
+/// An attribute of a license() target in `bazel query --output=build` output, e.g., `package_name = "abseil"`
+static BUILD_ATTRIBUTE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r#"^\s*(name|package_name|package_version|license_kinds)\s*=\s*(.+?),?\s*$"#).unwrap());
+
+/// Request parameters for the license_scan tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct LicenseScanRequest {
+    /// Go packages whose dependencies to scan with go-licenses (default: "./...")
+    #[serde(default)]
+    pub packages: Option<String>,
+    /// Bazel targets whose dependencies to scan for rules_license license() targets, e.g., ["//cmd/server"].
+    /// When set, bazel is used instead of go-licenses.
+    #[serde(default)]
+    pub targets: Vec<String>,
+}
+
+impl Validatable for LicenseScanRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        for arg in self.packages.iter().chain(&self.targets) {
+            validate_argument(arg)?;
+            validate_not_flag(arg)?;
+        }
+        Ok(())
+    }
+}
+
+/// A dependency and the license found for it
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Dependency {
+    name: String,
+    /// SPDX identifier, or "Unknown" when none was found
+    license: String,
+    /// Where the license was found: a license file's URL, or the license() target declaring it
+    source: String,
+}
+
+/// How a dependency's license fares under the policy
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Status {
+    Allowed,
+    Denied,
+    Unknown,
+}
+
+impl Status {
+    fn as_str(self) -> &'static str {
+        match self {
+            Status::Allowed => "allowed",
+            Status::Denied => "denied",
+            Status::Unknown => "unknown",
+        }
+    }
+}
+
+/// Scan the dependencies' licenses with a validated request and execution context. The result is the
+/// report as JSON, which the server returns as structured content described by `output_schema`.
+pub fn execute(req: &LicenseScanRequest, ctx: &ExecutionContext) -> String {
+    let (scanner, dependencies) = if req.targets.is_empty() {
+        if find_executable("go-licenses").is_none() {
+            return "Error: go-licenses is not installed. Install it with: \
+                    go install github.com/google/go-licenses@latest"
+                .to_string();
+        }
+        let mut cmd = Command::new("go-licenses");
+        cmd.args(["report", req.packages.as_deref().unwrap_or("./...")]);
+        match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) if ctx.dry_run => return output,
+            ExecutionResult::Success(output) => ("go-licenses", parse_go_licenses(&output)),
+            result => return format!("Error: go-licenses failed:\n{}", result.into_string()),
+        }
+    } else {
+        let query = format!("kind(license, deps({}))", req.targets.join(" + "));
+        let mut cmd = Command::new("bazel");
+        cmd.args(["query", "--output=build", &query]);
+        match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) if ctx.dry_run => return output,
+            ExecutionResult::Success(output) => ("bazel", parse_license_targets(&output)),
+            result => return format!("Error: bazel query failed:\n{}", result.into_string()),
+        }
+    };
+    report(scanner, &dependencies, &POLICY)
+}
+
+/// Dependencies in `go-licenses report` output: lines of module, license URL and license name
+fn parse_go_licenses(output: &str) -> Vec<Dependency> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let fields: Vec<&str> = line.split(',').map(str::trim).collect();
+            match fields[..] {
+                [name, url, license] if !name.is_empty() => Some(Dependency {
+                    name: name.to_string(),
+                    license: license.to_string(),
+                    source: url.to_string(),
+                }),
+                _ => None,
+            }
+        })
+        .collect()
+}
+
+/// Dependencies declared by license() targets in `bazel query --output=build` output, one per license kind.
+/// Kinds are labels such as "@rules_license//licenses/spdx:Apache-2.0", whose name is the SPDX identifier.
+fn parse_license_targets(output: &str) -> Vec<Dependency> {
+    let mut dependencies = Vec::new();
+    for block in output.split("\nlicense(").skip(usize::from(!output.starts_with("license("))) {
+        let mut attributes = std::collections::HashMap::new();
+        for line in block.lines() {
+            if let Some(captures) = BUILD_ATTRIBUTE.captures(line) {
+                attributes.insert(captures[1].to_string(), captures[2].to_string());
+            }
+        }
+        let unquote = |key: &str| attributes.get(key).map(|value| value.trim_matches('"').to_string());
+        let Some(target) = unquote("name") else {
+            continue;
+        };
+        let name = match (unquote("package_name"), unquote("package_version")) {
+            (Some(name), Some(version)) if !version.is_empty() => format!("{}@{}", name, version),
+            (Some(name), _) if !name.is_empty() => name,
+            _ => target.clone(),
+        };
+        let kinds: Vec<String> = attributes
+            .get("license_kinds")
+            .map(|kinds| {
+                kinds
+                    .trim_matches(|c| c == '[' || c == ']')
+                    .split(',')
+                    .map(|kind| kind.trim().trim_matches('"'))
+                    .filter(|kind| !kind.is_empty())
+                    .map(|kind| kind.rsplit(':').next().unwrap_or(kind).to_string())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let kinds = if kinds.is_empty() { vec!["Unknown".to_string()] } else { kinds };
+        for license in kinds {
+            dependencies.push(Dependency {
+                name: name.clone(),
+                license,
+                source: format!("license target {}", target),
+            });
+        }
+    }
+    dependencies
+}
+
+/// Whether a license matches a policy entry: the same identifier, or a prefix ending in `*`
+fn matches(license: &str, entry: &str) -> bool {
+    let (license, entry) = (license.to_lowercase(), entry.to_lowercase());
+    match entry.strip_suffix('*') {
+        Some(prefix) => license.starts_with(prefix),
+        None => license == entry,
+    }
+}
+
+fn status(license: &str, policy: &LicensePolicy) -> Status {
+    if license.is_empty() || license.eq_ignore_ascii_case("unknown") {
+        Status::Unknown
+    } else if policy.deny.iter().any(|entry| matches(license, entry))
+        || (!policy.allow.is_empty() && !policy.allow.iter().any(|entry| matches(license, entry)))
+    {
+        Status::Denied
+    } else {
+        Status::Allowed
+    }
+}
+
+/// The report as JSON: a verdict ("pass", "review" when some licenses are unknown, or "fail" when some
+/// are denied), the policy, the dependencies flagged, and every dependency with its license's status
+fn report(scanner: &str, dependencies: &[Dependency], policy: &LicensePolicy) -> String {
+    let rows: Vec<_> = dependencies
+        .iter()
+        .map(|dependency| (dependency, status(&dependency.license, policy)))
+        .collect();
+    let verdict = if rows.iter().any(|(_, status)| *status == Status::Denied) {
+        "fail"
+    } else if rows.iter().any(|(_, status)| *status == Status::Unknown) {
+        "review"
+    } else {
+        "pass"
+    };
+    let entry = |(dependency, status): &(&Dependency, Status)| {
+        json!({
+            "name": dependency.name,
+            "license": dependency.license,
+            "source": dependency.source,
+            "status": status.as_str(),
+        })
+    };
+    let report = json!({
+        "verdict": verdict,
+        "scanner": scanner,
+        "policy": {"deny": policy.deny, "allow": policy.allow},
+        "flagged": rows.iter().filter(|(_, status)| *status != Status::Allowed).map(entry).collect::<Vec<_>>(),
+        "dependencies": rows.iter().map(entry).collect::<Vec<_>>(),
+    });
+    serde_json::to_string_pretty(&report).unwrap_or_default()
+}
+
+/// JSON Schema of the report, declared as the tool's output schema
+pub fn output_schema() -> Value {
+    let strings = json!({"type": "array", "items": {"type": "string"}});
+    let dependencies = json!({
+        "type": "array",
+        "items": {
+            "type": "object",
+            "properties": {
+                "name": {"type": "string"},
+                "license": {"type": "string"},
+                "source": {"type": "string"},
+                "status": {"type": "string", "enum": ["allowed", "denied", "unknown"]},
+            },
+            "required": ["name", "license", "source", "status"],
+        },
+    });
+    json!({
+        "type": "object",
+        "properties": {
+            "verdict": {"type": "string", "enum": ["pass", "review", "fail"]},
+            "scanner": {"type": "string"},
+            "policy": {
+                "type": "object",
+                "properties": {"deny": strings, "allow": strings},
+                "required": ["deny", "allow"],
+            },
+            "flagged": dependencies,
+            "dependencies": dependencies,
+        },
+        "required": ["verdict", "scanner", "policy", "flagged", "dependencies"],
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_load_policy() {
+        let policy = load_policy(|var| match var {
+            "LICENSE_DENY" => Some(" AGPL-*; GPL-3.0 ;".to_string()),
+            _ => None,
+        });
+        assert_eq!(policy.deny, ["AGPL-*", "GPL-3.0"]);
+        assert!(policy.allow.is_empty());
+    }
+
+    #[test]
+    fn test_status() {
+        let policy = LicensePolicy {
+            deny: vec!["agpl-*".to_string()],
+            allow: vec![],
+        };
+        assert_eq!(status("AGPL-3.0-only", &policy), Status::Denied);
+        assert_eq!(status("MIT", &policy), Status::Allowed);
+        assert_eq!(status("Unknown", &policy), Status::Unknown);
+        let policy = LicensePolicy {
+            deny: vec![],
+            allow: vec!["MIT".to_string(), "Apache-2.0".to_string()],
+        };
+        assert_eq!(status("apache-2.0", &policy), Status::Allowed);
+        assert_eq!(status("MPL-2.0", &policy), Status::Denied);
+    }
+
+    #[test]
+    fn test_parse_go_licenses() {
+        let output = "github.com/spf13/cobra,https://github.com/spf13/cobra/blob/v1.8.0/LICENSE.txt,Apache-2.0\n\
+                      example.com/internal,Unknown,Unknown\n";
+        let dependencies = parse_go_licenses(output);
+        assert_eq!(dependencies.len(), 2);
+        assert_eq!(dependencies[0].name, "github.com/spf13/cobra");
+        assert_eq!(dependencies[0].license, "Apache-2.0");
+        assert_eq!(dependencies[1].license, "Unknown");
+    }
+
+    #[test]
+    fn test_parse_license_targets() {
+        let output = "# /ws/external/abseil/BUILD:3:8\nlicense(\n  name = \"license\",\n  \
+                      license_kinds = [\"@rules_license//licenses/spdx:Apache-2.0\"],\n  \
+                      package_name = \"abseil-cpp\",\n  package_version = \"20240116.2\",\n)\n\
+                      # /ws/third_party/blob/BUILD:1:8\nlicense(\n  name = \"blob_license\",\n)\n";
+        let dependencies = parse_license_targets(output);
+        assert_eq!(
+            dependencies,
+            [
+                Dependency {
+                    name: "abseil-cpp@20240116.2".to_string(),
+                    license: "Apache-2.0".to_string(),
+                    source: "license target license".to_string(),
+                },
+                Dependency {
+                    name: "blob_license".to_string(),
+                    license: "Unknown".to_string(),
+                    source: "license target blob_license".to_string(),
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_report() {
+        let dependency = |name: &str, license: &str| Dependency {
+            name: name.to_string(),
+            license: license.to_string(),
+            source: String::new(),
+        };
+        let policy = LicensePolicy {
+            deny: vec!["GPL-3.0*".to_string()],
+            allow: vec![],
+        };
+        let dependencies = [dependency("a", "MIT"), dependency("b", "GPL-3.0-or-later"), dependency("c", "Unknown")];
+        let parsed: Value = serde_json::from_str(&report("go-licenses", &dependencies, &policy)).unwrap();
+        assert_eq!(parsed["verdict"], "fail");
+        assert_eq!(parsed["flagged"].as_array().unwrap().len(), 2);
+        assert_eq!(parsed["flagged"][0]["status"], "denied");
+        assert_eq!(parsed["dependencies"][0]["status"], "allowed");
+        for field in output_schema()["required"].as_array().unwrap() {
+            assert!(parsed.get(field.as_str().unwrap()).is_some(), "missing {}", field);
+        }
+        let parsed: Value = serde_json::from_str(&report("bazel", &dependencies[..1], &policy)).unwrap();
+        assert_eq!(parsed["verdict"], "pass");
+    }
+}
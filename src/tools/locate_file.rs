@@ -0,0 +1,253 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::ignore::IgnoreRules;
+use crate::request::ExecutionContext;
+use crate::security::{validate_no_traversal, validate_path, validate_path_with_working_dir, Validatable, ValidationError};
+
+/// Matches returned when the request doesn't set max_results
+const DEFAULT_MAX_RESULTS: usize = 20;
+
+/// Entries examined before the search stops, so a huge tree can't stall the call
+const MAX_SCANNED: usize = 200_000;
+
+/// Request parameters for the locate_file tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct LocateFileRequest {
+    /// Part of the file name, in any case and with or without separators, e.g., "foo_service_test" finds FooServiceTest.java
+    pub query: String,
+
+    /// The directory to search under. Defaults to "." if not provided.
+    #[serde(default = "default_path")]
+    pub path: String,
+
+    /// Most matches to return (default: 20)
+    #[serde(default)]
+    pub max_results: Option<usize>,
+
+    /// Also search what the repository's .gitignore and .bazelignore files ignore, such as bazel-out
+    #[serde(default)]
+    pub include_ignored: bool,
+}
+
+fn default_path() -> String {
+    ".".to_string()
+}
+
+impl Validatable for LocateFileRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_no_traversal(&self.path)?;
+        validate_path(&self.path)?;
+        Ok(())
+    }
+}
+
+/// Find files by fuzzy name with a validated request and execution context
+pub fn execute(req: &LocateFileRequest, ctx: &ExecutionContext) -> String {
+    if let Some(ref working_dir) = ctx.working_dir {
+        if let Err(e) = validate_path_with_working_dir(&req.path, working_dir) {
+            return e.to_string();
+        }
+    }
+    let query = normalize(&req.query);
+    if query.is_empty() {
+        return "Error: query must contain at least one letter or digit".to_string();
+    }
+    let root = match ctx.working_dir {
+        Some(ref dir) => Path::new(dir).join(&req.path),
+        None => PathBuf::from(&req.path),
+    };
+    if !root.is_dir() {
+        return format!("Error: '{}' is not a directory", req.path);
+    }
+
+    let ignore = (!req.include_ignored).then(|| IgnoreRules::for_dir(&root));
+    let (mut matches, complete) = search(&root, &query, ignore);
+    if matches.is_empty() {
+        return format!("No files matching '{}' under {}", req.query, req.path);
+    }
+    // Best score first, then shorter paths
+    matches.sort_by(|a, b| b.0.cmp(&a.0).then(a.1.len().cmp(&b.1.len())).then(a.1.cmp(&b.1)));
+    let mut lines: Vec<String> = matches
+        .into_iter()
+        .take(req.max_results.unwrap_or(DEFAULT_MAX_RESULTS))
+        .map(|(_, path)| path)
+        .collect();
+    if !complete {
+        lines.push(format!("(search stopped after {} entries; narrow path to search the rest)", MAX_SCANNED));
+    }
+    lines.join("\n")
+}
+
+/// Walk the tree under `root` and score every file against the query. Symlinked directories aren't
+/// followed, and blocked paths are skipped. Returns the matches and whether the whole tree was searched.
+fn search(root: &Path, query: &str, mut ignore: Option<IgnoreRules>) -> (Vec<(u32, String)>, bool) {
+    let mut matches = Vec::new();
+    let mut pending = vec![root.to_path_buf()];
+    let mut scanned = 0;
+    while let Some(dir) = pending.pop() {
+        // The root's own rules were loaded with the rules above it
+        if let Some(rules) = ignore.as_mut().filter(|_| dir != root) {
+            rules.enter(&dir);
+        }
+        let entries = match fs::read_dir(&dir) {
+            Ok(entries) => entries,
+            Err(_) => continue,
+        };
+        for entry in entries.flatten() {
+            scanned += 1;
+            if scanned > MAX_SCANNED {
+                return (matches, false);
+            }
+            let path = entry.path();
+            if entry.file_name() == ".git" || ignore.as_ref().is_some_and(|rules| rules.is_ignored(&path)) {
+                continue;
+            }
+            if validate_path(&path.to_string_lossy()).is_err() {
+                continue;
+            }
+            let file_type = match entry.file_type() {
+                Ok(file_type) => file_type,
+                Err(_) => continue,
+            };
+            if file_type.is_dir() {
+                pending.push(path);
+                continue;
+            }
+            let relative = path.strip_prefix(root).unwrap_or(&path).to_string_lossy().to_string();
+            if let Some(score) = score(query, &entry.file_name().to_string_lossy(), &relative) {
+                matches.push((score, relative));
+            }
+        }
+    }
+    (matches, true)
+}
+
+/// Lowercase letters and digits only, so "foo_service_test" and "FooServiceTest" compare equal
+fn normalize(text: &str) -> String {
+    text.chars()
+        .filter(|c| c.is_alphanumeric())
+        .flat_map(char::to_lowercase)
+        .collect()
+}
+
+/// How well a file matches a normalized query, higher is better; None if it doesn't match
+fn score(query: &str, name: &str, path: &str) -> Option<u32> {
+    let stem = normalize(name.split('.').next().unwrap_or(name));
+    let name = normalize(name);
+    if stem == query || name == query {
+        Some(100)
+    } else if name.starts_with(query) {
+        Some(80)
+    } else if name.contains(query) {
+        Some(60)
+    } else if is_subsequence(query, &name) {
+        Some(40)
+    } else if normalize(path).contains(query) {
+        Some(30)
+    } else if is_subsequence(query, &normalize(path)) {
+        Some(10)
+    } else {
+        None
+    }
+}
+
+/// Whether the characters of `needle` appear in `haystack` in order, e.g., "fst" in "fooservicetest"
+fn is_subsequence(needle: &str, haystack: &str) -> bool {
+    let mut chars = haystack.chars();
+    needle.chars().all(|c| chars.any(|h| h == c))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn setup_tree() -> TempDir {
+        let temp_dir = TempDir::new().unwrap();
+        let root = temp_dir.path();
+        for dir in ["java/com/app", "go/service", "bazel-out/bin", ".git"] {
+            fs::create_dir_all(root.join(dir)).unwrap();
+        }
+        for file in [
+            "java/com/app/FooServiceTest.java",
+            "java/com/app/FooService.java",
+            "go/service/foo_service_test.go",
+            "go/service/food_store_test.go",
+            "bazel-out/bin/foo_service_test",
+            "README.md",
+        ] {
+            fs::write(root.join(file), "").unwrap();
+        }
+        fs::write(root.join(".gitignore"), "bazel-out/\n").unwrap();
+        temp_dir
+    }
+
+    fn locate(temp_dir: &TempDir, query: &str, include_ignored: bool) -> String {
+        let req = LocateFileRequest {
+            query: query.to_string(),
+            path: ".".to_string(),
+            max_results: None,
+            include_ignored,
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        execute(&req, &ctx)
+    }
+
+    #[test]
+    fn test_locate_ranks_exact_names_first() {
+        let temp_dir = setup_tree();
+        // The .git directory makes the temp dir a repository, so its .gitignore hides bazel-out
+        assert_eq!(
+            locate(&temp_dir, "foo_service_test", false),
+            "go/service/foo_service_test.go\njava/com/app/FooServiceTest.java"
+        );
+    }
+
+    #[test]
+    fn test_locate_fuzzy_match() {
+        let temp_dir = setup_tree();
+        let result = locate(&temp_dir, "fooSvcTest", false);
+        let lines: Vec<&str> = result.lines().collect();
+        assert_eq!(lines, vec!["go/service/foo_service_test.go", "java/com/app/FooServiceTest.java"]);
+    }
+
+    #[test]
+    fn test_locate_include_ignored() {
+        let temp_dir = setup_tree();
+        assert!(locate(&temp_dir, "foo_service_test", true).contains("bazel-out/bin/foo_service_test"));
+    }
+
+    #[test]
+    fn test_locate_no_match() {
+        let temp_dir = setup_tree();
+        assert_eq!(locate(&temp_dir, "zzz", false), "No files matching 'zzz' under .");
+        assert!(locate(&temp_dir, "__", false).starts_with("Error: query must contain"));
+    }
+
+    #[test]
+    fn test_score() {
+        assert_eq!(score("fooservice", "FooService.java", "java/FooService.java"), Some(100));
+        assert_eq!(score("fooservice", "FooServiceTest.java", "java/FooServiceTest.java"), Some(80));
+        assert_eq!(score("service", "FooService.java", "java/FooService.java"), Some(60));
+        assert_eq!(score("fst", "FooServiceTest.java", "java/FooServiceTest.java"), Some(40));
+        assert_eq!(score("javafoo", "Foo.java", "java/Foo.java"), Some(30));
+        assert_eq!(score("xyz", "Foo.java", "java/Foo.java"), None);
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = LocateFileRequest {
+            query: "foo".to_string(),
+            path: "../src".to_string(),
+            max_results: None,
+            include_ignored: false,
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+}
@@ -1,19 +1,30 @@
+use regex::Regex;
 use rmcp::schemars;
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
+use std::path::Path;
 use std::process::Command;
+use std::sync::LazyLock;
 
 use crate::executor::run_command;
+use crate::ignore::IgnoreRules;
 use crate::request::ExecutionContext;
 use crate::security::{validate_argument, validate_no_traversal, validate_not_flag, validate_path, validate_path_with_working_dir, Validatable, ValidationError};
 
 /// Request parameters for the ls tool
-#[derive(Debug, Deserialize, schemars::JsonSchema)]
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
 pub struct LsRequest {
     /// The path to list contents of. Defaults to "." if not provided.
     #[serde(default = "default_path")]
     pub path: String,
+
+    /// Leave out entries ignored by the repository's .gitignore or .bazelignore files
+    #[serde(default)]
+    pub respect_ignore: bool,
 }
 
+/// The name at the end of an `ls -al` line, after the mode, links, owner, group, size and date fields
+static LS_NAME: LazyLock<Regex> = LazyLock::new(|| Regex::new(r"^(?:\S+\s+){8}(.+)$").unwrap());
+
 fn default_path() -> String {
     ".".to_string()
 }
@@ -40,7 +51,35 @@ pub fn execute(req: &LsRequest, ctx: &ExecutionContext) -> String {
 
     let mut cmd = Command::new("ls");
     cmd.args(["-al", &req.path]);
-    run_command(cmd, ctx).into_string()
+    let output = run_command(cmd, ctx).into_string();
+    if !req.respect_ignore || output.starts_with("Error:") {
+        return output;
+    }
+    let dir = match ctx.working_dir {
+        Some(ref working_dir) => Path::new(working_dir).join(&req.path),
+        None => std::env::current_dir().unwrap_or_default().join(&req.path),
+    };
+    if !dir.is_dir() {
+        return output;
+    }
+    filter_ignored(&output, &dir, &IgnoreRules::for_dir(&dir))
+}
+
+/// Drop the `ls -al` lines of entries in `dir` that the ignore rules match
+fn filter_ignored(output: &str, dir: &Path, ignore: &IgnoreRules) -> String {
+    output
+        .lines()
+        .filter(|line| {
+            let name = match LS_NAME.captures(line) {
+                Some(captures) => captures[1].to_string(),
+                None => return true,
+            };
+            // Symlinks are listed as "name -> target"
+            let name = name.split(" -> ").next().unwrap_or(&name);
+            name == "." || name == ".." || !ignore.is_ignored(&dir.join(name))
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
 }
 
 #[cfg(test)]
@@ -77,6 +116,7 @@ mod tests {
         let temp_dir = setup_test_dir();
         let req = LsRequest {
             path: temp_dir.path().to_string_lossy().to_string(),
+            respect_ignore: false,
         };
         assert!(req.validate().is_ok());
         let result = execute(&req, &ExecutionContext::default());
@@ -90,6 +130,7 @@ mod tests {
         let temp_dir = setup_test_dir();
         let req = LsRequest {
             path: temp_dir.path().to_string_lossy().to_string(),
+            respect_ignore: false,
         };
         assert!(req.validate().is_ok());
         let result = execute(&req, &ExecutionContext::default());
@@ -100,12 +141,36 @@ mod tests {
     fn test_ls_tool_nonexistent_path() {
         let req = LsRequest {
             path: "/nonexistent/path".to_string(),
+            respect_ignore: false,
         };
         assert!(req.validate().is_ok());
         let result = execute(&req, &ExecutionContext::default());
         assert!(result.contains("No such file or directory"));
     }
 
+    #[test]
+    fn test_ls_tool_respects_ignore_files() {
+        let temp_dir = setup_test_dir();
+        fs::create_dir(temp_dir.path().join(".git")).unwrap();
+        fs::create_dir(temp_dir.path().join("bazel-out")).unwrap();
+        fs::write(temp_dir.path().join(".gitignore"), "*.txt\n").unwrap();
+        fs::write(temp_dir.path().join(".bazelignore"), "bazel-out\n").unwrap();
+        let mut req = LsRequest {
+            path: temp_dir.path().to_string_lossy().to_string(),
+            respect_ignore: true,
+        };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.contains("file2.rs"));
+        assert!(result.contains("subdir"));
+        assert!(!result.contains("file1.txt"), "{}", result);
+        assert!(!result.contains("bazel-out"), "{}", result);
+        assert!(!result.contains(" .git\n"), "{}", result);
+
+        req.respect_ignore = false;
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.contains("file1.txt") && result.contains("bazel-out"));
+    }
+
     #[test]
     fn test_ls_request_default() {
         let request: LsRequest = serde_json::from_str("{}").unwrap();
@@ -116,6 +181,7 @@ mod tests {
     fn test_validate_blocks_shell_injection_semicolon() {
         let req = LsRequest {
             path: "/tmp; echo hello".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -127,6 +193,7 @@ mod tests {
     fn test_validate_blocks_shell_injection_pipe() {
         let req = LsRequest {
             path: "/tmp | echo hello".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -138,6 +205,7 @@ mod tests {
     fn test_validate_blocks_shell_injection_backtick() {
         let req = LsRequest {
             path: "`echo hello`".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -149,6 +217,7 @@ mod tests {
     fn test_validate_blocks_shell_injection_dollar() {
         let req = LsRequest {
             path: "$(echo hello)".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -160,6 +229,7 @@ mod tests {
     fn test_validate_allows_other_paths() {
         let req = LsRequest {
             path: "/tmp".to_string(),
+            respect_ignore: false,
         };
         assert!(req.validate().is_ok());
     }
@@ -168,6 +238,7 @@ mod tests {
     fn test_validate_blocks_flag_injection_single_dash() {
         let req = LsRequest {
             path: "-la".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -179,6 +250,7 @@ mod tests {
     fn test_validate_blocks_flag_injection_double_dash() {
         let req = LsRequest {
             path: "--help".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -190,6 +262,7 @@ mod tests {
     fn test_validate_allows_paths_with_internal_dashes() {
         let req = LsRequest {
             path: "/path/with-dash/file".to_string(),
+            respect_ignore: false,
         };
         assert!(req.validate().is_ok());
     }
@@ -198,6 +271,7 @@ mod tests {
     fn test_validate_blocks_path_traversal() {
         let req = LsRequest {
             path: "/tmp/../etc".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -209,6 +283,7 @@ mod tests {
     fn test_validate_blocks_path_traversal_relative() {
         let req = LsRequest {
             path: "../secret".to_string(),
+            respect_ignore: false,
         };
         assert!(matches!(
             req.validate(),
@@ -1,5 +1,75 @@
+pub mod bazel_cache;
+pub mod binary_info;
+pub mod cancel;
+pub mod change_summary;
+pub mod debug_test;
+pub mod diff_outputs;
+pub mod digest;
+pub mod doctor;
+pub mod download;
+pub mod exists;
+pub mod explain_failure;
+pub mod get_output;
 pub mod git;
+pub mod golden;
+pub mod history;
+pub mod gpu_info;
+pub mod host_info;
+pub mod job_cancel;
+pub mod job_logs;
+pub mod job_start;
+pub mod job_status;
+pub mod library_deps;
+pub mod license_scan;
+pub mod locate_file;
 pub mod ls;
+pub mod overlay_diff;
+pub mod owners;
+pub mod pipeline;
+pub mod presubmit;
+pub mod ps_jobs;
+pub mod purge_scratch;
+pub mod repro_check;
+pub mod rerun;
+pub mod sbom;
+pub mod symbolicate;
+pub mod transcript;
+pub mod usage_report;
 
+pub use bazel_cache::BazelCacheRequest;
+pub use binary_info::BinaryInfoRequest;
+pub use cancel::CancelRequest;
+pub use change_summary::ChangeSummaryRequest;
+pub use debug_test::DebugTestRequest;
+pub use diff_outputs::DiffOutputsRequest;
+pub use digest::DigestRequest;
+pub use doctor::DoctorRequest;
+pub use download::DownloadRequest;
+pub use exists::ExistsRequest;
+pub use explain_failure::ExplainFailureRequest;
+pub use get_output::GetOutputRequest;
 pub use git::GitRequest;
+pub use golden::GoldenRequest;
+pub use history::HistoryRequest;
+pub use gpu_info::GpuInfoRequest;
+pub use host_info::HostInfoRequest;
+pub use job_cancel::JobCancelRequest;
+pub use job_logs::JobLogsRequest;
+pub use job_start::JobStartRequest;
+pub use job_status::JobStatusRequest;
+pub use library_deps::LibraryDepsRequest;
+pub use license_scan::LicenseScanRequest;
+pub use locate_file::LocateFileRequest;
 pub use ls::LsRequest;
+pub use overlay_diff::OverlayDiffRequest;
+pub use owners::OwnersRequest;
+pub use pipeline::PipelineRequest;
+pub use presubmit::PresubmitRequest;
+pub use ps_jobs::PsJobsRequest;
+pub use purge_scratch::PurgeScratchRequest;
+pub use repro_check::ReproCheckRequest;
+pub use rerun::RerunRequest;
+pub use sbom::SbomRequest;
+pub use symbolicate::SymbolicateRequest;
+pub use transcript::TranscriptRequest;
+pub use usage_report::UsageReportRequest;
@@ -0,0 +1,155 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::Path;
+
+use crate::diff::unified_diff;
+use crate::overlay::{self, Change, Overlay};
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::transcript::format_utc;
+
+/// Request parameters for the overlay_diff tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct OverlayDiffRequest {
+    /// ID of the overlay, as named in the result of the call that ran on it
+    pub id: u64,
+    /// Only list the changed paths, without their diffs (default: false)
+    #[serde(default)]
+    pub name_only: Option<bool>,
+}
+
+impl Validatable for OverlayDiffRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Show what the commands run on an overlay changed, with a validated request and execution context
+pub fn execute(req: &OverlayDiffRequest, _ctx: &ExecutionContext) -> String {
+    let Some(overlay) = overlay::get(req.id) else {
+        return format!("Error: No overlay with ID {}", req.id);
+    };
+    let changes = match overlay.changes() {
+        Ok(changes) => changes,
+        Err(e) => return format!("Error: Failed to read overlay {}: {}", overlay.id, e),
+    };
+    render(&overlay, &changes, req.name_only.unwrap_or(false))
+}
+
+/// A summary line, a line per changed path, and unless `name_only` their unified diffs, as git shows them
+fn render(overlay: &Overlay, changes: &[Change], name_only: bool) -> String {
+    let mut lines = vec![format!(
+        "Overlay {} of {} ({}, {}): {} changed path{}",
+        overlay.id,
+        overlay.workspace.display(),
+        overlay.tool,
+        format_utc(overlay.created),
+        changes.len(),
+        if changes.len() == 1 { "" } else { "s" }
+    )];
+    for change in changes {
+        let status = match change {
+            Change::Added(_) => 'A',
+            Change::Modified(_) => 'M',
+            Change::Deleted(_) => 'D',
+        };
+        lines.push(format!("{} {}", status, change.path().display()));
+    }
+    if !name_only {
+        for change in changes {
+            let diff = file_diff(overlay, change);
+            if !diff.is_empty() {
+                lines.push(String::new());
+                lines.push(diff.trim_end().to_string());
+            }
+        }
+    }
+    lines.join("\n")
+}
+
+/// Unified diff of a changed path, from the workspace to the upper layer
+fn file_diff(overlay: &Overlay, change: &Change) -> String {
+    let path = change.path();
+    let (before, after) = (overlay.workspace.join(path), overlay.upper.join(path));
+    let (mut old_label, mut new_label) = (format!("a/{}", path.display()), format!("b/{}", path.display()));
+    let (old, new) = match change {
+        Change::Added(_) => {
+            old_label = "/dev/null".to_string();
+            (Some(String::new()), read(&after))
+        }
+        Change::Modified(_) => (read(&before), read(&after)),
+        Change::Deleted(_) => {
+            new_label = "/dev/null".to_string();
+            (read(&before), Some(String::new()))
+        }
+    };
+    match (old, new) {
+        (Some(old), Some(new)) => match unified_diff(&old, &new, &old_label, &new_label) {
+            // Only its metadata changed, e.g., its mode
+            Some(diff) if diff.is_empty() => String::new(),
+            Some(diff) => diff,
+            None => format!("{} and {} differ in too many lines to diff", old_label, new_label),
+        },
+        _ => format!("Binary files {} and {} differ", old_label, new_label),
+    }
+}
+
+/// A file's text, or a symlink's target as "symlink -> target"; None for binary files
+fn read(path: &Path) -> Option<String> {
+    if let Ok(target) = fs::read_link(path) {
+        return Some(format!("symlink -> {}\n", target.display()));
+    }
+    let data = fs::read(path).ok()?;
+    if data.contains(&0) {
+        return None;
+    }
+    String::from_utf8(data).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::path::PathBuf;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_render() {
+        let workspace = TempDir::new().unwrap();
+        let upper = TempDir::new().unwrap();
+        fs::write(workspace.path().join("main.go"), "package main\n").unwrap();
+        fs::write(workspace.path().join("old.txt"), "gone\n").unwrap();
+        fs::write(upper.path().join("main.go"), "package main\n\nfunc main() {}\n").unwrap();
+        fs::write(upper.path().join("logo.png"), [0x89, b'P', b'N', b'G', 0]).unwrap();
+        let overlay = Overlay {
+            id: 3,
+            tool: "presubmit".to_string(),
+            workspace: workspace.path().to_path_buf(),
+            upper: upper.path().to_path_buf(),
+            work: PathBuf::new(),
+            created: 1_792_071_900,
+        };
+        let changes = [
+            Change::Added(PathBuf::from("logo.png")),
+            Change::Modified(PathBuf::from("main.go")),
+            Change::Deleted(PathBuf::from("old.txt")),
+        ];
+        let output = render(&overlay, &changes, false);
+        assert!(output.contains("(presubmit, 2026-10-15T13:45:00Z): 3 changed paths\n"));
+        assert!(output.contains("\nA logo.png\nM main.go\nD old.txt\n"));
+        assert!(output.contains("Binary files /dev/null and b/logo.png differ"));
+        assert!(output.contains("--- a/main.go\n+++ b/main.go\n"));
+        assert!(output.contains("+func main() {}"));
+        assert!(output.contains("--- a/old.txt\n+++ /dev/null\n"));
+        assert!(render(&overlay, &changes, true).ends_with("D old.txt"));
+    }
+
+    #[test]
+    fn test_unknown_overlay() {
+        let req = OverlayDiffRequest {
+            id: 999_999,
+            name_only: None,
+        };
+        assert_eq!(execute(&req, &ExecutionContext::default()), "Error: No overlay with ID 999999");
+    }
+}
@@ -0,0 +1,389 @@
+use regex::Regex;
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+
+use crate::request::ExecutionContext;
+use crate::security::{validate_argument, validate_no_traversal, validate_path, validate_path_with_working_dir, Validatable, ValidationError};
+
+/// CODEOWNERS locations relative to the repository root, in lookup order (first found wins)
+const CODEOWNERS_LOCATIONS: &[&str] = &[
+    ".github/CODEOWNERS",
+    "CODEOWNERS",
+    "docs/CODEOWNERS",
+    ".gitlab/CODEOWNERS",
+];
+
+/// Request parameters for the owners tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct OwnersRequest {
+    /// The file or directory to look up owners for. Defaults to "." if not provided.
+    #[serde(default = "default_path")]
+    pub path: String,
+}
+
+fn default_path() -> String {
+    ".".to_string()
+}
+
+impl Validatable for OwnersRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        validate_argument(&self.path)?;
+        validate_no_traversal(&self.path)?;
+        validate_path(&self.path)?;
+        Ok(())
+    }
+}
+
+/// Resolve the owners of a path with a validated request and execution context
+pub fn execute(req: &OwnersRequest, ctx: &ExecutionContext) -> String {
+    // Validate that path combined with working_dir doesn't access blocked paths
+    if let Some(ref working_dir) = ctx.working_dir {
+        if let Err(e) = validate_path_with_working_dir(&req.path, working_dir) {
+            return format!("Error: {}", e);
+        }
+    }
+
+    let base = match ctx.working_dir {
+        Some(ref dir) => PathBuf::from(dir),
+        None => match std::env::current_dir() {
+            Ok(cwd) => cwd,
+            Err(e) => return format!("Error: Failed to resolve current directory: {}", e),
+        },
+    };
+    let target = normalize(&base.join(&req.path));
+
+    let root = match target.ancestors().find(|dir| dir.join(".git").exists()) {
+        Some(root) => root.to_path_buf(),
+        None => return format!("Error: '{}' is not inside a git repository", req.path),
+    };
+    let relative = target
+        .strip_prefix(&root)
+        .unwrap_or(Path::new(""))
+        .to_string_lossy()
+        .into_owned();
+
+    let mut owners: Vec<(String, String)> = Vec::new();
+    if let Some((source, names)) = codeowners_for(&root, &relative) {
+        for name in names {
+            owners.push((name, source.clone()));
+        }
+    }
+    for (source, names) in owners_files_for(&root, &target) {
+        for name in names {
+            owners.push((name, source.clone()));
+        }
+    }
+
+    let display = if relative.is_empty() { "." } else { relative.as_str() };
+    if owners.is_empty() {
+        return format!("No owners found for {}", display);
+    }
+
+    let mut lines = vec![format!("Owners of {}:", display)];
+    let mut seen = Vec::new();
+    for (name, source) in owners {
+        if !seen.contains(&name) {
+            lines.push(format!("{}\t({})", name, source));
+            seen.push(name);
+        }
+    }
+    lines.join("\n")
+}
+
+/// Make a path absolute-looking without "." components, canonicalizing when it exists
+fn normalize(path: &Path) -> PathBuf {
+    match path.canonicalize() {
+        Ok(p) => p,
+        Err(_) => path
+            .components()
+            .filter(|c| !matches!(c, Component::CurDir))
+            .collect(),
+    }
+}
+
+/// Find the last CODEOWNERS rule matching a root-relative path.
+/// Returns the rule's location (file:line pattern) and its owners.
+fn codeowners_for(root: &Path, relative: &str) -> Option<(String, Vec<String>)> {
+    let (location, contents) = CODEOWNERS_LOCATIONS
+        .iter()
+        .find_map(|loc| fs::read_to_string(root.join(loc)).ok().map(|c| (*loc, c)))?;
+
+    let mut matched = None;
+    for (index, line) in contents.lines().enumerate() {
+        let line = line.trim();
+        // Skip comments and GitLab section headers
+        if line.is_empty() || line.starts_with('#') || line.starts_with('[') {
+            continue;
+        }
+        let mut parts = line.split_whitespace();
+        let pattern = match parts.next() {
+            Some(p) => p,
+            None => continue,
+        };
+        let names: Vec<String> = parts
+            .take_while(|part| !part.starts_with('#'))
+            .map(String::from)
+            .collect();
+        if let Some(regex) = pattern_to_regex(pattern) {
+            // Last matching rule wins
+            if regex.is_match(relative) {
+                matched = Some((format!("{}:{} {}", location, index + 1, pattern), names));
+            }
+        }
+    }
+
+    // A matching rule without owners explicitly leaves the path unowned
+    matched.filter(|(_, names)| !names.is_empty())
+}
+
+/// Collect owners from OWNERS files in the target's directory and its parents up to the root.
+/// Stops climbing at a file containing "set noparent".
+fn owners_files_for(root: &Path, target: &Path) -> Vec<(String, Vec<String>)> {
+    let start = if target.is_dir() {
+        target
+    } else {
+        target.parent().unwrap_or(root)
+    };
+
+    let mut result = Vec::new();
+    for dir in start.ancestors() {
+        if !dir.starts_with(root) {
+            break;
+        }
+        let file = dir.join("OWNERS");
+        if let Ok(contents) = fs::read_to_string(&file) {
+            let (names, noparent) = parse_owners_file(&contents);
+            if !names.is_empty() {
+                let source = file.strip_prefix(root).unwrap_or(&file).to_string_lossy().into_owned();
+                result.push((source, names));
+            }
+            if noparent {
+                break;
+            }
+        }
+        if dir == root {
+            break;
+        }
+    }
+    result
+}
+
+/// Parse an OWNERS file. Accepts both plain one-owner-per-line files and the
+/// YAML approvers/reviewers format. Returns the owners and whether "set noparent" was present.
+fn parse_owners_file(contents: &str) -> (Vec<String>, bool) {
+    let mut names = Vec::new();
+    let mut noparent = false;
+    for line in contents.lines() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') || line.ends_with(':') {
+            continue;
+        }
+        if line == "set noparent" {
+            noparent = true;
+            continue;
+        }
+        // Per-file rules and includes are not supported
+        if line.starts_with("per-file") || line.starts_with("file:") || line.starts_with("include ") {
+            continue;
+        }
+        let name = line.trim_start_matches("- ").trim();
+        if !name.is_empty() && !names.iter().any(|n| n == name) {
+            names.push(name.to_string());
+        }
+    }
+    (names, noparent)
+}
+
+/// Convert a gitignore-style pattern, as used in CODEOWNERS, to a regex matching root-relative paths.
+/// A pattern matches the path itself and, unless its last segment is a wildcard, everything under it.
+pub fn pattern_to_regex(pattern: &str) -> Option<Regex> {
+    let dir_only = pattern.ends_with('/');
+    let trimmed = pattern.trim_end_matches('/');
+    // Patterns with a leading or middle slash are anchored to the root
+    let anchored = trimmed.contains('/');
+    let trimmed = trimmed.trim_start_matches('/');
+    if trimmed.is_empty() {
+        return None;
+    }
+
+    let mut body = String::new();
+    let mut chars = trimmed.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '*' if chars.peek() == Some(&'*') => {
+                chars.next();
+                if chars.peek() == Some(&'/') {
+                    chars.next();
+                    body.push_str("(?:.*/)?");
+                } else {
+                    body.push_str(".*");
+                }
+            }
+            '*' => body.push_str("[^/]*"),
+            '?' => body.push_str("[^/]"),
+            _ => body.push_str(&regex::escape(&c.to_string())),
+        }
+    }
+
+    let last_segment = trimmed.rsplit('/').next().unwrap_or(trimmed);
+    let prefix = if anchored { "^" } else { "^(?:.*/)?" };
+    let suffix = if !dir_only && last_segment.contains('*') && last_segment != "**" {
+        "$"
+    } else {
+        "(?:/.*)?$"
+    };
+    Regex::new(&format!("{}{}{}", prefix, body, suffix)).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn matches(pattern: &str, path: &str) -> bool {
+        pattern_to_regex(pattern).unwrap().is_match(path)
+    }
+
+    fn setup_repo() -> TempDir {
+        let temp_dir = TempDir::new().unwrap();
+        fs::create_dir(temp_dir.path().join(".git")).unwrap();
+        fs::create_dir_all(temp_dir.path().join("src/tools")).unwrap();
+        fs::create_dir_all(temp_dir.path().join("docs")).unwrap();
+        fs::write(temp_dir.path().join("src/tools/ls.rs"), "").unwrap();
+        temp_dir
+    }
+
+    fn lookup(temp_dir: &TempDir, path: &str) -> String {
+        let req = OwnersRequest {
+            path: path.to_string(),
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        execute(&req, &ctx)
+    }
+
+    // Pattern tests
+    #[test]
+    fn test_pattern_unanchored_matches_anywhere() {
+        assert!(matches("*.rs", "src/tools/ls.rs"));
+        assert!(matches("*.rs", "main.rs"));
+        assert!(!matches("*.rs", "README.md"));
+    }
+
+    #[test]
+    fn test_pattern_anchored_directory() {
+        assert!(matches("/src/", "src"));
+        assert!(matches("/src/", "src/tools/ls.rs"));
+        assert!(!matches("/src/", "other/src/main.rs"));
+    }
+
+    #[test]
+    fn test_pattern_unanchored_directory_matches_nested() {
+        assert!(matches("tools/", "src/tools/ls.rs"));
+    }
+
+    #[test]
+    fn test_pattern_single_star_is_not_recursive() {
+        assert!(matches("docs/*", "docs/intro.md"));
+        assert!(!matches("docs/*", "docs/guides/setup.md"));
+    }
+
+    #[test]
+    fn test_pattern_double_star() {
+        assert!(matches("**/tests/**", "a/b/tests/c.rs"));
+        assert!(matches("src/**/*.rs", "src/tools/ls.rs"));
+        assert!(matches("src/**/*.rs", "src/main.rs"));
+    }
+
+    // OWNERS parsing tests
+    #[test]
+    fn test_parse_owners_plain() {
+        let (names, noparent) = parse_owners_file("# comment\nalice@example.com\nbob@example.com\n");
+        assert_eq!(names, vec!["alice@example.com", "bob@example.com"]);
+        assert!(!noparent);
+    }
+
+    #[test]
+    fn test_parse_owners_noparent_and_per_file() {
+        let (names, noparent) = parse_owners_file("set noparent\nalice\nper-file *.md=bob\n");
+        assert_eq!(names, vec!["alice"]);
+        assert!(noparent);
+    }
+
+    #[test]
+    fn test_parse_owners_yaml() {
+        let (names, _) = parse_owners_file("approvers:\n  - alice\nreviewers:\n  - bob\n  - alice\n");
+        assert_eq!(names, vec!["alice", "bob"]);
+    }
+
+    // Lookup tests
+    #[test]
+    fn test_codeowners_last_match_wins() {
+        let temp_dir = setup_repo();
+        fs::create_dir(temp_dir.path().join(".github")).unwrap();
+        fs::write(
+            temp_dir.path().join(".github/CODEOWNERS"),
+            "* @org/everyone\n/src/tools/ @org/tools @carol\n",
+        )
+        .unwrap();
+        let result = lookup(&temp_dir, "src/tools/ls.rs");
+        assert!(result.starts_with("Owners of src/tools/ls.rs:"));
+        assert!(result.contains("@org/tools\t(.github/CODEOWNERS:2 /src/tools/)"));
+        assert!(result.contains("@carol"));
+        assert!(!result.contains("@org/everyone"));
+    }
+
+    #[test]
+    fn test_codeowners_rule_without_owners_unowns_path() {
+        let temp_dir = setup_repo();
+        fs::write(temp_dir.path().join("CODEOWNERS"), "* @org/everyone\n/docs/\n").unwrap();
+        assert!(lookup(&temp_dir, "docs").starts_with("No owners found"));
+    }
+
+    #[test]
+    fn test_owners_files_inherit_until_noparent() {
+        let temp_dir = setup_repo();
+        fs::write(temp_dir.path().join("OWNERS"), "root-owner\n").unwrap();
+        fs::write(temp_dir.path().join("src/OWNERS"), "src-owner\n").unwrap();
+        let result = lookup(&temp_dir, "src/tools/ls.rs");
+        assert!(result.contains("src-owner\t(src/OWNERS)"));
+        assert!(result.contains("root-owner\t(OWNERS)"));
+
+        fs::write(temp_dir.path().join("src/OWNERS"), "set noparent\nsrc-owner\n").unwrap();
+        let result = lookup(&temp_dir, "src/tools/ls.rs");
+        assert!(result.contains("src-owner"));
+        assert!(!result.contains("root-owner"));
+    }
+
+    #[test]
+    fn test_not_in_repository() {
+        let temp_dir = TempDir::new().unwrap();
+        assert!(lookup(&temp_dir, ".").contains("is not inside a git repository"));
+    }
+
+    #[test]
+    fn test_validate_blocks_path_traversal() {
+        let req = OwnersRequest {
+            path: "../secret".to_string(),
+        };
+        assert!(matches!(
+            req.validate(),
+            Err(ValidationError::PathTraversal(_))
+        ));
+    }
+
+    #[test]
+    fn test_validate_blocks_shell_injection() {
+        let req = OwnersRequest {
+            path: "src; rm".to_string(),
+        };
+        assert!(matches!(
+            req.validate(),
+            Err(ValidationError::ShellInjection(_))
+        ));
+    }
+}
@@ -0,0 +1,327 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::process::Command;
+
+use crate::executor::run_pipeline;
+use crate::request::ExecutionContext;
+use crate::security::{
+    is_flag_like, validate_argument, validate_no_traversal, validate_path, validate_path_with_working_dir,
+    Validatable, ValidationError,
+};
+
+/// Commands a pipeline stage may run. They only read files and write to stdout, given the options
+/// `writes_files` rejects.
+const ALLOWED_PIPELINE_COMMANDS: &[&str] = &["cat", "cut", "grep", "head", "sort", "tail", "tr", "uniq", "wc"];
+
+/// Most stages a pipeline may have
+const MAX_STAGES: usize = 8;
+
+/// Request parameters for the pipeline tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct PipelineRequest {
+    /// The commands to run, in order; each one reads the output of the one before it
+    pub stages: Vec<PipelineStage>,
+}
+
+/// One command of a pipeline
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct PipelineStage {
+    /// The command to run (cat, cut, grep, head, sort, tail, tr, uniq, wc)
+    pub command: String,
+    /// Arguments to pass to the command
+    #[serde(default)]
+    pub args: Vec<String>,
+}
+
+impl Validatable for PipelineRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if self.stages.is_empty() {
+            return Err(ValidationError::InvalidPipeline("it has no stages".to_string()));
+        }
+        if self.stages.len() > MAX_STAGES {
+            return Err(ValidationError::InvalidPipeline(format!(
+                "it has {} stages; at most {} are allowed",
+                self.stages.len(),
+                MAX_STAGES
+            )));
+        }
+        for stage in &self.stages {
+            stage.validate()?;
+        }
+        Ok(())
+    }
+}
+
+impl PipelineStage {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if !ALLOWED_PIPELINE_COMMANDS.contains(&self.command.as_str()) {
+            return Err(ValidationError::DisallowedCommand {
+                command: self.command.clone(),
+                allowed: ALLOWED_PIPELINE_COMMANDS.join(", "),
+            });
+        }
+        for arg in &self.args {
+            validate_argument(arg)?;
+            if writes_files(&self.command, arg) {
+                return Err(ValidationError::InvalidPipeline(format!(
+                    "{}'s '{}' option writes files",
+                    self.command, arg
+                )));
+            }
+        }
+        if let Some(option) = reads_unchecked_files(&self.command, &self.args) {
+            return Err(ValidationError::InvalidPipeline(format!(
+                "{}'s '{}' option reads files whose paths can't be checked; name each file instead",
+                self.command, option
+            )));
+        }
+        // uniq writes its output to a second operand
+        if self.command == "uniq" && self.args.iter().filter(|arg| !is_flag_like(arg)).count() > 1 {
+            return Err(ValidationError::InvalidPipeline(
+                "uniq's second operand is a file it writes; give option values as -f1 or --skip-fields=1".to_string(),
+            ));
+        }
+        // Block ".." to keep code simple and prevent any attempts to access blocked paths
+        for path in self.paths() {
+            validate_no_traversal(path)?;
+            validate_path(path)?;
+        }
+        Ok(())
+    }
+
+    /// Arguments that could name a file the command reads: its operands and option values, including
+    /// those attached to the option, as in -f/etc/patterns or --file=/etc/patterns. Patterns and counts
+    /// are included too; they are checked like paths, which rejects few of them.
+    fn paths(&self) -> impl Iterator<Item = &str> {
+        self.args.iter().filter_map(|arg| {
+            if !is_flag_like(arg) {
+                return Some(arg.as_str());
+            }
+            match arg.strip_prefix("--") {
+                Some(long) => long.split_once('=').map(|(_, value)| value),
+                None => arg.get(2..).filter(|value| !value.is_empty()),
+            }
+        })
+    }
+}
+
+/// Whether an argument is an option that makes the command write files or run another program:
+/// sort's --output and --compress-program, including abbreviations, and -o in a group of short options
+fn writes_files(command: &str, arg: &str) -> bool {
+    if command != "sort" || !is_flag_like(arg) {
+        return false;
+    }
+    match arg.strip_prefix("--") {
+        Some(long) => {
+            let name = long.split('=').next().unwrap_or_default();
+            ["output", "compress-program"].iter().any(|option| option.starts_with(name))
+        }
+        None => arg.contains('o'),
+    }
+}
+
+/// grep's short options that take a value, which is the rest of a group such as -e<pattern>
+const GREP_SHORT_OPTIONS_WITH_VALUES: &str = "ABCDdefm";
+
+/// The first option that makes the command read files other than those named as arguments, whose
+/// paths therefore aren't checked against the blocked paths: grep's recursive options, which read
+/// every file under a directory (-r, -R, --recursive, --dereference-recursive, -d recurse and
+/// --directories=recurse, including abbreviations), and --files0-from, which reads a list of files
+fn reads_unchecked_files<'a>(command: &str, args: &'a [String]) -> Option<&'a str> {
+    let is_recurse = |value: &str| !value.is_empty() && "recurse".starts_with(value);
+    let mut args = args.iter().map(String::as_str).peekable();
+    while let Some(arg) = args.next() {
+        if !is_flag_like(arg) {
+            continue;
+        }
+        if let Some(long) = arg.strip_prefix("--") {
+            let (name, value) = match long.split_once('=') {
+                Some((name, value)) => (name, Some(value)),
+                None => (long, None),
+            };
+            if name.is_empty() {
+                continue;
+            }
+            if "files0-from".starts_with(name) {
+                return Some(arg);
+            }
+            if command != "grep" {
+                continue;
+            }
+            if "recursive".starts_with(name) || "dereference-recursive".starts_with(name) {
+                return Some(arg);
+            }
+            if "directories".starts_with(name) {
+                let value = value.or_else(|| args.peek().copied()).unwrap_or_default();
+                if is_recurse(value) {
+                    return Some(arg);
+                }
+            }
+        } else if command == "grep" {
+            for (i, option) in arg.char_indices().skip(1) {
+                if option == 'r' || option == 'R' {
+                    return Some(arg);
+                }
+                if GREP_SHORT_OPTIONS_WITH_VALUES.contains(option) {
+                    let value = &arg[i + 1..];
+                    let value = if value.is_empty() { args.peek().copied().unwrap_or_default() } else { value };
+                    if option == 'd' && is_recurse(value) {
+                        return Some(arg);
+                    }
+                    break;
+                }
+            }
+        }
+    }
+    None
+}
+
+/// Execute a pipeline with a validated request and execution context
+pub fn execute(req: &PipelineRequest, ctx: &ExecutionContext) -> String {
+    // Validate that paths combined with working_dir don't access blocked paths
+    if let Some(ref working_dir) = ctx.working_dir {
+        for path in req.stages.iter().flat_map(PipelineStage::paths) {
+            if let Err(e) = validate_path_with_working_dir(path, working_dir) {
+                return format!("Error: {}", e);
+            }
+        }
+    }
+
+    let stages = req
+        .stages
+        .iter()
+        .map(|stage| {
+            let mut cmd = Command::new(&stage.command);
+            cmd.args(&stage.args);
+            cmd
+        })
+        .collect();
+    run_pipeline(stages, ctx).into_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn stage(command: &str, args: &[&str]) -> PipelineStage {
+        PipelineStage {
+            command: command.to_string(),
+            args: args.iter().map(|arg| arg.to_string()).collect(),
+        }
+    }
+
+    #[test]
+    fn test_pipeline_filters_file() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("build.log"), "ok 1\nerr: a\nok 2\nerr: b\nerr: c\n").unwrap();
+        let req = PipelineRequest {
+            stages: vec![stage("cat", &["build.log"]), stage("grep", &["err"]), stage("head", &["-2"])],
+        };
+        assert!(req.validate().is_ok());
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        assert_eq!(execute(&req, &ctx), "err: a\nerr: b\n");
+    }
+
+    #[test]
+    fn test_validate_rejects_disallowed_command() {
+        let req = PipelineRequest {
+            stages: vec![stage("cat", &["build.log"]), stage("sh", &["-c", "rm -rf /"])],
+        };
+        assert_eq!(
+            req.validate(),
+            Err(ValidationError::DisallowedCommand {
+                command: "sh".to_string(),
+                allowed: "cat, cut, grep, head, sort, tail, tr, uniq, wc".to_string(),
+            })
+        );
+    }
+
+    #[test]
+    fn test_validate_rejects_stage_counts() {
+        assert!(matches!(
+            PipelineRequest { stages: vec![] }.validate(),
+            Err(ValidationError::InvalidPipeline(_))
+        ));
+        let req = PipelineRequest {
+            stages: (0..=MAX_STAGES).map(|_| stage("cat", &[])).collect(),
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidPipeline(_))));
+    }
+
+    #[test]
+    fn test_validate_checks_every_stage() {
+        let req = PipelineRequest {
+            stages: vec![stage("cat", &["build.log"]), stage("grep", &["err; rm -rf /"])],
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::ShellInjection(_))));
+        let req = PipelineRequest {
+            stages: vec![stage("cat", &["build.log"]), stage("grep", &["-f", "../patterns"])],
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::PathTraversal(_))));
+    }
+
+    #[test]
+    fn test_validate_rejects_writing_files() {
+        for args in [&["-o", "out"][..], &["-uo", "out"], &["--output=out"], &["--out=out"], &["--compress=gzip"]] {
+            assert!(matches!(stage("sort", args).validate(), Err(ValidationError::InvalidPipeline(_))), "{:?}", args);
+        }
+        assert!(stage("sort", &["-u", "-k2", "--numeric-sort"]).validate().is_ok());
+        assert!(matches!(stage("uniq", &["in", "out"]).validate(), Err(ValidationError::InvalidPipeline(_))));
+        assert!(stage("uniq", &["-c", "--skip-fields=1", "in"]).validate().is_ok());
+    }
+
+    #[test]
+    fn test_validate_rejects_recursive_grep() {
+        // /home/dev itself passes the path check, but reading everything under it would read .ssh
+        crate::security::set_blocked_paths_for_test(&["/home/dev/.ssh".to_string()]);
+        assert!(stage("grep", &["-n", "secret", "/home/dev/notes.txt"]).validate().is_ok());
+        assert!(matches!(
+            stage("cat", &["/home/dev/.ssh/id_ed25519"]).validate(),
+            Err(ValidationError::BlockedPath(_))
+        ));
+        let req = PipelineRequest {
+            stages: vec![stage("grep", &["-r", "secret", "/home/dev"])],
+        };
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidPipeline(_))));
+        for args in [
+            &["-rn", "secret", "/home/dev"][..],
+            &["-nR", "secret", "/home/dev"],
+            &["--recursive", "secret", "/home/dev"],
+            &["--rec", "secret", "/home/dev"],
+            &["--dereference-recursive", "secret", "/home/dev"],
+            &["-d", "recurse", "secret", "/home/dev"],
+            &["-drec", "secret", "/home/dev"],
+            &["--directories=recurse", "secret", "/home/dev"],
+            &["--directories", "recurse", "secret", "/home/dev"],
+        ] {
+            assert!(matches!(stage("grep", args).validate(), Err(ValidationError::InvalidPipeline(_))), "{:?}", args);
+        }
+        // -e takes the rest of the group as its pattern, and skipping directories is allowed
+        assert!(stage("grep", &["-eR", "build.log"]).validate().is_ok());
+        assert!(stage("grep", &["-d", "skip", "-n", "err", "build.log"]).validate().is_ok());
+        // sort's -r reverses the order
+        assert!(stage("sort", &["-r", "build.log"]).validate().is_ok());
+    }
+
+    #[test]
+    fn test_validate_rejects_files0_from() {
+        for command in ["wc", "sort"] {
+            for args in [&["--files0-from=list"][..], &["--files0-from", "list"], &["--files0=list"]] {
+                let result = stage(command, args).validate();
+                assert!(matches!(result, Err(ValidationError::InvalidPipeline(_))), "{} {:?}", command, args);
+            }
+        }
+        assert!(stage("wc", &["-l", "build.log"]).validate().is_ok());
+    }
+
+    #[test]
+    fn test_paths() {
+        let stage = stage("grep", &["-n", "-f/tmp/patterns", "--file=/tmp/more", "--count", "err", "build.log"]);
+        assert_eq!(stage.paths().collect::<Vec<_>>(), vec!["/tmp/patterns", "/tmp/more", "err", "build.log"]);
+    }
+}
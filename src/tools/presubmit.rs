@@ -0,0 +1,321 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::process::Command;
+use std::sync::LazyLock;
+
+use crate::executor::{run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::security::{validate_argument, validate_not_flag, Validatable, ValidationError};
+use crate::template::expand;
+use crate::toolchain::mismatches;
+
+/// A single presubmit stage and the command it runs
+#[derive(Debug, Clone)]
+struct Stage {
+    name: &'static str,
+    /// Command line to run; empty if the stage is not configured
+    argv: Vec<String>,
+    /// Whether the impacted targets are appended to the command
+    takes_targets: bool,
+}
+
+impl Stage {
+    fn from_env(name: &'static str, var: &str, takes_targets: bool) -> Self {
+        let argv = std::env::var(var)
+            .unwrap_or_default()
+            .split_whitespace()
+            .map(String::from)
+            .collect();
+        Self { name, argv, takes_targets }
+    }
+}
+
+/// Presubmit stages loaded from PRESUBMIT_*_CMD environment variables at startup.
+/// Each value is a whitespace-separated command line, e.g., PRESUBMIT_LINT_CMD="cargo clippy".
+/// Stages run in this order; unconfigured stages are skipped.
+static STAGES: LazyLock<Vec<Stage>> = LazyLock::new(|| {
+    vec![
+        Stage::from_env("format", "PRESUBMIT_FORMAT_CMD", false),
+        Stage::from_env("lint", "PRESUBMIT_LINT_CMD", false),
+        Stage::from_env("build", "PRESUBMIT_BUILD_CMD", true),
+        Stage::from_env("test", "PRESUBMIT_TEST_CMD", true),
+    ]
+});
+
+/// What to do with the remaining stages once a stage fails
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, schemars::JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum FailurePolicy {
+    /// Skip the remaining stages
+    #[default]
+    Stop,
+    /// Run the remaining stages anyway
+    Continue,
+}
+
+/// Request parameters for the presubmit tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct PresubmitRequest {
+    /// Impacted targets to build and test. Appended to the build and test stage commands.
+    #[serde(default)]
+    pub targets: Vec<String>,
+    /// What to do when a stage fails: "stop" (default) or "continue"
+    #[serde(default)]
+    pub on_failure: FailurePolicy,
+}
+
+impl Validatable for PresubmitRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        for target in &self.targets {
+            validate_argument(target)?;
+            validate_not_flag(target)?;
+        }
+        Ok(())
+    }
+}
+
+/// Run the configured presubmit stages with a validated request and execution context
+pub fn execute(req: &PresubmitRequest, ctx: &ExecutionContext) -> String {
+    let report = run_stages(req, ctx, &STAGES);
+    add_warnings(report, &mismatches(ctx))
+}
+
+/// Insert warnings after the verdict and stage summary, ahead of the stage output
+fn add_warnings(report: String, warnings: &[String]) -> String {
+    if warnings.is_empty() {
+        return report;
+    }
+    match report.split_once("\n\n") {
+        Some((summary, details)) => format!("{}\n{}\n\n{}", summary, warnings.join("\n"), details),
+        None => format!("{}\n{}", report, warnings.join("\n")),
+    }
+}
+
+/// Programs run by the configured stages, as (stage name, program) pairs.
+/// Programs named with placeholders are only known per call and are left out.
+pub fn configured_programs() -> Vec<(&'static str, String)> {
+    STAGES
+        .iter()
+        .filter_map(|stage| stage.argv.first().map(|program| (stage.name, program.clone())))
+        .filter(|(_, program)| !program.contains("${"))
+        .collect()
+}
+
+/// Internal implementation for testability - takes stages as parameter.
+fn run_stages(req: &PresubmitRequest, ctx: &ExecutionContext, stages: &[Stage]) -> String {
+    if stages.iter().all(|stage| stage.argv.is_empty()) {
+        return "Error: No presubmit stages are configured. Set PRESUBMIT_FORMAT_CMD, PRESUBMIT_LINT_CMD, PRESUBMIT_BUILD_CMD, or PRESUBMIT_TEST_CMD.".to_string();
+    }
+
+    let mut summary = Vec::new();
+    let mut details = Vec::new();
+    let mut failed = false;
+
+    for stage in stages {
+        if stage.argv.is_empty() {
+            summary.push(format!("{}: SKIPPED (not configured)", stage.name));
+            continue;
+        }
+        if failed && req.on_failure == FailurePolicy::Stop {
+            summary.push(format!("{}: SKIPPED (earlier stage failed)", stage.name));
+            continue;
+        }
+
+        // Placeholders in the configured command are resolved for this call
+        let argv: Vec<String> = stage.argv.iter().map(|arg| expand(arg, ctx)).collect();
+        let mut cmd = Command::new(&argv[0]);
+        cmd.args(&argv[1..]);
+        if stage.takes_targets {
+            cmd.args(&req.targets);
+        }
+
+        let (status, output) = match run_command(cmd, ctx) {
+            ExecutionResult::Success(output) => ("PASS", output),
+            result => {
+                failed = true;
+                ("FAIL", result.into_string())
+            }
+        };
+        summary.push(format!("{}: {}", stage.name, status));
+        if !output.trim().is_empty() {
+            details.push(format!("--- {} output ---\n{}", stage.name, output.trim_end()));
+        }
+    }
+
+    let verdict = if failed { "FAIL" } else { "PASS" };
+    let mut report = format!("Verdict: {}\n{}", verdict, summary.join("\n"));
+    for detail in details {
+        report.push_str("\n\n");
+        report.push_str(&detail);
+    }
+    report
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::executor::FakeExecutor;
+    use std::sync::Arc;
+
+    fn stage(name: &'static str, argv: &[&str], takes_targets: bool) -> Stage {
+        Stage {
+            name,
+            argv: argv.iter().map(|s| s.to_string()).collect(),
+            takes_targets,
+        }
+    }
+
+    fn make_request(targets: &[&str], on_failure: FailurePolicy) -> PresubmitRequest {
+        PresubmitRequest {
+            targets: targets.iter().map(|s| s.to_string()).collect(),
+            on_failure,
+        }
+    }
+
+    #[test]
+    fn test_all_stages_pass() {
+        let stages = vec![
+            stage("format", &["true"], false),
+            stage("lint", &["true"], false),
+            stage("build", &["true"], true),
+            stage("test", &["true"], true),
+        ];
+        let req = make_request(&[], FailurePolicy::Stop);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.starts_with("Verdict: PASS"));
+        assert!(result.contains("format: PASS"));
+        assert!(result.contains("test: PASS"));
+    }
+
+    #[test]
+    fn test_stage_commands_run_through_executor() {
+        let fake = Arc::new(FakeExecutor {
+            failing: vec!["bazel".to_string()],
+            ..Default::default()
+        });
+        let ctx = ExecutionContext {
+            executor: Some(fake.clone()),
+            ..Default::default()
+        };
+        let stages = vec![
+            stage("lint", &["cargo", "clippy"], false),
+            stage("build", &["bazel", "build"], true),
+            stage("test", &["bazel", "test"], true),
+        ];
+        let req = make_request(&["//src:lib"], FailurePolicy::Stop);
+        let result = run_stages(&req, &ctx, &stages);
+        assert!(result.starts_with("Verdict: FAIL\nlint: PASS\nbuild: FAIL\ntest: SKIPPED"), "{}", result);
+        assert_eq!(fake.commands(), vec!["cargo clippy", "bazel build //src:lib"]);
+    }
+
+    #[test]
+    fn test_failure_stops_remaining_stages() {
+        let stages = vec![
+            stage("format", &["true"], false),
+            stage("lint", &["false"], false),
+            stage("build", &["true"], true),
+        ];
+        let req = make_request(&[], FailurePolicy::Stop);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.starts_with("Verdict: FAIL"));
+        assert!(result.contains("lint: FAIL"));
+        assert!(result.contains("build: SKIPPED (earlier stage failed)"));
+    }
+
+    #[test]
+    fn test_failure_continue_runs_remaining_stages() {
+        let stages = vec![
+            stage("lint", &["false"], false),
+            stage("build", &["true"], true),
+        ];
+        let req = make_request(&[], FailurePolicy::Continue);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.starts_with("Verdict: FAIL"));
+        assert!(result.contains("build: PASS"));
+    }
+
+    #[test]
+    fn test_targets_appended_to_build_stage() {
+        let stages = vec![
+            stage("format", &["echo", "format"], false),
+            stage("build", &["echo", "building"], true),
+        ];
+        let req = make_request(&["//src:lib", "//src:bin"], FailurePolicy::Stop);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.contains("building //src:lib //src:bin"));
+        assert!(!result.contains("format //src:lib"));
+    }
+
+    #[test]
+    fn test_stage_placeholders_are_expanded() {
+        let stages = vec![stage("build", &["echo", "--output_base=${WORKSPACE}/out"], true)];
+        let ctx = ExecutionContext {
+            working_dir: Some("/tmp".to_string()),
+            ..Default::default()
+        };
+        let result = run_stages(&make_request(&[], FailurePolicy::Stop), &ctx, &stages);
+        assert!(result.contains("--output_base=/tmp/out"), "{}", result);
+    }
+
+    #[test]
+    fn test_unconfigured_stages_are_skipped() {
+        let stages = vec![
+            stage("format", &[], false),
+            stage("lint", &["true"], false),
+        ];
+        let req = make_request(&[], FailurePolicy::Stop);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.starts_with("Verdict: PASS"));
+        assert!(result.contains("format: SKIPPED (not configured)"));
+    }
+
+    #[test]
+    fn test_no_stages_configured() {
+        let stages = vec![stage("format", &[], false)];
+        let req = make_request(&[], FailurePolicy::Stop);
+        let result = run_stages(&req, &ExecutionContext::default(), &stages);
+        assert!(result.starts_with("Error: No presubmit stages are configured"));
+    }
+
+    #[test]
+    fn test_add_warnings() {
+        let warnings = vec!["Warning: toolchain mismatch: bazel 6.5.0 found, 7.x expected".to_string()];
+        assert_eq!(
+            add_warnings("Verdict: PASS\nbuild: PASS\n\n--- build output ---\nok".to_string(), &warnings),
+            "Verdict: PASS\nbuild: PASS\nWarning: toolchain mismatch: bazel 6.5.0 found, 7.x expected\n\n--- build output ---\nok"
+        );
+        assert_eq!(
+            add_warnings("Verdict: PASS\nbuild: PASS".to_string(), &warnings),
+            "Verdict: PASS\nbuild: PASS\nWarning: toolchain mismatch: bazel 6.5.0 found, 7.x expected"
+        );
+        assert_eq!(add_warnings("Verdict: PASS".to_string(), &[]), "Verdict: PASS");
+    }
+
+    #[test]
+    fn test_deserialize_on_failure() {
+        let req: PresubmitRequest =
+            serde_json::from_str(r#"{"targets": ["//..."], "on_failure": "continue"}"#).unwrap();
+        assert_eq!(req.on_failure, FailurePolicy::Continue);
+        let req: PresubmitRequest = serde_json::from_str("{}").unwrap();
+        assert_eq!(req.on_failure, FailurePolicy::Stop);
+        assert!(req.targets.is_empty());
+    }
+
+    #[test]
+    fn test_validate_rejects_flag_target() {
+        let req = make_request(&["--config=evil"], FailurePolicy::Stop);
+        assert!(matches!(
+            req.validate(),
+            Err(ValidationError::FlagInjection(_))
+        ));
+    }
+
+    #[test]
+    fn test_validate_rejects_shell_injection_in_target() {
+        let req = make_request(&["//src:lib; rm"], FailurePolicy::Stop);
+        assert!(matches!(
+            req.validate(),
+            Err(ValidationError::ShellInjection(_))
+        ));
+    }
+}
@@ -0,0 +1,93 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::executor::{running_commands, RunningCommand};
+use crate::notify::format_elapsed;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+use crate::transcript::{format_utc, session_id};
+
+/// Request parameters for the ps_jobs tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct PsJobsRequest {
+    /// Only list commands run by this tool, e.g., "presubmit"
+    pub tool: Option<String>,
+}
+
+impl Validatable for PsJobsRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// List the commands still running with a validated request and execution context
+pub fn execute(req: &PsJobsRequest, _ctx: &ExecutionContext) -> String {
+    let mut commands = running_commands();
+    if let Some(ref tool) = req.tool {
+        commands.retain(|command| command.tool == *tool);
+    }
+    if commands.is_empty() {
+        return format!("Session {}: no commands running", session_id());
+    }
+    let mut lines = vec![format!(
+        "Session {}: {} command{} running",
+        session_id(),
+        commands.len(),
+        if commands.len() == 1 { "" } else { "s" }
+    )];
+    lines.extend(commands.iter().map(|command| describe(command)));
+    lines.join("\n")
+}
+
+/// One line about a running command, e.g.,
+/// "#4 presubmit (job 2): bazel test //... - started 2026-10-15T13:45:00Z, running 2m 5s, 1204 lines"
+fn describe(command: &RunningCommand) -> String {
+    let job = command.job.map(|id| format!(" (job {})", id)).unwrap_or_default();
+    format!(
+        "#{} {}{}: {} - started {}, running {}, {} line{}",
+        command.id,
+        command.tool,
+        job,
+        command.command,
+        format_utc(command.started),
+        format_elapsed(command.elapsed()),
+        command.lines(),
+        if command.lines() == 1 { "" } else { "s" }
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::executor::run_command;
+    use std::process::Command;
+    use std::time::Duration;
+
+    #[cfg(unix)]
+    #[test]
+    fn test_lists_running_command() {
+        let ctx = ExecutionContext {
+            timeout: Some(Duration::from_secs(5)),
+            tool: Some("test_lists_running_command".to_string()),
+            ..Default::default()
+        };
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo one; echo two; sleep 1"]);
+        let running = std::thread::spawn(move || run_command(cmd, &ctx));
+        let req = PsJobsRequest {
+            tool: Some("test_lists_running_command".to_string()),
+        };
+        let mut listing = String::new();
+        for _ in 0..50 {
+            listing = execute(&req, &ExecutionContext::default());
+            if listing.contains("2 lines") {
+                break;
+            }
+            std::thread::sleep(Duration::from_millis(10));
+        }
+        assert!(listing.contains("1 command running"), "{}", listing);
+        assert!(listing.contains("test_lists_running_command: sh -c 'echo one; echo two; sleep 1' - started "));
+        running.join().unwrap();
+        assert!(execute(&req, &ExecutionContext::default()).ends_with("no commands running"));
+    }
+}
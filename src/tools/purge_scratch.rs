@@ -0,0 +1,70 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::time::Duration;
+
+use crate::request::ExecutionContext;
+use crate::retention::{purge, CleanupReport};
+use crate::scratch::scratch_dir;
+use crate::security::{Validatable, ValidationError};
+use crate::tools::host_info::format_bytes;
+
+/// Request parameters for the purge_scratch tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct PurgeScratchRequest {
+    /// Only remove items unused for longer than this many seconds. Removes everything if not provided.
+    #[serde(default)]
+    pub older_than_secs: Option<u64>,
+}
+
+impl Validatable for PurgeScratchRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Purge the scratch area with a validated request and execution context
+pub fn execute(req: &PurgeScratchRequest, ctx: &ExecutionContext) -> String {
+    if ctx.dry_run {
+        let items = match req.older_than_secs {
+            Some(secs) => format!("items unused for {}s", secs),
+            None => "every item".to_string(),
+        };
+        return format!("Dry run, not executed: would remove {} from {}", items, scratch_dir().display());
+    }
+    let report = purge(req.older_than_secs.map(Duration::from_secs));
+    format_report(&report, &scratch_dir().display().to_string())
+}
+
+fn format_report(report: &CleanupReport, root: &str) -> String {
+    format!(
+        "Removed {} items from {}, freeing {}",
+        report.removed,
+        root,
+        format_bytes(report.freed)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_report() {
+        let report = CleanupReport {
+            removed: 3,
+            freed: 3 * 1024 * 1024,
+        };
+        assert_eq!(
+            format_report(&report, "/tmp/scratch"),
+            "Removed 3 items from /tmp/scratch, freeing 3.0 MiB"
+        );
+    }
+
+    #[test]
+    fn test_deserialize_optional_age() {
+        let req: PurgeScratchRequest = serde_json::from_str("{}").unwrap();
+        assert_eq!(req.older_than_secs, None);
+        let req: PurgeScratchRequest = serde_json::from_str(r#"{"older_than_secs": 86400}"#).unwrap();
+        assert_eq!(req.older_than_secs, Some(86400));
+    }
+}
@@ -0,0 +1,275 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+use crate::executor::{run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::scratch::{scratch_dir, scratch_subdir_in};
+use crate::security::{validate_argument, validate_not_flag, Validatable, ValidationError};
+use crate::tools::download::sha256_file;
+use crate::transcript::session_id;
+
+/// Most targets per request
+const MAX_TARGETS: usize = 50;
+
+/// Flags keeping both builds from taking outputs from a cache, which could hide or cause a difference
+const NO_CACHE_FLAGS: &[&str] = &["--noremote_accept_cached", "--disk_cache="];
+
+/// Checks run so far, to give each one its own output bases
+static CHECKS: AtomicUsize = AtomicUsize::new(0);
+
+/// Request parameters for the repro_check tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct ReproCheckRequest {
+    /// Targets to build, e.g., ["//src:server"]
+    pub targets: Vec<String>,
+    /// Run each build in a new output base under the scratch area, instead of cleaning the workspace's
+    /// output base between them (default: false). Slower, as external repositories are set up twice,
+    /// but the workspace's outputs are left as they are.
+    #[serde(default)]
+    pub clean_output_bases: Option<bool>,
+}
+
+impl Validatable for ReproCheckRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        for target in &self.targets {
+            validate_argument(target)?;
+            validate_not_flag(target)?;
+        }
+        Ok(())
+    }
+}
+
+/// Where each build runs: the workspace's output base, cleaned before the second build, or a new one per build
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum OutputBases {
+    Cleaned,
+    Fresh([PathBuf; 2]),
+}
+
+/// Build the targets twice and compare their outputs with a validated request and execution context
+pub fn execute(req: &ReproCheckRequest, ctx: &ExecutionContext) -> String {
+    if req.targets.is_empty() || req.targets.len() > MAX_TARGETS {
+        return format!("Error: Between 1 and {} targets are allowed, got {}", MAX_TARGETS, req.targets.len());
+    }
+    let bases = if req.clean_output_bases.unwrap_or(false) {
+        let check = CHECKS.fetch_add(1, Ordering::Relaxed) + 1;
+        let dir = match scratch_subdir_in(scratch_dir(), &format!("repro/{}-{}", session_id(), check)) {
+            Ok(dir) => dir,
+            Err(e) => return format!("Error: Failed to create output bases: {}", e),
+        };
+        OutputBases::Fresh([dir.join("1"), dir.join("2")])
+    } else {
+        OutputBases::Cleaned
+    };
+    let result = check(req, ctx, &bases);
+    if let OutputBases::Fresh(ref dirs) = bases {
+        for dir in dirs {
+            remove_output_base(ctx, dir);
+        }
+        if let Some(parent) = dirs[0].parent() {
+            let _ = fs::remove_dir(parent);
+        }
+    }
+    result
+}
+
+/// Run both builds, hashing each one's outputs before the next starts, and report how they compare
+fn check(req: &ReproCheckRequest, ctx: &ExecutionContext, bases: &OutputBases) -> String {
+    let mut hashes = Vec::new();
+    for build in 0..2 {
+        let base = match bases {
+            OutputBases::Fresh(dirs) => Some(dirs[build].as_path()),
+            OutputBases::Cleaned => None,
+        };
+        if build == 1 && base.is_none() {
+            if let Err(e) = run(bazel(None, "clean", &[]), ctx) {
+                return format!("Error: bazel clean before the second build failed:\n{}", e);
+            }
+        }
+        let mut args: Vec<&str> = NO_CACHE_FLAGS.to_vec();
+        args.extend(req.targets.iter().map(String::as_str));
+        let built = run(bazel(base, "build", &args), ctx);
+        if ctx.dry_run {
+            let between = match bases {
+                OutputBases::Cleaned => "runs bazel clean",
+                OutputBases::Fresh(_) => "switches to a second new output base",
+            };
+            return format!(
+                "{}\nThen hashes the targets' outputs, {}, builds them again and compares the hashes",
+                built.unwrap_or_else(|e| e),
+                between
+            );
+        }
+        if let Err(e) = built {
+            return format!("Error: Build {} of 2 failed:\n{}", build + 1, e);
+        }
+        match outputs(ctx, base, &req.targets).and_then(|(root, files)| hash_outputs(&root, &files)) {
+            Ok(outputs) => hashes.push(outputs),
+            Err(e) => return format!("Error: Failed to hash the outputs of build {} of 2: {}", build + 1, e),
+        }
+    }
+    let how = match bases {
+        OutputBases::Cleaned => "the output base was cleaned between them",
+        OutputBases::Fresh(_) => "each ran in a new output base",
+    };
+    render(&hashes[0], &hashes[1], how)
+}
+
+/// A bazel command, run in `output_base` if given
+fn bazel(output_base: Option<&Path>, command: &str, args: &[&str]) -> Command {
+    let mut cmd = Command::new("bazel");
+    if let Some(base) = output_base {
+        cmd.arg(format!("--output_base={}", base.display()));
+    }
+    cmd.arg(command).args(args);
+    cmd
+}
+
+/// Run a command, returning its output, or its result as the error if it failed
+fn run(cmd: Command, ctx: &ExecutionContext) -> Result<String, String> {
+    match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) => Ok(output),
+        result => Err(result.into_string()),
+    }
+}
+
+/// The execution root and the targets' output files under it, as bazel cquery lists them
+fn outputs(ctx: &ExecutionContext, base: Option<&Path>, targets: &[String]) -> Result<(PathBuf, Vec<String>), String> {
+    let root = run(bazel(base, "info", &["execution_root"]), ctx)?;
+    let mut args = vec!["--output=files"];
+    args.extend(NO_CACHE_FLAGS);
+    args.extend(targets.iter().map(String::as_str));
+    let files = run(bazel(base, "cquery", &args), ctx)?;
+    let files = files.lines().map(str::trim).filter(|line| !line.is_empty()).map(str::to_string).collect();
+    Ok((PathBuf::from(root.trim()), files))
+}
+
+/// SHA-256 of each output file by its path under the execution root. Directories, such as tree
+/// artifacts, are walked, with each file in them hashed on its own.
+fn hash_outputs(root: &Path, files: &[String]) -> Result<BTreeMap<String, String>, String> {
+    let mut hashes = BTreeMap::new();
+    for file in files {
+        hash_path(&root.join(file), file, &mut hashes).map_err(|e| format!("{}: {}", file, e))?;
+    }
+    Ok(hashes)
+}
+
+fn hash_path(path: &Path, relative: &str, hashes: &mut BTreeMap<String, String>) -> std::io::Result<()> {
+    if path.is_dir() {
+        for entry in fs::read_dir(path)? {
+            let entry = entry?;
+            let name = entry.file_name().to_string_lossy().to_string();
+            hash_path(&entry.path(), &format!("{}/{}", relative, name), hashes)?;
+        }
+    } else {
+        hashes.insert(relative.to_string(), sha256_file(path)?);
+    }
+    Ok(())
+}
+
+/// A verdict, then each output that differs between the builds or came out of only one of them
+fn render(first: &BTreeMap<String, String>, second: &BTreeMap<String, String>, how: &str) -> String {
+    let mut differences = Vec::new();
+    for (path, hash) in first {
+        match second.get(path) {
+            Some(other) if other != hash => {
+                differences.push(format!("DIFFERS {} ({} != {})", path, &hash[..12], &other[..12]));
+            }
+            Some(_) => {}
+            None => differences.push(format!("ONLY IN BUILD 1 {}", path)),
+        }
+    }
+    differences.extend(
+        second
+            .keys()
+            .filter(|path| !first.contains_key(*path))
+            .map(|path| format!("ONLY IN BUILD 2 {}", path)),
+    );
+    let outputs = first.len().max(second.len());
+    let mut lines = vec![
+        format!(
+            "Verdict: {}",
+            if differences.is_empty() { "REPRODUCIBLE" } else { "NOT REPRODUCIBLE" }
+        ),
+        format!(
+            "{} of {} output{} differ between two builds; {}, and neither took outputs from a cache",
+            differences.len(),
+            outputs,
+            if outputs == 1 { "" } else { "s" },
+            how
+        ),
+    ];
+    lines.extend(differences);
+    lines.join("\n")
+}
+
+/// Stop the server of an output base made for a check and remove it. bazel makes its outputs read-only,
+/// so it is asked to remove them first.
+fn remove_output_base(ctx: &ExecutionContext, base: &Path) {
+    if !base.exists() {
+        return;
+    }
+    let _ = run(bazel(Some(base), "clean", &["--expunge"]), ctx);
+    let _ = run(bazel(Some(base), "shutdown", &[]), ctx);
+    if let Err(e) = fs::remove_dir_all(base) {
+        tracing::warn!("Failed to remove output base {}: {}", base.display(), e);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn hashes(entries: &[(&str, &str)]) -> BTreeMap<String, String> {
+        entries.iter().map(|(path, hash)| (path.to_string(), hash.repeat(16))).collect()
+    }
+
+    #[test]
+    fn test_validate() {
+        let req = |targets: &[&str]| ReproCheckRequest {
+            targets: targets.iter().map(|s| s.to_string()).collect(),
+            clean_output_bases: None,
+        };
+        assert!(req(&["//src:server", "//lib/..."]).validate().is_ok());
+        assert!(req(&["--config=ci"]).validate().is_err());
+        assert!(req(&["//src:server; rm -rf /"]).validate().is_err());
+        assert!(execute(&req(&[]), &ExecutionContext::default()).starts_with("Error: Between 1 and 50 targets"));
+    }
+
+    #[test]
+    fn test_render() {
+        let first = hashes(&[("bin/a", "aa"), ("bin/b", "bb"), ("bin/old", "cc")]);
+        let second = hashes(&[("bin/a", "aa"), ("bin/b", "b0"), ("bin/new", "dd")]);
+        let output = render(&first, &second, "each ran in a new output base");
+        assert_eq!(
+            output,
+            "Verdict: NOT REPRODUCIBLE\n\
+             3 of 3 outputs differ between two builds; each ran in a new output base, and neither took outputs \
+             from a cache\n\
+             DIFFERS bin/b (bbbbbbbbbbbb != b0b0b0b0b0b0)\n\
+             ONLY IN BUILD 1 bin/old\n\
+             ONLY IN BUILD 2 bin/new"
+        );
+        assert!(render(&first, &first, "cleaned").starts_with("Verdict: REPRODUCIBLE\n0 of 3 outputs"));
+    }
+
+    #[test]
+    fn test_hash_outputs_walks_tree_artifacts() {
+        let root = TempDir::new().unwrap();
+        fs::create_dir_all(root.path().join("bin/docs")).unwrap();
+        fs::write(root.path().join("bin/server"), "binary").unwrap();
+        fs::write(root.path().join("bin/docs/index.html"), "<html>").unwrap();
+        let files = ["bin/server".to_string(), "bin/docs".to_string()];
+        let hashes = hash_outputs(root.path(), &files).unwrap();
+        let paths: Vec<&str> = hashes.keys().map(String::as_str).collect();
+        assert_eq!(paths, ["bin/docs/index.html", "bin/server"]);
+        assert_eq!(hashes["bin/server"], sha256_file(&root.path().join("bin/server")).unwrap());
+        assert!(hash_outputs(root.path(), &["bin/missing".to_string()]).unwrap_err().starts_with("bin/missing: "));
+    }
+}
@@ -0,0 +1,100 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+
+use crate::diff::unified_diff;
+use crate::history::{self, MAX_HISTORY_ENTRIES};
+use crate::security::{Validatable, ValidationError};
+
+/// Request parameters for the rerun tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct RerunRequest {
+    /// Call number of the earlier execution, as shown in the session transcript
+    pub call: u64,
+    /// Append a unified diff of the new output against the original output
+    #[serde(default)]
+    pub diff: bool,
+}
+
+impl Validatable for RerunRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Re-run a recorded call with its original request. `dispatch` runs a tool by name
+/// under the current policy, returning None if the tool cannot be re-run.
+pub fn execute(req: &RerunRequest, dispatch: impl FnOnce(&str, Value) -> Option<String>) -> String {
+    let original = match history::get(req.call) {
+        Some(original) => original,
+        None => {
+            return format!(
+                "Error: Call {} is not in the execution history (only the last {} calls are kept)",
+                req.call, MAX_HISTORY_ENTRIES
+            )
+        }
+    };
+    let output = match dispatch(&original.tool, original.input) {
+        Some(output) => output,
+        None => return format!("Error: Call {} ({}) cannot be re-run", req.call, original.tool),
+    };
+
+    let mut result = format!("Rerun of call {} ({}):\n{}", req.call, original.tool, output);
+    if req.diff {
+        let old_label = format!("call {}", req.call);
+        let diff = match unified_diff(&original.output, &output, &old_label, "rerun") {
+            Some(diff) if diff.is_empty() => "Output unchanged".to_string(),
+            Some(diff) => diff,
+            None => "Outputs differ in too many lines to diff".to_string(),
+        };
+        result.push_str(&format!("\n\n--- Diff against call {} ---\n{}", req.call, diff));
+    }
+    result
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_rerun_dispatches_recorded_request() {
+        history::record(2_000_001, "git", json!({"subcommand": "status"}), "clean");
+        let req = RerunRequest { call: 2_000_001, diff: false };
+        let result = execute(&req, |tool, input| {
+            assert_eq!(tool, "git");
+            assert_eq!(input["subcommand"], "status");
+            Some("clean".to_string())
+        });
+        assert_eq!(result, "Rerun of call 2000001 (git):\nclean");
+    }
+
+    #[test]
+    fn test_rerun_with_diff() {
+        history::record(2_000_002, "ls_tool", json!({}), "a\nb\n");
+        let req = RerunRequest { call: 2_000_002, diff: true };
+        let result = execute(&req, |_, _| Some("a\nc\n".to_string()));
+        assert!(result.contains("--- Diff against call 2000002 ---\n--- call 2000002\n+++ rerun\n"));
+        assert!(result.contains("-b\n+c\n"));
+
+        let result = execute(&req, |_, _| Some("a\nb\n".to_string()));
+        assert!(result.ends_with("Output unchanged"));
+    }
+
+    #[test]
+    fn test_rerun_unknown_call() {
+        let req = RerunRequest { call: 2_000_999, diff: false };
+        let result = execute(&req, |_, _| panic!("must not dispatch"));
+        assert!(result.starts_with("Error: Call 2000999 is not in the execution history"));
+    }
+
+    #[test]
+    fn test_rerun_refused_by_dispatch() {
+        history::record(2_000_003, "download", json!({}), "");
+        let req = RerunRequest { call: 2_000_003, diff: false };
+        assert_eq!(
+            execute(&req, |_, _| None),
+            "Error: Call 2000003 (download) cannot be re-run"
+        );
+    }
+}
@@ -0,0 +1,447 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::collections::BTreeSet;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::executor::{find_executable, run_command, ExecutionResult};
+use crate::request::ExecutionContext;
+use crate::scratch::{scratch_dir, scratch_subdir_in};
+use crate::security::{
+    validate_no_traversal, validate_not_flag, validate_path, validate_path_with_working_dir, Validatable,
+    ValidationError,
+};
+use crate::transcript::{format_utc, session_id};
+
+/// SBOMs generated so far, to give each one its own document namespace and syft output file
+static DOCUMENTS: AtomicUsize = AtomicUsize::new(0);
+
+/// SBOM document format
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, schemars::JsonSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum SbomFormat {
+    /// SPDX 2.3 JSON
+    #[default]
+    Spdx,
+    /// CycloneDX 1.5 JSON
+    Cyclonedx,
+}
+
+/// Request parameters for the sbom tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct SbomRequest {
+    /// Directory or built artifact to describe, relative to working_dir if not absolute (default: working_dir)
+    #[serde(default)]
+    pub path: Option<String>,
+    /// "spdx" (default) or "cyclonedx"
+    #[serde(default)]
+    pub format: SbomFormat,
+}
+
+impl Validatable for SbomRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if let Some(ref path) = self.path {
+            validate_not_flag(path)?;
+            validate_no_traversal(path)?;
+            validate_path(path)?;
+        }
+        Ok(())
+    }
+}
+
+/// A dependency found without syft
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord)]
+struct Package {
+    name: String,
+    version: String,
+    /// Package URL, e.g., "pkg:golang/golang.org/x/sys@v0.20.0"
+    purl: String,
+}
+
+impl Package {
+    fn go(name: &str, version: &str) -> Self {
+        Package {
+            name: name.to_string(),
+            version: version.to_string(),
+            purl: format!("pkg:golang/{}@{}", name, version),
+        }
+    }
+
+    fn bazel(name: &str, version: &str) -> Self {
+        Package {
+            name: name.to_string(),
+            version: version.to_string(),
+            purl: format!("pkg:generic/{}@{}", name, version),
+        }
+    }
+}
+
+/// What a native SBOM describes: the workspace's module or the artifact, and what it depends on
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+struct Inventory {
+    name: String,
+    version: Option<String>,
+    packages: BTreeSet<Package>,
+    /// Files the packages were read from, e.g., "go.mod"
+    sources: Vec<String>,
+}
+
+/// Generate an SBOM with a validated request and execution context. The result is the SBOM document as
+/// JSON, which the server returns as structured content described by `output_schema`.
+pub fn execute(req: &SbomRequest, ctx: &ExecutionContext) -> String {
+    if let (Some(path), Some(working_dir)) = (&req.path, &ctx.working_dir) {
+        if let Err(e) = validate_path_with_working_dir(path, working_dir) {
+            return e.to_string();
+        }
+    }
+    let base = ctx.working_dir.as_ref().map(PathBuf::from);
+    let target = match (&req.path, base) {
+        (Some(path), Some(base)) => base.join(path),
+        (Some(path), None) => PathBuf::from(path),
+        (None, Some(base)) => base,
+        (None, None) => std::env::current_dir().unwrap_or_default(),
+    };
+    if !target.exists() {
+        return format!("Error: '{}' does not exist", target.display());
+    }
+    let document = DOCUMENTS.fetch_add(1, Ordering::Relaxed) + 1;
+    if find_executable("syft").is_some() {
+        return syft(ctx, &target, req.format, document);
+    }
+    if ctx.dry_run {
+        let how = if target.is_dir() { "its go.mod and MODULE.bazel.lock" } else { "`go version -m`" };
+        return format!("Dry run: syft is not installed; would read {} with {}", target.display(), how);
+    }
+    let inventory = if target.is_dir() { read_workspace(&target) } else { read_artifact(ctx, &target) };
+    match inventory {
+        Some(inventory) => {
+            let now = SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_secs());
+            let sbom = match req.format {
+                SbomFormat::Spdx => spdx(&inventory, now, document),
+                SbomFormat::Cyclonedx => cyclonedx(&inventory, now),
+            };
+            serde_json::to_string_pretty(&sbom).unwrap_or_default()
+        }
+        None if target.is_dir() => format!(
+            "Error: syft is not installed, and {} has no go.mod or MODULE.bazel.lock to read",
+            target.display()
+        ),
+        None => format!(
+            "Error: syft is not installed, and {} is not a Go binary `go version -m` can read",
+            target.display()
+        ),
+    }
+}
+
+/// Generate the SBOM with syft, which writes it to a file so the output cap can't cut the document
+fn syft(ctx: &ExecutionContext, target: &Path, format: SbomFormat, document: usize) -> String {
+    let dir = match scratch_subdir_in(scratch_dir(), "sbom") {
+        Ok(dir) => dir,
+        Err(e) => return format!("Error: Failed to create the SBOM directory: {}", e),
+    };
+    let file = dir.join(format!("{}-{}.json", session_id(), document));
+    let output = match format {
+        SbomFormat::Spdx => "spdx-json",
+        SbomFormat::Cyclonedx => "cyclonedx-json",
+    };
+    let mut cmd = Command::new("syft");
+    cmd.arg(target).arg("-o").arg(format!("{}={}", output, file.display()));
+    let result = match run_command(cmd, ctx) {
+        ExecutionResult::Success(output) if ctx.dry_run => output,
+        ExecutionResult::Success(_) => match fs::read_to_string(&file) {
+            Ok(sbom) => sbom,
+            Err(e) => format!("Error: Failed to read the SBOM syft wrote: {}", e),
+        },
+        result => format!("Error: syft failed:\n{}", result.into_string()),
+    };
+    let _ = fs::remove_file(&file);
+    result
+}
+
+/// The dependencies a workspace declares in go.mod and MODULE.bazel.lock; None if it has neither
+fn read_workspace(dir: &Path) -> Option<Inventory> {
+    let mut inventory = Inventory {
+        name: dir.file_name().map_or_else(|| dir.display().to_string(), |name| name.to_string_lossy().to_string()),
+        ..Default::default()
+    };
+    if let Ok(go_mod) = fs::read_to_string(dir.join("go.mod")) {
+        let (module, packages) = parse_go_mod(&go_mod);
+        if let Some(module) = module {
+            inventory.name = module;
+        }
+        inventory.packages.extend(packages);
+        inventory.sources.push("go.mod".to_string());
+    }
+    if let Ok(lock) = fs::read_to_string(dir.join("MODULE.bazel.lock")) {
+        inventory.packages.extend(parse_bazel_lock(&lock));
+        inventory.sources.push("MODULE.bazel.lock".to_string());
+    }
+    (!inventory.sources.is_empty()).then_some(inventory)
+}
+
+/// The module and dependencies built into a Go binary; None if it isn't one or go isn't installed
+fn read_artifact(ctx: &ExecutionContext, path: &Path) -> Option<Inventory> {
+    find_executable("go")?;
+    let mut cmd = Command::new("go");
+    cmd.args(["version", "-m"]).arg(path);
+    let ExecutionResult::Success(output) = run_command(cmd, ctx) else {
+        return None;
+    };
+    let mut inventory = parse_go_version(&output)?;
+    if inventory.name.is_empty() {
+        inventory.name = path.file_name().map(|name| name.to_string_lossy().to_string()).unwrap_or_default();
+    }
+    inventory.sources.push("go version -m".to_string());
+    Some(inventory)
+}
+
+/// The module path and required modules of a go.mod, from single `require` lines and `require (...)` blocks
+fn parse_go_mod(go_mod: &str) -> (Option<String>, Vec<Package>) {
+    let mut module = None;
+    let mut packages = Vec::new();
+    let mut in_require = false;
+    for line in go_mod.lines() {
+        let line = line.split("//").next().unwrap_or_default().trim();
+        let words: Vec<&str> = line.split_whitespace().collect();
+        match words[..] {
+            ["module", name] => module = Some(name.trim_matches('"').to_string()),
+            ["require", "("] => in_require = true,
+            [")"] => in_require = false,
+            ["require", name, version] => packages.push(Package::go(name, version)),
+            [name, version] if in_require => packages.push(Package::go(name, version)),
+            _ => {}
+        }
+    }
+    (module, packages)
+}
+
+/// The modules a MODULE.bazel.lock resolved: from the registry files it fetched, in current lockfiles,
+/// or from the module graph of older ones
+fn parse_bazel_lock(lock: &str) -> Vec<Package> {
+    let Ok(lock) = serde_json::from_str::<Value>(lock) else {
+        return Vec::new();
+    };
+    let mut packages = Vec::new();
+    if let Some(hashes) = lock["registryFileHashes"].as_object() {
+        // e.g., "https://bcr.bazel.build/modules/rules_go/0.50.1/MODULE.bazel"
+        for url in hashes.keys() {
+            let parts: Vec<&str> = url.rsplit('/').take(4).collect();
+            if let [file, version, name, "modules"] = parts[..] {
+                if file == "MODULE.bazel" {
+                    packages.push(Package::bazel(name, version));
+                }
+            }
+        }
+    }
+    if let Some(graph) = lock["moduleDepGraph"].as_object() {
+        for module in graph.values() {
+            if let (Some(name), Some(version)) = (module["name"].as_str(), module["version"].as_str()) {
+                // The root module has no version
+                if !version.is_empty() {
+                    packages.push(Package::bazel(name, version));
+                }
+            }
+        }
+    }
+    packages
+}
+
+/// The main module and dependencies in `go version -m` output; None if it lists neither
+fn parse_go_version(output: &str) -> Option<Inventory> {
+    let mut inventory = Inventory::default();
+    let mut packages: Vec<Package> = Vec::new();
+    for line in output.lines() {
+        let fields: Vec<&str> = line.split('\t').map(str::trim).filter(|f| !f.is_empty()).collect();
+        match fields[..] {
+            ["mod", name, version, ..] => {
+                inventory.name = name.to_string();
+                inventory.version = Some(version.to_string()).filter(|v| v != "(devel)");
+            }
+            ["dep", name, version, ..] => packages.push(Package::go(name, version)),
+            // The dependency above was replaced by this one
+            ["=>", name, version, ..] => {
+                if let Some(last) = packages.last_mut() {
+                    *last = Package::go(name, version);
+                }
+            }
+            _ => {}
+        }
+    }
+    if inventory.name.is_empty() && packages.is_empty() {
+        return None;
+    }
+    inventory.packages.extend(packages);
+    Some(inventory)
+}
+
+/// An SPDX 2.3 document: the described module, which depends on each package
+fn spdx(inventory: &Inventory, now: u64, document: usize) -> Value {
+    let mut packages = vec![json!({
+        "name": inventory.name,
+        "SPDXID": "SPDXRef-Root",
+        "versionInfo": inventory.version.as_deref().unwrap_or("NOASSERTION"),
+        "downloadLocation": "NOASSERTION",
+    })];
+    let mut relationships = vec![json!({
+        "spdxElementId": "SPDXRef-DOCUMENT",
+        "relationshipType": "DESCRIBES",
+        "relatedSpdxElement": "SPDXRef-Root",
+    })];
+    for (i, package) in inventory.packages.iter().enumerate() {
+        let id = format!("SPDXRef-Package-{}", i + 1);
+        packages.push(json!({
+            "name": package.name,
+            "SPDXID": id,
+            "versionInfo": package.version,
+            "downloadLocation": "NOASSERTION",
+            "externalRefs": [{
+                "referenceCategory": "PACKAGE-MANAGER",
+                "referenceType": "purl",
+                "referenceLocator": package.purl,
+            }],
+        }));
+        relationships.push(json!({
+            "spdxElementId": "SPDXRef-Root",
+            "relationshipType": "DEPENDS_ON",
+            "relatedSpdxElement": id,
+        }));
+    }
+    json!({
+        "spdxVersion": "SPDX-2.3",
+        "dataLicense": "CC0-1.0",
+        "SPDXID": "SPDXRef-DOCUMENT",
+        "name": inventory.name,
+        "documentNamespace": format!("urn:command-runner:sbom:{}:{}", session_id(), document),
+        "creationInfo": {
+            "created": format_utc(now),
+            "creators": [format!("Tool: command-runner-mcp-server-{}", env!("CARGO_PKG_VERSION"))],
+            "comment": format!("Read from {}", inventory.sources.join(" and ")),
+        },
+        "packages": packages,
+        "relationships": relationships,
+    })
+}
+
+/// A CycloneDX 1.5 BOM: the described module as its subject, and each package as a library component
+fn cyclonedx(inventory: &Inventory, now: u64) -> Value {
+    let mut subject = json!({"type": "application", "bom-ref": "root", "name": inventory.name});
+    if let Some(ref version) = inventory.version {
+        subject["version"] = json!(version);
+    }
+    let components: Vec<Value> = inventory
+        .packages
+        .iter()
+        .map(|package| {
+            json!({
+                "type": "library",
+                "bom-ref": package.purl,
+                "name": package.name,
+                "version": package.version,
+                "purl": package.purl,
+            })
+        })
+        .collect();
+    json!({
+        "bomFormat": "CycloneDX",
+        "specVersion": "1.5",
+        "version": 1,
+        "metadata": {
+            "timestamp": format_utc(now),
+            "tools": {"components": [{
+                "type": "application",
+                "name": "command-runner-mcp-server",
+                "version": env!("CARGO_PKG_VERSION"),
+            }]},
+            "component": subject,
+            "properties": [{"name": "sources", "value": inventory.sources.join(", ")}],
+        },
+        "components": components,
+        "dependencies": [{
+            "ref": "root",
+            "dependsOn": inventory.packages.iter().map(|package| package.purl.as_str()).collect::<Vec<_>>(),
+        }],
+    })
+}
+
+/// JSON Schema of the SBOM, declared as the tool's output schema. The documents themselves follow the SPDX
+/// and CycloneDX schemas, so only the field naming each format is checked here.
+pub fn output_schema() -> Value {
+    json!({
+        "type": "object",
+        "description": "An SPDX 2.3 or CycloneDX 1.5 JSON document, as chosen by format",
+        "anyOf": [{"required": ["spdxVersion"]}, {"required": ["bomFormat"]}],
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_go_mod() {
+        let go_mod = "module example.com/app\n\ngo 1.22\n\nrequire github.com/spf13/cobra v1.8.0\n\n\
+                      require (\n\tgolang.org/x/sys v0.20.0 // indirect\n\tgopkg.in/yaml.v3 v3.0.1\n)\n";
+        let (module, packages) = parse_go_mod(go_mod);
+        assert_eq!(module.as_deref(), Some("example.com/app"));
+        let purls: Vec<&str> = packages.iter().map(|package| package.purl.as_str()).collect();
+        assert_eq!(
+            purls,
+            [
+                "pkg:golang/github.com/spf13/cobra@v1.8.0",
+                "pkg:golang/golang.org/x/sys@v0.20.0",
+                "pkg:golang/gopkg.in/yaml.v3@v3.0.1"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_bazel_lock() {
+        let current = r#"{"lockFileVersion": 11, "registryFileHashes": {
+            "https://bcr.bazel.build/bazel_registry.json": "8a28",
+            "https://bcr.bazel.build/modules/rules_go/0.50.1/MODULE.bazel": "b4d2",
+            "https://bcr.bazel.build/modules/rules_go/0.50.1/source.json": "205b"}}"#;
+        assert_eq!(parse_bazel_lock(current), [Package::bazel("rules_go", "0.50.1")]);
+        let older = r#"{"lockFileVersion": 3, "moduleDepGraph": {
+            "<root>": {"name": "app", "version": ""},
+            "platforms@0.0.7": {"name": "platforms", "version": "0.0.7"}}}"#;
+        assert_eq!(parse_bazel_lock(older), [Package::bazel("platforms", "0.0.7")]);
+        assert!(parse_bazel_lock("not json").is_empty());
+    }
+
+    #[test]
+    fn test_parse_go_version() {
+        let output = "/tmp/app: go1.22.3\n\tpath\texample.com/app\n\tmod\texample.com/app\t(devel)\t\n\
+                      \tdep\tgolang.org/x/sys\tv0.20.0\th1:abc=\n\tdep\texample.com/fork\tv1.0.0\n\
+                      \t=>\texample.com/patched\tv1.0.1\th1:def=\n\tbuild\tGOOS=linux\n";
+        let inventory = parse_go_version(output).unwrap();
+        assert_eq!(inventory.name, "example.com/app");
+        assert_eq!(inventory.version, None);
+        let names: Vec<&str> = inventory.packages.iter().map(|package| package.name.as_str()).collect();
+        assert_eq!(names, ["example.com/patched", "golang.org/x/sys"]);
+        assert!(parse_go_version("/tmp/script.sh: not executable file\n").is_none());
+    }
+
+    #[test]
+    fn test_documents() {
+        let inventory = Inventory {
+            name: "example.com/app".to_string(),
+            version: None,
+            packages: [Package::go("golang.org/x/sys", "v0.20.0")].into_iter().collect(),
+            sources: vec!["go.mod".to_string()],
+        };
+        let doc = spdx(&inventory, 1_792_071_900, 1);
+        assert_eq!(doc["spdxVersion"], "SPDX-2.3");
+        assert_eq!(doc["creationInfo"]["created"], "2026-10-15T13:45:00Z");
+        assert_eq!(doc["packages"][1]["externalRefs"][0]["referenceLocator"], "pkg:golang/golang.org/x/sys@v0.20.0");
+        assert_eq!(doc["relationships"][1]["relatedSpdxElement"], "SPDXRef-Package-1");
+        let bom = cyclonedx(&inventory, 1_792_071_900);
+        assert_eq!(bom["bomFormat"], "CycloneDX");
+        assert_eq!(bom["metadata"]["component"]["name"], "example.com/app");
+        assert_eq!(bom["components"][0]["purl"], "pkg:golang/golang.org/x/sys@v0.20.0");
+        assert_eq!(bom["dependencies"][0]["dependsOn"][0], "pkg:golang/golang.org/x/sys@v0.20.0");
+    }
+}
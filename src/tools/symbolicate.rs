@@ -0,0 +1,276 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+
+use crate::request::ExecutionContext;
+use crate::security::{contains_traversal, validate_path, Validatable, ValidationError};
+use crate::tools::explain_failure::FILE_REFERENCE;
+
+/// Source lines shown around each frame's line when the request doesn't set context_lines
+const DEFAULT_CONTEXT_LINES: usize = 3;
+
+/// Most source lines shown on each side of a frame's line
+const MAX_CONTEXT_LINES: usize = 20;
+
+/// Most frames included in a report
+const MAX_FRAMES: usize = 30;
+
+/// Request parameters for the symbolicate tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct SymbolicateRequest {
+    /// The stack trace, as printed by a Go panic, a C++ sanitizer, gdb or similar
+    pub trace: String,
+
+    /// Source lines to show before and after each frame's line (default: 3, maximum: 20)
+    #[serde(default)]
+    pub context_lines: Option<usize>,
+}
+
+impl Validatable for SymbolicateRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        // The trace is only parsed; every file it names is checked before being read
+        Ok(())
+    }
+}
+
+/// A stack frame: the trace line naming its function, and the file and line it points at
+#[derive(Debug, PartialEq)]
+struct Frame {
+    function: String,
+    path: String,
+    line: usize,
+}
+
+/// Resolve a stack trace against the workspace with a validated request and execution context
+pub fn execute(req: &SymbolicateRequest, ctx: &ExecutionContext) -> String {
+    let frames = parse_frames(&req.trace);
+    if frames.is_empty() {
+        return "Error: No file:line references found in the trace".to_string();
+    }
+    let workspace = PathBuf::from(ctx.working_dir.as_deref().unwrap_or("."));
+    let roots = roots(&workspace);
+    let context = req.context_lines.unwrap_or(DEFAULT_CONTEXT_LINES).min(MAX_CONTEXT_LINES);
+
+    let mut resolved = 0;
+    let mut report = Vec::new();
+    for (i, frame) in frames.iter().take(MAX_FRAMES).enumerate() {
+        report.push(format!("#{} {}", i, frame.function));
+        let reference = format!("{}:{}", frame.path, frame.line);
+        let file = match resolve(&frame.path, &roots) {
+            Some(file) => file,
+            None => {
+                report.push(format!("   {} (not found)", reference));
+                continue;
+            }
+        };
+        let shown = file.strip_prefix(&workspace).unwrap_or(&file).display().to_string();
+        match snippet(&file, frame.line, context) {
+            Some(lines) => {
+                resolved += 1;
+                if shown == frame.path {
+                    report.push(format!("   {}", reference));
+                } else {
+                    report.push(format!("   {} -> {}", reference, shown));
+                }
+                report.extend(lines);
+            }
+            None => report.push(format!("   {} -> {} (no line {})", reference, shown, frame.line)),
+        }
+    }
+    if frames.len() > MAX_FRAMES {
+        report.push(format!("... {} more frames", frames.len() - MAX_FRAMES));
+    }
+    let shown = frames.len().min(MAX_FRAMES);
+    format!("Resolved {} of {} frames\n\n{}", resolved, shown, report.join("\n"))
+}
+
+/// Frames in trace order, one per distinct file:line reference. Go prints the function on the line
+/// before its location, so a line holding only a location takes its function from the line above.
+fn parse_frames(trace: &str) -> Vec<Frame> {
+    let lines: Vec<&str> = trace.lines().collect();
+    let mut frames: Vec<Frame> = Vec::new();
+    for (i, line) in lines.iter().enumerate() {
+        let captures = match FILE_REFERENCE.captures(line) {
+            Some(captures) => captures,
+            None => continue,
+        };
+        let (path, number) = (captures[1].to_string(), captures[2].parse().unwrap_or(0));
+        if frames.iter().any(|f| f.path == path && f.line == number) {
+            continue;
+        }
+        let location_only = line.trim_start().starts_with(&path);
+        let function = match i.checked_sub(1).map(|j| lines[j].trim()) {
+            Some(previous) if location_only && !FILE_REFERENCE.is_match(previous) => previous.to_string(),
+            _ => line.trim().to_string(),
+        };
+        frames.push(Frame {
+            function,
+            path,
+            line: number,
+        });
+    }
+    frames
+}
+
+/// Directories paths are resolved against: the workspace, and bazel's execution root through its
+/// convenience symlink, where external repositories live
+fn roots(workspace: &Path) -> Vec<PathBuf> {
+    let mut roots = vec![workspace.to_path_buf()];
+    if let Some(name) = workspace.canonicalize().ok().and_then(|dir| dir.file_name().map(|n| n.to_os_string())) {
+        let execroot = workspace.join(format!("bazel-{}", name.to_string_lossy()));
+        if execroot.is_dir() {
+            roots.push(execroot);
+        }
+    }
+    roots
+}
+
+/// Find a file named in a trace. Traces from CI machines and bazel sandboxes carry prefixes that
+/// don't exist here, e.g., "/home/ci/src/app/" or ".../execroot/_main/", so the longest suffix of the
+/// path that exists under one of the roots wins. A path that exists as given, such as the Go runtime's
+/// sources, is used when no suffix does. Blocked paths are never resolved.
+fn resolve(path: &str, roots: &[PathBuf]) -> Option<PathBuf> {
+    if contains_traversal(path) {
+        return None;
+    }
+    let components: Vec<&str> = Path::new(path)
+        .components()
+        .filter_map(|c| match c {
+            Component::Normal(name) => name.to_str(),
+            _ => None,
+        })
+        .collect();
+    let readable = |candidate: &Path| candidate.is_file() && validate_path(&candidate.to_string_lossy()).is_ok();
+    (0..components.len())
+        .map(|start| components[start..].join("/"))
+        .flat_map(|suffix| roots.iter().map(move |root| root.join(&suffix)))
+        .find(|candidate| readable(candidate))
+        .or_else(|| Some(PathBuf::from(path)).filter(|p| p.is_absolute() && readable(p)))
+}
+
+/// Numbered source lines around `line`, which is marked with ">"; None if the file is shorter
+fn snippet(file: &Path, line: usize, context: usize) -> Option<Vec<String>> {
+    let content = fs::read_to_string(file).ok()?;
+    let lines: Vec<&str> = content.lines().collect();
+    if line == 0 || line > lines.len() {
+        return None;
+    }
+    let start = line.saturating_sub(context).max(1);
+    let end = (line + context).min(lines.len());
+    let width = end.to_string().len();
+    Some(
+        (start..=end)
+            .map(|n| {
+                let marker = if n == line { ">" } else { " " };
+                let text = format!("   {} {:>width$} | {}", marker, n, lines[n - 1], width = width);
+                text.trim_end().to_string()
+            })
+            .collect(),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    const GO_TRACE: &str = "panic: runtime error: index out of range [3] with length 3
+
+goroutine 1 [running]:
+example.com/app/parser.(*Parser).next(0xc000010000)
+\t/home/ci/src/app/parser/parser.go:3 +0x1d
+main.main()
+\t/home/ci/src/app/main.go:2 +0x25
+";
+
+    fn setup_workspace() -> TempDir {
+        let temp_dir = TempDir::new().unwrap();
+        fs::create_dir(temp_dir.path().join("parser")).unwrap();
+        fs::write(
+            temp_dir.path().join("parser/parser.go"),
+            "package parser\n\nfunc (p *Parser) next() Token { return p.stack[3] }\n",
+        )
+        .unwrap();
+        temp_dir
+    }
+
+    fn symbolicate(temp_dir: &TempDir, trace: &str, context_lines: usize) -> String {
+        let req = SymbolicateRequest {
+            trace: trace.to_string(),
+            context_lines: Some(context_lines),
+        };
+        let ctx = ExecutionContext {
+            working_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+            ..Default::default()
+        };
+        execute(&req, &ctx)
+    }
+
+    #[test]
+    fn test_parse_go_frames() {
+        let frames = parse_frames(GO_TRACE);
+        assert_eq!(
+            frames,
+            vec![
+                Frame {
+                    function: "example.com/app/parser.(*Parser).next(0xc000010000)".to_string(),
+                    path: "/home/ci/src/app/parser/parser.go".to_string(),
+                    line: 3,
+                },
+                Frame {
+                    function: "main.main()".to_string(),
+                    path: "/home/ci/src/app/main.go".to_string(),
+                    line: 2,
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_cpp_frames() {
+        let trace = "    #0 0x4f2b1c in Parser::Next() src/parser.cc:42:7\n    #1 0x4f2c00 in main src/main.cc:10:3\n";
+        let frames = parse_frames(trace);
+        assert_eq!(frames[0].function, "#0 0x4f2b1c in Parser::Next() src/parser.cc:42:7");
+        assert_eq!((frames[0].path.as_str(), frames[0].line), ("src/parser.cc", 42));
+        assert_eq!(frames.len(), 2);
+    }
+
+    #[test]
+    fn test_symbolicate_strips_foreign_prefix() {
+        let temp_dir = setup_workspace();
+        assert_eq!(
+            symbolicate(&temp_dir, GO_TRACE, 1),
+            "Resolved 1 of 2 frames\n\n\
+             #0 example.com/app/parser.(*Parser).next(0xc000010000)\n   \
+             /home/ci/src/app/parser/parser.go:3 -> parser/parser.go\n     \
+             2 |\n   \
+             > 3 | func (p *Parser) next() Token { return p.stack[3] }\n\
+             #1 main.main()\n   \
+             /home/ci/src/app/main.go:2 (not found)"
+        );
+    }
+
+    #[test]
+    fn test_symbolicate_bazel_execroot_path() {
+        let temp_dir = setup_workspace();
+        let trace = "\t/root/.cache/bazel/_bazel_root/1f2e/execroot/_main/parser/parser.go:1 +0x1d\n";
+        let result = symbolicate(&temp_dir, trace, 0);
+        assert!(result.ends_with("-> parser/parser.go\n   > 1 | package parser"), "{}", result);
+    }
+
+    #[test]
+    fn test_resolve_skips_traversal() {
+        let temp_dir = setup_workspace();
+        assert_eq!(resolve("../parser/parser.go", &[temp_dir.path().to_path_buf()]), None);
+    }
+
+    #[test]
+    fn test_symbolicate_without_references() {
+        let temp_dir = setup_workspace();
+        assert_eq!(
+            symbolicate(&temp_dir, "Segmentation fault (core dumped)", 3),
+            "Error: No file:line references found in the trace"
+        );
+    }
+}
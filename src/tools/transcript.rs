@@ -0,0 +1,95 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::request::ExecutionContext;
+use crate::labels;
+use crate::security::{Validatable, ValidationError};
+use crate::transcript::{entries, session_id, to_json, to_markdown};
+
+/// Export format for the session transcript
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, schemars::JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TranscriptFormat {
+    #[default]
+    Json,
+    Markdown,
+}
+
+/// Request parameters for the transcript tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct TranscriptRequest {
+    /// Export format: "json" (default) or "markdown"
+    #[serde(default)]
+    pub format: TranscriptFormat,
+    /// Only include calls with this label, as "name=value", or "name" for any value, e.g., "task=T-42"
+    pub label: Option<String>,
+}
+
+impl Validatable for TranscriptRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        Ok(())
+    }
+}
+
+/// Export the current session's transcript with a validated request and execution context
+pub fn execute(req: &TranscriptRequest, _ctx: &ExecutionContext) -> String {
+    let mut entries = entries();
+    if let Some(ref selector) = req.label {
+        entries.retain(|entry| labels::matches(&entry.labels, selector));
+    }
+    match req.format {
+        TranscriptFormat::Json => to_json(session_id(), &entries),
+        TranscriptFormat::Markdown => to_markdown(session_id(), &entries),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::labels::Labels;
+    use crate::transcript::{next_call, record};
+    use serde_json::json;
+
+    #[test]
+    fn test_deserialize_format() {
+        let req: TranscriptRequest = serde_json::from_str("{}").unwrap();
+        assert_eq!(req.format, TranscriptFormat::Json);
+        let req: TranscriptRequest = serde_json::from_str(r#"{"format": "markdown"}"#).unwrap();
+        assert_eq!(req.format, TranscriptFormat::Markdown);
+    }
+
+    #[test]
+    fn test_export_json_includes_session() {
+        let req = TranscriptRequest {
+            format: TranscriptFormat::Json,
+            label: None,
+        };
+        let parsed: serde_json::Value = serde_json::from_str(&execute(&req, &ExecutionContext::default())).unwrap();
+        assert_eq!(parsed["session"], session_id());
+    }
+
+    #[test]
+    fn test_export_markdown() {
+        let req = TranscriptRequest {
+            format: TranscriptFormat::Markdown,
+            label: None,
+        };
+        let result = execute(&req, &ExecutionContext::default());
+        assert!(result.starts_with(&format!("# Transcript for session {}", session_id())));
+    }
+
+    #[test]
+    fn test_export_filters_by_label() {
+        let call = next_call();
+        let labels = Labels::from([("task".to_string(), "test_export_filters_by_label".to_string())]);
+        record(call, "host_info", json!({}), "os: linux", labels);
+        let req = TranscriptRequest {
+            format: TranscriptFormat::Json,
+            label: Some("task=test_export_filters_by_label".to_string()),
+        };
+        let parsed: serde_json::Value = serde_json::from_str(&execute(&req, &ExecutionContext::default())).unwrap();
+        let entries = parsed["entries"].as_array().unwrap();
+        assert_eq!(entries.len(), 1);
+        assert_eq!(entries[0]["call"], call);
+    }
+}
@@ -0,0 +1,87 @@
+use rmcp::schemars;
+use serde::{Deserialize, Serialize};
+
+use crate::accounting::{self, Window, MAX_REPORT_DAYS, TENANT_LABEL};
+use crate::labels::validate_label;
+use crate::request::ExecutionContext;
+use crate::security::{Validatable, ValidationError};
+
+/// Days a report covers unless the request says otherwise
+const DEFAULT_DAYS: u64 = 30;
+
+/// Output format of the usage report
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, Serialize, schemars::JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum UsageReportFormat {
+    #[default]
+    Text,
+    Csv,
+}
+
+/// Request parameters for the usage_report tool
+#[derive(Debug, Deserialize, Serialize, schemars::JsonSchema)]
+pub struct UsageReportRequest {
+    /// Label to total usage by (default: "tenant"), e.g., "task" or "agent"
+    pub group_by: Option<String>,
+    /// Period to total usage over: "hour", "day" (default), "week" or "all"
+    #[serde(default)]
+    pub window: Window,
+    /// How many days back the report covers (default: 30, at most 366)
+    pub days: Option<u64>,
+    /// Output format: "text" (default) or "csv" for chargeback spreadsheets
+    #[serde(default)]
+    pub format: UsageReportFormat,
+}
+
+impl Validatable for UsageReportRequest {
+    fn validate(&self) -> Result<(), ValidationError> {
+        if let Some(ref group_by) = self.group_by {
+            validate_label(group_by, "x")?;
+        }
+        Ok(())
+    }
+}
+
+/// Report usage from the usage ledger with a validated request and execution context
+pub fn execute(req: &UsageReportRequest, _ctx: &ExecutionContext) -> String {
+    let group_by = req.group_by.as_deref().unwrap_or(TENANT_LABEL);
+    let days = req.days.unwrap_or(DEFAULT_DAYS).clamp(1, MAX_REPORT_DAYS);
+    let records = match accounting::load_since(accounting::now_secs().saturating_sub(days * 86_400)) {
+        Ok(records) => records,
+        Err(e) => return format!("Error: {}", e),
+    };
+    let rows = accounting::aggregate(&records, group_by, req.window);
+    match req.format {
+        UsageReportFormat::Text => accounting::to_text(&rows, group_by),
+        UsageReportFormat::Csv => accounting::to_csv(&rows, group_by),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deserialize_defaults() {
+        let req: UsageReportRequest = serde_json::from_str("{}").unwrap();
+        assert_eq!(req.window, Window::Day);
+        assert_eq!(req.format, UsageReportFormat::Text);
+        let req: UsageReportRequest =
+            serde_json::from_str(r#"{"group_by": "task", "window": "week", "format": "csv"}"#).unwrap();
+        assert_eq!(req.window, Window::Week);
+        assert_eq!(req.format, UsageReportFormat::Csv);
+        assert!(req.validate().is_ok());
+    }
+
+    #[test]
+    fn test_rejects_bad_group_by() {
+        let req: UsageReportRequest = serde_json::from_str(r#"{"group_by": "tenant=payments"}"#).unwrap();
+        assert!(matches!(req.validate(), Err(ValidationError::InvalidLabel(_))));
+    }
+
+    #[test]
+    fn test_without_ledger() {
+        let req: UsageReportRequest = serde_json::from_str("{}").unwrap();
+        assert_eq!(execute(&req, &ExecutionContext::default()), "Error: USAGE_LEDGER is not set");
+    }
+}
@@ -0,0 +1,289 @@
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::VecDeque;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{LazyLock, Mutex};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::labels::{self, Labels};
+use crate::severity;
+
+/// Maximum number of entries kept per session; the oldest entries are dropped first
+const MAX_TRANSCRIPT_ENTRIES: usize = 1000;
+
+/// Maximum length of the result summary stored for each entry
+const MAX_SUMMARY_CHARS: usize = 200;
+
+/// Replacement for redacted input values
+const REDACTED: &str = "[REDACTED]";
+
+/// URI scheme for transcript resources
+pub const TRANSCRIPT_URI_SCHEME: &str = "transcript://";
+
+/// Identifier of this server session. Each stdio client gets its own server
+/// process, so the process ID and start time identify the session.
+static SESSION_ID: LazyLock<String> = LazyLock::new(|| {
+    let started = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or_default();
+    format!("{}-{}", started, std::process::id())
+});
+
+static TRANSCRIPT: Mutex<VecDeque<TranscriptEntry>> = Mutex::new(VecDeque::new());
+
+/// Number of the last recorded call; numbers keep increasing after old entries are dropped
+static LAST_CALL: AtomicU64 = AtomicU64::new(0);
+
+/// A single tool call recorded in the session transcript
+#[derive(Debug, Clone, Serialize)]
+pub struct TranscriptEntry {
+    /// Position of the call in the session, starting at 1
+    pub call: u64,
+    /// Time the call finished, in UTC
+    pub time: String,
+    pub tool: String,
+    /// Tool arguments with environment variable values redacted
+    pub input: Value,
+    /// "ok" or "error"
+    pub status: &'static str,
+    /// First line of the result, truncated
+    pub summary: String,
+    /// Number of lines in the result
+    pub lines: usize,
+    /// Number of error lines in the result
+    pub errors: usize,
+    /// Number of warning lines in the result
+    pub warnings: usize,
+    /// Labels attributing the call: the session's and any it set
+    #[serde(skip_serializing_if = "Labels::is_empty")]
+    pub labels: Labels,
+}
+
+impl TranscriptEntry {
+    fn new(call: u64, tool: &str, mut input: Value, output: &str, labels: Labels) -> Self {
+        redact(&mut input);
+        let first_line = output.lines().next().unwrap_or_default();
+        let (errors, warnings) = severity::counts(output);
+        Self {
+            call,
+            time: format_utc(now_secs()),
+            tool: tool.to_string(),
+            input,
+            status: if output.starts_with("Error") { "error" } else { "ok" },
+            summary: first_line.chars().take(MAX_SUMMARY_CHARS).collect(),
+            lines: output.lines().count(),
+            errors,
+            warnings,
+            labels,
+        }
+    }
+}
+
+/// Identifier of the current session
+pub fn session_id() -> &'static str {
+    &SESSION_ID
+}
+
+/// URI of the current session's transcript resource
+pub fn transcript_uri() -> String {
+    format!("{}{}", TRANSCRIPT_URI_SCHEME, session_id())
+}
+
+/// Reserve the number of a tool call that is starting
+pub fn next_call() -> u64 {
+    LAST_CALL.fetch_add(1, Ordering::Relaxed) + 1
+}
+
+/// Record a finished tool call in the session transcript, returning its entry
+pub fn record(call: u64, tool: &str, input: Value, output: &str, labels: Labels) -> TranscriptEntry {
+    let entry = TranscriptEntry::new(call, tool, input, output, labels);
+    let mut transcript = TRANSCRIPT.lock().unwrap_or_else(|e| e.into_inner());
+    if transcript.len() == MAX_TRANSCRIPT_ENTRIES {
+        transcript.pop_front();
+    }
+    transcript.push_back(entry.clone());
+    entry
+}
+
+/// Snapshot of the session transcript, oldest entry first
+pub fn entries() -> Vec<TranscriptEntry> {
+    let transcript = TRANSCRIPT.lock().unwrap_or_else(|e| e.into_inner());
+    transcript.iter().cloned().collect()
+}
+
+/// Remove secrets from recorded input. Environment variable values and stdin payloads, e.g., a token
+/// piped to jq, are where tools accept secrets, so they are always replaced; a payload keeps its size.
+fn redact(input: &mut Value) {
+    if let Some(Value::Object(env)) = input.get_mut("env") {
+        for value in env.values_mut() {
+            *value = Value::String(REDACTED.to_string());
+        }
+    }
+    for key in ["stdin", "stdin_base64"] {
+        if let Some(Value::String(data)) = input.get(key) {
+            input[key] = Value::String(format!("[REDACTED {} bytes]", data.len()));
+        }
+    }
+}
+
+/// Render a transcript as JSON
+pub fn to_json(session: &str, entries: &[TranscriptEntry]) -> String {
+    let document = serde_json::json!({
+        "session": session,
+        "entries": entries,
+    });
+    serde_json::to_string_pretty(&document).unwrap_or_default()
+}
+
+/// Render a transcript as Markdown
+pub fn to_markdown(session: &str, entries: &[TranscriptEntry]) -> String {
+    let mut out = format!("# Transcript for session {}\n", session);
+    if entries.is_empty() {
+        out.push_str("\nNo tool calls recorded.\n");
+    }
+    for entry in entries {
+        let input = serde_json::to_string_pretty(&entry.input).unwrap_or_default();
+        out.push_str(&format!("\n## {}. {} at {} ({})\n", entry.call, entry.tool, entry.time, entry.status));
+        if !entry.labels.is_empty() {
+            out.push_str(&format!("\nLabels: {}\n", labels::format(&entry.labels)));
+        }
+        out.push_str(&format!(
+            "\nInput:\n\n```json\n{}\n```\n\nResult ({} lines, {} errors, {} warnings): {}\n",
+            input,
+            entry.lines,
+            entry.errors,
+            entry.warnings,
+            entry.summary
+        ));
+    }
+    out
+}
+
+fn now_secs() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or_default()
+}
+
+/// Year, month and day of a day counted from the Unix epoch, in the proleptic Gregorian calendar
+pub fn civil_from_days(days: i64) -> (i64, i64, i64) {
+    // Howard Hinnant's algorithm
+    let z = days + 719_468;
+    let era = z.div_euclid(146_097);
+    let doe = z.rem_euclid(146_097);
+    let yoe = (doe - doe / 1_460 + doe / 36_524 - doe / 146_096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + if month <= 2 { 1 } else { 0 };
+    (year, month, day)
+}
+
+/// Format seconds since the Unix epoch as an ISO 8601 UTC timestamp
+pub fn format_utc(secs: u64) -> String {
+    let (year, month, day) = civil_from_days((secs / 86_400) as i64);
+    let rem = secs % 86_400;
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}Z",
+        year,
+        month,
+        day,
+        rem / 3_600,
+        (rem % 3_600) / 60,
+        rem % 60
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_entry_redacts_env_values() {
+        let input = json!({"path": "/tmp", "env": {"API_TOKEN": "hunter2", "DEBUG": "1"}});
+        let entry = TranscriptEntry::new(1, "ls_tool", input, "total 0\n", Labels::new());
+        assert_eq!(entry.input["env"]["API_TOKEN"], REDACTED);
+        assert_eq!(entry.input["env"]["DEBUG"], REDACTED);
+        assert_eq!(entry.input["path"], "/tmp");
+
+        let input = json!({"stdin": "ghp_secret\n", "env": {}});
+        let entry = TranscriptEntry::new(2, "pipeline", input, "", Labels::new());
+        assert_eq!(entry.input["stdin"], "[REDACTED 11 bytes]");
+        let input = json!({"stdin_base64": "AP8K", "stdin": null});
+        let entry = TranscriptEntry::new(3, "pipeline", input, "", Labels::new());
+        assert_eq!(entry.input["stdin_base64"], "[REDACTED 4 bytes]");
+        assert_eq!(entry.input["stdin"], Value::Null);
+    }
+
+    #[test]
+    fn test_entry_summarizes_output() {
+        let output = "Error: not a git repository\nFailure category: not-found";
+        let entry = TranscriptEntry::new(1, "git", json!({}), output, Labels::new());
+        assert_eq!(entry.status, "error");
+        assert_eq!(entry.summary, "Error: not a git repository");
+        assert_eq!(entry.lines, 2);
+
+        let long = "x".repeat(MAX_SUMMARY_CHARS + 50);
+        let entry = TranscriptEntry::new(2, "ls_tool", json!({}), &long, Labels::new());
+        assert_eq!(entry.status, "ok");
+        assert_eq!(entry.summary.len(), MAX_SUMMARY_CHARS);
+    }
+
+    #[test]
+    fn test_record_appends_to_transcript() {
+        let first = next_call();
+        let second = next_call();
+        assert!(second > first);
+        let input = json!({"marker": "test_record_appends_to_transcript"});
+        record(first, "host_info", input, "os: linux", Labels::new());
+        assert!(entries()
+            .iter()
+            .any(|e| e.call == first && e.input["marker"] == "test_record_appends_to_transcript"));
+    }
+
+    #[test]
+    fn test_to_json() {
+        let entries = vec![TranscriptEntry::new(1, "ls_tool", json!({"path": "src"}), "a\nb", Labels::new())];
+        let parsed: Value = serde_json::from_str(&to_json("s1", &entries)).unwrap();
+        assert_eq!(parsed["session"], "s1");
+        assert_eq!(parsed["entries"][0]["tool"], "ls_tool");
+        assert_eq!(parsed["entries"][0]["lines"], 2);
+        assert_eq!(parsed["entries"][0]["errors"], 0);
+        // Only labeled calls have labels
+        assert!(parsed["entries"][0].get("labels").is_none());
+        let labels = Labels::from([("task".to_string(), "T-42".to_string())]);
+        let entries = vec![TranscriptEntry::new(2, "git", json!({}), "ok", labels)];
+        let parsed: Value = serde_json::from_str(&to_json("s1", &entries)).unwrap();
+        assert_eq!(parsed["entries"][0]["labels"]["task"], "T-42");
+    }
+
+    #[test]
+    fn test_to_markdown() {
+        let labels = Labels::from([("task".to_string(), "T-42".to_string())]);
+        let entries = vec![TranscriptEntry::new(1, "ls_tool", json!({"path": "src"}), "a\nb", labels)];
+        let markdown = to_markdown("s1", &entries);
+        assert!(markdown.starts_with("# Transcript for session s1"));
+        assert!(markdown.contains("## 1. ls_tool at "));
+        assert!(markdown.contains("\nLabels: task=T-42\n"));
+        assert!(markdown.contains("\"path\": \"src\""));
+        assert!(markdown.contains("Result (2 lines, 0 errors, 0 warnings): a"));
+        assert!(to_markdown("s1", &[]).contains("No tool calls recorded."));
+    }
+
+    #[test]
+    fn test_format_utc() {
+        assert_eq!(format_utc(0), "1970-01-01T00:00:00Z");
+        assert_eq!(format_utc(951_782_400), "2000-02-29T00:00:00Z");
+        assert_eq!(format_utc(1_792_065_600), "2026-10-15T12:00:00Z");
+    }
+
+    #[test]
+    fn test_session_id_is_stable() {
+        assert_eq!(session_id(), session_id());
+        assert_eq!(transcript_uri(), format!("transcript://{}", session_id()));
+    }
+}
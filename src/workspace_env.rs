@@ -0,0 +1,163 @@
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::LazyLock;
+
+use crate::security::validate_env_var;
+
+/// Files variables are loaded from, in order; later files override earlier ones
+const ENV_FILES: &[&str] = &[".env", ".envrc"];
+
+/// Variables that may be loaded from a workspace's env files, loaded from WORKSPACE_ENV_KEYS
+/// environment variable at startup. Format: semicolon-separated names, e.g., "GOFLAGS;NODE_ENV".
+/// Nothing is loaded when no keys are configured.
+static ALLOWED_KEYS: LazyLock<Vec<String>> = LazyLock::new(|| {
+    std::env::var("WORKSPACE_ENV_KEYS")
+        .unwrap_or_default()
+        .split(';')
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+        .collect()
+});
+
+/// Allowlisted variables from the env files of the workspace containing `working_dir`
+pub fn workspace_env(working_dir: &Path) -> Vec<(String, String)> {
+    workspace_env_impl(working_dir, &ALLOWED_KEYS)
+}
+
+/// Internal implementation for testability - takes the allowed keys as parameter.
+fn workspace_env_impl(working_dir: &Path, allowed: &[String]) -> Vec<(String, String)> {
+    if allowed.is_empty() {
+        return Vec::new();
+    }
+    let mut vars = Vec::new();
+    for file in find_env_files(working_dir) {
+        let content = match fs::read_to_string(&file) {
+            Ok(content) => content,
+            Err(_) => continue,
+        };
+        for (key, value) in parse(&content) {
+            if !allowed.contains(&key) {
+                continue;
+            }
+            // The same rules as a request's env apply, so a checked-in file can't set PATH or LD_PRELOAD
+            if let Err(e) = validate_env_var(&key, &value) {
+                tracing::warn!("Ignoring {} from {}: {}", key, file.display(), e);
+                continue;
+            }
+            vars.push((key, value));
+        }
+    }
+    vars
+}
+
+/// The env files of the nearest directory at or above `dir` that has any, like direnv
+fn find_env_files(dir: &Path) -> Vec<PathBuf> {
+    dir.ancestors()
+        .map(|ancestor| {
+            ENV_FILES
+                .iter()
+                .map(|name| ancestor.join(name))
+                .filter(|path| path.is_file())
+                .collect::<Vec<_>>()
+        })
+        .find(|files| !files.is_empty())
+        .unwrap_or_default()
+}
+
+/// Parse `KEY=VALUE` and `export KEY=VALUE` lines. Anything else, such as direnv's
+/// `use` or `layout` directives, is skipped; nothing is evaluated by a shell.
+fn parse(content: &str) -> Vec<(String, String)> {
+    content
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty() && !line.starts_with('#'))
+        .filter_map(|line| {
+            let line = line.strip_prefix("export ").unwrap_or(line);
+            let (key, value) = line.split_once('=')?;
+            let key = key.trim();
+            if key.is_empty() || !key.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+                return None;
+            }
+            Some((key.to_string(), unquote(value.trim())))
+        })
+        .collect()
+}
+
+/// Strip matching quotes, or a trailing comment from an unquoted value
+fn unquote(value: &str) -> String {
+    for quote in ['"', '\''] {
+        if value.len() >= 2 && value.starts_with(quote) && value.ends_with(quote) {
+            return value[1..value.len() - 1].to_string();
+        }
+    }
+    match value.split_once(" #") {
+        Some((value, _)) => value.trim_end().to_string(),
+        None => value.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn keys(names: &[&str]) -> Vec<String> {
+        names.iter().map(|s| s.to_string()).collect()
+    }
+
+    #[test]
+    fn test_parse() {
+        let content = "# comment\nexport GOFLAGS=-mod=mod\nNODE_ENV=\"test\"\nCC='clang' \nuse nix\nDEBUG=1 # verbose\nBAD KEY=1\n";
+        assert_eq!(
+            parse(content),
+            vec![
+                ("GOFLAGS".to_string(), "-mod=mod".to_string()),
+                ("NODE_ENV".to_string(), "test".to_string()),
+                ("CC".to_string(), "clang".to_string()),
+                ("DEBUG".to_string(), "1".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_workspace_env_loads_only_allowed_keys() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join(".env"), "GOFLAGS=-mod=mod\nSECRET_TOKEN=abc\n").unwrap();
+        fs::write(temp_dir.path().join(".envrc"), "export GOFLAGS=-mod=vendor\nexport PATH=/evil/bin\n").unwrap();
+        let vars = workspace_env_impl(temp_dir.path(), &keys(&["GOFLAGS", "PATH"]));
+        // .envrc is read after .env, so its value wins when both are applied in order
+        assert_eq!(
+            vars,
+            vec![
+                ("GOFLAGS".to_string(), "-mod=mod".to_string()),
+                ("GOFLAGS".to_string(), "-mod=vendor".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_workspace_env_requires_opt_in() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join(".env"), "GOFLAGS=-mod=mod\n").unwrap();
+        assert!(workspace_env_impl(temp_dir.path(), &[]).is_empty());
+    }
+
+    #[test]
+    fn test_workspace_env_searches_parent_directories() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join(".env"), "GOFLAGS=-mod=mod\n").unwrap();
+        let subdir = temp_dir.path().join("pkg/lib");
+        fs::create_dir_all(&subdir).unwrap();
+        assert_eq!(
+            workspace_env_impl(&subdir, &keys(&["GOFLAGS"])),
+            vec![("GOFLAGS".to_string(), "-mod=mod".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_workspace_env_rejects_shell_injection() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join(".env"), "GOFLAGS=$(curl evil)\n").unwrap();
+        assert!(workspace_env_impl(temp_dir.path(), &keys(&["GOFLAGS"])).is_empty());
+    }
+}